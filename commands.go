@@ -0,0 +1,760 @@
+// Commands
+//
+// This file defines the subcommands of the command-line tool, each with its own flag set, usage
+// text and validation, and the flat flag syntax of earlier versions, which is kept as a
+// deprecated alias.
+//
+// Key features:
+// - convert <mode> and validate <mode> for the file based conversion modes
+// - serve for the HTTP server
+// - cache stats|prune|clear|export|import|merge|verify|repair|compact for maintaining the disk cache
+// - warmup for filling the disk cache ahead of a conversion without network access
+// - The flags of each command are registered from shared groups, so they behave the same everywhere
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/cache"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/pipeline"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/progress"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Commands
+const (
+	commandConvert  = "convert"
+	commandValidate = "validate"
+	commandServe    = "serve"
+	commandCache    = "cache"
+	commandWarmup   = "warmup"
+)
+
+// Cache actions
+const (
+	cacheStats   = "stats"
+	cachePrune   = "prune"
+	cacheClear   = "clear"
+	cacheExport  = "export"
+	cacheImport  = "import"
+	cacheMerge   = "merge"
+	cacheVerify  = "verify"
+	cacheRepair  = "repair"
+	cacheCompact = "compact"
+)
+
+// modeServe is the mode of the serve command, as named by the deprecated -mode flag
+const modeServe = "serve"
+
+// conversionMode is a conversion mode of the convert and validate commands
+type conversionMode struct {
+	name        string // Name on the command line, e.g. coord2vegref
+	mode        string // Mode of the pipeline
+	description string
+}
+
+// conversionModes lists the conversion modes in the order they are shown in the usage text
+var conversionModes = []conversionMode{
+	{"coord2vegref", pipeline.ModeCoordToVegref, "Convert coordinates to vegreferanse"},
+	{"vegref2coord", pipeline.ModeVegrefToCoord, "Convert vegreferanse to coordinates"},
+	{"legacy2coord", pipeline.ModeLegacyVegrefToCoord, "Convert legacy vegreferanse to vegsystemreferanse and coordinates"},
+	{"range2geom", pipeline.ModeRangeToGeometry, "Convert vegreferanse ranges to road geometry"},
+}
+
+// cacheActions lists the actions of the cache command in the order they are shown in the usage text
+var cacheActions = []struct {
+	name        string
+	description string
+}{
+	{cacheStats, "Show the number of entries and the size of the disk cache"},
+	{cachePrune, "Remove the entries written longer ago than -older-than"},
+	{cacheClear, "Remove all entries"},
+	{cacheExport, "Write all entries to a gzip-compressed JSON lines archive at -output, or to stdout"},
+	{cacheImport, "Add the entries of the archive at -input, resolving conflicts by -policy"},
+	{cacheMerge, "Add the entries of the cache directory -from, resolving conflicts by -policy"},
+	{cacheVerify, "Report corrupt, empty and legacy-format entries"},
+	{cacheRepair, "Delete corrupt and empty entries, or look them up again with -refetch"},
+	{cacheCompact, "Rewrite legacy-format entries into the current format"},
+}
+
+// flagValues holds the flag values that are turned into the mode-specific configurations
+type flagValues struct {
+	xColumn, yColumn, vegreferanseColumn, dateColumn int
+	geometryFormat, legacyDate, runTolerance         string
+	roadCategories, phases, trafficGroup             string
+	excludeArms                                      bool
+	listen                                           string
+	maxRequestBytes                                  int64
+	maxBatchSize                                     int
+}
+
+// registerCommonFlags registers the logging and config file flags of every command
+func registerCommonFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.LogLevel, "log-level", "info", "Minimum level of the log lines written to stderr: debug, info, warn or error")
+	fs.StringVar(&config.LogFormat, "log-format", logging.FormatText, "Format of the log lines: text or json")
+	fs.StringVar(&config.ConfigFile, "config", "", "JSON or YAML (.yaml, .yml) config file with settings for flags not given on the command line (env: VEGREF_CONFIG)")
+	fs.StringVar(&config.Profile, "profile", "", "Named profile in the config file to apply on top of its top-level settings (env: VEGREF_PROFILE)")
+}
+
+// registerCacheDirFlag registers the disk cache directory flag
+func registerCacheDirFlag(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.CacheDir, "cache-dir", "cache/api_responses", "Directory for disk cache")
+}
+
+// registerWorkersFlag registers the number of concurrent workers
+func registerWorkersFlag(fs *flag.FlagSet, config *Config) {
+	fs.IntVar(&config.Workers, "workers", 5, "Number of concurrent workers")
+}
+
+// registerAPIFlags registers the flags of the commands calling the API
+func registerAPIFlags(fs *flag.FlagSet, config *Config) {
+	fs.BoolVar(&config.DisableCache, "no-cache", false, "Disable disk cache")
+	fs.BoolVar(&config.ClearCache, "clear-cache", false, "Clear existing cache before starting")
+	fs.IntVar(&config.MemoryCache, "memory-cache", cache.DefaultMemoryEntries, "Number of recently used cache entries kept in memory in front of the disk cache, 0 to disable")
+	registerRateLimitFlags(fs, config)
+	fs.IntVar(&config.MaxDistance, "max-distance", 10, "Maximum distance in meters for filtering API results")
+	registerLookupFlags(fs, config)
+	fs.StringVar(&config.RecordPath, "record", "", "Record all API requests and responses to an archive file, for reproducible runs")
+	fs.StringVar(&config.ReplayPath, "replay", "", "Replay API responses from an archive file recorded with -record, without network access")
+	fs.BoolVar(&config.Offline, "offline", false, "Answer coordinate lookups from the disk cache only, without network access; misses get the status cache_miss")
+	fs.StringVar(&config.MissingKeysPath, "missing-keys", "", "File to write the coordinates missing from the disk cache in -offline mode to, as input for warmup (default: <output>.missing.txt)")
+	fs.StringVar(&config.MetricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics while running, e.g. :9090")
+	fs.StringVar(&config.MetricsFile, "metrics-file", "", "Write Prometheus metrics to this file at exit, for the node_exporter textfile collector")
+}
+
+// registerRateLimitFlags registers the API rate limit flags
+func registerRateLimitFlags(fs *flag.FlagSet, config *Config) {
+	fs.IntVar(&config.RateLimit, "rate-limit", 40, "Number of API calls allowed per time frame (NVDB default: 40)")
+	fs.IntVar(&config.RateLimitTime, "rate-time", 1000, "Rate limit time frame in milliseconds (NVDB default: 1000)")
+}
+
+// registerLookupFlags registers the flags that, together with the road filter, select the disk
+// cache entries of the lookups
+func registerLookupFlags(fs *flag.FlagSet, config *Config) {
+	fs.IntVar(&config.SRID, "srid", nvdb.DefaultSRID, "SRID of input and output coordinates: 4326, 5972, 5973, 5975, 25832, 25833 or 25835")
+	fs.StringVar(&config.Date, "date", "", "Date (YYYY-MM-DD) to look up the road network at, for historical lookups (default: today)")
+}
+
+// registerFileFlags registers the input file flag, and the output file and progress flags when
+// the command writes an output file
+func registerFileFlags(fs *flag.FlagSet, config *Config, output bool) {
+	fs.StringVar(&config.InputPath, "input", "", "Input file path (required)")
+	if output {
+		fs.StringVar(&config.OutputPath, "output", "", "Output file path (required)")
+		fs.StringVar(&config.Progress, "progress", progress.ModeAuto, "Progress display: auto (bar on a terminal, log lines otherwise), bar, log or off")
+	}
+}
+
+// registerColumnFlags registers the column flags of a conversion mode, or of all modes when mode is empty
+func registerColumnFlags(fs *flag.FlagSet, values *flagValues, mode string) {
+	all := mode == ""
+	if all || mode != pipeline.ModeRangeToGeometry {
+		fs.IntVar(&values.dateColumn, "date-column", -1, "0-based index of an optional column with a per-row date (YYYY-MM-DD) that overrides -date")
+	}
+	if all || mode == pipeline.ModeCoordToVegref {
+		fs.IntVar(&values.xColumn, "x-column", -1, "0-based index of the column containing X coordinates (required for coord_to_vegref mode)")
+		fs.IntVar(&values.yColumn, "y-column", -1, "0-based index of the column containing Y coordinates (required for coord_to_vegref mode)")
+	}
+	if all || mode != pipeline.ModeCoordToVegref {
+		fs.IntVar(&values.vegreferanseColumn, "vegreferanse-column", -1, "0-based index of the column containing vegreferanse (required for vegref_to_coord, vegref_range_to_geometry and legacy_vegref_to_coord modes)")
+	}
+	if all || mode == pipeline.ModeVegrefToCoord || mode == pipeline.ModeLegacyVegrefToCoord {
+		fs.StringVar(&values.legacyDate, "legacy-date", vegref.DefaultLegacyVegreferanseDate, "Date (YYYY-MM-DD) at which legacy vegreferanse values are resolved; a -date-column date is used instead when it is on or before "+vegref.DefaultLegacyVegreferanseDate)
+	}
+	if all || mode == pipeline.ModeRangeToGeometry {
+		fs.StringVar(&values.geometryFormat, "geometry-format", "wkt", "Output geometry format for vegref_range_to_geometry mode: wkt or geojson")
+	}
+}
+
+// registerRoadFilterFlags registers the candidate road flags of coordinate lookups
+func registerRoadFilterFlags(fs *flag.FlagSet, values *flagValues) {
+	fs.StringVar(&values.roadCategories, "road-categories", "", "Comma separated road categories to accept in coord_to_vegref mode, e.g. E,R (default: all)")
+	fs.StringVar(&values.phases, "phase", "", "Comma separated road phases to accept in coord_to_vegref mode: V, A, P and/or F (default: all)")
+	fs.StringVar(&values.trafficGroup, "traffic-group", "", "Traffic group to accept in coord_to_vegref mode: K (motor vehicles) or G (pedestrians and cyclists) (default: all)")
+	fs.BoolVar(&values.excludeArms, "exclude-arms", false, "Exclude intersection and side facility arms in coord_to_vegref mode")
+}
+
+// registerReportFlags registers the road report flags of coord_to_vegref mode
+func registerReportFlags(fs *flag.FlagSet, config *Config, values *flagValues) {
+	fs.StringVar(&config.ReportPath, "report", "", "Write the road runs (road section, rows, metre range and direction) to this file in coord_to_vegref mode, as JSON or CSV by the extension .json or .csv")
+	fs.StringVar(&config.QualityReportPath, "quality-report", "", "Write the quality report of the matches (distance histogram, unmatched, ambiguous and overridden rows, errors) to this JSON file in coord_to_vegref mode")
+	fs.BoolVar(&config.QualitySummary, "quality-summary", false, "Print the quality summary of the matches to stdout after the road summary in coord_to_vegref mode, instead of logging its counts")
+	fs.StringVar(&config.HTMLReportPath, "html-report", "", "Write a self-contained HTML report with the road summary, the quality report and a plot of the track to this file in coord_to_vegref mode")
+	fs.StringVar(&values.runTolerance, "run-tolerance", "", "Longest interruption absorbed into a road run and reported as a gap, in rows (e.g. 2), metres along the road (e.g. 50m, at most 10 rows) or both (e.g. 50m,5) (default: none)")
+}
+
+// registerServeFlags registers the flags of the HTTP server
+func registerServeFlags(fs *flag.FlagSet, values *flagValues) {
+	fs.StringVar(&values.listen, "listen", ":8080", "Address to listen on in serve mode")
+	fs.Int64Var(&values.maxRequestBytes, "max-request-bytes", 1<<20, "Maximum request body size in bytes in serve mode")
+	fs.IntVar(&values.maxBatchSize, "max-batch-size", 1000, "Maximum number of points in a batch request in serve mode")
+}
+
+// registerCacheActionFlags registers the flags of an action of the cache command, or of all
+// actions when action is empty
+func registerCacheActionFlags(fs *flag.FlagSet, config *Config, values *flagValues, action string) {
+	all := action == ""
+	if all || action == cachePrune {
+		fs.DurationVar(&config.Cache.OlderThan, "older-than", 0, "Age of the entries to remove with prune, e.g. 720h (required for prune)")
+	}
+	if all || action == cacheExport {
+		fs.StringVar(&config.Cache.OutputPath, "output", "", "Output file of export (default: stdout)")
+	}
+	if all || action == cacheImport {
+		fs.StringVar(&config.Cache.InputPath, "input", "", "Archive written by export to import (required for import)")
+	}
+	if all || action == cacheMerge {
+		fs.StringVar(&config.Cache.From, "from", "", "Cache directory to merge from (required for merge)")
+	}
+	if all || action == cacheImport || action == cacheMerge {
+		fs.StringVar(&config.Cache.Policy, "policy", cache.PolicyNewest, "Conflict policy for entries that exist with other matches: newest, keep or non-empty")
+	}
+	if all || action == cacheRepair {
+		fs.BoolVar(&config.Cache.Refetch, "refetch", false, "Look up the repaired entries of the lookups selected by -srid, -date and the road filter again, instead of only deleting them")
+		registerRateLimitFlags(fs, config)
+	}
+	if all || action == cacheRepair || action == cacheCompact {
+		// The lookup flags select the query variant of the entries to look up again or to compact
+		registerLookupFlags(fs, config)
+		registerRoadFilterFlags(fs, values)
+	}
+}
+
+// registerWarmupFlags registers the flags selecting the points of the warm-up command
+func registerWarmupFlags(fs *flag.FlagSet, config *Config, values *flagValues) {
+	fs.StringVar(&config.InputPath, "input", "", "Input file with the coordinates to look up, in the columns -x-column and -y-column")
+	fs.IntVar(&values.xColumn, "x-column", -1, "0-based index of the column containing X coordinates (required with -input)")
+	fs.IntVar(&values.yColumn, "y-column", -1, "0-based index of the column containing Y coordinates (required with -input)")
+	fs.IntVar(&values.dateColumn, "date-column", -1, "0-based index of an optional column of -input with a per-row date (YYYY-MM-DD) that overrides -date")
+	fs.StringVar(&config.Warmup.BBox, "bbox", "", "Bounding box minX,minY,maxX,maxY of a grid of points to look up, in the coordinate system of -srid")
+	fs.StringVar(&config.Warmup.Range, "range", "", "Vegreferanse range to look up points along, e.g. \"EV6 S1D1 m0-5000\"")
+	fs.Float64Var(&config.Warmup.Spacing, "spacing", 10, "Distance between the points of -bbox and -range, in the units of -srid (meters, or degrees for 4326)")
+	fs.StringVar(&config.Warmup.StatePath, "state", "", "File recording the progress for resuming an interrupted warm-up (default: .warmup-state in the cache directory)")
+}
+
+// newFlagSet creates a flag set that only collects errors, leaving it to the caller to report them
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// commandFlagSet creates the flag set of the command named by the first arguments and sets the
+// command and mode of the config. Returns the flag set and the arguments left for it to parse.
+// On error the flag set is still returned, for printing the usage text.
+func commandFlagSet(args []string, config *Config, values *flagValues) (*flag.FlagSet, []string, error) {
+	progName := filepath.Base(os.Args[0])
+
+	// Start from the defaults of every flag, so that the settings a command has no flags for
+	// still hold valid values
+	registerLegacyFlags(newFlagSet("defaults"), config, values)
+
+	// Flat flag syntax of earlier versions
+	if len(args) == 0 || len(args[0]) > 0 && args[0][0] == '-' {
+		fs := newFlagSet(progName)
+		registerLegacyFlags(fs, config, values)
+		fs.Usage = func() { printLegacyUsage(fs) }
+		config.LegacySyntax = true
+		if len(args) == 0 {
+			fs.Usage = func() { printUsage(fs.Output()) }
+			return fs, nil, fmt.Errorf("command is required")
+		}
+		return fs, args, nil
+	}
+
+	command := args[0]
+	fs := newFlagSet(progName + " " + command)
+	registerCommonFlags(fs, config)
+	config.Command = command
+
+	switch command {
+	case commandConvert, commandValidate:
+		if len(args) < 2 || findMode(args[1]) == nil {
+			fs.Usage = func() { printModesUsage(fs, command) }
+			if len(args) < 2 || args[1] == "-h" || args[1] == "-help" || args[1] == "--help" {
+				return fs, nil, fmt.Errorf("%s requires a conversion mode", command)
+			}
+			return fs, nil, fmt.Errorf("unknown conversion mode: %s", args[1])
+		}
+		mode := findMode(args[1])
+		fs = newFlagSet(progName + " " + command + " " + mode.name)
+		registerCommonFlags(fs, config)
+		config.Mode = mode.mode
+
+		registerFileFlags(fs, config, command == commandConvert)
+		registerColumnFlags(fs, values, mode.mode)
+		registerWorkersFlag(fs, config)
+		if command == commandConvert {
+			registerCacheDirFlag(fs, config)
+			registerAPIFlags(fs, config)
+			if mode.mode == pipeline.ModeCoordToVegref {
+				registerRoadFilterFlags(fs, values)
+				registerReportFlags(fs, config, values)
+			}
+		}
+
+		description := mode.description
+		if command == commandValidate {
+			description = "Check an input file for the " + mode.name + " conversion without calling the API"
+		}
+		fs.Usage = func() { printCommandUsage(fs, description, "-input=<file> [flags]") }
+		return fs, args[2:], nil
+
+	case commandServe:
+		config.Mode = modeServe
+		registerCacheDirFlag(fs, config)
+		registerAPIFlags(fs, config)
+		registerWorkersFlag(fs, config)
+		registerRoadFilterFlags(fs, values)
+		registerServeFlags(fs, values)
+		fs.Usage = func() { printCommandUsage(fs, "Serve the conversions over HTTP with JSON endpoints", "[flags]") }
+		return fs, args[1:], nil
+
+	case commandCache:
+		config.Cache = &CacheConfig{}
+		if len(args) >= 2 {
+			config.Cache.Action = args[1]
+		}
+		fs.Usage = func() { printCacheUsage(fs) }
+		if !isCacheAction(config.Cache.Action) {
+			registerCacheDirFlag(fs, config)
+			if config.Cache.Action == "" || config.Cache.Action[0] == '-' {
+				return fs, nil, fmt.Errorf("cache requires an action")
+			}
+			return fs, nil, fmt.Errorf("unknown cache action: %s", config.Cache.Action)
+		}
+		fs = newFlagSet(progName + " " + command + " " + config.Cache.Action)
+		registerCommonFlags(fs, config)
+		registerCacheDirFlag(fs, config)
+		registerCacheActionFlags(fs, config, values, config.Cache.Action)
+		fs.Usage = func() { printCacheUsage(fs) }
+		return fs, args[2:], nil
+
+	case commandWarmup:
+		config.Warmup = &WarmupConfig{}
+		registerCacheDirFlag(fs, config)
+		registerWarmupFlags(fs, config, values)
+		registerRateLimitFlags(fs, config)
+		registerLookupFlags(fs, config)
+		registerRoadFilterFlags(fs, values)
+		registerWorkersFlag(fs, config)
+		fs.StringVar(&config.Progress, "progress", progress.ModeAuto, "Progress display: auto (bar on a terminal, log lines otherwise), bar, log or off")
+		fs.Usage = func() { printWarmupUsage(fs) }
+		return fs, args[1:], nil
+
+	default:
+		fs.Usage = func() { printUsage(fs.Output()) }
+		return fs, nil, fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+// registerLegacyFlags registers the flags of the deprecated flat flag syntax, where -mode selects
+// what to do
+func registerLegacyFlags(fs *flag.FlagSet, config *Config, values *flagValues) {
+	fs.StringVar(&config.Mode, "mode", "", "Conversion mode: coord_to_vegref, vegref_to_coord, vegref_range_to_geometry, legacy_vegref_to_coord or serve (required)")
+	registerFileFlags(fs, config, true)
+	registerCommonFlags(fs, config)
+	registerCacheDirFlag(fs, config)
+	registerAPIFlags(fs, config)
+	registerWorkersFlag(fs, config)
+	registerColumnFlags(fs, values, "")
+	registerRoadFilterFlags(fs, values)
+	registerReportFlags(fs, config, values)
+	registerServeFlags(fs, values)
+}
+
+// knownFlag reports whether a flag exists in any command, for checking the settings of a config
+// file shared by all commands
+func knownFlag(name string) bool {
+	var config Config
+	var values flagValues
+	all := newFlagSet("all")
+	registerLegacyFlags(all, &config, &values)
+	config.Cache = &CacheConfig{}
+	cacheFlags := newFlagSet(commandCache)
+	registerCacheActionFlags(cacheFlags, &config, &values, "")
+	config.Warmup = &WarmupConfig{}
+	warmupFlags := newFlagSet(commandWarmup)
+	registerWarmupFlags(warmupFlags, &config, &values)
+	return all.Lookup(name) != nil || cacheFlags.Lookup(name) != nil || warmupFlags.Lookup(name) != nil
+}
+
+// findMode returns the conversion mode with the given command-line name, or nil when there is none
+func findMode(name string) *conversionMode {
+	for i := range conversionModes {
+		if conversionModes[i].name == name {
+			return &conversionModes[i]
+		}
+	}
+	return nil
+}
+
+// modeName returns the command-line name of a conversion mode
+func modeName(mode string) string {
+	for _, m := range conversionModes {
+		if m.mode == mode {
+			return m.name
+		}
+	}
+	return mode
+}
+
+// isCacheAction reports whether action is an action of the cache command
+func isCacheAction(action string) bool {
+	for _, a := range cacheActions {
+		if a.name == action {
+			return true
+		}
+	}
+	return false
+}
+
+// printUsage prints the overview of the commands
+func printUsage(w io.Writer) {
+	progName := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(w, "Bidirectional conversion between UTM33 coordinates and vegreferanse\n\n")
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s <command> [flags]\n\n", progName)
+	fmt.Fprintf(w, "Commands:\n")
+	for _, mode := range conversionModes {
+		fmt.Fprintf(w, "  convert %s%s%s\n", mode.name, getSpaces(14-len(mode.name)), mode.description)
+	}
+	fmt.Fprintf(w, "  validate <mode>%sCheck an input file for a conversion mode without calling the API\n", getSpaces(7))
+	fmt.Fprintf(w, "  serve%sServe the conversions over HTTP with JSON endpoints\n", getSpaces(17))
+	for _, action := range cacheActions {
+		fmt.Fprintf(w, "  cache %s%s%s\n", action.name, getSpaces(16-len(action.name)), action.description)
+	}
+	fmt.Fprintf(w, "  warmup%sFill the disk cache with the lookups of an input file, an area or a road\n", getSpaces(16))
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", progName)
+	fmt.Fprintf(w, "The flat flag syntax of earlier versions, e.g. '%s -mode=coord_to_vegref ...', is deprecated but still works.\n", progName)
+}
+
+// printModesUsage prints the conversion modes of the convert or validate command
+func printModesUsage(fs *flag.FlagSet, command string) {
+	w := fs.Output()
+	progName := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s %s <mode> -input=<file> [flags]\n\n", progName, command)
+	fmt.Fprintf(w, "Modes:\n")
+	for _, mode := range conversionModes {
+		fmt.Fprintf(w, "  %s%s%s\n", mode.name, getSpaces(14-len(mode.name)), mode.description)
+	}
+	fmt.Fprintf(w, "\nRun '%s %s <mode> -h' for the flags of a mode.\n", progName, command)
+}
+
+// printCacheUsage prints the actions and flags of the cache command
+func printCacheUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+
+	fmt.Fprintf(w, "Maintain the disk cache of API responses\n\n")
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s <action> [flags]\n\n", fs.Name())
+	fmt.Fprintf(w, "Actions:\n")
+	for _, action := range cacheActions {
+		fmt.Fprintf(w, "  %s%s%s\n", action.name, getSpaces(10-len(action.name)), action.description)
+	}
+	fmt.Fprintf(w, "\n")
+	printFlags(fs, "Flags", nil)
+}
+
+// printCommandUsage prints the usage line of a command followed by its flags, required flags first
+func printCommandUsage(fs *flag.FlagSet, description, arguments string) {
+	w := fs.Output()
+
+	fmt.Fprintf(w, "%s\n\n", description)
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s %s\n\n", fs.Name(), arguments)
+
+	requiredFlags := []string{"input", "output", "x-column", "y-column", "vegreferanse-column"}
+	printFlags(fs, "Required flags", func(name string) bool { return contains(requiredFlags, name) })
+	printFlags(fs, "Optional flags", func(name string) bool { return !contains(requiredFlags, name) })
+}
+
+// printWarmupUsage prints the usage line of the warmup command followed by its flags, the flags
+// selecting the points first
+func printWarmupUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+
+	fmt.Fprintf(w, "Fill the disk cache with the lookups of an input file, an area or a road, without writing output\n\n")
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s -input=<file> -x-column=<index> -y-column=<index> [flags]\n", fs.Name())
+	fmt.Fprintf(w, "  %s -bbox=<minX,minY,maxX,maxY> [flags]\n", fs.Name())
+	fmt.Fprintf(w, "  %s -range=<vegreferanse> [flags]\n\n", fs.Name())
+
+	pointFlags := []string{"input", "x-column", "y-column", "bbox", "range", "spacing", "state"}
+	printFlags(fs, "Point flags", func(name string) bool { return contains(pointFlags, name) })
+	printFlags(fs, "Optional flags", func(name string) bool { return !contains(pointFlags, name) })
+}
+
+// printLegacyUsage prints the overview of the commands followed by the flags of the deprecated
+// flat flag syntax
+func printLegacyUsage(fs *flag.FlagSet) {
+	printUsage(fs.Output())
+	fmt.Fprintf(fs.Output(), "\n")
+	printFlags(fs, "Flags of the deprecated flat syntax", nil)
+}
+
+// printFlags prints the flags selected by include, or all flags when include is nil, with their
+// defaults. Nothing is printed when no flag is selected.
+func printFlags(fs *flag.FlagSet, title string, include func(name string) bool) {
+	w := fs.Output()
+
+	// Calculate the maximum flag name length for proper alignment
+	maxFlagLen := 0
+	var selected []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		if include != nil && !include(f.Name) {
+			return
+		}
+		selected = append(selected, f)
+		maxFlagLen = max(maxFlagLen, len(f.Name))
+	})
+	if len(selected) == 0 {
+		return
+	}
+	// Add some padding
+	columnWidth := maxFlagLen + 4
+
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, f := range selected {
+		defaultValue := f.DefValue
+		if defaultValue != "" && defaultValue != "false" && defaultValue != "0" && defaultValue != "0s" {
+			fmt.Fprintf(w, "  -%s%s%s (default: %s)\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage, defaultValue)
+		} else {
+			fmt.Fprintf(w, "  -%s%s%s\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage)
+		}
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// legacyCommand returns the command replacing a run with the deprecated flat flag syntax
+func legacyCommand(config Config) string {
+	if config.Command == commandServe {
+		return commandServe
+	}
+	return commandConvert + " " + modeName(config.Mode)
+}
+
+// runCache runs an action of the cache command
+func runCache(config Config) {
+	diskCache, err := cache.NewDiskCache(config.CacheDir)
+	if err != nil {
+		fatal("Failed to open disk cache", err)
+	}
+
+	switch config.Cache.Action {
+	case cacheStats:
+		count, size, err := diskCache.Stats()
+		if err != nil {
+			fatal("Failed to get cache statistics", err)
+		}
+		fmt.Printf("Directory:\t%s\n", config.CacheDir)
+		fmt.Printf("Entries:\t%d\n", count)
+		fmt.Printf("Size:\t%.2f MB\n", float64(size)/(1024*1024))
+
+	case cachePrune:
+		removed, err := diskCache.Prune(config.Cache.OlderThan)
+		if err != nil {
+			fatal("Failed to prune disk cache", err)
+		}
+		slog.Info("Pruned disk cache", "removed", removed, "older_than", config.Cache.OlderThan.String())
+		logCacheStats("Disk cache", diskCache)
+
+	case cacheClear:
+		if err := diskCache.Clear(); err != nil {
+			fatal("Failed to clear disk cache", err)
+		}
+		slog.Info("Cache cleared successfully", "directory", config.CacheDir)
+
+	case cacheExport:
+		var w io.Writer = os.Stdout
+		if config.Cache.OutputPath != "" {
+			file, err := os.Create(config.Cache.OutputPath)
+			if err != nil {
+				fatal("Failed to create export file", err)
+			}
+			defer file.Close()
+			w = file
+		}
+		exported, err := diskCache.Export(w)
+		if err != nil {
+			fatal("Failed to export disk cache", err)
+		}
+		slog.Info("Exported disk cache", "entries", exported, "output", cmp.Or(config.Cache.OutputPath, "stdout"))
+
+	case cacheImport:
+		file, err := os.Open(config.Cache.InputPath)
+		if err != nil {
+			fatal("Failed to open cache archive", err)
+		}
+		defer file.Close()
+		report, err := diskCache.Import(file, config.Cache.Policy)
+		if err != nil {
+			fatal("Failed to import cache archive", err)
+		}
+		logMergeReport("Imported cache archive", report, "input", config.Cache.InputPath, "policy", config.Cache.Policy)
+
+	case cacheMerge:
+		source, err := cache.NewDiskCache(config.Cache.From)
+		if err != nil {
+			fatal("Failed to open disk cache to merge from", err)
+		}
+		report, err := diskCache.Merge(source, config.Cache.Policy)
+		if err != nil {
+			fatal("Failed to merge disk cache", err)
+		}
+		logMergeReport("Merged disk cache", report, "from", config.Cache.From, "policy", config.Cache.Policy)
+
+	case cacheVerify:
+		report, err := diskCache.Verify()
+		if err != nil {
+			fatal("Failed to verify disk cache", err)
+		}
+		for _, issue := range report.Issues {
+			if issue.Err != nil {
+				fmt.Printf("%s\t%s\t%v\n", issue.Status, issue.File, issue.Err)
+			} else {
+				fmt.Printf("%s\t%s\n", issue.Status, issue.File)
+			}
+		}
+		broken := report.Count(cache.StatusCorrupt) + report.Count(cache.StatusEmpty)
+		attrs := []any{"entries", report.Entries, "corrupt", report.Count(cache.StatusCorrupt),
+			"empty", report.Count(cache.StatusEmpty), "legacy", report.Count(cache.StatusLegacy)}
+		if broken > 0 {
+			slog.Error("Disk cache has corrupt or empty entries, run cache repair to fix them", attrs...)
+			os.Exit(1)
+		}
+		slog.Info("Verified disk cache", attrs...)
+
+	case cacheRepair:
+		// Without -refetch the entries are only deleted, and no API client is needed
+		var fetcher cache.Fetcher
+		if config.Cache.Refetch {
+			fetcher = nvdb.NewVegvesenetAPIV4(append(lookupOptions(config), nvdb.WithDiskCache(diskCache))...)
+		}
+		report, err := diskCache.Repair(fetcher)
+		if err != nil {
+			fatal("Failed to repair disk cache", err)
+		}
+		slog.Info("Repaired disk cache", "deleted", report.Deleted, "refetched", report.Refetched, "failed", report.Failed)
+
+	case cacheCompact:
+		// The API client is only used for the query variant of its lookups, and makes no calls
+		apiClient := nvdb.NewVegvesenetAPIV4(lookupOptions(config)...)
+		report, err := diskCache.Compact(apiClient.CacheVariant())
+		if err != nil {
+			fatal("Failed to compact disk cache", err)
+		}
+		slog.Info("Compacted disk cache", "rewritten", report.Rewritten, "skipped", report.Skipped)
+		if report.Skipped > 0 {
+			slog.Warn("Legacy entries of other lookups were skipped, run compact with the -srid, -date and road filter flags they were looked up with",
+				"skipped", report.Skipped)
+		}
+	}
+}
+
+// logMergeReport logs the result of a cache import or merge
+func logMergeReport(msg string, report cache.MergeReport, attrs ...any) {
+	slog.Info(msg, append(attrs, "added", report.Added, "skipped", report.Skipped,
+		"conflicted", report.Conflicted, "replaced", report.Replaced)...)
+}
+
+// warmupStateFile is the default state file of the warmup command, in the cache directory
+const warmupStateFile = ".warmup-state"
+
+// runWarmup looks up the points of an input file, a bounding box or a road range to fill the disk
+// cache, and exits with an error status when lookups failed or the warm-up was interrupted
+func runWarmup(config Config) {
+	diskCache, err := cache.NewDiskCache(config.CacheDir)
+	if err != nil {
+		fatal("Failed to open disk cache", err)
+	}
+	apiClient := nvdb.NewVegvesenetAPIV4(append(lookupOptions(config), nvdb.WithDiskCache(diskCache))...)
+	logCacheStats("Using disk cache", diskCache)
+
+	var points []pipeline.WarmupPoint
+	var coordinates []vegref.Coordinate
+	switch {
+	case config.InputPath != "":
+		points, err = pipeline.InputPoints(config.InputPath, *config.CoordToVegref)
+	case config.Warmup.BBox != "":
+		bbox, _ := pipeline.ParseBoundingBox(config.Warmup.BBox) // Validated by parseConfig
+		coordinates, err = pipeline.GridPoints(bbox, config.Warmup.Spacing)
+		points = pipeline.WarmupPoints(coordinates)
+	default:
+		var geometry vegref.RoadGeometry // The range is validated and normalised by parseConfig
+		if geometry, err = apiClient.GetGeometryFromVegreferanseRange(config.Warmup.Range); err == nil {
+			coordinates, err = pipeline.LinePoints(geometry.Geometry, config.Warmup.Spacing)
+			points = pipeline.WarmupPoints(coordinates)
+		}
+	}
+	if err != nil {
+		fatal("Failed to get the points to warm up", err)
+	}
+
+	statePath := cmp.Or(config.Warmup.StatePath, filepath.Join(config.CacheDir, warmupStateFile))
+	slog.Info("Starting cache warm-up", "points", len(points), "state", statePath, "workers", config.Workers,
+		"srid", config.SRID, "candidate_roads", config.RoadFilter.String(),
+		"calls_per_second", float64(config.RateLimit)*1000/float64(config.RateLimitTime))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startTime := time.Now()
+	report, err := pipeline.Warmup(ctx, points, apiClient, pipeline.WarmupConfig{
+		Workers:   config.Workers,
+		StatePath: statePath,
+		Progress:  setupProgress(config, apiClient),
+	})
+	attrs := []any{"points", report.Points, "resumed", report.Resumed, "fetched", report.Fetched, "failed", report.Failed,
+		"api_calls", apiClient.Stats().APICalls, "elapsed", time.Since(startTime).Round(time.Millisecond).String()}
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Warn("Cache warm-up interrupted, run the same command again to resume", attrs...)
+		os.Exit(1)
+	case err != nil:
+		fatal("Cache warm-up failed", err)
+	case report.Failed > 0:
+		slog.Error("Cache warm-up finished with failed lookups, run the same command again to retry them", attrs...)
+		os.Exit(1)
+	}
+	slog.Info("Cache warm-up completed", attrs...)
+	logCacheStats("Final disk cache", diskCache)
+}
+
+// runValidate checks the input file for a conversion without calling the API, and exits with an
+// error status when a line would fail
+func runValidate(config Config) {
+	pipelineConfig := pipelineConfig(config, nil)
+	slog.Info("Validating input file", "input", config.InputPath, "mode", modeName(config.Mode))
+
+	startTime := time.Now()
+	checked, failed, err := pipeline.ValidateFile(config.InputPath, pipelineConfig)
+	if err != nil {
+		fatal("Invalid input file", err)
+	}
+	pipeline.LogFailedRows(failed, pipelineConfig)
+
+	elapsed := time.Since(startTime).Round(time.Millisecond).String()
+	if len(failed) > 0 {
+		slog.Error("Validation failed", "input", config.InputPath, "lines", checked, "invalid_lines", len(failed), "elapsed", elapsed)
+		os.Exit(1)
+	}
+	slog.Info("Validation passed", "input", config.InputPath, "lines", checked, "elapsed", elapsed)
+}
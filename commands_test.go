@@ -0,0 +1,226 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/pipeline"
+)
+
+// TestCommandFlagSet tests the flags registered for each command, mode and action
+func TestCommandFlagSet(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		mode    string
+		present []string
+		absent  []string
+	}{
+		{
+			args: []string{"convert", "coord2vegref"}, command: commandConvert, mode: pipeline.ModeCoordToVegref,
+			present: []string{"input", "output", "x-column", "y-column", "date-column", "road-categories", "report", "quality-summary", "run-tolerance", "srid", "offline", "config"},
+			absent:  []string{"vegreferanse-column", "legacy-date", "geometry-format", "listen", "mode"},
+		},
+		{
+			args: []string{"convert", "vegref2coord"}, command: commandConvert, mode: pipeline.ModeVegrefToCoord,
+			present: []string{"vegreferanse-column", "legacy-date", "date-column", "rate-limit"},
+			absent:  []string{"x-column", "y-column", "road-categories", "report", "geometry-format"},
+		},
+		{
+			args: []string{"convert", "legacy2coord"}, command: commandConvert, mode: pipeline.ModeLegacyVegrefToCoord,
+			present: []string{"vegreferanse-column", "legacy-date"},
+			absent:  []string{"x-column", "geometry-format"},
+		},
+		{
+			args: []string{"convert", "range2geom"}, command: commandConvert, mode: pipeline.ModeRangeToGeometry,
+			present: []string{"vegreferanse-column", "geometry-format"},
+			absent:  []string{"date-column", "legacy-date", "x-column"},
+		},
+		{
+			args: []string{"validate", "coord2vegref"}, command: commandValidate, mode: pipeline.ModeCoordToVegref,
+			present: []string{"input", "x-column", "workers"},
+			absent:  []string{"output", "progress", "cache-dir", "rate-limit", "road-categories", "report"},
+		},
+		{
+			args: []string{"serve"}, command: commandServe, mode: modeServe,
+			present: []string{"listen", "max-request-bytes", "max-batch-size", "road-categories", "cache-dir", "rate-limit"},
+			absent:  []string{"input", "output", "x-column", "report"},
+		},
+		{
+			args: []string{"cache", "prune"}, command: commandCache,
+			present: []string{"older-than", "cache-dir", "log-level"},
+			absent:  []string{"input", "output", "policy", "refetch"},
+		},
+		{
+			args: []string{"cache", "merge"}, command: commandCache,
+			present: []string{"from", "policy"},
+			absent:  []string{"input", "older-than"},
+		},
+		{
+			args: []string{"cache", "repair"}, command: commandCache,
+			present: []string{"refetch", "rate-limit", "srid", "date", "road-categories"},
+			absent:  []string{"policy", "from"},
+		},
+		{
+			args: []string{"warmup"}, command: commandWarmup,
+			present: []string{"input", "x-column", "y-column", "date-column", "bbox", "range", "spacing", "state", "srid", "date", "phase", "progress"},
+			absent:  []string{"output", "vegreferanse-column", "report", "listen"},
+		},
+		{
+			args:    []string{"-mode=coord_to_vegref"},
+			present: []string{"mode", "input", "output", "x-column", "vegreferanse-column", "geometry-format", "report", "listen"},
+			absent:  []string{"older-than", "bbox"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			var config Config
+			var values flagValues
+			fs, _, err := commandFlagSet(tt.args, &config, &values)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if config.Command != tt.command || config.Mode != tt.mode {
+				t.Errorf("Expected command %q and mode %q, got %q and %q", tt.command, tt.mode, config.Command, config.Mode)
+			}
+			for _, name := range tt.present {
+				if fs.Lookup(name) == nil {
+					t.Errorf("Expected flag -%s", name)
+				}
+			}
+			for _, name := range tt.absent {
+				if fs.Lookup(name) != nil {
+					t.Errorf("Expected no flag -%s", name)
+				}
+			}
+		})
+	}
+}
+
+// TestCommandErrors tests the errors of unknown commands, modes and actions and of flags that
+// belong to another command or mode
+func TestCommandErrors(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "command is required"},
+		{[]string{"frobnicate"}, "unknown command: frobnicate"},
+		{[]string{"convert"}, "convert requires a conversion mode"},
+		{[]string{"validate", "-h"}, "validate requires a conversion mode"},
+		{[]string{"convert", "coord_to_vegref"}, "unknown conversion mode: coord_to_vegref"},
+		{[]string{"cache"}, "cache requires an action"},
+		{[]string{"cache", "-cache-dir=x"}, "cache requires an action"},
+		{[]string{"cache", "shrink"}, "unknown cache action: shrink"},
+		{[]string{"convert", "coord2vegref", "-vegreferanse-column=1"}, "flag provided but not defined: -vegreferanse-column"},
+		{[]string{"convert", "vegref2coord", "-x-column=1"}, "flag provided but not defined: -x-column"},
+		{[]string{"convert", "range2geom", "-legacy-date=2019-12-31"}, "flag provided but not defined: -legacy-date"},
+		{[]string{"validate", "coord2vegref", "-output=out.txt"}, "flag provided but not defined: -output"},
+		{[]string{"serve", "-input=in.txt"}, "flag provided but not defined: -input"},
+		{[]string{"cache", "prune", "-policy=keep"}, "flag provided but not defined: -policy"},
+		{[]string{"warmup", "-report=report.json"}, "flag provided but not defined: -report"},
+		{[]string{"serve", "extra"}, "unexpected argument: extra"},
+		{[]string{"warmup", "-range=EV6 S1D1 m0-"}, "invalid range"},
+		{[]string{"warmup", "-range=EV6 S1D1 m200"}, `range "EV6 S1D1 m200" must have a metre range`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			_, fs, err := parseConfig(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error %q, got %v", tt.expected, err)
+			}
+			if fs == nil || fs.Usage == nil {
+				t.Error("Expected a flag set with a usage text")
+			}
+		})
+	}
+}
+
+// TestWarmupRange tests that the -range of the warm-up is normalised like the input of range2geom
+func TestWarmupRange(t *testing.T) {
+	config, _, err := parseConfig([]string{"warmup", "-range=ev6s1d1m0-5000", "-cache-dir=" + t.TempDir()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Warmup.Range != "EV6 S1D1 m0-5000" {
+		t.Errorf("Expected range %q, got %q", "EV6 S1D1 m0-5000", config.Warmup.Range)
+	}
+}
+
+// TestLegacyModeAlias tests that the deprecated -mode flag selects the command and mode
+func TestLegacyModeAlias(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("X\tY\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	output := filepath.Join(dir, "output.txt")
+
+	tests := []struct {
+		args    []string
+		command string
+		mode    string
+		legacy  string
+	}{
+		{[]string{"-mode=coord_to_vegref", "-input=" + input, "-output=" + output, "-x-column=0", "-y-column=1"},
+			commandConvert, pipeline.ModeCoordToVegref, "convert coord2vegref"},
+		{[]string{"-mode=vegref_to_coord", "-input=" + input, "-output=" + output, "-vegreferanse-column=0"},
+			commandConvert, pipeline.ModeVegrefToCoord, "convert vegref2coord"},
+		{[]string{"-mode=legacy_vegref_to_coord", "-input=" + input, "-output=" + output, "-vegreferanse-column=0"},
+			commandConvert, pipeline.ModeLegacyVegrefToCoord, "convert legacy2coord"},
+		{[]string{"-mode=vegref_range_to_geometry", "-input=" + input, "-output=" + output, "-vegreferanse-column=0"},
+			commandConvert, pipeline.ModeRangeToGeometry, "convert range2geom"},
+		{[]string{"-mode=serve"}, commandServe, modeServe, "serve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			config, _, err := parseConfig(tt.args)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !config.LegacySyntax || config.Command != tt.command || config.Mode != tt.mode {
+				t.Errorf("Expected legacy command %q and mode %q, got %+v", tt.command, tt.mode, config)
+			}
+			if command := legacyCommand(config); command != tt.legacy {
+				t.Errorf("Expected replacement command %q, got %q", tt.legacy, command)
+			}
+		})
+	}
+
+	if _, _, err := parseConfig([]string{"-mode=frobnicate", "-input=" + input, "-output=" + output}); err == nil {
+		t.Error("Expected an error for an unknown -mode")
+	}
+}
+
+// TestKnownFlag tests the settings accepted in a config file shared by all commands
+func TestKnownFlag(t *testing.T) {
+	tests := []struct {
+		name  string
+		known bool
+	}{
+		{"rate-limit", true},
+		{"x-column", true},
+		{"vegreferanse-column", true},
+		{"listen", true},
+		{"run-tolerance", true},
+		{"older-than", true}, // cache prune
+		{"policy", true},     // cache import and merge
+		{"refetch", true},    // cache repair
+		{"bbox", true},       // warmup
+		{"spacing", true},    // warmup
+		{"rate_limit", false},
+		{"frobnicate", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if known := knownFlag(tt.name); known != tt.known {
+			t.Errorf("Expected knownFlag(%q) to be %v, got %v", tt.name, tt.known, known)
+		}
+	}
+}
@@ -1,196 +1,151 @@
 // Koordinater til Vegreferanse
 //
 // This program converts UTM33 coordinates to Norwegian road references (vegreferanse)
-// using the Norwegian Public Roads Administration (NVDB) API v4.
+// using the Norwegian Public Roads Administration (NVDB) API v4. Other coordinate
+// systems supported by NVDB can be selected with the -srid flag.
 //
 // Features:
 // - Converts UTM33 coordinates to vegreferanse using the NVDB API v4
+// - Resolves vegreferanse ranges to road centreline geometry (WKT or GeoJSON)
+// - Converts legacy (pre-2020) vegreferanse to the current vegsystemreferanse and coordinates
 // - Intelligent road selection that maintains travel continuity when multiple road matches are available
 // - Efficient disk-based caching system to reduce API calls and speed up processing
 // - Configurable API rate limiting to comply with NVDB's usage policies
 // - Parallel processing with configurable number of workers
 // - Processes tab-delimited input files containing coordinate data
+// - HTTP server mode exposing the conversions as a JSON REST API
+// - Prometheus metrics on a listener or in a textfile collector file
+// - Structured logging to stderr as text or JSON, filtered by level
+// - Subcommands for converting, validating input files, serving and maintaining the cache
 //
-// The main component in this file handles:
-// - File I/O operations
-// - Command-line flag processing
-// - Coordination of the conversion process
-// - Worker management for parallel processing
+// The main component in this file is a thin command-line wrapper that handles:
+// - Command-line flag processing and validation, merged with environment variables and a config file
+// - Setting up the disk cache and the API client
+// - Running the conversion pipeline or the HTTP server
+//
+// The subcommands and their flags are defined in commands.go.
+//
+// The conversion itself lives in the importable packages under pkg/.
 
 package main
 
 import (
-	"bufio"
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/cache"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/pipeline"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/progress"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/server"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/settings"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
 )
 
 // Config holds all program configuration settings
 type Config struct {
+	// Command settings
+	Command      string // One of the commands: convert, validate, serve, cache or warmup
+	LegacySyntax bool   // Set when the deprecated flat flag syntax with -mode was used
+
 	// Mode settings
-	Mode string `validate:"required,oneof=coord_to_vegref vegref_to_coord"`
+	Mode string `validate:"required_if=Command convert,required_if=Command validate,required_if=Command serve,omitempty,oneof=coord_to_vegref vegref_to_coord vegref_range_to_geometry legacy_vegref_to_coord serve"`
 
-	// File paths
-	InputPath  string `validate:"required,fileexists"`
-	OutputPath string `validate:"required,outputdirexists"`
+	// File paths (only used by the convert, validate and warmup commands)
+	InputPath  string `validate:"required_if=Command convert,required_if=Command validate,omitempty,fileexists"`
+	OutputPath string `validate:"required_if=Command convert,omitempty,outputdirexists"`
 
 	// Cache settings
 	DisableCache bool
 	CacheDir     string
 	ClearCache   bool
+	MemoryCache  int          `validate:"min=0"` // Number of cache entries kept in memory, 0 to disable the memory tier
+	Cache        *CacheConfig `validate:"required_if=Command cache"`
+
+	// Warm-up settings
+	Warmup *WarmupConfig `validate:"required_if=Command warmup"`
 
 	// API settings
-	RateLimit     int `validate:"min=1,max=1000"`
-	RateLimitTime int `validate:"min=1,max=10000"`
-	MaxDistance   int `validate:"min=1,max=10000"` // Maximum distance in meters for filtering results
+	RateLimit     int    `validate:"min=1,max=1000"`
+	RateLimitTime int    `validate:"min=1,max=10000"`
+	MaxDistance   int    `validate:"min=1,max=10000"`                             // Maximum distance in meters for filtering results
+	SRID          int    `validate:"oneof=4326 5972 5973 5975 25832 25833 25835"` // Coordinate system for input and output coordinates
+	Date          string `validate:"omitempty,datetime=2006-01-02"`               // Date for historical lookups, empty for the current road network
+
+	// Record/replay settings
+	RecordPath string `validate:"omitempty,excluded_with=ReplayPath,outputdirexists"` // Archive to record API responses to
+	ReplayPath string `validate:"omitempty,fileexists"`                               // Archive to replay API responses from
+
+	// Offline settings
+	Offline         bool   `validate:"excluded_with=RecordPath,excluded_with=ReplayPath,excluded_with=DisableCache"` // Answer from the disk cache only
+	MissingKeysPath string `validate:"omitempty,outputdirexists"`                                                    // File to write the offline cache misses to
+
+	// Config file settings
+	ConfigFile string             // JSON or YAML config file with settings for the flags not given on the command line
+	Profile    string             // Named profile in the config file
+	Settings   []settings.Setting // Effective value and source of every flag
+
+	// Logging settings
+	LogLevel  string `validate:"oneof=debug info warn error"` // Minimum level of the log lines written to stderr
+	LogFormat string `validate:"oneof=text json"`             // Format of the log lines
+
+	// Metrics settings
+	MetricsAddr string `validate:"omitempty,hostname_port"`   // Address of the listener serving /metrics, empty when disabled
+	MetricsFile string `validate:"omitempty,outputdirexists"` // File to write the metrics to at exit, for the node_exporter textfile collector
+
+	// Report settings
+	ReportPath        string                `validate:"omitempty,outputdirexists"` // File to write the road runs of coord_to_vegref mode to, as JSON or CSV
+	RunTolerance      pipeline.RunTolerance // Interruptions absorbed into the road runs of coord_to_vegref mode
+	QualityReportPath string                `validate:"omitempty,outputdirexists"` // File to write the quality report of coord_to_vegref mode to, as JSON
+	QualitySummary    bool                  // Print the quality summary of coord_to_vegref mode to stdout instead of logging it
+	HTMLReportPath    string                `validate:"omitempty,outputdirexists"` // File to write the HTML report of coord_to_vegref mode to
 
 	// Processing settings
-	Workers int `validate:"min=1,max=100"`
+	Progress   string          `validate:"oneof=auto bar log off"` // Progress display mode
+	Workers    int             `validate:"min=1,max=100"`
+	RoadFilter nvdb.RoadFilter // Candidate roads accepted for coordinate lookups in coord_to_vegref and serve modes
 
 	// Mode-specific configurations (only one will be populated based on the mode)
-	CoordToVegref *CoordToVegrefConfig `validate:"required_if=Mode coord_to_vegref"`
-	VegrefToCoord *VegrefToCoordConfig `validate:"required_if=Mode vegref_to_coord"`
-}
-
-// CoordToVegrefConfig holds configuration specific to coordinates to vegreferanse mode
-type CoordToVegrefConfig struct {
-	XColumn int `validate:"min=0"`
-	YColumn int `validate:"min=0"`
-}
-
-// VegrefToCoordConfig holds configuration specific to vegreferanse to coordinates mode
-type VegrefToCoordConfig struct {
-	VegreferanseColumn int `validate:"min=0"`
-}
-
-// Coordinate represents a geographical coordinate point
-type Coordinate struct {
-	X float64 // Easting (X)
-	Y float64 // Northing (Y)
-}
-
-// VegreferanseProvider defines the interface for services that can convert coordinates to vegreferanse
-type VegreferanseProvider interface {
-	// GetVegreferanseFromCoordinates converts UTM33 coordinates to a vegreferanse string
-	GetVegreferanseFromCoordinates(x, y float64) (string, error)
-
-	// GetVegreferanseMatches returns all matching vegreferanses for the given coordinates
-	GetVegreferanseMatches(x, y float64) ([]VegreferanseMatch, error)
-}
-
-// CoordinateProvider defines the interface for services that can convert vegreferanse to coordinates
-type CoordinateProvider interface {
-	// GetCoordinatesFromVegreferanse converts a vegreferanse string to UTM33 coordinates
-	GetCoordinatesFromVegreferanse(vegreferanse string) (Coordinate, error)
-}
-
-// RateLimiter handles API rate limiting
-type RateLimiter struct {
-	calls     []time.Time
-	limit     int
-	timeFrame time.Duration
-	mu        sync.Mutex
-}
-
-// processTask represents a single line to be processed
-type processTask struct {
-	lineIdx int
-	line    string
-}
-
-// processResult represents the result of processing a single line
-type processResult struct {
-	lineIdx      int
-	line         string
-	vegreferanse string
-	matches      []VegreferanseMatch
-	err          error
+	CoordToVegref *pipeline.CoordToVegrefConfig `validate:"required_if=Mode coord_to_vegref"`
+	VegrefToCoord *pipeline.VegrefToCoordConfig `validate:"required_if=Mode vegref_to_coord"`
+	RangeToGeom   *pipeline.RangeToGeomConfig   `validate:"required_if=Mode vegref_range_to_geometry"`
+	LegacyToCoord *pipeline.VegrefToCoordConfig `validate:"required_if=Mode legacy_vegref_to_coord"`
+	Serve         *server.ServeConfig           `validate:"required_if=Mode serve"`
 }
 
-// roadRange represents a continuous range of rows for a specific road
-type roadRange struct {
-	startRow int
-	endRow   int
+// CacheConfig holds the settings of the cache command
+type CacheConfig struct {
+	Action     string        `validate:"oneof=stats prune clear export import merge verify repair compact"`
+	OlderThan  time.Duration `validate:"required_if=Action prune,min=0"`                 // Age of the entries to remove with prune
+	OutputPath string        `validate:"omitempty,outputdirexists"`                      // Output file of export, empty for stdout
+	InputPath  string        `validate:"required_if=Action import,omitempty,fileexists"` // Archive to import
+	From       string        `validate:"required_if=Action merge,omitempty,dir"`         // Cache directory to merge from
+	Policy     string        `validate:"omitempty,oneof=newest keep non-empty"`          // Conflict policy of import and merge
+	Refetch    bool          // Look up the entries deleted by repair again
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(limit int, timeFrame time.Duration) *RateLimiter {
-	return &RateLimiter{
-		calls:     make([]time.Time, 0, limit),
-		limit:     limit,
-		timeFrame: timeFrame,
-	}
-}
-
-// Wait blocks until a new API call is allowed
-func (r *RateLimiter) Wait() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	now := time.Now()
-
-	// Remove timestamps older than the time frame
-	validCalls := make([]time.Time, 0, len(r.calls))
-	for _, call := range r.calls {
-		if now.Sub(call) < r.timeFrame {
-			validCalls = append(validCalls, call)
-		}
-	}
-	r.calls = validCalls
-
-	// If we've reached the limit, wait until we can make a new call
-	if len(r.calls) >= r.limit {
-		oldest := r.calls[0]
-		waitTime := r.timeFrame - now.Sub(oldest)
-		if waitTime > 0 {
-			time.Sleep(waitTime)
-			now = time.Now()
-
-			// Re-filter calls after waiting since more might have expired
-			validCalls = make([]time.Time, 0, len(r.calls))
-			for _, call := range r.calls {
-				if now.Sub(call) < r.timeFrame {
-					validCalls = append(validCalls, call)
-				}
-			}
-			r.calls = validCalls
-		}
-	}
-
-	// Add the new call time
-	r.calls = append(r.calls, now)
-}
-
-// Helper function to get maximum of two integers
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
-// extractRoadNumber extracts the road number (e.g., "E18", "Rv4") from a vegreferanse string
-func extractRoadNumber(vegreferanse string) string {
-	if vegreferanse == "" {
-		return ""
-	}
-
-	// Split by space and take the first part (e.g., "E18" from "E18 S65D1 m12621")
-	parts := strings.Fields(vegreferanse)
-	if len(parts) == 0 {
-		return ""
-	}
-
-	return parts[0]
+// WarmupConfig holds the settings of the warmup command. The input file is InputPath of Config.
+type WarmupConfig struct {
+	BBox      string  // Bounding box of the grid of points to look up
+	Range     string  // Vegreferanse range to look up points along
+	Spacing   float64 `validate:"gt=0"`                      // Distance between the points of BBox and Range
+	StatePath string  `validate:"omitempty,outputdirexists"` // File recording the progress, empty for the default in the cache directory
 }
 
 // validateFileExists validates that a file exists
@@ -212,42 +167,139 @@ func validateOutputDirExists(fl validator.FieldLevel) bool {
 	return true
 }
 
-// parseConfig parses command-line flags and returns a Config struct
-func parseConfig() (Config, error) {
+// parseConfig parses the command line and returns a Config struct, together with the flag set of
+// the command for printing its usage text
+func parseConfig(args []string) (Config, *flag.FlagSet, error) {
 	var config Config
 
 	// Variables to store flag values temporarily until we know which mode-specific config to create
-	var xColumn, yColumn, vegreferanseColumn int
-
-	// Define common flags
-	flag.StringVar(&config.Mode, "mode", "", "Conversion mode: coord_to_vegref or vegref_to_coord (required)")
-	flag.StringVar(&config.InputPath, "input", "", "Input file path (required)")
-	flag.StringVar(&config.OutputPath, "output", "", "Output file path (required)")
-	flag.BoolVar(&config.DisableCache, "no-cache", false, "Disable disk cache")
-	flag.StringVar(&config.CacheDir, "cache-dir", "cache/api_responses", "Directory for disk cache")
-	flag.BoolVar(&config.ClearCache, "clear-cache", false, "Clear existing cache before starting")
-	flag.IntVar(&config.RateLimit, "rate-limit", 40, "Number of API calls allowed per time frame (NVDB default: 40)")
-	flag.IntVar(&config.RateLimitTime, "rate-time", 1000, "Rate limit time frame in milliseconds (NVDB default: 1000)")
-	flag.IntVar(&config.MaxDistance, "max-distance", 10, "Maximum distance in meters for filtering API results")
-	flag.IntVar(&config.Workers, "workers", 5, "Number of concurrent workers")
-
-	// Mode-specific flags - use temporary variables
-	flag.IntVar(&xColumn, "x-column", -1, "0-based index of the column containing X coordinates (required for coord_to_vegref mode)")
-	flag.IntVar(&yColumn, "y-column", -1, "0-based index of the column containing Y coordinates (required for coord_to_vegref mode)")
-	flag.IntVar(&vegreferanseColumn, "vegreferanse-column", -1, "0-based index of the column containing vegreferanse (required for vegref_to_coord mode)")
-
-	flag.Parse()
+	var values flagValues
+
+	fs, rest, err := commandFlagSet(args, &config, &values)
+	if err != nil {
+		return config, fs, err
+	}
+	if err := fs.Parse(rest); err != nil {
+		return config, fs, err
+	}
+	if fs.NArg() > 0 {
+		return config, fs, fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+	}
+	if config.LegacySyntax {
+		config.Command = commandConvert
+		if config.Mode == modeServe {
+			config.Command = commandServe
+		}
+	}
+
+	// Fill in the flags not given on the command line from the environment and the config file
+	config.ConfigFile = cmp.Or(config.ConfigFile, os.Getenv(settings.EnvName("config")))
+	config.Profile = cmp.Or(config.Profile, os.Getenv(settings.EnvName("profile")))
+	var configFile *settings.File
+	if config.ConfigFile != "" {
+		if configFile, err = settings.LoadFile(config.ConfigFile); err != nil {
+			return config, fs, err
+		}
+		if err := configFile.Check(knownFlag, "config", "profile"); err != nil {
+			return config, fs, err
+		}
+	}
+	effective, err := settings.Apply(fs, configFile, config.Profile, os.LookupEnv, "config", "profile")
+	if err != nil {
+		return config, fs, err
+	}
+	config.Settings = effective
+
+	// The repair and compact actions and the warm-up select the cache entries of the lookups with the road filter
+	if config.Command == commandCache || config.Command == commandWarmup {
+		roadFilter, err := nvdb.ParseRoadFilter(values.roadCategories, values.phases, values.trafficGroup, values.excludeArms)
+		if err != nil {
+			return config, fs, err
+		}
+		config.RoadFilter = roadFilter
+	}
+
+	// The warm-up looks up the points of exactly one source
+	if config.Command == commandWarmup {
+		sources := 0
+		for _, source := range []string{config.InputPath, config.Warmup.BBox, config.Warmup.Range} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return config, fs, fmt.Errorf("warmup requires exactly one of -input=<file>, -bbox=<minX,minY,maxX,maxY> or -range=<vegreferanse>")
+		}
+		if config.InputPath != "" {
+			config.CoordToVegref = &pipeline.CoordToVegrefConfig{XColumn: values.xColumn, YColumn: values.yColumn, DateColumn: values.dateColumn}
+		}
+		if config.Warmup.BBox != "" {
+			if _, err := pipeline.ParseBoundingBox(config.Warmup.BBox); err != nil {
+				return config, fs, err
+			}
+		}
+		if config.Warmup.Range != "" {
+			ref, err := vegref.ParseVegsystemreferanse(config.Warmup.Range)
+			if err != nil {
+				return config, fs, fmt.Errorf("invalid range: %w", err)
+			}
+			if !ref.IsRange() {
+				return config, fs, fmt.Errorf("range %q must have a metre range, e.g. \"EV6 S1D1 m0-5000\"", config.Warmup.Range)
+			}
+			config.Warmup.Range = ref.String()
+		}
+	}
 
 	// Create the appropriate mode-specific configuration based on mode
 	switch config.Mode {
 	case "coord_to_vegref":
-		config.CoordToVegref = &CoordToVegrefConfig{
-			XColumn: xColumn,
-			YColumn: yColumn,
+		roadFilter, err := nvdb.ParseRoadFilter(values.roadCategories, values.phases, values.trafficGroup, values.excludeArms)
+		if err != nil {
+			return config, fs, err
+		}
+		config.RoadFilter = roadFilter
+		config.CoordToVegref = &pipeline.CoordToVegrefConfig{
+			XColumn:    values.xColumn,
+			YColumn:    values.yColumn,
+			DateColumn: values.dateColumn,
+		}
+		if config.ReportPath != "" {
+			if _, err := pipeline.RoadReportFormat(config.ReportPath); err != nil {
+				return config, fs, err
+			}
 		}
+		runTolerance, err := pipeline.ParseRunTolerance(values.runTolerance)
+		if err != nil {
+			return config, fs, err
+		}
+		config.RunTolerance = runTolerance
 	case "vegref_to_coord":
-		config.VegrefToCoord = &VegrefToCoordConfig{
-			VegreferanseColumn: vegreferanseColumn,
+		config.VegrefToCoord = &pipeline.VegrefToCoordConfig{
+			VegreferanseColumn: values.vegreferanseColumn,
+			LegacyDate:         values.legacyDate,
+			DateColumn:         values.dateColumn,
+		}
+	case "legacy_vegref_to_coord":
+		config.LegacyToCoord = &pipeline.VegrefToCoordConfig{
+			VegreferanseColumn: values.vegreferanseColumn,
+			LegacyDate:         values.legacyDate,
+			DateColumn:         values.dateColumn,
+		}
+	case "vegref_range_to_geometry":
+		config.RangeToGeom = &pipeline.RangeToGeomConfig{
+			VegreferanseColumn: values.vegreferanseColumn,
+			GeometryFormat:     values.geometryFormat,
+		}
+	case "serve":
+		roadFilter, err := nvdb.ParseRoadFilter(values.roadCategories, values.phases, values.trafficGroup, values.excludeArms)
+		if err != nil {
+			return config, fs, err
+		}
+		config.RoadFilter = roadFilter
+		config.Serve = &server.ServeConfig{
+			Listen:          values.listen,
+			MaxRequestBytes: values.maxRequestBytes,
+			MaxBatchSize:    values.maxBatchSize,
 		}
 	}
 
@@ -264,730 +316,559 @@ func parseConfig() (Config, error) {
 		for _, e := range validationErrors {
 			switch e.Field() {
 			case "Mode":
-				return config, fmt.Errorf("invalid mode: %s, must be either coord_to_vegref or vegref_to_coord", config.Mode)
+				return config, fs, fmt.Errorf("invalid mode: %s, must be one of coord_to_vegref, vegref_to_coord, vegref_range_to_geometry, legacy_vegref_to_coord or serve", config.Mode)
 			case "InputPath":
-				if e.Tag() == "required" {
-					return config, fmt.Errorf("input file path is required: use -input=<file>")
+				if e.StructNamespace() == "Config.Cache.InputPath" && e.Tag() == "required_if" {
+					return config, fs, fmt.Errorf("cache archive path is required: use -input=<file>")
+				} else if e.StructNamespace() == "Config.Cache.InputPath" {
+					return config, fs, fmt.Errorf("cache archive does not exist: %s", config.Cache.InputPath)
+				} else if e.Tag() == "required_if" {
+					return config, fs, fmt.Errorf("input file path is required: use -input=<file>")
 				} else if e.Tag() == "fileexists" {
-					return config, fmt.Errorf("input file does not exist: %s", config.InputPath)
+					return config, fs, fmt.Errorf("input file does not exist: %s", config.InputPath)
 				}
 			case "OutputPath":
-				if e.Tag() == "required" {
-					return config, fmt.Errorf("output file path is required: use -output=<file>")
+				if e.StructNamespace() == "Config.Cache.OutputPath" {
+					return config, fs, fmt.Errorf("export directory does not exist: %s", filepath.Dir(config.Cache.OutputPath))
+				} else if e.Tag() == "required_if" {
+					return config, fs, fmt.Errorf("output file path is required: use -output=<file>")
 				} else if e.Tag() == "outputdirexists" {
-					return config, fmt.Errorf("output directory does not exist: %s", filepath.Dir(config.OutputPath))
+					return config, fs, fmt.Errorf("output directory does not exist: %s", filepath.Dir(config.OutputPath))
 				}
+			case "From":
+				if e.Tag() == "required_if" {
+					return config, fs, fmt.Errorf("cache directory to merge from is required: use -from=<directory>")
+				}
+				return config, fs, fmt.Errorf("cache directory to merge from does not exist: %s", config.Cache.From)
+			case "Policy":
+				return config, fs, fmt.Errorf("invalid conflict policy: %s, must be one of newest, keep or non-empty", config.Cache.Policy)
+			case "Spacing":
+				return config, fs, fmt.Errorf("invalid spacing: %v, must be positive", config.Warmup.Spacing)
+			case "StatePath":
+				return config, fs, fmt.Errorf("state file directory does not exist: %s", filepath.Dir(config.Warmup.StatePath))
+			case "XColumn", "YColumn":
+				return config, fs, fmt.Errorf("coordinate columns are required: use -x-column=<index> and -y-column=<index>")
+			case "Offline":
+				return config, fs, fmt.Errorf("-offline cannot be used with -record, -replay or -no-cache")
+			case "ReportPath":
+				return config, fs, fmt.Errorf("report directory does not exist: %s", filepath.Dir(config.ReportPath))
+			case "QualityReportPath":
+				return config, fs, fmt.Errorf("quality report directory does not exist: %s", filepath.Dir(config.QualityReportPath))
+			case "HTMLReportPath":
+				return config, fs, fmt.Errorf("HTML report directory does not exist: %s", filepath.Dir(config.HTMLReportPath))
+			case "MissingKeysPath":
+				return config, fs, fmt.Errorf("missing keys directory does not exist: %s", filepath.Dir(config.MissingKeysPath))
+			case "MemoryCache":
+				return config, fs, fmt.Errorf("invalid memory cache size: %d, must be 0 or more entries", config.MemoryCache)
+			case "OlderThan":
+				return config, fs, fmt.Errorf("a positive age of the entries to prune is required: use -older-than=<duration>, e.g. -older-than=720h")
 			case "CoordToVegref":
-				return config, fmt.Errorf("coord_to_vegref configuration is required for coord_to_vegref mode")
+				return config, fs, fmt.Errorf("coord_to_vegref configuration is required for coord_to_vegref mode")
 			case "VegrefToCoord":
-				return config, fmt.Errorf("vegref_to_coord configuration is required for vegref_to_coord mode")
+				return config, fs, fmt.Errorf("vegref_to_coord configuration is required for vegref_to_coord mode")
+			case "LegacyToCoord":
+				return config, fs, fmt.Errorf("legacy_vegref_to_coord configuration is required for legacy_vegref_to_coord mode")
+			case "Serve":
+				return config, fs, fmt.Errorf("serve configuration is required for serve mode")
+			case "Listen":
+				return config, fs, fmt.Errorf("invalid listen address: %s, must be host:port or :port", values.listen)
+			case "MaxRequestBytes":
+				return config, fs, fmt.Errorf("invalid maximum request size: %d, must be at least 1024 bytes", values.maxRequestBytes)
+			case "MaxBatchSize":
+				return config, fs, fmt.Errorf("invalid maximum batch size: %d, must be between 1 and 100000", values.maxBatchSize)
+			case "Date":
+				return config, fs, fmt.Errorf("invalid date: %s, must be in YYYY-MM-DD format", config.Date)
+			case "DateColumn":
+				return config, fs, fmt.Errorf("invalid date column: %d, must be a column index or -1", values.dateColumn)
+			case "LegacyDate":
+				return config, fs, fmt.Errorf("invalid legacy date: %s, must be in YYYY-MM-DD format", values.legacyDate)
+			case "RangeToGeom":
+				return config, fs, fmt.Errorf("vegref_range_to_geometry configuration is required for vegref_range_to_geometry mode")
+			case "GeometryFormat":
+				return config, fs, fmt.Errorf("invalid geometry format: %s, must be either wkt or geojson", values.geometryFormat)
+			case "RecordPath":
+				if e.Tag() == "excluded_with" {
+					return config, fs, fmt.Errorf("-record and -replay cannot be used together")
+				}
+				return config, fs, fmt.Errorf("archive directory does not exist: %s", filepath.Dir(config.RecordPath))
+			case "ReplayPath":
+				return config, fs, fmt.Errorf("archive file does not exist: %s", config.ReplayPath)
+			case "LogLevel":
+				return config, fs, fmt.Errorf("invalid log level: %s, must be one of debug, info, warn or error", config.LogLevel)
+			case "LogFormat":
+				return config, fs, fmt.Errorf("invalid log format: %s, must be either text or json", config.LogFormat)
+			case "MetricsAddr":
+				return config, fs, fmt.Errorf("invalid metrics address: %s, must be host:port or :port", config.MetricsAddr)
+			case "MetricsFile":
+				return config, fs, fmt.Errorf("metrics file directory does not exist: %s", filepath.Dir(config.MetricsFile))
+			case "Progress":
+				return config, fs, fmt.Errorf("invalid progress mode: %s, must be one of auto, bar, log or off", config.Progress)
+			case "SRID":
+				return config, fs, fmt.Errorf("unsupported SRID: %d, must be one of 4326, 5972, 5973, 5975, 25832, 25833 or 25835", config.SRID)
 			default:
-				return config, fmt.Errorf("invalid value for %s: %v", e.Field(), e.Value())
+				return config, fs, fmt.Errorf("invalid value for %s: %v", e.Field(), e.Value())
 			}
 		}
-		return config, err
+		return config, fs, err
 	}
 
-	return config, nil
+	return config, fs, nil
 }
 
-// setupCache initializes and configures the disk cache
-func setupCache(config Config) string {
+// setupCache initializes and configures the disk cache, returning nil when it is disabled
+func setupCache(config Config) *cache.DiskCache {
 	if config.DisableCache {
-		return ""
+		return nil
+	}
+	if config.RecordPath != "" || config.ReplayPath != "" {
+		// Every API response must be part of the archive, so the disk cache is bypassed
+		slog.Info("Disk cache is bypassed while recording or replaying API responses")
+		return nil
 	}
 
 	cacheDirPath := config.CacheDir
-	if err := os.MkdirAll(cacheDirPath, 0755); err != nil {
-		fmt.Printf("Warning: Failed to create cache directory: %v\n", err)
-		return "" // Disable disk cache if we can't create the directory
+	diskCache, err := cache.NewDiskCache(cacheDirPath, cache.WithMemoryEntries(config.MemoryCache))
+	if err != nil {
+		slog.Warn("Failed to initialize disk cache", logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+		return nil // Disable disk cache if we can't create the directory
 	}
 
 	if config.ClearCache {
 		// Clear cache if requested
-		dc, err := NewVegreferanseDiskCache(cacheDirPath)
-		if err != nil {
-			fmt.Printf("Warning: Failed to initialize disk cache: %v\n", err)
+		slog.Info("Clearing disk cache")
+		if err := diskCache.Clear(); err != nil {
+			slog.Warn("Failed to clear cache", logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
 		} else {
-			fmt.Println("Clearing disk cache...")
-			if err := dc.Clear(); err != nil {
-				fmt.Printf("Warning: Failed to clear cache: %v\n", err)
-			} else {
-				// Recreate the directory after clearing
-				_ = os.MkdirAll(cacheDirPath, 0755)
-				fmt.Println("Cache cleared successfully.")
-			}
+			slog.Info("Cache cleared successfully")
 		}
 	}
 
-	return cacheDirPath
+	return diskCache
 }
 
-// readInputFile reads the input file and returns header and data lines
-func readInputFile(inputPath string, config Config) (string, []string, error) {
-	// Open input file
-	inputFile, err := os.Open(inputPath)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to open input file: %w", err)
+// lookupOptions returns the API client options for the rate limit and the settings that determine
+// the results of the lookups
+func lookupOptions(config Config) []nvdb.Option {
+	return []nvdb.Option{
+		nvdb.WithRateLimit(config.RateLimit, time.Duration(config.RateLimitTime)*time.Millisecond),
+		nvdb.WithSRID(config.SRID),
+		nvdb.WithTidspunkt(config.Date),
+		nvdb.WithRoadFilter(config.RoadFilter),
 	}
-	defer inputFile.Close()
-
-	scanner := bufio.NewScanner(inputFile)
-
-	// Process header
-	var header string
-	if !scanner.Scan() {
-		return "", nil, fmt.Errorf("input file is empty")
-	}
-	header = scanner.Text()
-
-	// Verify columns in header
-	headerColumns := strings.Split(header, "\t")
-	expectedColumnCount := len(headerColumns)
-
-	// Validate column indices based on mode
-	switch config.Mode {
-	case "coord_to_vegref":
-		if config.CoordToVegref == nil {
-			return "", nil, fmt.Errorf("coord_to_vegref configuration is not initialized")
-		}
-
-		// Validate X and Y column indices
-		if config.CoordToVegref.XColumn < 0 || config.CoordToVegref.XColumn >= expectedColumnCount {
-			return "", nil, fmt.Errorf("column X index %d is out of range (file has %d columns)",
-				config.CoordToVegref.XColumn, expectedColumnCount)
-		}
-		if config.CoordToVegref.YColumn < 0 || config.CoordToVegref.YColumn >= expectedColumnCount {
-			return "", nil, fmt.Errorf("column Y index %d is out of range (file has %d columns)",
-				config.CoordToVegref.YColumn, expectedColumnCount)
-		}
-		fmt.Printf("Input file has %d columns. Using column %d for X and column %d for Y coordinates\n",
-			expectedColumnCount, config.CoordToVegref.XColumn, config.CoordToVegref.YColumn)
+}
 
-	case "vegref_to_coord":
-		if config.VegrefToCoord == nil {
-			return "", nil, fmt.Errorf("vegref_to_coord configuration is not initialized")
+// setupArchive returns the API client options for recording or replaying API responses, and the
+// recorder to close when recording
+func setupArchive(config Config) ([]nvdb.Option, *nvdb.Recorder, error) {
+	switch {
+	case config.RecordPath != "":
+		recorder, err := nvdb.NewRecorder(config.RecordPath, nil)
+		if err != nil {
+			return nil, nil, err
 		}
+		slog.Info("Recording API responses", "archive", config.RecordPath)
+		return []nvdb.Option{nvdb.WithHTTPClient(&http.Client{Timeout: nvdb.DefaultHTTPTimeout, Transport: recorder})}, recorder, nil
 
-		// Validate vegreferanse column index
-		if config.VegrefToCoord.VegreferanseColumn < 0 || config.VegrefToCoord.VegreferanseColumn >= expectedColumnCount {
-			return "", nil, fmt.Errorf("column Vegreferanse index %d is out of range (file has %d columns)",
-				config.VegrefToCoord.VegreferanseColumn, expectedColumnCount)
+	case config.ReplayPath != "":
+		replayer, err := nvdb.NewReplayer(config.ReplayPath)
+		if err != nil {
+			return nil, nil, err
 		}
-		fmt.Printf("Input file has %d columns. Using column %d for Vegreferanse\n",
-			expectedColumnCount, config.VegrefToCoord.VegreferanseColumn)
+		slog.Info("Replaying API responses without network access", "archive", config.ReplayPath)
+		// Replayed responses need no rate limiting
+		return []nvdb.Option{nvdb.WithHTTPClient(&http.Client{Transport: replayer}), nvdb.WithRateLimiter(nil)}, nil, nil
 	}
+	return nil, nil, nil
+}
 
-	// Read all data lines into memory
-	var lines []string
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+// closeArchive closes the recorder, if any, and reports the number of recorded responses
+func closeArchive(recorder *nvdb.Recorder, config Config) {
+	if recorder == nil {
+		return
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", nil, fmt.Errorf("error reading input file: %w", err)
+	if err := recorder.Close(); err != nil {
+		slog.Error("Failed to close archive", "archive", config.RecordPath, logging.KeyError, err)
+		return
 	}
-
-	fmt.Printf("Read %d lines from file\n", len(lines)+1) // +1 for header
-
-	return header, lines, nil
+	slog.Info("Recorded API responses", "archive", config.RecordPath, "responses", recorder.Entries())
 }
 
-// processCoordinatesToVegreferanse processes the input file to convert coordinates to vegreferanse
-func processCoordinatesToVegreferanse(lines []string, provider VegreferanseProvider, workers int, modeConfig CoordToVegrefConfig, maxDistance int) ([]processResult, error) {
-	// Create a channel for tasks and results with buffering
-	taskChannel := make(chan processTask, len(lines))
-	resultChannel := make(chan processResult, len(lines))
-
-	// Start workers
-	var wg sync.WaitGroup
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for task := range taskChannel {
-				line := task.line
-				lineIdx := task.lineIdx
-
-				// Split the line by tabs
-				fields := strings.Split(line, "\t")
-
-				// Skip lines that don't have enough columns for coordinates
-				if len(fields) <= max(modeConfig.XColumn, modeConfig.YColumn) {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("line doesn't have enough columns for coordinates"),
-					}
-					continue
-				}
-
-				// Parse X and Y coordinates
-				x, err := strconv.ParseFloat(fields[modeConfig.XColumn], 64)
-				if err != nil {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("invalid X coordinate: %v", err),
-					}
-					continue
-				}
-
-				y, err := strconv.ParseFloat(fields[modeConfig.YColumn], 64)
-				if err != nil {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("invalid Y coordinate: %v", err),
-					}
-					continue
-				}
-
-				// Get all matches for this coordinate
-				matches, err := provider.GetVegreferanseMatches(x, y)
-				if err != nil {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("API error: %v", err),
-					}
-					continue
-				}
-
-				// Filter matches by distance if specified
-				filteredMatches := filterMatchesByDistance(matches, maxDistance)
+// cacheStatsInterval is the minimum time between two walks of the disk cache for its size metrics
+const cacheStatsInterval = 30 * time.Second
 
-				// Default to empty string if no matches were found after filtering
-				vegreferanse := ""
-				if len(filteredMatches) > 0 {
-					// Get the first match by default - the selector will improve this
-					vegreferanse = filteredMatches[0].Vegsystemreferanse.Kortform
-				}
-
-				resultChannel <- processResult{
-					lineIdx:      lineIdx,
-					line:         line,
-					vegreferanse: vegreferanse,
-					matches:      filteredMatches, // Store filtered matches for the selector
-				}
-			}
-		}()
+// setupMetrics registers the disk cache size metrics and starts the metrics listener, if configured
+func setupMetrics(config Config, diskCache *cache.DiskCache) error {
+	if diskCache != nil {
+		// Both gauges of a scrape are read from one walk of the cache directory
+		metrics.NewGaugeFunc("vegref_cache_entries", "Number of entries in the disk cache.", func() float64 {
+			count, _, _ := diskCache.CachedStats(cacheStatsInterval)
+			return float64(count)
+		})
+		metrics.NewGaugeFunc("vegref_cache_size_bytes", "Total size of the disk cache files in bytes.", func() float64 {
+			_, size, _ := diskCache.CachedStats(cacheStatsInterval)
+			return float64(size)
+		})
+		metrics.NewGaugeFunc("vegref_cache_memory_entries", "Number of cache entries in the memory tier.", func() float64 {
+			return float64(diskCache.MemoryEntries())
+		})
 	}
 
-	// Queue all tasks
-	for i, line := range lines {
-		taskChannel <- processTask{
-			lineIdx: i,
-			line:    line,
-		}
+	if config.MetricsAddr == "" {
+		return nil
 	}
-	close(taskChannel)
-
-	// Wait for all workers to finish
-	wg.Wait()
-	close(resultChannel)
 
-	// Collect results
-	results := make([]processResult, len(lines))
-	for result := range resultChannel {
-		results[result.lineIdx] = result
+	// Listen before returning, so that an address in use is reported as a configuration error
+	listener, err := net.Listen("tcp", config.MetricsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start metrics listener: %w", err)
 	}
-
-	// Sort results by lineIdx
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].lineIdx < results[j].lineIdx
-	})
-
-	return results, nil
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("Metrics listener stopped", logging.KeyError, err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", listener.Addr()))
+	return nil
 }
 
-// processVegreferanseToCoordinates processes the input file to convert vegreferanse to coordinates
-func processVegreferanseToCoordinates(lines []string, provider CoordinateProvider, workers int, modeConfig VegrefToCoordConfig) ([]processResult, error) {
-	// Create a channel for tasks and results with buffering
-	taskChannel := make(chan processTask, len(lines))
-	resultChannel := make(chan processResult, len(lines))
-
-	// Start workers
-	var wg sync.WaitGroup
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for task := range taskChannel {
-				line := task.line
-				lineIdx := task.lineIdx
-
-				// Split the line by tabs
-				fields := strings.Split(line, "\t")
-
-				// Skip lines that don't have enough columns for vegreferanse
-				if len(fields) <= modeConfig.VegreferanseColumn {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("line doesn't have enough columns for vegreferanse"),
-					}
-					continue
-				}
-
-				// Get vegreferanse from the specified column
-				vegreferanse := strings.TrimSpace(fields[modeConfig.VegreferanseColumn])
-				if vegreferanse == "" {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("empty vegreferanse"),
-					}
-					continue
-				}
-
-				// Get coordinates for this vegreferanse
-				coords, err := provider.GetCoordinatesFromVegreferanse(vegreferanse)
-				if err != nil {
-					resultChannel <- processResult{
-						lineIdx: lineIdx,
-						line:    line,
-						err:     fmt.Errorf("API error: %v", err),
-					}
-					continue
-				}
-
-				// Format the result - the original line will have the coordinates appended
-				xValue := fmt.Sprintf("%.6f", coords.X)
-				yValue := fmt.Sprintf("%.6f", coords.Y)
-
-				// Create a modified line with X and Y coordinates
-				resultChannel <- processResult{
-					lineIdx:      lineIdx,
-					line:         line,
-					vegreferanse: fmt.Sprintf("%s\t%s", xValue, yValue), // Using vegreferanse field to store X and Y for compatibility
-				}
-			}
-		}()
+// writeMissingKeys writes the coordinates an offline run could not find in the disk cache to the
+// missing keys file, which the warmup command reads to fill the cache with them
+func writeMissingKeys(config Config, apiClient *nvdb.VegvesenetAPIV4) {
+	if !config.Offline {
+		return
 	}
-
-	// Queue all tasks
-	for i, line := range lines {
-		taskChannel <- processTask{
-			lineIdx: i,
-			line:    line,
-		}
+	keys := apiClient.MissingKeys()
+	if len(keys) == 0 {
+		slog.Info("All lookups were answered from the disk cache")
+		return
 	}
-	close(taskChannel)
 
-	// Wait for all workers to finish
-	wg.Wait()
-	close(resultChannel)
-
-	// Collect results
-	results := make([]processResult, len(lines))
-	for result := range resultChannel {
-		results[result.lineIdx] = result
+	path := config.MissingKeysPath
+	if path == "" && config.OutputPath != "" {
+		path = config.OutputPath + ".missing.txt"
 	}
-
-	// Sort results by lineIdx
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].lineIdx < results[j].lineIdx
-	})
-
-	return results, nil
-}
-
-// filterMatchesByDistance filters vegreferanse matches by maximum distance
-func filterMatchesByDistance(matches []VegreferanseMatch, maxDistance int) []VegreferanseMatch {
-	if maxDistance <= 0 {
-		return matches // No filtering if maxDistance is not positive
+	if path == "" {
+		slog.Warn("Lookups were missing from the disk cache, use -missing-keys=<file> to list them", "missing", len(keys))
+		return
 	}
-
-	filtered := make([]VegreferanseMatch, 0, len(matches))
-	for _, match := range matches {
-		if match.Avstand <= float64(maxDistance) {
-			filtered = append(filtered, match)
-		}
+	if err := nvdb.WriteMissingKeys(path, keys); err != nil {
+		slog.Error("Failed to write missing keys", "path", path, logging.KeyError, err)
+		return
 	}
-	return filtered
+	slog.Warn("Lookups were missing from the disk cache, run warmup with the missing keys to fill it", "missing", len(keys), "path", path,
+		"command", warmupCommand(config, path))
 }
 
-// applyVegreferanseSelector applies the road continuity selection to results
-func applyVegreferanseSelector(results []processResult) {
-	selector := NewVegreferanseSelector(10) // Keep track of last 10 vegreferanses
+// warmupCommand returns the warmup command filling the disk cache with the missing keys file at
+// path. It repeats the lookup settings of the run, which are part of the cache keys, and reads the
+// Date column so each point is looked up at the date it was missing at.
+func warmupCommand(config Config, path string) string {
+	args := []string{"warmup", "-input=" + path, "-x-column=0", "-y-column=1", "-date-column=2",
+		"-cache-dir=" + config.CacheDir, fmt.Sprintf("-srid=%d", config.SRID)}
+	if config.Date != "" {
+		args = append(args, "-date="+config.Date)
+	}
+	filter := config.RoadFilter
+	if len(filter.Kategorier) > 0 {
+		args = append(args, "-road-categories="+strings.Join(filter.Kategorier, ","))
+	}
+	if len(filter.Faser) > 0 {
+		args = append(args, "-phase="+strings.Join(filter.Faser, ","))
+	}
+	if filter.Trafikantgruppe != "" {
+		args = append(args, "-traffic-group="+filter.Trafikantgruppe)
+	}
+	if filter.ExcludeArms {
+		args = append(args, "-exclude-arms")
+	}
 
-	// Apply selector in sequential order
-	for i := range results {
-		result := &results[i]
-		if len(result.matches) > 0 {
-			result.vegreferanse = selector.SelectBestMatch(result.matches)
-			selector.AddToHistory(result.vegreferanse)
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t'\"") {
+			args[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 		}
 	}
+	return strings.Join(args, " ")
 }
 
-// identifyRoadRanges identifies the ranges of rows for each road number
-func identifyRoadRanges(results []processResult) map[string][]roadRange {
-	roadNumbers := make(map[string][]roadRange)
-	currentRoad := ""
-	currentRange := roadRange{}
-
-	// First identify all the road number ranges
-	for i, result := range results {
-		roadNumber := extractRoadNumber(result.vegreferanse)
-
-		// Skip empty road numbers
-		if roadNumber == "" {
-			// If we were tracking a road, finish the current range
-			if currentRoad != "" {
-				currentRange.endRow = i
-				roadNumbers[currentRoad] = append(roadNumbers[currentRoad], currentRange)
-				currentRoad = ""
-			}
-			continue
-		}
-
-		// If this is a new road or the first road number
-		if roadNumber != currentRoad {
-			// If we were tracking a road, finish the current range
-			if currentRoad != "" {
-				currentRange.endRow = i
-				roadNumbers[currentRoad] = append(roadNumbers[currentRoad], currentRange)
-			}
-
-			// Start a new range
-			currentRoad = roadNumber
-			currentRange = roadRange{startRow: i + 1} // +1 because we want 1-indexed row numbers for display
-		} else {
-			// Same road, continue the current range
-			// We'll update the end row at the end or when the road changes
-			currentRange.endRow = i + 1
-		}
+// writeMetricsFile writes the metrics to the configured file, if any
+func writeMetricsFile(config Config) {
+	if config.MetricsFile == "" {
+		return
 	}
-
-	// Handle the last range if there was one
-	if currentRoad != "" {
-		currentRange.endRow = len(results)
-		roadNumbers[currentRoad] = append(roadNumbers[currentRoad], currentRange)
+	if err := metrics.Default.WriteFile(config.MetricsFile); err != nil {
+		slog.Error("Failed to write metrics file", "path", config.MetricsFile, logging.KeyError, err)
+		return
 	}
-
-	return roadNumbers
+	slog.Info("Metrics written", "path", config.MetricsFile)
 }
 
-// writeResults writes the processed results to the output file with mode-specific handling
-func writeResults(outputPath, header string, results []processResult) (int, error) {
-	// Open output file
-	outputFile, err := os.Create(outputPath)
+func main() {
+	// Parse the command line
+	config, fs, err := parseConfig(os.Args[1:])
 	if err != nil {
-		return 0, fmt.Errorf("failed to create output file: %w", err)
+		fs.SetOutput(os.Stderr)
+		if errors.Is(err, flag.ErrHelp) {
+			fs.Usage()
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "Error in configuration: %v\n\n", err)
+		fs.Usage()
+		os.Exit(1)
 	}
-	defer outputFile.Close()
-
-	// Create buffered writer
-	writer := bufio.NewWriter(outputFile)
 
-	// Write header
-	_, err = writer.WriteString(header + "\n")
+	// Log to stderr, so that stdout only carries the road report and the requested quality summary
+	logger, err := logging.New(os.Stderr, config.LogLevel, config.LogFormat)
 	if err != nil {
-		return 0, fmt.Errorf("failed to write header: %w", err)
+		fmt.Fprintf(os.Stderr, "Error in configuration: %v\n\n", err)
+		fs.SetOutput(os.Stderr)
+		fs.Usage()
+		os.Exit(1)
 	}
-
-	// Write data lines
-	linesWritten := 0
-	errCount := 0
-
-	for _, result := range results {
-		if result.err != nil {
-			fmt.Printf("Error on line %d: %v\n", result.lineIdx+1, result.err)
-			errCount++
-			continue
-		}
-
-		line := result.line + "\t" + result.vegreferanse + "\n"
-		_, err = writer.WriteString(line)
-		if err != nil {
-			return linesWritten, fmt.Errorf("failed to write line %d: %w", result.lineIdx+1, err)
-		}
-		linesWritten++
+	slog.SetDefault(logger)
+	if config.LegacySyntax {
+		slog.Warn("The -mode flag syntax is deprecated, use the subcommand instead", "mode", config.Mode, "command", legacyCommand(config))
 	}
+	logEffectiveConfig(config)
 
-	// Flush writer
-	if err = writer.Flush(); err != nil {
-		return linesWritten, fmt.Errorf("failed to flush writer: %w", err)
+	// The cache and validate commands make no API calls, and the warm-up writes no output
+	switch config.Command {
+	case commandCache:
+		runCache(config)
+		return
+	case commandValidate:
+		runValidate(config)
+		return
+	case commandWarmup:
+		runWarmup(config)
+		return
 	}
 
-	if errCount > 0 {
-		fmt.Printf("Encountered errors on %d lines. Those lines were skipped in the output.\n", errCount)
-	}
+	// Log the mode-specific information
+	switch config.Mode {
+	case "coord_to_vegref":
+		if config.CoordToVegref == nil {
+			fatal("coord_to_vegref configuration is not initialized", nil)
+		}
 
-	return linesWritten, nil
-}
+		slog.Info("Starting conversion of coordinates to vegreferanse using NVDB API v4",
+			"input", config.InputPath, "output", config.OutputPath,
+			"x_column", config.CoordToVegref.XColumn, "y_column", config.CoordToVegref.YColumn,
+			"candidate_roads", config.RoadFilter.String())
 
-// generateRoadReport generates and prints a report of road number ranges
-func generateRoadReport(roadNumbers map[string][]roadRange) {
-	fmt.Println("\nRoad numbers summary:")
-	if len(roadNumbers) == 0 {
-		fmt.Println("No road numbers identified.")
-		return
-	}
+	case "vegref_to_coord":
+		if config.VegrefToCoord == nil {
+			fatal("vegref_to_coord configuration is not initialized", nil)
+		}
 
-	// Get the roads in sorted order for consistent output
-	roadList := make([]string, 0, len(roadNumbers))
-	for road := range roadNumbers {
-		roadList = append(roadList, road)
-	}
-	sort.Strings(roadList)
+		slog.Info("Starting conversion of vegreferanse to coordinates using NVDB API v4",
+			"input", config.InputPath, "output", config.OutputPath,
+			"vegreferanse_column", config.VegrefToCoord.VegreferanseColumn)
 
-	for _, road := range roadList {
-		ranges := roadNumbers[road]
+	case "legacy_vegref_to_coord":
+		if config.LegacyToCoord == nil {
+			fatal("legacy_vegref_to_coord configuration is not initialized", nil)
+		}
 
-		// Merge adjacent ranges for cleaner output
-		if len(ranges) > 1 {
-			mergedRanges := []roadRange{ranges[0]}
+		slog.Info("Starting conversion of legacy vegreferanse to vegsystemreferanse using NVDB API v4",
+			"input", config.InputPath, "output", config.OutputPath,
+			"vegreferanse_column", config.LegacyToCoord.VegreferanseColumn,
+			"legacy_date", config.LegacyToCoord.LegacyDate)
 
-			for i := 1; i < len(ranges); i++ {
-				lastRange := &mergedRanges[len(mergedRanges)-1]
-				currentRange := ranges[i]
+	case "vegref_range_to_geometry":
+		if config.RangeToGeom == nil {
+			fatal("vegref_range_to_geometry configuration is not initialized", nil)
+		}
 
-				// If current range starts immediately after last range ends, merge them
-				if currentRange.startRow <= lastRange.endRow+1 {
-					if currentRange.endRow > lastRange.endRow {
-						lastRange.endRow = currentRange.endRow
-					}
-				} else {
-					// Non-adjacent range, add as a new entry
-					mergedRanges = append(mergedRanges, currentRange)
-				}
-			}
+		slog.Info("Starting conversion of vegreferanse ranges to road geometry using NVDB API v4",
+			"input", config.InputPath, "output", config.OutputPath,
+			"vegreferanse_column", config.RangeToGeom.VegreferanseColumn,
+			"geometry_format", config.RangeToGeom.GeometryFormat)
 
-			ranges = mergedRanges
+	case "serve":
+		if config.Serve == nil {
+			fatal("serve configuration is not initialized", nil)
 		}
 
-		for _, r := range ranges {
-			// Add 2 to account for:
-			// 1. The header row (index 0 -> row 1)
-			// 2. Converting from 0-indexed to 1-indexed
-			fmt.Printf("%s - Rows %d-%d\n", road, r.startRow+1, r.endRow+1)
-		}
+		slog.Info("Starting HTTP server for conversions using NVDB API v4",
+			"max_request_bytes", config.Serve.MaxRequestBytes, "max_batch_size", config.Serve.MaxBatchSize,
+			"candidate_roads", config.RoadFilter.String())
 	}
-}
 
-// processFile reads, processes, and writes the results to the output file
-func processFile(inputPath, outputPath string, apiClient *VegvesenetAPIV4, config Config) error {
-	// Read input file
-	header, lines, err := readInputFile(inputPath, config)
+	// Set up recording or replaying of the API responses
+	archiveOptions, recorder, err := setupArchive(config)
 	if err != nil {
-		return err
+		fatal("Failed to set up the archive", err)
 	}
 
-	// Process based on selected mode
-	var results []processResult
-	switch config.Mode {
-	case "coord_to_vegref":
-		if config.CoordToVegref == nil {
-			return fmt.Errorf("coord_to_vegref configuration is not initialized")
+	// Create the API client using the v4 implementation
+	options := append(lookupOptions(config), nvdb.WithDiskCache(setupCache(config)))
+	options = append(options, archiveOptions...)
+	if config.Offline {
+		options = append(options, nvdb.WithOffline())
+	}
+	apiClient := nvdb.NewVegvesenetAPIV4(options...)
+	if config.Offline {
+		if apiClient.DiskCache() == nil {
+			fatal("The disk cache is required in offline mode", nil)
 		}
+		slog.Info("Offline mode: coordinate lookups are answered from the disk cache only, without network access")
+	}
 
-		fmt.Println("Converting coordinates to vegreferanse...")
-		results, err = processCoordinatesToVegreferanse(
-			lines,
-			apiClient,
-			config.Workers,
-			*config.CoordToVegref,
-			config.MaxDistance,
-		)
+	if err := setupMetrics(config, apiClient.DiskCache()); err != nil {
+		fatal("Failed to set up metrics", err)
+	}
 
-		if err != nil {
-			return err
-		}
+	// Log cache statistics if disk cache is enabled
+	if apiClient.DiskCache() != nil {
+		logCacheStats("Using disk cache", apiClient.DiskCache())
+	} else {
+		slog.Info("Disk cache is disabled")
+	}
 
-		// Apply the vegreferanse selector to improve road matching
-		applyVegreferanseSelector(results)
+	settings := []any{
+		"mode", config.Mode,
+		"srid", config.SRID,
+		"coordinate_system", nvdb.SRIDLabel(config.SRID),
+	}
+	if config.Mode != modeServe {
+		settings = append(settings, "workers", config.Workers)
+	}
+	if config.Date != "" {
+		settings = append(settings, "road_network_date", config.Date)
+	}
+	if config.ReplayPath == "" && !config.Offline {
+		settings = append(settings, "rate_limit_calls", config.RateLimit, "rate_limit_ms", config.RateLimitTime,
+			"calls_per_second", float64(config.RateLimit)*1000/float64(config.RateLimitTime))
+	}
+	slog.Info("Settings", settings...)
 
-		// Update header to add the vegreferanse column
-		header = header + "\tVegreferanse"
+	// In serve mode the shared API client serves all requests until interrupted
+	if config.Command == commandServe {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-	case "vegref_to_coord":
-		if config.VegrefToCoord == nil {
-			return fmt.Errorf("vegref_to_coord configuration is not initialized")
+		srv := server.NewServer(apiClient, *config.Serve, config.MaxDistance, config.Workers)
+		err := srv.ListenAndServe(ctx)
+		closeArchive(recorder, config)
+		writeMissingKeys(config, apiClient)
+		writeMetricsFile(config)
+		if apiClient.DiskCache() != nil {
+			logCacheLookups(apiClient.DiskCache())
 		}
-
-		fmt.Println("Converting vegreferanse to coordinates...")
-		results, err = processVegreferanseToCoordinates(
-			lines,
-			apiClient,
-			config.Workers,
-			*config.VegrefToCoord,
-		)
-
 		if err != nil {
-			return err
+			fatal("Server failed", err)
 		}
+		slog.Info("Server stopped")
+		return
+	}
 
-		// Update header to add X and Y columns
-		header = header + "\tX_UTM33\tY_UTM33"
+	tracker := setupProgress(config, apiClient)
 
-	default:
-		return fmt.Errorf("invalid mode: %s", config.Mode)
-	}
+	startTime := time.Now()
+	err = pipeline.ProcessFile(config.InputPath, config.OutputPath, apiClient, pipelineConfig(config, tracker))
+	elapsedTime := time.Since(startTime)
 
-	// Write results to output file
-	linesWritten, err := writeResults(outputPath, header, results)
 	if err != nil {
-		return err
+		slog.Error("Failed to process file", "input", config.InputPath, logging.KeyError, err)
+	} else {
+		slog.Info("Successfully processed file", "input", config.InputPath, "output", config.OutputPath, "elapsed", elapsedTime.Round(time.Millisecond).String())
 	}
 
-	fmt.Printf("Processed %d lines, wrote %d lines to %s\n", len(lines), linesWritten, outputPath)
+	closeArchive(recorder, config)
+	writeMissingKeys(config, apiClient)
+	writeMetricsFile(config)
 
-	// In coord_to_vegref mode, generate a road report
-	if config.Mode == "coord_to_vegref" {
-		// Identify road number ranges
-		roadNumbers := identifyRoadRanges(results)
-		// Generate road report
-		generateRoadReport(roadNumbers)
+	// Log final cache statistics
+	if apiClient.DiskCache() != nil {
+		logCacheLookups(apiClient.DiskCache())
+		logCacheStats("Final disk cache", apiClient.DiskCache())
 	}
 
-	return nil
+	slog.Info("Conversion completed")
 }
 
-func main() {
-	// Set custom usage text with automatic flag generation
-	flag.Usage = func() {
-		// Get the program name from os.Args[0], but use just the base name for cleaner output
-		progName := filepath.Base(os.Args[0])
-
-		fmt.Fprintf(os.Stderr, "Bidirectional conversion between UTM33 coordinates and vegreferanse\n\n")
-		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  For coord_to_vegref mode (coordinates to vegreferanse):\n")
-		fmt.Fprintf(os.Stderr, "    %s -mode=coord_to_vegref -input=<file> -output=<file> -x-column=<index> -y-column=<index> [options]\n\n", progName)
-		fmt.Fprintf(os.Stderr, "  For vegref_to_coord mode (vegreferanse to coordinates):\n")
-		fmt.Fprintf(os.Stderr, "    %s -mode=vegref_to_coord -input=<file> -output=<file> -vegreferanse-column=<index> [options]\n\n", progName)
-
-		// Group flags by category
-		requiredFlags := []string{"mode", "input", "output"}
-		modeSpecificFlags := []string{"x-column", "y-column", "vegreferanse-column"}
-
-		// Calculate the maximum flag name length for proper alignment
-		maxFlagLen := 0
-		flag.VisitAll(func(f *flag.Flag) {
-			if len(f.Name) > maxFlagLen {
-				maxFlagLen = len(f.Name)
-			}
-		})
-		// Add some padding
-		columnWidth := maxFlagLen + 4
-
-		// Print required flags
-		fmt.Fprintf(os.Stderr, "Required flags:\n")
-		flag.VisitAll(func(f *flag.Flag) {
-			if contains(requiredFlags, f.Name) {
-				fmt.Fprintf(os.Stderr, "  -%s%s%s\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage)
-			}
-		})
-		fmt.Fprintf(os.Stderr, "\n")
-
-		// Print mode-specific flags
-		fmt.Fprintf(os.Stderr, "Mode-specific flags:\n")
-		flag.VisitAll(func(f *flag.Flag) {
-			if contains(modeSpecificFlags, f.Name) {
-				fmt.Fprintf(os.Stderr, "  -%s%s%s\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage)
-			}
-		})
-		fmt.Fprintf(os.Stderr, "\n")
-
-		// Print optional flags
-		fmt.Fprintf(os.Stderr, "Optional flags:\n")
-		flag.VisitAll(func(f *flag.Flag) {
-			if !contains(requiredFlags, f.Name) && !contains(modeSpecificFlags, f.Name) {
-				defaultValue := f.DefValue
-				if defaultValue != "" && defaultValue != "false" && defaultValue != "0" {
-					fmt.Fprintf(os.Stderr, "  -%s%s%s (default: %s)\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage, defaultValue)
-				} else {
-					fmt.Fprintf(os.Stderr, "  -%s%s%s\n", f.Name, getSpaces(columnWidth-len(f.Name)), f.Usage)
-				}
-			}
-		})
-	}
-
-	// Parse command-line flags
-	config, err := parseConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error in configuration: %v\n\n", err)
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	// Print the mode-specific information
-	switch config.Mode {
-	case "coord_to_vegref":
-		if config.CoordToVegref == nil {
-			fmt.Fprintf(os.Stderr, "Error: coord_to_vegref configuration is not initialized\n")
-			os.Exit(1)
+// setupProgress returns the tracker reporting progress on stderr, fed by the API client counters
+func setupProgress(config Config, apiClient *nvdb.VegvesenetAPIV4) *progress.Tracker {
+	tracker, err := progress.New(os.Stderr, config.Progress, func() progress.Stats {
+		stats := apiClient.Stats()
+		rateLimit := 0.0
+		if apiClient.RateLimiter() != nil {
+			rateLimit = apiClient.RateLimiter().CallsPerSecond()
 		}
-
-		fmt.Println("Starting conversion of coordinates to vegreferanse using NVDB API v4...")
-		fmt.Println("Input file: ", config.InputPath)
-		fmt.Println("Output file:", config.OutputPath)
-		fmt.Printf("Coordinate columns: X=%d, Y=%d (0-based indices in tab-delimited file)\n",
-			config.CoordToVegref.XColumn, config.CoordToVegref.YColumn)
-
-	case "vegref_to_coord":
-		if config.VegrefToCoord == nil {
-			fmt.Fprintf(os.Stderr, "Error: vegref_to_coord configuration is not initialized\n")
-			os.Exit(1)
+		return progress.Stats{
+			APICalls:    stats.APICalls,
+			CacheHits:   stats.CacheHits,
+			CacheMisses: stats.CacheMisses,
+			RateLimit:   rateLimit,
 		}
-
-		fmt.Println("Starting conversion of vegreferanse to coordinates using NVDB API v4...")
-		fmt.Println("Input file: ", config.InputPath)
-		fmt.Println("Output file:", config.OutputPath)
-		fmt.Printf("Vegreferanse column: %d (0-based index in tab-delimited file)\n",
-			config.VegrefToCoord.VegreferanseColumn)
+	})
+	if err != nil {
+		fatal("Failed to set up progress reporting", err)
 	}
+	return tracker
+}
 
-	// Set up disk cache
-	cacheDirPath := setupCache(config)
+// pipelineConfig returns the settings of a file conversion
+func pipelineConfig(config Config, tracker *progress.Tracker) pipeline.Config {
+	return pipeline.Config{
+		Mode:              config.Mode,
+		Workers:           config.Workers,
+		MaxDistance:       config.MaxDistance,
+		ReportPath:        config.ReportPath,
+		RunTolerance:      config.RunTolerance,
+		QualityReportPath: config.QualityReportPath,
+		QualitySummary:    config.QualitySummary,
+		HTMLReportPath:    config.HTMLReportPath,
+		CoordToVegref:     config.CoordToVegref,
+		VegrefToCoord:     config.VegrefToCoord,
+		RangeToGeom:       config.RangeToGeom,
+		LegacyToCoord:     config.LegacyToCoord,
+		Progress:          tracker,
+	}
+}
 
-	// Create the API client using the v4 implementation
-	apiClient := NewVegvesenetAPIV4(
-		config.RateLimit,
-		time.Duration(config.RateLimitTime)*time.Millisecond,
-		cacheDirPath,
-	)
-
-	// Print cache statistics if disk cache is enabled
-	if apiClient.diskCache != nil {
-		count, size, err := apiClient.diskCache.Stats()
-		if err != nil {
-			fmt.Printf("Failed to get cache statistics: %v\n", err)
-		} else {
-			fmt.Printf("Using disk cache with %d entries (%.2f MB)\n", count, float64(size)/(1024*1024))
+// logEffectiveConfig logs the value of every setting after merging flags, environment variables
+// and the config file, together with the settings that did not come from the defaults
+func logEffectiveConfig(config Config) {
+	attrs := []any{"command", config.Command, "config_file", config.ConfigFile, "profile", config.Profile}
+	var overrides []string
+	for _, setting := range config.Settings {
+		attrs = append(attrs, setting.Name, setting.Value)
+		if setting.Source != settings.SourceDefault {
+			overrides = append(overrides, setting.Name+"="+setting.Source)
 		}
-	} else {
-		fmt.Println("Disk cache is disabled.")
 	}
+	attrs = append(attrs, "sources", strings.Join(overrides, ","))
+	slog.Info("Effective configuration", attrs...)
+}
 
-	fmt.Printf("Processing file %s using %d workers\n", config.InputPath, config.Workers)
-	fmt.Printf("Mode: %s\n", config.Mode)
-	fmt.Printf("API rate limit: %d calls per %dms (%.1f calls/second)\n",
-		config.RateLimit, config.RateLimitTime, float64(config.RateLimit)*1000/float64(config.RateLimitTime))
+// logCacheStats logs the number of entries and the size of the disk cache
+func logCacheStats(message string, diskCache *cache.DiskCache) {
+	count, size, err := diskCache.Stats()
+	if err != nil {
+		slog.Warn("Failed to get cache statistics", logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+		return
+	}
+	slog.Info(message, "entries", count, "size_mb", math.Round(float64(size)/(1024*1024)*100)/100)
+}
 
-	startTime := time.Now()
-	err = processFile(config.InputPath, config.OutputPath, apiClient, config)
-	elapsedTime := time.Since(startTime)
+// logCacheLookups logs the hits and misses of the memory and disk tiers of the cache
+func logCacheLookups(diskCache *cache.DiskCache) {
+	stats := diskCache.LookupStats()
+	slog.Info("Cache lookups", "memory_hits", stats.Memory.Hits, "memory_misses", stats.Memory.Misses,
+		"disk_hits", stats.Disk.Hits, "disk_misses", stats.Disk.Misses, "memory_entries", diskCache.MemoryEntries())
+}
 
+// fatal logs an error that stops the program and exits
+func fatal(message string, err error) {
 	if err != nil {
-		fmt.Printf("Error processing file %s: %v\n", config.InputPath, err)
+		slog.Error(message, logging.KeyError, err)
 	} else {
-		fmt.Printf("Successfully processed %s -> %s in %v\n", config.InputPath, config.OutputPath, elapsedTime)
-	}
-
-	// Print final cache statistics
-	if apiClient.diskCache != nil {
-		count, size, err := apiClient.diskCache.Stats()
-		if err != nil {
-			fmt.Printf("Failed to get cache statistics: %v\n", err)
-		} else {
-			fmt.Printf("Final disk cache: %d entries (%.2f MB)\n", count, float64(size)/(1024*1024))
-		}
+		slog.Error(message)
 	}
-
-	fmt.Println("Conversion completed.")
+	os.Exit(1)
 }
 
 // Helper function to check if a string is in a slice
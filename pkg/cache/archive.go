@@ -0,0 +1,322 @@
+// Cache Archive Component
+//
+// This component moves cache entries between machines, so that the caches built up by several
+// people can be pooled instead of every lookup being made once per person.
+//
+// Key features:
+// - Export of all entries to one portable gzip-compressed JSON lines archive with a metadata header
+// - Import of an archive and merging of another cache directory with a conflict policy
+// - Conflict policies: newest entry wins, keep the existing entry, or prefer entries with matches
+// - Entries keep their modification time, so ages survive the move
+// - Reports how many entries were added, skipped and conflicted
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// ArchiveFormat identifies cache archives in their header
+const ArchiveFormat = "vegref-cache"
+
+// ArchiveVersion is the version of the archive format written by Export
+const ArchiveVersion = 1
+
+// Conflict policies for entries that exist on both sides with different matches
+const (
+	PolicyNewest   = "newest"    // The entry written last wins
+	PolicyKeep     = "keep"      // The existing entry is kept
+	PolicyNonEmpty = "non-empty" // The entry with matches wins, the newest when both or neither have matches
+)
+
+// ArchiveHeader is the first line of a cache archive
+type ArchiveHeader struct {
+	Format  string    `json:"format"` // Always ArchiveFormat
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Source  string    `json:"source,omitempty"` // Cache directory the archive was exported from
+	Host    string    `json:"host,omitempty"`   // Machine the archive was exported on
+}
+
+// ExportEntry is a cache entry in an archive, one JSON object per line after the header
+type ExportEntry struct {
+	File     string                     `json:"file"` // Path of the cache file relative to the cache directory
+	X        float64                    `json:"x"`
+	Y        float64                    `json:"y"`
+	Variant  string                     `json:"variant,omitempty"`
+	Legacy   bool                       `json:"legacy,omitempty"` // The variant is not known, since the entry is in the legacy format
+	Modified time.Time                  `json:"modified"`
+	Matches  []vegref.VegreferanseMatch `json:"matches"`
+}
+
+// MergeReport is the result of Import and Merge
+type MergeReport struct {
+	Added      int // Entries that did not exist
+	Skipped    int // Entries that existed with the same matches
+	Conflicted int // Entries that existed with other matches, or broken
+	Replaced   int // Conflicted entries replaced according to the policy
+}
+
+// Export writes all cache entries to w as a gzip-compressed JSON lines archive, starting with an
+// ArchiveHeader. Unparsable entries are left out. Returns the number of entries written.
+func (c *DiskCache) Export(w io.Writer) (int, error) {
+	unlock, err := c.lockShared()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	gzipWriter := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gzipWriter)
+	host, _ := os.Hostname()
+	header := ArchiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, Created: time.Now().UTC(), Source: c.cacheDir, Host: host}
+	if err := encoder.Encode(header); err != nil {
+		return 0, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	exported := 0
+	err = c.walkFiles(func(path, relativePath string) error {
+		entry, err := readExportEntry(path, relativePath)
+		if err != nil {
+			slog.Warn("Skipping unparsable cache file", "path", path, logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+			return nil
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+		exported++
+		return nil
+	})
+	if err != nil {
+		return exported, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return exported, fmt.Errorf("failed to write export: %w", err)
+	}
+	return exported, nil
+}
+
+// readExportEntry reads a cache file as an archive entry
+func readExportEntry(path, relativePath string) (ExportEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ExportEntry{}, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ExportEntry{}, err
+	}
+	e, legacy, err := decodeEntry(data)
+	if err != nil {
+		return ExportEntry{}, err
+	}
+
+	entry := ExportEntry{File: relativePath, X: e.X, Y: e.Y, Variant: e.Variant, Modified: info.ModTime().UTC(), Matches: e.Matches}
+	if legacy {
+		x, y, hash, err := fileKey(relativePath)
+		if err != nil {
+			return ExportEntry{}, err
+		}
+		entry.X, entry.Y, entry.Legacy = x, y, hash != ""
+	}
+	return entry, nil
+}
+
+// Import adds the entries of an archive written by Export, resolving conflicts with existing
+// entries according to the policy
+func (c *DiskCache) Import(r io.Reader, policy string) (MergeReport, error) {
+	var report MergeReport
+	if err := checkPolicy(policy); err != nil {
+		return report, err
+	}
+
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return report, fmt.Errorf("invalid cache archive: %w", err)
+	}
+	defer gzipReader.Close()
+
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return report, fmt.Errorf("invalid cache archive: missing header: %w", cmp.Or(scanner.Err(), io.ErrUnexpectedEOF))
+	}
+	var header ArchiveHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != ArchiveFormat {
+		return report, fmt.Errorf("invalid cache archive: the first line is not a %s header", ArchiveFormat)
+	}
+	if header.Version > ArchiveVersion {
+		return report, fmt.Errorf("cache archive version %d is newer than the supported version %d", header.Version, ArchiveVersion)
+	}
+
+	unlock, err := c.lockExclusive()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	c.memory.purge()
+
+	for line := 2; scanner.Scan(); line++ {
+		var entry ExportEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return report, fmt.Errorf("invalid entry on line %d of cache archive: %w", line, err)
+		}
+		if err := c.importEntry(entry, policy, &report); err != nil {
+			return report, fmt.Errorf("failed to import entry on line %d of cache archive: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("failed to read cache archive: %w", err)
+	}
+	return report, nil
+}
+
+// Merge adds the entries of another cache directory, resolving conflicts with existing entries
+// according to the policy. Unparsable entries of the other cache are left out.
+func (c *DiskCache) Merge(source *DiskCache, policy string) (MergeReport, error) {
+	var report MergeReport
+	if err := checkPolicy(policy); err != nil {
+		return report, err
+	}
+	sourceDir, _ := filepath.Abs(source.cacheDir)
+	targetDir, _ := filepath.Abs(c.cacheDir)
+	if sourceDir == targetDir {
+		return report, errors.New("can not merge a cache directory into itself")
+	}
+
+	// Lock the directories in the order of their paths, so that two merges in opposite directions
+	// can not each hold one directory while waiting for the other
+	lockFirst, lockSecond := source.lockShared, c.lockExclusive
+	if targetDir < sourceDir {
+		lockFirst, lockSecond = c.lockExclusive, source.lockShared
+	}
+	unlockFirst, err := lockFirst()
+	if err != nil {
+		return report, err
+	}
+	defer unlockFirst()
+	unlockSecond, err := lockSecond()
+	if err != nil {
+		return report, err
+	}
+	defer unlockSecond()
+	c.memory.purge()
+
+	err = source.walkFiles(func(path, relativePath string) error {
+		entry, err := readExportEntry(path, relativePath)
+		if err != nil {
+			slog.Warn("Skipping unparsable cache file", "path", path, logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+			return nil
+		}
+		return c.importEntry(entry, policy, &report)
+	})
+	return report, err
+}
+
+// importEntry writes an entry unless an existing entry wins according to the policy. The caller
+// must hold the exclusive lock.
+func (c *DiskCache) importEntry(entry ExportEntry, policy string, report *MergeReport) error {
+	if !filepath.IsLocal(entry.File) || !strings.HasSuffix(entry.File, ".json") {
+		return fmt.Errorf("invalid cache file path %q", entry.File)
+	}
+	if _, _, _, err := fileKey(entry.File); err != nil {
+		return err
+	}
+	path := filepath.Join(c.cacheDir, filepath.FromSlash(entry.File))
+
+	data, err := encodeExportEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		report.Added++
+	case err != nil:
+		return fmt.Errorf("failed to read cache file: %w", err)
+	default:
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		e, _, decodeErr := decodeEntry(existing)
+		if decodeErr == nil && sameMatches(e.Matches, entry.Matches) {
+			report.Skipped++
+			return nil
+		}
+		report.Conflicted++
+		// A broken entry is always replaced
+		if decodeErr == nil && !replaces(entry, info.ModTime(), e.Matches, policy) {
+			return nil
+		}
+		report.Replaced++
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache subdirectory: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return os.Chtimes(path, entry.Modified, entry.Modified)
+}
+
+// replaces reports whether an incoming entry replaces an existing entry with other matches
+func replaces(entry ExportEntry, existingModified time.Time, existingMatches []vegref.VegreferanseMatch, policy string) bool {
+	switch policy {
+	case PolicyKeep:
+		return false
+	case PolicyNonEmpty:
+		if (len(entry.Matches) > 0) != (len(existingMatches) > 0) {
+			return len(entry.Matches) > 0
+		}
+	}
+	return entry.Modified.After(existingModified)
+}
+
+// encodeExportEntry returns the cache file content of an archive entry
+func encodeExportEntry(entry ExportEntry) ([]byte, error) {
+	var data []byte
+	var err error
+	if entry.Legacy {
+		data, err = json.Marshal(entry.Matches)
+	} else {
+		data, err = json.Marshal(cacheFile{X: entry.X, Y: entry.Y, Variant: entry.Variant, Matches: entry.Matches})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize matches: %w", err)
+	}
+	return data, nil
+}
+
+// sameMatches reports whether two entries hold the same matches
+func sameMatches(a, b []vegref.VegreferanseMatch) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// checkPolicy returns an error for an unknown conflict policy
+func checkPolicy(policy string) error {
+	switch policy {
+	case PolicyNewest, PolicyKeep, PolicyNonEmpty:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q, must be one of %s, %s or %s", policy, PolicyNewest, PolicyKeep, PolicyNonEmpty)
+}
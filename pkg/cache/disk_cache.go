@@ -0,0 +1,376 @@
+// Disk Cache Component
+//
+// This component provides persistent caching of API responses to improve performance
+// and reduce the number of API calls needed.
+//
+// Key features:
+// - File-based caching of vegreferanse data indexed by coordinates and query variant (e.g. SRID)
+// - Safe for concurrent use by goroutines and by processes sharing one cache directory
+// - Crash-safe writes that replace entries atomically
+// - Bounded in-memory LRU tier in front of the files, with hit and miss counters per tier
+// - Organizes cache files in subdirectories to prevent too many files in a single directory
+// - Provides methods to get, set, clear and prune cache entries and retrieve cache statistics
+// - Stores the key of each lookup with its matches, while still reading the bare matches of earlier versions
+// - Helps stay within API rate limits by reducing the need for repeated API calls
+// - Counts lookups and writes as metrics
+
+package cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Metrics for cache lookups and writes
+var (
+	cacheLookups = metrics.NewCounterVec("vegref_cache_lookups_total",
+		"Disk cache lookups by result (hit, miss or error).", "result")
+	cacheWrites = metrics.NewCounterVec("vegref_cache_writes_total",
+		"Disk cache writes by result (ok or error).", "result")
+	memoryLookups = metrics.NewCounterVec("vegref_cache_memory_lookups_total",
+		"Memory tier lookups by result (hit or miss). Misses continue to the disk cache.", "result")
+)
+
+// cacheFile is the format of a cache file. It holds the key of the lookup together with the matches,
+// so that the entry can be looked up again. Earlier versions wrote the matches as a bare JSON
+// array, which is still read as the legacy format.
+type cacheFile struct {
+	X       float64                    `json:"x"`
+	Y       float64                    `json:"y"`
+	Variant string                     `json:"variant,omitempty"`
+	Matches []vegref.VegreferanseMatch `json:"matches"`
+}
+
+// errEmptyEntry is returned for cache files without content
+var errEmptyEntry = errors.New("empty cache file")
+
+// decodeEntry parses a cache file in the current or the legacy format. For the legacy format only
+// the matches are set.
+func decodeEntry(data []byte) (cacheFile, bool, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return cacheFile{}, false, errEmptyEntry
+	}
+
+	var e cacheFile
+	if data[0] != '{' {
+		err := json.Unmarshal(data, &e.Matches)
+		return e, true, err
+	}
+	err := json.Unmarshal(data, &e)
+	return e, false, err
+}
+
+// DiskCache implements a persistent cache for API responses
+type DiskCache struct {
+	cacheDir string
+	mu       sync.RWMutex
+	memory   *memoryCache // Most recently used entries, nil when the memory tier is disabled
+
+	// Lookup counters of the disk tier
+	diskHits   atomic.Int64
+	diskMisses atomic.Int64
+
+	// Result of the last walk of the cache directory, reused by CachedStats
+	statsMu    sync.Mutex
+	statsTime  time.Time
+	statsCount int
+	statsSize  int64
+}
+
+// Option configures the disk cache
+type Option func(*DiskCache)
+
+// WithMemoryEntries keeps up to entries of the most recently used entries in memory. Zero disables
+// the memory tier. Without this option DefaultMemoryEntries entries are kept.
+func WithMemoryEntries(entries int) Option {
+	return func(c *DiskCache) {
+		c.memory = newMemoryCache(entries)
+	}
+}
+
+// NewDiskCache creates a new disk cache at the specified directory
+func NewDiskCache(cacheDir string, opts ...Option) (*DiskCache, error) {
+	// Create cache directory if it doesn't exist
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &DiskCache{
+		cacheDir: cacheDir,
+		memory:   newMemoryCache(DefaultMemoryEntries),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// LookupStats returns the hit and miss counters of the memory and disk tiers
+func (c *DiskCache) LookupStats() LookupStats {
+	return LookupStats{
+		Memory: c.memory.stats(),
+		Disk:   TierStats{Hits: c.diskHits.Load(), Misses: c.diskMisses.Load()},
+	}
+}
+
+// MemoryEntries returns the number of entries in the memory tier
+func (c *DiskCache) MemoryEntries() int {
+	return c.memory.len()
+}
+
+// getCacheFilePath creates a cache file path from coordinates and query variant.
+// The variant holds the query parameters that affect the result; an empty variant
+// maps to the original file naming so existing cache entries stay valid.
+// The subdirectory is created by the writes, so that reads never change the cache directory.
+func (c *DiskCache) getCacheFilePath(x, y float64, variant string) string {
+	// Format coordinates to 6 decimal places
+	key := fmt.Sprintf("%.6f,%.6f", x, y)
+
+	// Replace any characters that might be invalid in filenames
+	safeKey := strings.ReplaceAll(key, ",", "_")
+
+	// Distinguish non-default query variants with a short hash of the variant
+	if variant != "" {
+		safeKey += "_" + variantHash(variant)
+	}
+
+	// Group files in subdirectories based on first 4 digits of X coordinate
+	// This prevents having too many files in a single directory
+	prefix := safeKey[:4]
+
+	return filepath.Join(c.cacheDir, prefix, safeKey+".json")
+}
+
+// variantHash returns the short hash of a query variant used in the cache file names
+func variantHash(variant string) string {
+	hash := sha1.Sum([]byte(variant))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// Get retrieves the cached VegreferanseMatches for the given coordinates and query variant,
+// from memory when the entry was used recently and from disk otherwise
+// Returns nil and false if no cache entry exists
+func (c *DiskCache) Get(x, y float64, variant string) ([]vegref.VegreferanseMatch, bool) {
+	filePath := c.getCacheFilePath(x, y, variant)
+
+	if c.memory != nil {
+		if matches, found := c.memory.get(filePath); found {
+			memoryLookups.Inc("hit")
+			return slices.Clone(matches), true
+		}
+		memoryLookups.Inc("miss")
+	}
+
+	// Entries are replaced atomically, so reading needs no lock on the cache directory
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Read file
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		c.diskMisses.Add(1)
+		cacheLookups.Inc("miss")
+		return nil, false
+	}
+	if err != nil {
+		slog.Warn("Failed to read cache file", "path", filePath, logging.KeyCoordinate, logging.Coordinate(x, y),
+			logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+		c.diskMisses.Add(1)
+		cacheLookups.Inc("error")
+		return nil, false
+	}
+
+	// Parse JSON
+	e, _, err := decodeEntry(data)
+	if err != nil {
+		slog.Warn("Failed to parse cache file", "path", filePath, logging.KeyCoordinate, logging.Coordinate(x, y),
+			logging.KeyErrorCategory, logging.CategoryCache, logging.KeyError, err)
+		c.diskMisses.Add(1)
+		cacheLookups.Inc("error")
+		return nil, false
+	}
+
+	c.diskHits.Add(1)
+	cacheLookups.Inc("hit")
+	c.memory.put(filePath, slices.Clone(e.Matches))
+	return e.Matches, true
+}
+
+// Set saves VegreferanseMatches to cache for the given coordinates and query variant
+func (c *DiskCache) Set(x, y float64, variant string, matches []vegref.VegreferanseMatch) error {
+	err := c.set(x, y, variant, matches)
+	if err != nil {
+		cacheWrites.Inc("error")
+	} else {
+		cacheWrites.Inc("ok")
+	}
+	return err
+}
+
+// set writes the cache file for Set
+func (c *DiskCache) set(x, y float64, variant string, matches []vegref.VegreferanseMatch) error {
+	unlock, err := c.lockShared()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	filePath := c.getCacheFilePath(x, y, variant)
+
+	// Convert the entry to JSON
+	data, err := json.Marshal(cacheFile{X: x, Y: y, Variant: variant, Matches: matches})
+	if err != nil {
+		return fmt.Errorf("failed to serialize matches: %w", err)
+	}
+
+	// Create directories if needed
+	dirPath := filepath.Dir(filePath)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create cache subdirectory: %w", err)
+	}
+
+	// Write to a temporary file and rename it into place, so that a crash never leaves a partial entry
+	if err := writeFileAtomic(filePath, data); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	c.memory.put(filePath, slices.Clone(matches))
+	return nil
+}
+
+// Clear removes all cached entries, keeping the cache directory and its lock file
+func (c *DiskCache) Clear() error {
+	unlock, err := c.lockExclusive()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	c.memory.purge()
+
+	entries, err := os.ReadDir(c.cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == lockFileName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.cacheDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes cache entries that were written longer ago than olderThan, together with
+// subdirectories left empty. Returns the number of entries removed.
+func (c *DiskCache) Prune(olderThan time.Duration) (int, error) {
+	unlock, err := c.lockExclusive()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	c.memory.purge()
+
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	err = filepath.WalkDir(c.cacheDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Temporary files left behind by interrupted writes are removed as well
+		tempFile := isTempFile(entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(path, ".json") && !tempFile {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove cache file: %w", err)
+			}
+			if !tempFile {
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	// Remove the subdirectories that are now empty
+	subDirs, err := os.ReadDir(c.cacheDir)
+	if err != nil {
+		return removed, err
+	}
+	for _, subDir := range subDirs {
+		if subDir.IsDir() {
+			_ = os.Remove(filepath.Join(c.cacheDir, subDir.Name())) // Fails for directories that are not empty
+		}
+	}
+	return removed, nil
+}
+
+// Stats returns cache statistics
+func (c *DiskCache) Stats() (int, int64, error) {
+	unlock, err := c.lockShared()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	var count int
+	var totalSize int64
+
+	err = filepath.Walk(c.cacheDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".json") {
+			count++
+			totalSize += info.Size()
+		}
+		return nil
+	})
+
+	return count, totalSize, err
+}
+
+// CachedStats returns the cache statistics of Stats, walking the cache directory at most once per
+// maxAge. Callers asking for the statistics at the same time share one walk, so that frequent
+// readers such as metrics scrapes do not hold the cache lock for a walk each.
+func (c *DiskCache) CachedStats(maxAge time.Duration) (int, int64, error) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if !c.statsTime.IsZero() && time.Since(c.statsTime) < maxAge {
+		return c.statsCount, c.statsSize, nil
+	}
+	count, size, err := c.Stats()
+	if err != nil {
+		return 0, 0, err
+	}
+	c.statsTime, c.statsCount, c.statsSize = time.Now(), count, size
+	return count, size, nil
+}
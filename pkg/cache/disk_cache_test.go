@@ -0,0 +1,474 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// TestPruneAndExport tests removing old entries and exporting the remaining ones
+func TestPruneAndExport(t *testing.T) {
+	diskCache, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	match := vegref.VegreferanseMatch{Avstand: 1.5}
+	match.Vegsystemreferanse.Kortform = "EV6 S1D1 m10"
+	if err := diskCache.Set(262000, 6650000, "", []vegref.VegreferanseMatch{match}); err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+	if err := diskCache.Set(100000, 7000000, "", []vegref.VegreferanseMatch{}); err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+
+	// Age the second entry
+	oldPath := diskCache.getCacheFilePath(100000, 7000000, "")
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(oldPath, old, old); err != nil {
+		t.Fatalf("Failed to age entry: %v", err)
+	}
+
+	removed, err := diskCache.Prune(24 * time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 pruned entry, got %d, %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Dir(oldPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty subdirectory to be removed, got %v", err)
+	}
+
+	var out bytes.Buffer
+	exported, err := diskCache.Export(&out)
+	if err != nil || exported != 1 {
+		t.Fatalf("Expected 1 exported entry, got %d, %v", exported, err)
+	}
+
+	gzipReader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("Invalid archive: %v", err)
+	}
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Scan()
+	var header ArchiveHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != ArchiveFormat || header.Version != ArchiveVersion {
+		t.Fatalf("Invalid archive header %s: %v", scanner.Bytes(), err)
+	}
+	scanner.Scan()
+	var entry ExportEntry
+	if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid export line: %v", err)
+	}
+	if entry.File != "2620/262000.000000_6650000.000000.json" || len(entry.Matches) != 1 || entry.Matches[0].Vegsystemreferanse.Kortform != "EV6 S1D1 m10" {
+		t.Errorf("Unexpected export entry %+v", entry)
+	}
+}
+
+// TestImportAndMerge tests moving entries between caches with each conflict policy
+func TestImportAndMerge(t *testing.T) {
+	newCache := func() *DiskCache {
+		diskCache, err := NewDiskCache(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		return diskCache
+	}
+	set := func(diskCache *DiskCache, x float64, matches []vegref.VegreferanseMatch, modified time.Time) {
+		if err := diskCache.Set(x, 6650000, "srid=4326", matches); err != nil {
+			t.Fatalf("Failed to set entry: %v", err)
+		}
+		if err := os.Chtimes(diskCache.getCacheFilePath(x, 6650000, "srid=4326"), modified, modified); err != nil {
+			t.Fatalf("Failed to age entry: %v", err)
+		}
+	}
+	older := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	newer := time.Now().Add(-time.Hour).Truncate(time.Second)
+	found := []vegref.VegreferanseMatch{{Avstand: 1}}
+	other := []vegref.VegreferanseMatch{{Avstand: 2}}
+	none := []vegref.VegreferanseMatch{}
+
+	// The source has a new entry, an identical entry, a newer entry and a newer empty entry
+	source := newCache()
+	set(source, 100000, found, newer)
+	set(source, 200000, found, newer)
+	set(source, 300000, other, newer)
+	set(source, 400000, none, newer)
+
+	var archive bytes.Buffer
+	if _, err := source.Export(&archive); err != nil {
+		t.Fatalf("Failed to export cache: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		expected MergeReport
+		avstand  float64 // Avstand of the entry at 300000 after the merge
+		empty    bool    // Whether the entry at 400000 is empty after the merge
+	}{
+		{"Newest", PolicyNewest, MergeReport{Added: 1, Skipped: 1, Conflicted: 2, Replaced: 2}, 2, true},
+		{"Keep", PolicyKeep, MergeReport{Added: 1, Skipped: 1, Conflicted: 2}, 1, false},
+		{"NonEmpty", PolicyNonEmpty, MergeReport{Added: 1, Skipped: 1, Conflicted: 2, Replaced: 1}, 2, false},
+	}
+	for _, tt := range tests {
+		for _, merge := range []bool{false, true} {
+			target := newCache()
+			set(target, 200000, found, older)
+			set(target, 300000, found, older)
+			set(target, 400000, found, older)
+
+			var report MergeReport
+			var err error
+			if merge {
+				report, err = target.Merge(source, tt.policy)
+			} else {
+				report, err = target.Import(bytes.NewReader(archive.Bytes()), tt.policy)
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+			if report != tt.expected {
+				t.Errorf("%s (merge %v): expected %+v, got %+v", tt.name, merge, tt.expected, report)
+			}
+
+			if matches, ok := target.Get(100000, 6650000, "srid=4326"); !ok || len(matches) != 1 {
+				t.Errorf("%s: expected the new entry to be added, got %v, %v", tt.name, matches, ok)
+			}
+			if matches, _ := target.Get(300000, 6650000, "srid=4326"); len(matches) != 1 || matches[0].Avstand != tt.avstand {
+				t.Errorf("%s: expected avstand %v, got %v", tt.name, tt.avstand, matches)
+			}
+			if matches, _ := target.Get(400000, 6650000, "srid=4326"); (len(matches) == 0) != tt.empty {
+				t.Errorf("%s: expected empty %v, got %v", tt.name, tt.empty, matches)
+			}
+			info, _ := os.Stat(target.getCacheFilePath(100000, 6650000, "srid=4326"))
+			if !info.ModTime().Equal(newer) {
+				t.Errorf("%s: expected the modification time to be kept, got %v", tt.name, info.ModTime())
+			}
+		}
+	}
+
+	target := newCache()
+	if _, err := target.Import(bytes.NewReader([]byte("not an archive")), PolicyNewest); err == nil {
+		t.Error("Expected an error for an invalid archive")
+	}
+	if _, err := target.Merge(target, PolicyNewest); err == nil {
+		t.Error("Expected an error for merging a cache into itself")
+	}
+	if _, err := target.Merge(source, "unknown"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// TestOppositeMerges tests that merges in opposite directions at the same time do not deadlock
+func TestOppositeMerges(t *testing.T) {
+	var caches [2]*DiskCache
+	for i := range caches {
+		diskCache, err := NewDiskCache(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		if err := diskCache.Set(float64(100000+i), 6650000, "", []vegref.VegreferanseMatch{{Avstand: 1}}); err != nil {
+			t.Fatalf("Failed to set entry: %v", err)
+		}
+		caches[i] = diskCache
+	}
+	lower, higher := caches[0], caches[1]
+	if higher.cacheDir < lower.cacheDir {
+		lower, higher = higher, lower
+	}
+
+	// While the lower directory is locked, start a merge into it and then a merge out of it. Had
+	// each merge locked its source first, they would each hold one directory once it is released.
+	unlock, err := lower.lockExclusive()
+	if err != nil {
+		t.Fatalf("Failed to lock cache: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := lower.Merge(higher, PolicyNewest)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		_, err := higher.Merge(lower, PolicyNewest)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	unlock()
+
+	for range 2 {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Merges in opposite directions deadlocked")
+		}
+	}
+	for _, diskCache := range caches {
+		if count, _, _ := diskCache.Stats(); count != 2 {
+			t.Errorf("Expected both entries in each cache, got %d", count)
+		}
+	}
+}
+
+// fakeFetcher looks up coordinates by storing a fixed match in the cache
+type fakeFetcher struct {
+	cache   *DiskCache
+	variant string
+	fetched int
+}
+
+func (f *fakeFetcher) CacheVariant() string {
+	return f.variant
+}
+
+func (f *fakeFetcher) GetVegreferanseMatches(x, y float64) ([]vegref.VegreferanseMatch, error) {
+	f.fetched++
+	matches := []vegref.VegreferanseMatch{{Avstand: 2}}
+	return matches, f.cache.Set(x, y, f.variant, matches)
+}
+
+// TestVerifyRepairCompact tests finding and fixing corrupt, empty and legacy-format entries
+func TestVerifyRepairCompact(t *testing.T) {
+	cacheDir := t.TempDir()
+	diskCache, err := NewDiskCache(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	if err := diskCache.Set(262000, 6650000, "", []vegref.VegreferanseMatch{{Avstand: 1}}); err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+	writeFile := func(x, y float64, variant, content string) string {
+		path := diskCache.getCacheFilePath(x, y, variant)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create cache subdirectory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write cache file: %v", err)
+		}
+		return path
+	}
+	legacyPath := writeFile(300000, 6700000, "", `[{"avstand": 3}]`)
+	writeFile(300001, 6700000, "srid=4326", `[]`) // Legacy entry of a variant that is not known
+	writeFile(400000, 6800000, "", `{"x": 400000, "y": 68`)
+	writeFile(500000, 6900000, "srid=4326", "")
+
+	// Legacy entries are still read
+	if matches, found := diskCache.Get(300000, 6700000, ""); !found || len(matches) != 1 || matches[0].Avstand != 3 {
+		t.Errorf("Expected the legacy entry to be read, got %v, %v", matches, found)
+	}
+
+	report, err := diskCache.Verify()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Entries != 5 || report.Count(StatusLegacy) != 2 || report.Count(StatusCorrupt) != 1 || report.Count(StatusEmpty) != 1 {
+		t.Errorf("Unexpected verify report %+v", report)
+	}
+
+	fetcher := &fakeFetcher{cache: diskCache, variant: "srid=4326"}
+	repairReport, err := diskCache.Repair(fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repairReport != (RepairReport{Deleted: 1, Refetched: 1}) || fetcher.fetched != 1 {
+		t.Errorf("Expected the empty entry to be fetched again and the corrupt one deleted, got %+v", repairReport)
+	}
+	if matches, found := diskCache.Get(500000, 6900000, "srid=4326"); !found || len(matches) != 1 {
+		t.Errorf("Expected the fetched entry to be cached, got %v, %v", matches, found)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(legacyPath, old, old); err != nil {
+		t.Fatalf("Failed to age entry: %v", err)
+	}
+	compactReport, err := diskCache.Compact()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if compactReport != (CompactReport{Rewritten: 1, Skipped: 1}) {
+		t.Errorf("Unexpected compact report %+v", compactReport)
+	}
+	data, _ := os.ReadFile(legacyPath)
+	if e, legacy, err := decodeEntry(data); err != nil || legacy || e.X != 300000 || e.Y != 6700000 || len(e.Matches) != 1 {
+		t.Errorf("Unexpected compacted entry %s", data)
+	}
+	if info, _ := os.Stat(legacyPath); !info.ModTime().Equal(old) {
+		t.Errorf("Expected the modification time to be kept, got %v", info.ModTime())
+	}
+
+	if compactReport, _ := diskCache.Compact("srid=4326"); compactReport != (CompactReport{Rewritten: 1}) {
+		t.Errorf("Expected the legacy entry of a known variant to be rewritten, got %+v", compactReport)
+	}
+	if report, _ := diskCache.Verify(); len(report.Issues) != 0 {
+		t.Errorf("Expected no issues after repair and compact, got %+v", report.Issues)
+	}
+}
+
+// TestSharedCacheDirectory tests two caches on one directory, like two processes sharing a cache
+func TestSharedCacheDirectory(t *testing.T) {
+	cacheDir := t.TempDir()
+	first, err := NewDiskCache(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	second, err := NewDiskCache(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	// Writes wait for the maintenance of the other cache to finish
+	unlock, err := first.lockExclusive()
+	if err != nil {
+		t.Fatalf("Failed to lock cache: %v", err)
+	}
+	written := make(chan error)
+	go func() {
+		written <- second.Set(262000, 6650000, "", []vegref.VegreferanseMatch{})
+	}()
+	select {
+	case <-written:
+		t.Fatal("Expected the write to wait for the exclusive lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-written; err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+
+	// Concurrent writes and clears never leave partial entries or temporary files
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			diskCache := []*DiskCache{first, second}[i%2]
+			for j := range 50 {
+				if err := diskCache.Set(float64(262000+j), 6650000, "", []vegref.VegreferanseMatch{{Avstand: float64(i)}}); err != nil {
+					t.Errorf("Failed to set entry: %v", err)
+					return
+				}
+				if i == 0 && j%10 == 0 {
+					if err := first.Clear(); err != nil {
+						t.Errorf("Failed to clear cache: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	report, err := second.Verify()
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("Expected no broken entries, got %+v, %v", report, err)
+	}
+	err = filepath.WalkDir(cacheDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && isTempFile(entry.Name()) {
+			t.Errorf("Temporary file left behind: %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk cache directory: %v", err)
+	}
+}
+
+// TestMemoryTier tests the LRU memory tier in front of the files and the lookup counters per tier
+func TestMemoryTier(t *testing.T) {
+	cacheDir := t.TempDir()
+	diskCache, err := NewDiskCache(cacheDir, WithMemoryEntries(2))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	for i := range 3 {
+		if err := diskCache.Set(float64(262000+i), 6650000, "", []vegref.VegreferanseMatch{{Avstand: float64(i)}}); err != nil {
+			t.Fatalf("Failed to set entry: %v", err)
+		}
+	}
+	if entries := diskCache.MemoryEntries(); entries != 2 {
+		t.Errorf("Expected 2 entries in memory, got %d", entries)
+	}
+
+	// The first entry was evicted and is read from disk, the last one is answered from memory
+	if matches, found := diskCache.Get(262000, 6650000, ""); !found || matches[0].Avstand != 0 {
+		t.Errorf("Expected the evicted entry from disk, got %v, %v", matches, found)
+	}
+	matches, found := diskCache.Get(262002, 6650000, "")
+	if !found || matches[0].Avstand != 2 {
+		t.Errorf("Expected the entry from memory, got %v, %v", matches, found)
+	}
+	matches[0].Avstand = 100 // Changing the returned matches leaves the cached ones alone
+	if matches, _ := diskCache.Get(262002, 6650000, ""); matches[0].Avstand != 2 {
+		t.Errorf("Expected the cached entry to be unchanged, got %v", matches)
+	}
+
+	// Reading a missing entry creates no subdirectory
+	if _, found := diskCache.Get(100000, 7000000, ""); found {
+		t.Error("Expected a miss")
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "1000")); !os.IsNotExist(err) {
+		t.Errorf("Expected no subdirectory to be created by a read, got %v", err)
+	}
+
+	expected := LookupStats{Memory: TierStats{Hits: 2, Misses: 2}, Disk: TierStats{Hits: 1, Misses: 1}}
+	if stats := diskCache.LookupStats(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+
+	// Maintenance empties the memory tier, so that removed entries are not answered from memory
+	if err := diskCache.Clear(); err != nil {
+		t.Fatalf("Failed to clear cache: %v", err)
+	}
+	if _, found := diskCache.Get(262002, 6650000, ""); found || diskCache.MemoryEntries() != 0 {
+		t.Errorf("Expected the memory tier to be cleared, got %v with %d entries", found, diskCache.MemoryEntries())
+	}
+
+	disabled, err := NewDiskCache(cacheDir, WithMemoryEntries(0))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	disabled.Get(262000, 6650000, "")
+	if stats := disabled.LookupStats(); stats.Memory != (TierStats{}) || stats.Disk.Misses != 1 {
+		t.Errorf("Expected only disk lookups without the memory tier, got %+v", stats)
+	}
+}
+
+// TestCachedStats tests that the cached statistics are reused until they are too old
+func TestCachedStats(t *testing.T) {
+	diskCache, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	set := func(x float64) {
+		if err := diskCache.Set(x, 6650000, "", []vegref.VegreferanseMatch{{Avstand: 1}}); err != nil {
+			t.Fatalf("Failed to set entry: %v", err)
+		}
+	}
+
+	set(262000)
+	if count, size, err := diskCache.CachedStats(time.Hour); err != nil || count != 1 || size == 0 {
+		t.Fatalf("Expected 1 entry, got %d entries of %d bytes, %v", count, size, err)
+	}
+
+	// A new entry is not seen until the statistics are older than the maximum age
+	set(262001)
+	if count, _, _ := diskCache.CachedStats(time.Hour); count != 1 {
+		t.Errorf("Expected the cached count 1, got %d", count)
+	}
+	if count, _, _ := diskCache.CachedStats(0); count != 2 {
+		t.Errorf("Expected a new walk to count 2 entries, got %d", count)
+	}
+}
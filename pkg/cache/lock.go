@@ -0,0 +1,177 @@
+// Cache Locking Component
+//
+// This component coordinates the processes sharing one cache directory, for example several
+// converters running against a cache on a network share.
+//
+// Key features:
+// - Advisory lock on a lock file in the cache directory, shared by all processes: a POSIX record
+//   lock (fcntl) on Unix, which NFS and SMB mounts pass on to the file server, and LockFileEx on
+//   Windows
+// - Shared locks for writing and reading entries, which are safe together since every entry is
+//   replaced atomically
+// - Exclusive locks for maintenance that removes or rewrites many entries, such as prune and clear
+// - Combined with in-process mutexes, so goroutines, caches of one process and processes follow
+//   the same rules
+// - Atomic writes through a temporary file renamed into place, so a crash never leaves a
+//   truncated entry
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// lockFileName is the name of the lock file in the cache directory
+const lockFileName = ".lock"
+
+// tempFileSuffix is the suffix of the temporary files of writes in progress
+const tempFileSuffix = ".tmp"
+
+// lockShared takes the in-process read lock and a shared lock on the cache directory. The
+// returned function releases both.
+func (c *DiskCache) lockShared() (func(), error) {
+	c.mu.RLock()
+	unlock, err := c.lockDir(false)
+	if err != nil {
+		c.mu.RUnlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		c.mu.RUnlock()
+	}, nil
+}
+
+// lockExclusive takes the in-process write lock and an exclusive lock on the cache directory,
+// waiting for the other processes to release theirs. The returned function releases both.
+func (c *DiskCache) lockExclusive() (func(), error) {
+	c.mu.Lock()
+	unlock, err := c.lockDir(true)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		c.mu.Unlock()
+	}, nil
+}
+
+// dirLock is the lock of one cache directory, shared by all caches of this process on that
+// directory. File record locks belong to a process rather than to a file descriptor: they do not
+// exclude the other caches of the same process, and closing any descriptor of the lock file
+// releases them all. So the caches of a process first agree among themselves through mu, and the
+// file lock is taken once, by the first holder, and released by the last.
+type dirLock struct {
+	mu      sync.RWMutex // Held by the holders of this process, shared or exclusive
+	state   sync.Mutex   // Guards holders and file
+	holders int
+	file    *os.File
+}
+
+// dirLocks holds the lock of each cache directory locked by this process, by lock file path
+var (
+	dirLocksMu sync.Mutex
+	dirLocks   = make(map[string]*dirLock)
+)
+
+// lockDir takes an advisory lock on the lock file of the cache directory, coordinating with the
+// other caches of this process and with other processes
+func (c *DiskCache) lockDir(exclusive bool) (func(), error) {
+	if err := os.MkdirAll(c.cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	path, err := filepath.Abs(filepath.Join(c.cacheDir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache lock file: %w", err)
+	}
+
+	dirLocksMu.Lock()
+	lock, ok := dirLocks[path]
+	if !ok {
+		lock = &dirLock{}
+		dirLocks[path] = lock
+	}
+	dirLocksMu.Unlock()
+
+	if exclusive {
+		lock.mu.Lock()
+	} else {
+		lock.mu.RLock()
+	}
+	release := func() {
+		if exclusive {
+			lock.mu.Unlock()
+		} else {
+			lock.mu.RUnlock()
+		}
+	}
+
+	lock.state.Lock()
+	defer lock.state.Unlock()
+	if lock.holders == 0 {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed to open cache lock file: %w", err)
+		}
+		if err := lockFile(file, exclusive); err != nil {
+			file.Close()
+			release()
+			return nil, fmt.Errorf("failed to lock cache directory: %w", err)
+		}
+		lock.file = file
+	}
+	lock.holders++
+
+	return func() {
+		lock.state.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			_ = unlockFile(lock.file)
+			lock.file.Close()
+			lock.file = nil
+		}
+		lock.state.Unlock()
+		release()
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path and renames it into
+// place, so that readers see either the old or the new content and never a partial write
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// isTempFile reports whether a file name is a temporary file of a write, which is left behind
+// when a process is killed while writing
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
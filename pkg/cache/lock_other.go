@@ -0,0 +1,20 @@
+//go:build !unix && !windows
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile fails on platforms without file locks, since the cache directory could not be shared
+// safely with other processes
+func lockFile(file *os.File, exclusive bool) error {
+	return fmt.Errorf("locking the cache directory is not supported on %s, use -no-cache", runtime.GOOS)
+}
+
+// unlockFile does nothing on platforms without file locks
+func unlockFile(file *os.File) error {
+	return nil
+}
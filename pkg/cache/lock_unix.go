@@ -0,0 +1,31 @@
+//go:build unix
+
+package cache
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory POSIX record lock on the whole file, waiting until it is available.
+// Unlike flock, record locks are passed on to the server by NFS and by SMB mounts with the Unix
+// extensions, so they also coordinate processes on different machines.
+func lockFile(file *os.File, exclusive bool) error {
+	lock := syscall.Flock_t{Type: syscall.F_RDLCK, Whence: io.SeekStart}
+	if exclusive {
+		lock.Type = syscall.F_WRLCK
+	}
+	for {
+		err := syscall.FcntlFlock(file.Fd(), syscall.F_SETLKW, &lock)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases the record lock on the file
+func unlockFile(file *os.File) error {
+	lock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+	return syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the length of the byte range locked, covering the whole file
+const lockRange = ^uint32(0)
+
+// lockFile takes a LockFileEx lock on the file, waiting until it is available. The lock is
+// enforced by the file server for caches on an SMB share.
+func lockFile(file *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, lockRange, lockRange, new(windows.Overlapped))
+}
+
+// unlockFile releases the lock on the file
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockRange, lockRange, new(windows.Overlapped))
+}
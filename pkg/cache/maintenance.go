@@ -0,0 +1,269 @@
+// Cache Maintenance Component
+//
+// This component finds and fixes cache files that lookups can not use. A lookup treats an
+// unreadable cache file as a miss, so without maintenance a corrupt file stays on disk and costs
+// an API call every time its coordinate is looked up.
+//
+// Key features:
+// - Verify reports corrupt, empty and legacy-format entries
+// - Repair deletes corrupt and empty entries, looking them up again when a fetcher is given
+// - Compact rewrites legacy-format entries into the current format, keeping their age
+// - The key of a legacy entry is recovered from its file name
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Statuses of the entries reported by Verify
+const (
+	StatusCorrupt = "corrupt" // The file can not be parsed
+	StatusEmpty   = "empty"   // The file has no content
+	StatusLegacy  = "legacy"  // The file holds the bare matches written by earlier versions
+)
+
+// Issue is a cache entry reported by Verify
+type Issue struct {
+	File   string // Path of the cache file relative to the cache directory
+	Status string // One of the Status constants
+	Err    error  // Parse error of a corrupt entry
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Entries int     // Number of cache files checked
+	Issues  []Issue // Entries that are not in the current format
+}
+
+// Count returns the number of issues with the given status
+func (r VerifyReport) Count(status string) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// RepairReport is the result of Repair
+type RepairReport struct {
+	Deleted   int // Corrupt and empty entries deleted without looking them up again
+	Refetched int // Corrupt and empty entries replaced by a new lookup
+	Failed    int // Corrupt and empty entries deleted whose new lookup failed
+}
+
+// CompactReport is the result of Compact
+type CompactReport struct {
+	Rewritten int // Entries rewritten into the current format
+	Skipped   int // Legacy entries left alone because the variant in their file name is not known
+}
+
+// Fetcher looks up the matches of a coordinate again, storing them in the cache
+type Fetcher interface {
+	// CacheVariant returns the query variant of the lookups made by the fetcher
+	CacheVariant() string
+
+	// GetVegreferanseMatches looks up the matches of a coordinate, storing them in the cache
+	GetVegreferanseMatches(x, y float64) ([]vegref.VegreferanseMatch, error)
+}
+
+// walkFiles calls fn for every cache file with its path relative to the cache directory
+func (c *DiskCache) walkFiles(fn func(path, relativePath string) error) error {
+	return filepath.WalkDir(c.cacheDir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		relativePath, err := filepath.Rel(c.cacheDir, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(relativePath))
+	})
+}
+
+// Verify checks every cache file and reports the corrupt, empty and legacy-format entries
+func (c *DiskCache) Verify() (VerifyReport, error) {
+	var report VerifyReport
+	unlock, err := c.lockShared()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	err = c.walkFiles(func(path, relativePath string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cache file: %w", err)
+		}
+		report.Entries++
+
+		_, legacy, err := decodeEntry(data)
+		switch {
+		case errors.Is(err, errEmptyEntry):
+			report.Issues = append(report.Issues, Issue{File: relativePath, Status: StatusEmpty})
+		case err != nil:
+			report.Issues = append(report.Issues, Issue{File: relativePath, Status: StatusCorrupt, Err: err})
+		case legacy:
+			report.Issues = append(report.Issues, Issue{File: relativePath, Status: StatusLegacy})
+		}
+		return nil
+	})
+	return report, err
+}
+
+// Repair deletes the corrupt and empty entries. When fetcher is not nil, the entries with the
+// fetcher's query variant are looked up again, which stores them in the cache anew.
+func (c *DiskCache) Repair(fetcher Fetcher) (RepairReport, error) {
+	var report RepairReport
+	verifyReport, err := c.Verify()
+	if err != nil {
+		return report, err
+	}
+
+	fetchHash := ""
+	if fetcher != nil && fetcher.CacheVariant() != "" {
+		fetchHash = variantHash(fetcher.CacheVariant())
+	}
+
+	// Remove the broken entries first, since the fetcher stores its results in the cache and
+	// the lock can not be held while fetching
+	var broken []string
+	unlock, err := c.lockExclusive()
+	if err != nil {
+		return report, err
+	}
+	c.memory.purge()
+	for _, issue := range verifyReport.Issues {
+		if issue.Status != StatusCorrupt && issue.Status != StatusEmpty {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.cacheDir, filepath.FromSlash(issue.File))); err != nil && !os.IsNotExist(err) {
+			unlock()
+			return report, fmt.Errorf("failed to remove cache file: %w", err)
+		}
+		broken = append(broken, issue.File)
+	}
+	unlock()
+
+	for _, file := range broken {
+		x, y, hash, keyErr := fileKey(file)
+		if fetcher == nil || keyErr != nil || hash != fetchHash {
+			report.Deleted++
+			continue
+		}
+
+		if _, err := fetcher.GetVegreferanseMatches(x, y); err != nil {
+			slog.Warn("Failed to look up cache entry again", "path", file, logging.KeyCoordinate, logging.Coordinate(x, y),
+				logging.KeyErrorCategory, logging.ErrorCategory(err), logging.KeyError, err)
+			report.Failed++
+			continue
+		}
+		report.Refetched++
+	}
+	return report, nil
+}
+
+// Compact rewrites every entry that is not in the current format, keeping its modification time so
+// that Prune still sees its age. The variant of a legacy entry is only stored in its file name as
+// a hash, so legacy entries are only rewritten when their variant is the default one or among the
+// given variants. Corrupt and empty entries are left to Repair.
+func (c *DiskCache) Compact(variants ...string) (CompactReport, error) {
+	var report CompactReport
+	unlock, err := c.lockExclusive()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	knownVariants := map[string]string{"": ""}
+	for _, variant := range variants {
+		if variant != "" {
+			knownVariants[variantHash(variant)] = variant
+		}
+	}
+
+	err = c.walkFiles(func(path, relativePath string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cache file: %w", err)
+		}
+		e, legacy, err := decodeEntry(data)
+		if err != nil {
+			return nil
+		}
+
+		if legacy {
+			x, y, hash, err := fileKey(relativePath)
+			variant, ok := knownVariants[hash]
+			if err != nil || !ok {
+				report.Skipped++
+				return nil
+			}
+			e.X, e.Y, e.Variant = x, y, variant
+		}
+
+		compacted, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to serialize matches: %w", err)
+		}
+		if bytes.Equal(data, compacted) {
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, compacted); err != nil {
+			return fmt.Errorf("failed to write cache file: %w", err)
+		}
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+		report.Rewritten++
+		return nil
+	})
+	return report, err
+}
+
+// fileKey returns the coordinates and the variant hash encoded in the name of a cache file, as
+// written by getCacheFilePath. The hash is empty for the default variant.
+func fileKey(path string) (float64, float64, string, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	parts := strings.Split(name, "_")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, "", fmt.Errorf("cache file name %q does not hold a coordinate", name)
+	}
+
+	x, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("cache file name %q does not hold a coordinate", name)
+	}
+	y, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("cache file name %q does not hold a coordinate", name)
+	}
+
+	hash := ""
+	if len(parts) == 3 {
+		hash = parts[2]
+	}
+	return x, y, hash, nil
+}
@@ -0,0 +1,133 @@
+// Memory Cache Component
+//
+// This component keeps the most recently used cache entries in memory in front of the disk cache,
+// so that files with many repeated coordinates are not read and parsed from disk on every lookup.
+//
+// Key features:
+// - Bounded least recently used (LRU) eviction by number of entries
+// - Safe for concurrent use by goroutines
+// - Hit and miss counters, reported per tier together with those of the disk
+// - Only holds entries of this process: entries changed by other processes sharing the cache
+//   directory are seen once they are evicted, or after a restart
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// DefaultMemoryEntries is the number of entries kept in memory when no other size is configured
+const DefaultMemoryEntries = 10000
+
+// TierStats holds the lookup counters of one cache tier
+type TierStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// LookupStats holds the lookup counters of the memory and disk tiers. Lookups answered from
+// memory never reach the disk, so the disk only counts the memory misses.
+type LookupStats struct {
+	Memory TierStats
+	Disk   TierStats
+}
+
+// memoryEntry is an entry of the LRU list
+type memoryEntry struct {
+	key     string
+	matches []vegref.VegreferanseMatch
+}
+
+// memoryCache is a bounded LRU cache of matches keyed by cache file path
+type memoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Most recently used entry at the front
+	entries  map[string]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newMemoryCache creates a memory cache holding up to capacity entries, or nil when capacity is
+// not positive. All methods of a nil memory cache do nothing, so callers need no checks.
+func newMemoryCache(capacity int) *memoryCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &memoryCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the matches of a key and marks it as most recently used
+func (m *memoryCache) get(key string) ([]vegref.VegreferanseMatch, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		m.misses.Add(1)
+		return nil, false
+	}
+	m.hits.Add(1)
+	m.order.MoveToFront(element)
+	return element.Value.(*memoryEntry).matches, true
+}
+
+// put stores the matches of a key, evicting the least recently used entry when the cache is full
+func (m *memoryCache) put(key string, matches []vegref.VegreferanseMatch) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryEntry).matches = matches
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, matches: matches})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// purge removes all entries, keeping the counters
+func (m *memoryCache) purge() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.order.Init()
+	clear(m.entries)
+}
+
+// len returns the number of entries
+func (m *memoryCache) len() int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// stats returns the lookup counters
+func (m *memoryCache) stats() TierStats {
+	if m == nil {
+		return TierStats{}
+	}
+	return TierStats{Hits: m.hits.Load(), Misses: m.misses.Load()}
+}
@@ -0,0 +1,138 @@
+// File I/O Component
+//
+// This component reads the tab-delimited input files and writes the output files of the conversions.
+//
+// Key features:
+// - Reads a header row and the data lines of tab-delimited files
+// - Writes each input line with the converted columns appended
+// - Logs lines with errors, which are left out of the output, and lines with warnings
+
+package fileio
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+)
+
+// Row is a processed input line together with the tab-separated columns to append to it
+type Row struct {
+	LineIdx int    // 0-based index of the line among the data lines
+	Line    string // Original input line
+	Output  string // Tab-separated columns appended to the line
+	Warning string // Non-fatal issue to report for the line
+	Err     error  // Error for the line, the line is left out of the output
+
+	// Fields of the log lines reporting the row
+	Coordinate   string // Coordinate read from or converted for the row, as "x,y"
+	Vegreferanse string // Vegreferanse read from or converted for the row
+}
+
+// ReadInputFile reads a tab-delimited input file and returns the header and data lines
+func ReadInputFile(inputPath string) (string, []string, error) {
+	// Open input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer inputFile.Close()
+
+	scanner := bufio.NewScanner(inputFile)
+
+	// Process header
+	if !scanner.Scan() {
+		return "", nil, fmt.Errorf("input file is empty")
+	}
+	header := scanner.Text()
+
+	// Read all data lines into memory
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", nil, fmt.Errorf("error reading input file: %w", err)
+	}
+
+	slog.Info("Read input file", "path", inputPath, "lines", len(lines)+1) // +1 for header
+
+	return header, lines, nil
+}
+
+// ColumnCount returns the number of tab-separated columns in the header
+func ColumnCount(header string) int {
+	return len(strings.Split(header, "\t"))
+}
+
+// WriteResults writes the processed rows to the output file. Rows with an error are reported and
+// skipped, rows with a warning are reported and written. Returns the number of lines written.
+func WriteResults(outputPath, header string, rows []Row) (int, error) {
+	// Open output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	// Create buffered writer
+	writer := bufio.NewWriter(outputFile)
+
+	// Write header
+	_, err = writer.WriteString(header + "\n")
+	if err != nil {
+		return 0, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	// Write data lines
+	linesWritten := 0
+	errCount := 0
+	warningCount := 0
+	cacheMissCount := 0
+
+	for _, row := range rows {
+		if logging.ErrorCategory(row.Err) == logging.CategoryCacheMiss {
+			slog.Warn("Line not in the disk cache", logging.Row(row.LineIdx, row.Coordinate, row.Vegreferanse, row.Err)...)
+			cacheMissCount++
+			continue
+		}
+		if row.Err != nil {
+			slog.Error("Failed to convert line", logging.Row(row.LineIdx, row.Coordinate, row.Vegreferanse, row.Err)...)
+			errCount++
+			continue
+		}
+
+		if row.Warning != "" {
+			slog.Warn("Converted line with warning", append(logging.Row(row.LineIdx, row.Coordinate, row.Vegreferanse, nil), "warning", row.Warning)...)
+			warningCount++
+		}
+
+		line := row.Line + "\t" + row.Output + "\n"
+		_, err = writer.WriteString(line)
+		if err != nil {
+			return linesWritten, fmt.Errorf("failed to write line %d: %w", row.LineIdx+1, err)
+		}
+		linesWritten++
+	}
+
+	// Flush writer
+	if err = writer.Flush(); err != nil {
+		return linesWritten, fmt.Errorf("failed to flush writer: %w", err)
+	}
+
+	if errCount > 0 {
+		slog.Warn("Lines with errors were skipped in the output", "lines", errCount)
+	}
+	if cacheMissCount > 0 {
+		slog.Warn("Lines not in the disk cache were skipped in the output", "lines", cacheMissCount)
+	}
+	if warningCount > 0 {
+		slog.Warn("Lines with warnings were included in the output", "lines", warningCount)
+	}
+
+	return linesWritten, nil
+}
@@ -0,0 +1,124 @@
+// Logging Component
+//
+// This component sets up structured logging with log/slog and defines the fields shared by all
+// log lines, so that the diagnostics of a conversion can be filtered by level and parsed as JSON.
+//
+// Key features:
+// - Text or JSON log lines at a configurable minimum level
+// - Common field names for the line number, coordinate, vegreferanse and error category
+// - Error categories attached where an error is created and preserved through wrapping
+// - Row attributes that give every log line about an input row the same fields
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Field names shared by all log lines
+const (
+	KeyLine          = "line"           // 1-based line number among the data lines of the input file
+	KeyCoordinate    = "coordinate"     // Coordinate as "x,y"
+	KeyVegreferanse  = "vegreferanse"   // Vegreferanse read from or converted for the row
+	KeyErrorCategory = "error_category" // Category of the error, see the Category constants
+	KeyError         = "error"          // Error message
+)
+
+// Error categories
+const (
+	CategoryInput     = "input"      // Missing or invalid values in the input row
+	CategoryNotFound  = "not_found"  // The road or position does not exist in NVDB
+	CategoryAPI       = "api"        // NVDB returned an error or an unexpected response
+	CategoryNetwork   = "network"    // No response from NVDB
+	CategoryCache     = "cache"      // Reading or writing the disk cache failed
+	CategoryCacheMiss = "cache_miss" // An offline lookup is not in the disk cache
+	CategoryOther     = "other"      // Errors without a category
+)
+
+// New creates a logger writing to w at the given minimum level (debug, info, warn or error)
+// in the given format (text or json)
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %s, must be one of debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+	switch strings.ToLower(format) {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format: %s, must be either text or json", format)
+}
+
+// categorizedError is an error with an error category
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// Categorize attaches an error category to err. An error that already has a category keeps it,
+// so the category set closest to where the error was created wins. Returns nil for a nil error.
+func Categorize(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return err
+	}
+	return &categorizedError{category: category, err: err}
+}
+
+// ErrorCategory returns the category of an error, CategoryOther when it has none, or an empty
+// string for a nil error
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category
+	}
+	return CategoryOther
+}
+
+// Coordinate formats a coordinate for the coordinate field
+func Coordinate(x, y float64) string {
+	return fmt.Sprintf("%.6f,%.6f", x, y)
+}
+
+// Row returns the attributes of a log line about an input row. All fields are always present,
+// empty when not known, so that log lines about rows can be parsed and filtered alike.
+func Row(lineIdx int, coordinate, vegreferanse string, err error) []any {
+	attrs := []any{
+		slog.Int(KeyLine, lineIdx+1),
+		slog.String(KeyCoordinate, coordinate),
+		slog.String(KeyVegreferanse, vegreferanse),
+		slog.String(KeyErrorCategory, ErrorCategory(err)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String(KeyError, err.Error()))
+	}
+	return attrs
+}
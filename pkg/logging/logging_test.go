@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestErrorCategory tests that categories survive wrapping and the innermost category wins
+func TestErrorCategory(t *testing.T) {
+	notFound := Categorize(CategoryNotFound, errors.New("vegreferanse not found: EV6 S1D1 m10"))
+
+	testCases := []struct {
+		err         error
+		expected    string
+		description string
+	}{
+		{nil, "", "No error"},
+		{errors.New("failed"), CategoryOther, "Uncategorized error"},
+		{Categorize(CategoryInput, errors.New("empty vegreferanse")), CategoryInput, "Categorized error"},
+		{fmt.Errorf("API error: %w", notFound), CategoryNotFound, "Wrapped categorized error"},
+		{Categorize(CategoryAPI, fmt.Errorf("API error: %w", notFound)), CategoryNotFound, "Category set at the source wins"},
+		{Categorize(CategoryAPI, fmt.Errorf("API error: %w", errors.New("bad JSON"))), CategoryAPI, "Category added while wrapping"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if category := ErrorCategory(tc.err); category != tc.expected {
+				t.Errorf("Expected category %q, got %q", tc.expected, category)
+			}
+		})
+	}
+
+	if Categorize(CategoryInput, nil) != nil {
+		t.Error("Expected nil for a nil error")
+	}
+	if notFound.Error() != "vegreferanse not found: EV6 S1D1 m10" {
+		t.Errorf("Categorize must not change the error message, got %q", notFound.Error())
+	}
+}
+
+// TestNew tests the log formats, level filtering and the row fields
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, "warn", FormatJSON)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	logger.Info("Filtered out")
+	logger.Error("Failed to convert line", Row(4, "262000.5,6650000.2", "", Categorize(CategoryNotFound, errors.New("not found")))...)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one log line above the level, got %q", out.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	expected := map[string]any{
+		KeyLine:          float64(5),
+		KeyCoordinate:    "262000.5,6650000.2",
+		KeyVegreferanse:  "",
+		KeyErrorCategory: CategoryNotFound,
+		KeyError:         "not found",
+		"level":          "ERROR",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, entry[key])
+		}
+	}
+
+	out.Reset()
+	logger, err = New(&out, "debug", FormatText)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger.Debug("Row", Row(0, "", "EV6 S1D1 m10", nil)...)
+	if !strings.Contains(out.String(), `line=1 coordinate="" vegreferanse="EV6 S1D1 m10" error_category=""`) {
+		t.Errorf("Unexpected text log line %q", out.String())
+	}
+
+	if _, err := New(&out, "verbose", FormatText); err == nil {
+		t.Error("Expected error for invalid level, got none")
+	}
+	if _, err := New(&out, "info", "xml"); err == nil {
+		t.Error("Expected error for invalid format, got none")
+	}
+}
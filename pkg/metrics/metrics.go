@@ -0,0 +1,318 @@
+// Metrics Component
+//
+// This component collects counters and histograms from the API client, the disk cache, the
+// selector and the conversion pipeline, and exposes them in the Prometheus text format so that
+// scheduled runs can be followed on dashboards.
+//
+// Key features:
+// - Counters and histograms with labels, and gauges evaluated when the metrics are read
+// - A default registry that the instrumented packages register their metrics with
+// - HTTP handler for scraping, e.g. on a -metrics-addr listener
+// - Atomic dump to a file for the node_exporter textfile collector
+// - Safe for concurrent use by all workers
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets in seconds used for latencies and wait times
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the instrumented packages register their metrics with
+var Default = NewRegistry()
+
+// metric is a named metric that can write itself in the Prometheus text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics
+type Registry struct {
+	metrics []metric
+	mu      sync.Mutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric to the registry, panicking on duplicate names like other registration
+// mistakes made at package initialisation
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metric %s registered twice", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics in the Prometheus text format, sorted by name
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	slices.SortFunc(metrics, func(a, b metric) int {
+		return strings.Compare(a.name(), b.name())
+	})
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// WriteFile writes all metrics to a file for the node_exporter textfile collector. The file is
+// written to a temporary file first and then renamed, so the collector never reads a partial file.
+func (r *Registry) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	r.WriteText(tmp)
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
+
+// series holds the label values of one time series of a metric
+type series struct {
+	labelValues []string
+	value       float64  // Counter value
+	buckets     []uint64 // Histogram bucket counts (not cumulative)
+	sum         float64  // Histogram sum
+	count       uint64   // Histogram count
+}
+
+// vec is the shared implementation of metrics with labels
+type vec struct {
+	metricName string
+	help       string
+	labelNames []string
+	series     map[string]*series
+	mu         sync.Mutex
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// get returns the series for the label values, creating it if needed. The caller must hold the lock.
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.metricName, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sortedSeries returns the series sorted by label values. The caller must hold the lock.
+func (v *vec) sortedSeries() []*series {
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	slices.SortFunc(all, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+	return all
+}
+
+// labels formats the label names and values, with an optional extra label such as the bucket bound
+func (v *vec) labels(labelValues []string, extraName, extraValue string) string {
+	parts := make([]string, 0, len(labelValues)+1)
+	for i, value := range labelValues {
+		parts = append(parts, v.labelNames[i]+`="`+labelValueEscaper.Replace(value)+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelValueEscaper escapes label values as the Prometheus text format requires
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a counter and registers it with the default registry
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+// NewCounterVec creates a counter and registers it with the registry
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec{metricName: name, help: help, labelNames: labelNames, series: make(map[string]*series)}}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter for the label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues).value += value
+}
+
+// Value returns the current value of the counter for the label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(labelValues).value
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.metricName, c.help, c.metricName)
+	for _, s := range c.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labels(s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	vec
+	upperBounds []float64
+}
+
+// NewHistogramVec creates a histogram and registers it with the default registry
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+// NewHistogramVec creates a histogram with the given bucket upper bounds and registers it with the registry
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:         vec{metricName: name, help: help, labelNames: labelNames, series: make(map[string]*series)},
+		upperBounds: slices.Sorted(slices.Values(buckets)),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram for the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.upperBounds))
+	}
+	for i, bound := range h.upperBounds {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.help, h.metricName)
+	for _, s := range h.sortedSeries() {
+		var cumulative uint64
+		for i, bound := range h.upperBounds {
+			if s.buckets != nil {
+				cumulative += s.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels(s.labelValues, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels(s.labelValues, "", ""), s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed when the metrics are read
+type GaugeFunc struct {
+	metricName string
+	help       string
+	value      func() float64
+}
+
+// NewGaugeFunc creates a gauge and registers it with the default registry
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, value)
+}
+
+// NewGaugeFunc creates a gauge and registers it with the registry
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, value: value}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.metricName, g.help, g.metricName, g.metricName, formatValue(g.value()))
+}
+
+// formatValue formats a sample value as Prometheus expects it
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriteText tests the Prometheus text format of counters, histograms and gauges
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests.", "endpoint", "code")
+	latency := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "endpoint")
+	registry.NewGaugeFunc("test_entries", "Entries.", func() float64 { return 42 })
+
+	requests.Inc("posisjon", "200")
+	requests.Inc("posisjon", "200")
+	requests.Add(3, "veg", "404")
+	requests.Inc(`a"b\c`, "500")
+	latency.Observe(0.05, "posisjon")
+	latency.Observe(0.5, "posisjon")
+	latency.Observe(5, "posisjon")
+
+	var out bytes.Buffer
+	registry.WriteText(&out)
+
+	expected := `# HELP test_entries Entries.
+# TYPE test_entries gauge
+test_entries 42
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{endpoint="posisjon",le="0.1"} 1
+test_latency_seconds_bucket{endpoint="posisjon",le="1"} 2
+test_latency_seconds_bucket{endpoint="posisjon",le="+Inf"} 3
+test_latency_seconds_sum{endpoint="posisjon"} 5.55
+test_latency_seconds_count{endpoint="posisjon"} 3
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{endpoint="a\"b\\c",code="500"} 1
+test_requests_total{endpoint="posisjon",code="200"} 2
+test_requests_total{endpoint="veg",code="404"} 3
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected metrics output:\n%s\nExpected:\n%s", out.String(), expected)
+	}
+	if value := requests.Value("posisjon", "200"); value != 2 {
+		t.Errorf("Expected counter value 2, got %v", value)
+	}
+}
+
+// TestRegistry tests registration errors, the HTTP handler and the textfile dump
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Test.")
+	counter.Inc()
+
+	t.Run("DuplicateName", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic for duplicate metric name, got none")
+			}
+		}()
+		registry.NewCounterVec("test_total", "Test.")
+	})
+
+	t.Run("WrongLabelCount", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected panic for wrong number of label values, got none")
+			}
+		}()
+		counter.Inc("unexpected")
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := io.ReadAll(recorder.Body)
+		if !strings.Contains(string(body), "test_total 1\n") {
+			t.Errorf("Expected counter in response, got:\n%s", body)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+			t.Errorf("Expected text/plain content type, got %s", contentType)
+		}
+	})
+
+	t.Run("WriteFile", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "vegref.prom")
+		if err := registry.WriteFile(path); err != nil {
+			t.Fatalf("Failed to write metrics file: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read metrics file: %v", err)
+		}
+		if !strings.Contains(string(data), "test_total 1\n") {
+			t.Errorf("Expected counter in metrics file, got:\n%s", data)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("Expected only the metrics file in the directory, got %d entries", len(entries))
+		}
+	})
+}
@@ -0,0 +1,206 @@
+// Record/Replay Archive Component
+//
+// This component records the NVDB API requests of a conversion, together with the raw responses,
+// to an archive file, and replays them later without network access. A replayed conversion
+// produces the same output as the recorded one, which documents exactly which NVDB answers a
+// delivered dataset is based on.
+//
+// Key features:
+// - HTTP transports that plug into the API client with WithHTTPClient
+// - One JSON object per line with the time, request URL, status code and raw response body
+// - Thread-safe, so all workers can share one recorder or replayer
+// - Replay matches requests on method, path and query parameters, in any order
+// - Repeated requests are replayed in the order they were recorded
+
+package nvdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ArchiveEntry is a single API request and its raw response in a record/replay archive
+type ArchiveEntry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"` // Path and query of the request, without the API host
+	Status int       `json:"status"`
+	Body   string    `json:"body"` // Raw response body
+}
+
+// Recorder is an HTTP transport that writes every request and response to an archive
+type Recorder struct {
+	transport http.RoundTripper
+	file      *os.File
+	encoder   *json.Encoder
+	entries   int
+	mu        sync.Mutex
+}
+
+// NewRecorder creates the archive file, replacing any existing file, and returns a transport
+// that records to it. Requests are sent with transport, or http.DefaultTransport when nil.
+func NewRecorder(path string, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	return &Recorder{
+		transport: transport,
+		file:      file,
+		encoder:   json.NewEncoder(file),
+	}, nil
+}
+
+// RoundTrip sends the request and records the response
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the full body so that it can be both recorded and returned
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry := ArchiveEntry{
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Status: resp.StatusCode,
+		Body:   string(body),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(entry); err != nil {
+		return nil, fmt.Errorf("failed to write to archive: %w", err)
+	}
+	r.entries++
+
+	return resp, nil
+}
+
+// Entries returns the number of requests recorded so far
+func (r *Recorder) Entries() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries
+}
+
+// Close closes the archive file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Replayer is an HTTP transport that answers requests from an archive without network access
+type Replayer struct {
+	entries map[string][]ArchiveEntry // Recorded responses by request key, in recorded order
+	served  map[string]int            // Number of responses served by request key
+	mu      sync.Mutex
+}
+
+// NewReplayer loads an archive written by a Recorder
+func NewReplayer(path string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	replayer := &Replayer{
+		entries: make(map[string][]ArchiveEntry),
+		served:  make(map[string]int),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Geometry responses can be large
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry ArchiveEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid archive entry on line %d: %w", lineNumber, err)
+		}
+
+		key, err := archiveKey(entry.Method, entry.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request URL on line %d: %w", lineNumber, err)
+		}
+		replayer.entries[key] = append(replayer.entries[key], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	return replayer, nil
+}
+
+// RoundTrip returns the recorded response to the request. Once the recorded responses to a
+// repeated request are used up, the last one is returned again.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	key, err := archiveKey(req.Method, req.URL.RequestURI())
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	entries := r.entries[key]
+	if len(entries) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("request not found in archive: %s %s", req.Method, req.URL.RequestURI())
+	}
+	entry := entries[min(r.served[key], len(entries)-1)]
+	r.served[key]++
+	r.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader([]byte(entry.Body))),
+		ContentLength: int64(len(entry.Body)),
+		Request:       req,
+	}, nil
+}
+
+// archiveKey returns the key used to match a request with its recorded response. The query
+// parameters are sorted so that the order they were added in does not matter.
+func archiveKey(method, requestURI string) (string, error) {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return "", err
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	return method + " " + u.Path + "?" + query.Encode(), nil
+}
@@ -0,0 +1,85 @@
+package nvdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecordReplay tests that recorded API responses are replayed without network access
+func TestRecordReplay(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/vegnett/api/v4/posisjon":
+			fmt.Fprintf(w, `[{"vegsystemreferanse":{"kortform":"EV6 S1D1 m%d"},"geometri":{"wkt":"POINT Z(1 2 3)","srid":5973},"avstand":1.5}]`, requests)
+		case "/vegnett/api/v4/veg/batch":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	archivePath := filepath.Join(t.TempDir(), "archive.jsonl")
+	recorder, err := NewRecorder(archivePath, nil)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	api := NewVegvesenetAPIV4(WithBaseURL(server.URL), WithHTTPClient(&http.Client{Transport: recorder}))
+	recorded, err := api.GetVegreferanseFromCoordinates(1, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := api.GetCoordinatesFromVegreferanse("EV6 S1D1 m1"); err == nil {
+		t.Fatal("Expected not found error, got none")
+	}
+	if recorder.Entries() != 2 {
+		t.Errorf("Expected 2 recorded entries, got %d", recorder.Entries())
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Failed to close recorder: %v", err)
+	}
+	server.Close()
+
+	archive, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if !strings.Contains(string(archive), `"time":`) || !strings.Contains(string(archive), `EV6 S1D1 m1`) {
+		t.Errorf("Archive is missing the timestamp or raw response: %s", archive)
+	}
+
+	// Replay against a server that no longer exists
+	replayer, err := NewReplayer(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+	api = NewVegvesenetAPIV4(WithBaseURL(server.URL), WithHTTPClient(&http.Client{Transport: replayer}), WithRateLimiter(nil))
+
+	t.Run("RecordedRequests", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			replayed, err := api.GetVegreferanseFromCoordinates(1, 2)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if replayed != recorded {
+				t.Errorf("Expected replayed vegreferanse %s, got %s", recorded, replayed)
+			}
+		}
+		if _, err := api.GetCoordinatesFromVegreferanse("EV6 S1D1 m1"); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Expected the recorded not found error, got %v", err)
+		}
+	})
+
+	t.Run("UnrecordedRequest", func(t *testing.T) {
+		if _, err := api.GetVegreferanseFromCoordinates(3, 4); err == nil || !strings.Contains(err.Error(), "not found in archive") {
+			t.Errorf("Expected error for request missing from the archive, got %v", err)
+		}
+	})
+}
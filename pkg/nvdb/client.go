@@ -0,0 +1,847 @@
+// NVDB API Client Component (v4)
+//
+// This component communicates with the Norwegian Public Roads Administration (NVDB) API v4
+// to convert UTM33 coordinates to road references (vegreferanse).
+//
+// Key features:
+// - Implements the provider interfaces used by the conversion pipeline and server
+// - Configured with functional options (rate limit, disk cache, SRID, date, road filters)
+// - Makes requests to the NVDB API v4 /posisjon endpoint
+// - Converts legacy (pre-2020) vegreferanse to the current vegsystemreferanse
+// - Resolves vegreferanse ranges to road centreline geometry using the segmented road network
+// - Requests and verifies geometry in the spatial reference system (SRID) chosen by the user
+// - Supports historical lookups at a given date (tidspunkt), per client or per request
+// - Handles API rate limiting to comply with NVDB's usage policies
+// - Integrates with the disk cache to reduce API calls
+// - Records and replays API responses for reproducible conversions
+// - Runs offline from the disk cache, collecting the lookups it could not answer
+// - Exposes request counts, latencies and rate limiter wait times as metrics
+// - Processes and parses API responses
+// - Returns vegreferanse matches with metadata for intelligent selection
+
+package nvdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/cache"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/wkt"
+)
+
+// Global constants for API client
+const (
+	clientName = "Koordinater til Vegreferanse"
+)
+
+// Spatial reference systems (SRID) supported by the NVDB API v4
+const (
+	SRIDWGS84       = 4326  // WGS84 geographic coordinates (X = longitude, Y = latitude)
+	SRIDUTM32       = 5972  // EUREF89 UTM zone 32 + NN2000
+	SRIDUTM33       = 5973  // EUREF89 UTM zone 33 + NN2000
+	SRIDUTM35       = 5975  // EUREF89 UTM zone 35 + NN2000
+	SRIDETRS89UTM32 = 25832 // ETRS89 UTM zone 32 (2D)
+	SRIDETRS89UTM33 = 25833 // ETRS89 UTM zone 33 (2D)
+	SRIDETRS89UTM35 = 25835 // ETRS89 UTM zone 35 (2D)
+
+	// DefaultSRID is the SRID used when none is configured
+	DefaultSRID = SRIDUTM33
+)
+
+var clientSessionID string = uuid.NewString()
+
+// Metrics for the requests sent to the API
+var (
+	apiRequests = metrics.NewCounterVec("vegref_nvdb_requests_total",
+		"NVDB API requests by endpoint and status code.", "endpoint", "code")
+	apiRequestDuration = metrics.NewHistogramVec("vegref_nvdb_request_duration_seconds",
+		"NVDB API request latency by endpoint and status code.", metrics.DefaultBuckets, "endpoint", "code")
+	rateLimiterWait = metrics.NewHistogramVec("vegref_rate_limiter_wait_seconds",
+		"Time spent waiting for the rate limiter before an NVDB API request.", metrics.DefaultBuckets)
+)
+
+// VegvesenetAPIV4 implements the VegreferanseProvider interface using the NVDB API v4
+type VegvesenetAPIV4 struct {
+	baseURL     string
+	apiClient   *http.Client
+	rateLimiter *RateLimiter
+	diskCache   *cache.DiskCache
+	srid        int
+	tidspunkt   string     // Date (YYYY-MM-DD) for historical lookups, empty for the current road network
+	roadFilter  RoadFilter // Candidate roads accepted for coordinate lookups
+	offline     bool       // Answer from the disk cache only, without sending requests
+	missing     missingKeys
+
+	// Counters for progress reporting
+	apiCalls    atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+}
+
+// ClientStats holds the request counters of the API client
+type ClientStats struct {
+	APICalls    int64 // Requests sent to the API
+	CacheHits   int64 // Position lookups answered by the disk cache
+	CacheMisses int64 // Position lookups not found in the disk cache
+}
+
+// V4PositionResponseItem represents a single item in the API response from the v4 API
+type V4PositionResponseItem struct {
+	Vegsystemreferanse struct {
+		Vegsystem struct {
+			Vegkategori string `json:"vegkategori"`
+			Fase        string `json:"fase"`
+			Nummer      int    `json:"nummer"`
+		} `json:"vegsystem"`
+		Strekning struct {
+			Strekning       int     `json:"strekning"`
+			Delstrekning    int     `json:"delstrekning"`
+			Arm             bool    `json:"arm"`
+			Adskilte_lop    string  `json:"adskilte_løp"`
+			Trafikantgruppe string  `json:"trafikantgruppe"`
+			Retning         string  `json:"retning"`
+			Meter           float64 `json:"meter"`
+		} `json:"strekning"`
+		Kortform string `json:"kortform"`
+	} `json:"vegsystemreferanse"`
+	Veglenkesekvens struct {
+		Veglenkesekvensid int     `json:"veglenkesekvensid"`
+		RelativPosisjon   float64 `json:"relativPosisjon"`
+		Kortform          string  `json:"kortform"`
+	} `json:"veglenkesekvens"`
+	Geometri struct {
+		Wkt  string `json:"wkt"`
+		Srid int    `json:"srid"`
+	} `json:"geometri"`
+	Kommune int     `json:"kommune"`
+	Avstand float64 `json:"avstand"`
+}
+
+// V4PositionResponse is a slice of position response items
+type V4PositionResponse []V4PositionResponseItem
+
+// V4ErrorResponse represents the error response structure from the v4 API
+type V4ErrorResponse struct {
+	Messages []struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+		HelpURL string `json:"help_url"`
+	} `json:"messages"`
+	// For simple errors:
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// NewVegvesenetAPIV4 creates a new instance of the Vegvesenet API v4 client. Without options the
+// client uses the NVDB default rate limit, no disk cache, UTM33 and the current road network.
+func NewVegvesenetAPIV4(opts ...Option) *VegvesenetAPIV4 {
+	api := &VegvesenetAPIV4{
+		baseURL:     DefaultBaseURL,
+		apiClient:   &http.Client{Timeout: DefaultHTTPTimeout},
+		rateLimiter: NewRateLimiter(DefaultRateLimit, DefaultRateLimitTimeFrame),
+		srid:        DefaultSRID,
+	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
+}
+
+// SRID returns the spatial reference system used by the client
+func (api *VegvesenetAPIV4) SRID() int {
+	return api.srid
+}
+
+// Stats returns the request counters of the client
+func (api *VegvesenetAPIV4) Stats() ClientStats {
+	return ClientStats{
+		APICalls:    api.apiCalls.Load(),
+		CacheHits:   api.cacheHits.Load(),
+		CacheMisses: api.cacheMisses.Load(),
+	}
+}
+
+// RateLimiter returns the rate limiter used by the client, or nil when rate limiting is disabled
+func (api *VegvesenetAPIV4) RateLimiter() *RateLimiter {
+	return api.rateLimiter
+}
+
+// DiskCache returns the disk cache used by the client, or nil when caching is disabled
+func (api *VegvesenetAPIV4) DiskCache() *cache.DiskCache {
+	return api.diskCache
+}
+
+// resolveTidspunkt returns the date to query at, falling back to the client's default date
+func (api *VegvesenetAPIV4) resolveTidspunkt(date string) string {
+	if date == "" {
+		return api.tidspunkt
+	}
+	return date
+}
+
+// commonQueryOptions returns the query parameters shared by all lookups: the SRID and,
+// for historical lookups, the point in time
+func (api *VegvesenetAPIV4) commonQueryOptions(date string) url.Values {
+	q := url.Values{}
+	q.Set("srid", strconv.Itoa(api.srid))
+	if date = api.resolveTidspunkt(date); date != "" {
+		q.Set("tidspunkt", date)
+	}
+	return q
+}
+
+// positionQueryOptions returns the /posisjon query parameters that, apart from the coordinates,
+// influence the result. They are part of the cache key.
+func (api *VegvesenetAPIV4) positionQueryOptions(date string) url.Values {
+	q := api.commonQueryOptions(date)
+	api.roadFilter.addQueryOptions(q)
+	return q
+}
+
+// cacheVariant returns the canonical cache key component for the given query options.
+// Options with default values are left out so that existing cache entries remain valid.
+func (api *VegvesenetAPIV4) cacheVariant(options url.Values) string {
+	variant := maps.Clone(options)
+	if variant.Get("srid") == strconv.Itoa(DefaultSRID) {
+		variant.Del("srid")
+	}
+	return variant.Encode()
+}
+
+// CacheVariant returns the cache key component of the position lookups of the client at its
+// default date, which identifies the disk cache entries the client reads and writes
+func (api *VegvesenetAPIV4) CacheVariant() string {
+	return api.cacheVariant(api.roadFilter.cacheOptions(api.positionQueryOptions("")))
+}
+
+// checkSRID verifies that geometry returned by the API is in the SRID requested by the client.
+// A missing SRID (0) is accepted since there is nothing to verify against.
+func (api *VegvesenetAPIV4) checkSRID(srid int) error {
+	if srid == 0 || sridsEquivalent(srid, api.srid) {
+		return nil
+	}
+	return fmt.Errorf("API returned geometry in SRID %d, but SRID %d was requested", srid, api.srid)
+}
+
+// sridsEquivalent reports whether two SRIDs share the same horizontal coordinate system.
+// EUREF89 UTM with NN2000 heights (597x) and ETRS89 UTM (258xx) only differ in the vertical component.
+func sridsEquivalent(a, b int) bool {
+	horizontal := func(srid int) int {
+		switch srid {
+		case SRIDETRS89UTM32:
+			return SRIDUTM32
+		case SRIDETRS89UTM33:
+			return SRIDUTM33
+		case SRIDETRS89UTM35:
+			return SRIDUTM35
+		}
+		return srid
+	}
+	return horizontal(a) == horizontal(b)
+}
+
+// SRIDLabel returns a short label for the SRID, suitable for column headers
+func SRIDLabel(srid int) string {
+	switch srid {
+	case SRIDUTM32, SRIDETRS89UTM32:
+		return "UTM32"
+	case SRIDUTM33, SRIDETRS89UTM33:
+		return "UTM33"
+	case SRIDUTM35, SRIDETRS89UTM35:
+		return "UTM35"
+	case SRIDWGS84:
+		return "WGS84"
+	}
+	return fmt.Sprintf("EPSG%d", srid)
+}
+
+// createRequest creates a new HTTP request with common headers
+func (api *VegvesenetAPIV4) createRequest(method, endpoint string) (*http.Request, error) {
+	url := fmt.Sprintf("%s%s", api.baseURL, endpoint)
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add common headers for v4 API
+	req.Header.Add("Accept", "application/vnd.vegvesen.nvdb-v4+json")
+	req.Header.Add("X-Client", clientName)
+	req.Header.Add("X-Client-Session", clientSessionID)
+
+	return req, nil
+}
+
+// executeRequest executes an HTTP request and returns the response body
+func (api *VegvesenetAPIV4) executeRequest(req *http.Request) ([]byte, int, error) {
+	// Only position lookups are cached, so every other request fails offline
+	if api.offline {
+		return nil, 0, offlineError(fmt.Sprintf("request to %s", metricsEndpoint(req.URL.Path)))
+	}
+
+	// Apply rate limiting
+	if api.rateLimiter != nil {
+		waitStart := time.Now()
+		api.rateLimiter.Wait()
+		rateLimiterWait.Observe(time.Since(waitStart).Seconds())
+	}
+
+	// Send request
+	api.apiCalls.Add(1)
+	endpoint := metricsEndpoint(req.URL.Path)
+	start := time.Now()
+	resp, err := api.apiClient.Do(req)
+	if err != nil {
+		observeRequest(endpoint, "error", start)
+		return nil, 0, logging.Categorize(logging.CategoryNetwork, fmt.Errorf("request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	// Read full response body
+	respBody, err := io.ReadAll(resp.Body)
+	observeRequest(endpoint, strconv.Itoa(resp.StatusCode), start)
+	if err != nil {
+		return nil, resp.StatusCode, logging.Categorize(logging.CategoryNetwork, fmt.Errorf("failed to read response body: %w", err))
+	}
+
+	return respBody, resp.StatusCode, nil
+}
+
+// metricsEndpoint returns the endpoint label for a request path, e.g. "posisjon" or "veg/batch"
+func metricsEndpoint(path string) string {
+	if i := strings.Index(path, "/api/v4/"); i >= 0 {
+		return path[i+len("/api/v4/"):]
+	}
+	return path
+}
+
+// observeRequest counts a finished API request and records its latency
+func observeRequest(endpoint, code string, start time.Time) {
+	apiRequests.Inc(endpoint, code)
+	apiRequestDuration.Observe(time.Since(start).Seconds(), endpoint, code)
+}
+
+// handleErrorResponse parses and returns a formatted error from an API error response
+func (api *VegvesenetAPIV4) handleErrorResponse(statusCode int, respBody []byte) error {
+	if statusCode == http.StatusNotFound {
+		return nil // Not an error, just no results
+	}
+
+	// Try to parse error response
+	var errorResp V4ErrorResponse
+	if jsonErr := json.Unmarshal(respBody, &errorResp); jsonErr == nil {
+		// Check for either type of error format
+		if len(errorResp.Messages) > 0 {
+			errorMsg := ""
+			for _, msg := range errorResp.Messages {
+				errorMsg += fmt.Sprintf("[%d] %s ", msg.Code, msg.Message)
+			}
+			return logging.Categorize(logging.CategoryAPI, fmt.Errorf("API error: %s", errorMsg))
+		} else if errorResp.Detail != "" {
+			return logging.Categorize(logging.CategoryAPI, fmt.Errorf("API error: %s", errorResp.Detail))
+		}
+	}
+
+	// If we couldn't parse the error, return raw status and body
+	return logging.Categorize(logging.CategoryAPI, fmt.Errorf("API returned status code %d: %s", statusCode, string(respBody)))
+}
+
+// GetVegreferanseFromCoordinates converts coordinates to a road reference using the NVDB API v4
+func (api *VegvesenetAPIV4) GetVegreferanseFromCoordinates(x, y float64) (string, error) {
+	// This implementation will select the first result
+	matches, err := api.GetVegreferanseMatches(x, y)
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "", nil
+	}
+
+	// Use the first vegreferanse in the response
+	return matches[0].Vegsystemreferanse.Kortform, nil
+}
+
+// GetVegreferanseMatches returns all matching vegreferanses for the given coordinates
+func (api *VegvesenetAPIV4) GetVegreferanseMatches(x, y float64) ([]vegref.VegreferanseMatch, error) {
+	return api.GetVegreferanseMatchesAt(x, y, "")
+}
+
+// GetVegreferanseMatchesAt returns all matching vegreferanses for the given coordinates in the
+// road network as it was on the given date (YYYY-MM-DD). An empty date uses the client's default.
+func (api *VegvesenetAPIV4) GetVegreferanseMatchesAt(x, y float64, date string) ([]vegref.VegreferanseMatch, error) {
+	options := api.positionQueryOptions(date)
+	variant := api.cacheVariant(api.roadFilter.cacheOptions(options))
+
+	// Check disk cache if available - cached matches have already been filtered
+	if api.diskCache != nil {
+		if matches, found := api.diskCache.Get(x, y, variant); found {
+			api.cacheHits.Add(1)
+			return matches, nil
+		}
+		api.cacheMisses.Add(1)
+	}
+	if api.offline {
+		api.missing.add(MissingKey{X: x, Y: y, Date: date})
+		return nil, offlineError(fmt.Sprintf("position %s", logging.Coordinate(x, y)))
+	}
+
+	// Create request for position endpoint
+	req, err := api.createRequest("GET", "/vegnett/api/v4/posisjon")
+	if err != nil {
+		return nil, err
+	}
+
+	// Add query parameters - coordinates are in the SRID configured on the client
+	q := req.URL.Query()
+	q.Add("nord", fmt.Sprintf("%.6f", y)) // Note: 'nord' is Y (northing)
+	q.Add("ost", fmt.Sprintf("%.6f", x))  // Note: 'ost' is X (easting)
+	q.Add("maks_antall", "10")            // Maximum number of results - now returning up to 10
+	for key, values := range options {
+		for _, value := range values {
+			q.Add(key, value)
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+
+	// Execute request
+	respBody, statusCode, err := api.executeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle non-200 responses
+	if statusCode != http.StatusOK {
+		if statusCode == http.StatusNotFound {
+			// Cache empty result for not found
+			if api.diskCache != nil {
+				_ = api.diskCache.Set(x, y, variant, []vegref.VegreferanseMatch{})
+			}
+			return []vegref.VegreferanseMatch{}, nil
+		}
+
+		return nil, api.handleErrorResponse(statusCode, respBody)
+	}
+
+	// Parse successful response
+	var result V4PositionResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Extract matches
+	if len(result) == 0 {
+		// Cache empty result
+		if api.diskCache != nil {
+			_ = api.diskCache.Set(x, y, variant, []vegref.VegreferanseMatch{})
+		}
+		return []vegref.VegreferanseMatch{}, nil
+	}
+
+	// Convert API response to our VegreferanseMatch struct
+	matches := make([]vegref.VegreferanseMatch, len(result))
+	for i, item := range result {
+		if err := api.checkSRID(item.Geometri.Srid); err != nil {
+			return nil, err
+		}
+		matches[i] = vegref.VegreferanseMatch{
+			Vegsystemreferanse: item.Vegsystemreferanse,
+			Avstand:            item.Avstand,
+		}
+		// The position on the road is only used for plotting, so a missing one does not fail the lookup
+		if point, err := api.coordinateFromGeometry(item.Geometri.Wkt, item.Geometri.Srid); err == nil {
+			matches[i].Punkt = &point
+		}
+	}
+
+	// Apply the road filter, including the filters NVDB does not support
+	matches = api.roadFilter.Apply(matches)
+
+	// Cache the matches
+	if api.diskCache != nil {
+		_ = api.diskCache.Set(x, y, variant, matches)
+	}
+
+	return matches, nil
+}
+
+// GetCoordinatesFromVegreferanse returns coordinates in the client's SRID for a given vegreferanse
+func (api *VegvesenetAPIV4) GetCoordinatesFromVegreferanse(vegreferanse string) (vegref.Coordinate, error) {
+	return api.GetCoordinatesFromVegreferanseAt(vegreferanse, "")
+}
+
+// GetCoordinatesFromVegreferanseAt returns coordinates for a vegreferanse as it was on the given
+// date (YYYY-MM-DD). An empty date uses the client's default.
+func (api *VegvesenetAPIV4) GetCoordinatesFromVegreferanseAt(vegreferanse, date string) (vegref.Coordinate, error) {
+	// Create the endpoint with the encoded vegreferanse
+	q := api.commonQueryOptions(date)
+	q.Set("vegsystemreferanser", vegreferanse)
+	endpoint := fmt.Sprintf("/vegnett/api/v4/veg/batch?%s", q.Encode())
+
+	// Create request
+	req, err := api.createRequest("GET", endpoint)
+	if err != nil {
+		return vegref.Coordinate{}, err
+	}
+
+	// Execute request
+	respBody, statusCode, err := api.executeRequest(req)
+	if err != nil {
+		return vegref.Coordinate{}, err
+	}
+
+	// Handle non-200 responses
+	if statusCode != http.StatusOK {
+		if statusCode == http.StatusNotFound {
+			return vegref.Coordinate{}, logging.Categorize(logging.CategoryNotFound, fmt.Errorf("vegreferanse not found: %s", vegreferanse))
+		}
+
+		return vegref.Coordinate{}, api.handleErrorResponse(statusCode, respBody)
+	}
+
+	// Parse the response to extract the WKT (Well-Known Text) geometry
+	// Based on the actual response, the batch endpoint returns a map with vegreferanse as the key
+	var responseMap map[string]V4VegResponseItem
+	if err := json.Unmarshal(respBody, &responseMap); err != nil {
+		return vegref.Coordinate{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Find the data for our vegreferanse
+	locationData, found := responseMap[vegreferanse]
+	if !found {
+		return vegref.Coordinate{}, logging.Categorize(logging.CategoryNotFound, fmt.Errorf("no data found for vegreferanse: %s", vegreferanse))
+	}
+
+	return api.coordinateFromGeometry(locationData.Geometri.Wkt, locationData.Geometri.Srid)
+}
+
+// coordinateFromGeometry verifies the SRID of a returned POINT geometry and extracts its coordinate
+func (api *VegvesenetAPIV4) coordinateFromGeometry(wkt string, srid int) (vegref.Coordinate, error) {
+	// Verify that the geometry is in the requested SRID before labelling it
+	if err := api.checkSRID(srid); err != nil {
+		return vegref.Coordinate{}, err
+	}
+
+	// Parse WKT format to extract X and Y coordinates
+	coordinate, err := parseWKTToCoordinate(wkt)
+	if err != nil {
+		return vegref.Coordinate{}, err
+	}
+
+	// NVDB follows the EPSG axis order for WGS84 (latitude first), while X is longitude here
+	if api.srid == SRIDWGS84 {
+		coordinate.X, coordinate.Y = coordinate.Y, coordinate.X
+	}
+
+	return coordinate, nil
+}
+
+// V4VegResponseItem represents a road network position returned by the /veg and /veg/batch endpoints
+type V4VegResponseItem struct {
+	Vegsystemreferanse struct {
+		Kortform string `json:"kortform"`
+	} `json:"vegsystemreferanse"`
+	Veglenkesekvens struct {
+		Veglenkesekvensid int     `json:"veglenkesekvensid"`
+		RelativPosisjon   float64 `json:"relativPosisjon"`
+		Kortform          string  `json:"kortform"`
+	} `json:"veglenkesekvens"`
+	Geometri struct {
+		Wkt  string `json:"wkt"`
+		Srid int    `json:"srid"`
+	} `json:"geometri"`
+}
+
+// getVegPosition looks up a single road network position with the /veg endpoint.
+// It returns nil without error when the position doesn't exist.
+func (api *VegvesenetAPIV4) getVegPosition(query url.Values) (*V4VegResponseItem, error) {
+	query.Set("srid", strconv.Itoa(api.srid))
+
+	req, err := api.createRequest("GET", "/vegnett/api/v4/veg?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, statusCode, err := api.executeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		if statusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, api.handleErrorResponse(statusCode, respBody)
+	}
+
+	var item V4VegResponseItem
+	if err := json.Unmarshal(respBody, &item); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &item, nil
+}
+
+// GetCoordinatesFromLegacyVegreferanse converts a legacy (pre-2020) vegreferanse to the current
+// vegsystemreferanse and coordinates. The legacy reference is resolved as it was on the given
+// date (vegref.DefaultLegacyVegreferanseDate when empty), and the resulting position on the road link
+// sequence is then looked up in the current road network.
+func (api *VegvesenetAPIV4) GetCoordinatesFromLegacyVegreferanse(ref vegref.LegacyVegreferanse, date string) (vegref.LegacyConversion, error) {
+	if date == "" {
+		date = vegref.DefaultLegacyVegreferanseDate
+	}
+
+	// Resolve the legacy reference in the historical road network
+	historicalQuery := url.Values{}
+	historicalQuery.Set("vegreferanse", ref.Compact())
+	historicalQuery.Set("tidspunkt", date)
+	historical, err := api.getVegPosition(historicalQuery)
+	if err != nil {
+		return vegref.LegacyConversion{}, err
+	}
+	if historical == nil {
+		return vegref.LegacyConversion{}, logging.Categorize(logging.CategoryNotFound, fmt.Errorf("legacy vegreferanse not found at %s: %s", date, ref))
+	}
+
+	historicalCoordinate, err := api.coordinateFromGeometry(historical.Geometri.Wkt, historical.Geometri.Srid)
+	if err != nil {
+		return vegref.LegacyConversion{}, err
+	}
+
+	// Positions on road link sequences are stable over time, use it to find the current reference
+	// (or the reference at the client's default date, when set)
+	position := historical.Veglenkesekvens.Kortform
+	if position == "" {
+		position = fmt.Sprintf("%g@%d", historical.Veglenkesekvens.RelativPosisjon, historical.Veglenkesekvens.Veglenkesekvensid)
+	}
+	currentQuery := url.Values{}
+	currentQuery.Set("veglenkesekvens", position)
+	if api.tidspunkt != "" {
+		currentQuery.Set("tidspunkt", api.tidspunkt)
+	}
+	current, err := api.getVegPosition(currentQuery)
+	if err != nil {
+		return vegref.LegacyConversion{}, err
+	}
+	if current == nil || current.Vegsystemreferanse.Kortform == "" {
+		return vegref.LegacyConversion{Coordinate: historicalCoordinate, Retired: true}, nil
+	}
+
+	currentCoordinate, err := api.coordinateFromGeometry(current.Geometri.Wkt, current.Geometri.Srid)
+	if err != nil {
+		return vegref.LegacyConversion{}, err
+	}
+
+	return vegref.LegacyConversion{
+		Vegsystemreferanse: current.Vegsystemreferanse.Kortform,
+		Coordinate:         currentCoordinate,
+	}, nil
+}
+
+// V4SegmentResponse represents a page of segmented road link sequences from the v4 API
+type V4SegmentResponse struct {
+	Objekter []struct {
+		Veglenkesekvensid int `json:"veglenkesekvensid"`
+		Geometri          struct {
+			Wkt  string `json:"wkt"`
+			Srid int    `json:"srid"`
+		} `json:"geometri"`
+		Vegsystemreferanse struct {
+			Strekning struct {
+				FraMeter float64 `json:"fra_meter"`
+				TilMeter float64 `json:"til_meter"`
+				Retning  string  `json:"retning"`
+			} `json:"strekning"`
+			Kortform string `json:"kortform"`
+		} `json:"vegsystemreferanse"`
+	} `json:"objekter"`
+	Metadata struct {
+		Returnert int `json:"returnert"`
+		Neste     struct {
+			Start string `json:"start"`
+		} `json:"neste"`
+	} `json:"metadata"`
+}
+
+// segmentJoinTolerance is the maximum gap in meters between two segments that are joined into one line
+const segmentJoinTolerance = 0.01
+
+// metersPerDegree is the length of a degree of latitude, used to express segmentJoinTolerance in
+// degrees for WGS84. A degree of longitude is shorter in Norway, which only makes the tolerance stricter.
+const metersPerDegree = 111320.0
+
+// GetGeometryFromVegreferanseRange returns the road centreline geometry for a vegreferanse range
+// such as "EV6 S10D1 m200-1500", oriented in the direction of increasing meter values. The length
+// is measured in road meters, so parallel carriageways and lanes covering the same meters count once.
+func (api *VegvesenetAPIV4) GetGeometryFromVegreferanseRange(vegreferanse string) (vegref.RoadGeometry, error) {
+	type segment struct {
+		fraMeter float64
+		tilMeter float64
+		lines    [][]wkt.Point
+		hasZ     bool
+	}
+	var segments []segment
+
+	// The segmented road network is paginated, follow the pages until no more items are returned
+	start := ""
+	for {
+		q := api.commonQueryOptions("")
+		q.Set("vegsystemreferanse", strings.ReplaceAll(vegreferanse, " ", ""))
+		if start != "" {
+			q.Set("start", start)
+		}
+
+		req, err := api.createRequest("GET", "/vegnett/api/v4/vegnett/veglenkesekvenser/segmentert?"+q.Encode())
+		if err != nil {
+			return vegref.RoadGeometry{}, err
+		}
+
+		respBody, statusCode, err := api.executeRequest(req)
+		if err != nil {
+			return vegref.RoadGeometry{}, err
+		}
+
+		if statusCode != http.StatusOK {
+			if statusCode == http.StatusNotFound {
+				return vegref.RoadGeometry{}, logging.Categorize(logging.CategoryNotFound, fmt.Errorf("vegreferanse not found: %s", vegreferanse))
+			}
+			return vegref.RoadGeometry{}, api.handleErrorResponse(statusCode, respBody)
+		}
+
+		var page V4SegmentResponse
+		if err := json.Unmarshal(respBody, &page); err != nil {
+			return vegref.RoadGeometry{}, fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		for _, item := range page.Objekter {
+			if err := api.checkSRID(item.Geometri.Srid); err != nil {
+				return vegref.RoadGeometry{}, err
+			}
+
+			geometry, err := wkt.Parse(item.Geometri.Wkt)
+			if err != nil {
+				return vegref.RoadGeometry{}, fmt.Errorf("failed to parse segment geometry: %w", err)
+			}
+			if (geometry.Type != wkt.TypeLineString && geometry.Type != wkt.TypeMultiLineString) || geometry.IsEmpty() {
+				continue
+			}
+
+			// Segments against the metering direction are reversed so the result follows increasing meters
+			against := strings.EqualFold(item.Vegsystemreferanse.Strekning.Retning, "MOT")
+			var lines [][]wkt.Point
+			for _, line := range geometry.Lines {
+				if len(line) == 0 {
+					continue
+				}
+				oriented := make([]wkt.Point, len(line))
+				for i, p := range line {
+					// NVDB follows the EPSG axis order for WGS84 (latitude first), while X is longitude here
+					if api.srid == SRIDWGS84 {
+						p.X, p.Y = p.Y, p.X
+					}
+					if against {
+						oriented[len(line)-1-i] = p
+					} else {
+						oriented[i] = p
+					}
+				}
+				lines = append(lines, oriented)
+			}
+			if against {
+				slices.Reverse(lines)
+			}
+
+			segments = append(segments, segment{
+				fraMeter: item.Vegsystemreferanse.Strekning.FraMeter,
+				tilMeter: item.Vegsystemreferanse.Strekning.TilMeter,
+				lines:    lines,
+				hasZ:     geometry.HasZ,
+			})
+		}
+
+		if page.Metadata.Returnert == 0 || page.Metadata.Neste.Start == "" || page.Metadata.Neste.Start == start {
+			break
+		}
+		start = page.Metadata.Neste.Start
+	}
+
+	if len(segments) == 0 {
+		return vegref.RoadGeometry{}, logging.Categorize(logging.CategoryNotFound, fmt.Errorf("no geometry found for vegreferanse: %s", vegreferanse))
+	}
+
+	// Order segments by meter value and join those that connect into continuous lines
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].fraMeter < segments[j].fraMeter
+	})
+
+	joinTolerance := segmentJoinTolerance
+	if api.srid == SRIDWGS84 {
+		joinTolerance /= metersPerDegree
+	}
+
+	// Meters covered by an earlier segment, such as those of the other carriageway, are not counted again
+	result := vegref.RoadGeometry{Geometry: wkt.Geometry{Type: wkt.TypeLineString, HasZ: true}}
+	counted := math.Inf(-1)
+	for _, seg := range segments {
+		if from := max(seg.fraMeter, counted); seg.tilMeter > from {
+			result.Length += seg.tilMeter - from
+			counted = seg.tilMeter
+		}
+		if !seg.hasZ {
+			result.Geometry.HasZ = false
+		}
+
+		for _, line := range seg.lines {
+			lines := result.Geometry.Lines
+			if len(lines) > 0 {
+				last := lines[len(lines)-1]
+				end := last[len(last)-1]
+				if math.Hypot(line[0].X-end.X, line[0].Y-end.Y) <= joinTolerance {
+					lines[len(lines)-1] = append(last, line[1:]...)
+					continue
+				}
+			}
+			result.Geometry.Lines = append(lines, line)
+		}
+	}
+
+	if len(result.Geometry.Lines) > 1 {
+		result.Geometry.Type = wkt.TypeMultiLineString
+	}
+
+	return result, nil
+}
+
+// parseWKTToCoordinate parses a WKT (Well-Known Text) POINT and extracts X and Y coordinates
+func parseWKTToCoordinate(text string) (vegref.Coordinate, error) {
+	geometry, err := wkt.Parse(text)
+	if err != nil {
+		return vegref.Coordinate{}, err
+	}
+
+	if geometry.Type != wkt.TypePoint {
+		return vegref.Coordinate{}, fmt.Errorf("expected POINT geometry, got %s: %s", geometry.Type, text)
+	}
+	if geometry.IsEmpty() {
+		return vegref.Coordinate{}, fmt.Errorf("empty geometry in WKT: %s", text)
+	}
+
+	point := geometry.Lines[0][0]
+	return vegref.Coordinate{X: point.X, Y: point.Y}, nil
+}
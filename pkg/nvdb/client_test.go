@@ -0,0 +1,1075 @@
+package nvdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/cache"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb/nvdbtest"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/selector"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// TestGetCoordinatesFromVegreferanse tests the conversion from vegreferanse to coordinates
+func TestGetCoordinatesFromVegreferanse(t *testing.T) {
+	// Create API client backed by the recorded NVDB responses
+	api := newFixtureAPI(t)
+
+	// Test cases with known vegreferanses
+	testCases := []struct {
+		vegreferanse    string
+		description     string
+		expectError     bool
+		expectedXPrefix string // Expected prefix of X coordinate to check (not exact due to potential API changes)
+		expectedYPrefix string // Expected prefix of Y coordinate to check
+	}{
+		{"FV7834 S1D1 m11", "County road 7834", false, "6414", "7679"}, // Based on the input sample
+		{"E6 S72D1 m1000", "E6 near Trondheim", false, "26", "70"},     // E6 near Trondheim
+		{"INVALID_VEGREF", "Invalid vegreferanse", true, "", ""},       // Should return error
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// Get coordinates for the vegreferanse
+			coordinates, err := api.GetCoordinatesFromVegreferanse(tc.vegreferanse)
+
+			// Check error condition
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error for vegreferanse %s, but got none", tc.vegreferanse)
+				}
+				return
+			}
+
+			// Otherwise, we expect no error
+			if err != nil {
+				t.Fatalf("Error getting coordinates: %v", err)
+			}
+
+			// Log the result
+			t.Logf("Vegreferanse %s -> Coordinates: X=%.6f, Y=%.6f",
+				tc.vegreferanse, coordinates.X, coordinates.Y)
+
+			// Convert coordinates to strings for prefix checking
+			xStr := formatCoordinate(coordinates.X)
+			yStr := formatCoordinate(coordinates.Y)
+
+			// Check if coordinates start with expected prefixes
+			if tc.expectedXPrefix != "" && !strings.HasPrefix(xStr, tc.expectedXPrefix) {
+				t.Errorf("X coordinate %s does not start with expected prefix %s",
+					xStr, tc.expectedXPrefix)
+			}
+			if tc.expectedYPrefix != "" && !strings.HasPrefix(yStr, tc.expectedYPrefix) {
+				t.Errorf("Y coordinate %s does not start with expected prefix %s",
+					yStr, tc.expectedYPrefix)
+			}
+
+			// Verify coordinates are reasonable for Norway
+			// Norway's UTM33 boundaries approximately
+			if coordinates.X < 0 || coordinates.X > 1000000 {
+				t.Errorf("X coordinate %.6f is outside reasonable range for Norway", coordinates.X)
+			}
+			if coordinates.Y < 6400000 || coordinates.Y > 7800000 {
+				t.Errorf("Y coordinate %.6f is outside reasonable range for Norway", coordinates.Y)
+			}
+		})
+	}
+}
+
+// TestRobustWKTHandling tests the handling of different WKT formats
+func TestRobustWKTHandling(t *testing.T) {
+	// Create fake coordinates for different WKT formats
+	testCases := []struct {
+		wkt         string
+		expectedX   float64
+		expectedY   float64
+		expectError bool
+		description string
+	}{
+		{"POINT (123.456 789.012)", 123.456, 789.012, false, "Simple POINT format"},
+		{"POINT Z (123.456 789.012 10.0)", 123.456, 789.012, false, "POINT Z format with elevation"},
+		{"POINT ZM (123.456 789.012 10.0 1.0)", 123.456, 789.012, false, "POINT ZM format"},
+		{"POINT EMPTY", 0, 0, true, "Empty point"},
+		{"INVALID", 0, 0, true, "Invalid WKT"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// Create a simulated response struct
+			var response struct {
+				Geometri struct {
+					Wkt  string
+					Srid int
+				}
+			}
+			response.Geometri.Wkt = tc.wkt
+
+			// Extract X and Y from WKT using our parsing logic from GetCoordinatesFromVegreferanse
+			var x, y float64
+			var err error
+
+			// Replicate the parsing logic from the function
+			wkt := response.Geometri.Wkt
+			wkt = strings.TrimPrefix(wkt, "POINT Z (")
+			wkt = strings.TrimPrefix(wkt, "POINT ZM (")
+			wkt = strings.TrimPrefix(wkt, "POINT (")
+			wkt = strings.TrimSuffix(wkt, ")")
+
+			if wkt == "EMPTY" || wkt == response.Geometri.Wkt {
+				err = fmt.Errorf("invalid WKT format: %s", wkt)
+			} else {
+				// Split the coordinates
+				parts := strings.Split(wkt, " ")
+				if len(parts) < 2 {
+					err = fmt.Errorf("invalid WKT format: %s", wkt)
+				} else {
+					// Try to parse X and Y
+					xStr := parts[0]
+					yStr := parts[1]
+
+					x, err = parseFloat(xStr)
+					if err == nil {
+						y, err = parseFloat(yStr)
+					}
+				}
+			}
+
+			// Check results against expected values
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error parsing WKT %s, but got none", tc.wkt)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error parsing WKT %s: %v", tc.wkt, err)
+			}
+
+			if x != tc.expectedX || y != tc.expectedY {
+				t.Errorf("Parsing WKT %s: expected coordinates (%.6f, %.6f), got (%.6f, %.6f)",
+					tc.wkt, tc.expectedX, tc.expectedY, x, y)
+			}
+		})
+	}
+}
+
+// Helper functions for testing
+
+// newFixtureAPI creates a client for the offline NVDB stand-in, serving the recorded responses in testdata
+func newFixtureAPI(t *testing.T, opts ...Option) *VegvesenetAPIV4 {
+	t.Helper()
+	server := nvdbtest.NewServer(t, filepath.Join("testdata", "nvdb_fixtures.json"))
+	return NewVegvesenetAPIV4(append([]Option{WithBaseURL(server.URL), WithHTTPClient(server.Client())}, opts...)...)
+}
+
+// parseFloat tries to parse a string as a float64
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+// formatCoordinate formats a coordinate for prefix checking
+func formatCoordinate(coord float64) string {
+	return fmt.Sprintf("%.6f", coord)
+}
+
+func TestVegvesenetAPIV4_Comprehensive(t *testing.T) {
+	// Basic functionality test
+	t.Run("BasicFunctionality", func(t *testing.T) {
+		// Create API client backed by the recorded NVDB responses
+		api := newFixtureAPI(t)
+
+		// Test coordinates that should return a valid road reference
+		x := 253671.97
+		y := 6648897.78
+
+		// First call - should hit the API and cache the result
+		vegreferanse, err := api.GetVegreferanseFromCoordinates(x, y)
+		if err != nil {
+			t.Fatalf("Error getting vegreferanse: %v", err)
+		}
+
+		// Verify we got a non-empty result
+		if vegreferanse == "" {
+			t.Fatal("Expected a non-empty vegreferanse, but got empty string")
+		}
+
+		t.Logf("Successfully retrieved vegreferanse: %s", vegreferanse)
+
+		// Second call with same coordinates - should use cached result
+		start := time.Now()
+		cachedVegreferanse, err := api.GetVegreferanseFromCoordinates(x, y)
+		if err != nil {
+			t.Fatalf("Error getting cached vegreferanse: %v", err)
+		}
+		cacheTime := time.Since(start)
+
+		// Verify cached result matches first result
+		if cachedVegreferanse != vegreferanse {
+			t.Fatalf("Cached vegreferanse %s does not match original %s", cachedVegreferanse, vegreferanse)
+		}
+
+		// Verify cache lookup was fast
+		if cacheTime > 50*time.Millisecond {
+			t.Logf("Warning: Cache retrieval took %v, which is longer than expected", cacheTime)
+		}
+
+		t.Logf("Successfully retrieved cached vegreferanse: %s", cachedVegreferanse)
+	})
+
+	// Test handling of non-existent roads
+	t.Run("NonExistentRoad", func(t *testing.T) {
+		// Create API client
+		api := newFixtureAPI(t)
+
+		// Test with coordinates far out at sea where there should be no roads
+		// Using coordinates in the North Sea
+		x := 141000.0
+		y := 6650000.0
+
+		vegreferanse, err := api.GetVegreferanseFromCoordinates(x, y)
+		if err != nil {
+			t.Fatalf("Error getting vegreferanse: %v", err)
+		}
+
+		// Should get empty string for non-existent road
+		if vegreferanse != "" {
+			t.Fatalf("Expected empty vegreferanse for non-existent road, but got: %s", vegreferanse)
+		}
+
+		t.Log("Successfully returned empty string for non-existent road")
+	})
+
+	// Test the full API, including raw response
+	t.Run("RecordedAPITests", func(t *testing.T) {
+		// Create an instance of the v4 API client
+		apiClient := newFixtureAPI(t)
+
+		// Test the API response using the regular method
+		t.Run("TestAPIResponse", func(t *testing.T) {
+			// Choose a known coordinate pair in Norway (E18 near Oslo)
+			// These coordinates are in UTM33 EUREF89 (EPSG:5973)
+			x := 253671.97
+			y := 6648897.78
+
+			// Use the regular method to get vegreferanse matches
+			matches, err := apiClient.GetVegreferanseMatches(x, y)
+			if err != nil {
+				t.Fatalf("Error making API request: %v", err)
+			}
+
+			// Log the matches for inspection
+			t.Logf("API returned %d matches for coordinates (%.6f, %.6f)", len(matches), x, y)
+			for i, match := range matches {
+				t.Logf("Match %d: %s (distance: %.2f meters)", i+1, match.Vegsystemreferanse.Kortform, match.Avstand)
+			}
+
+			// Verify we got at least one match
+			if len(matches) == 0 {
+				t.Errorf("Expected at least one match for coordinates (%.6f, %.6f)", x, y)
+			}
+		})
+
+		// Test the caching behavior
+		t.Run("CacheBehavior", func(t *testing.T) {
+			// Use a coordinate pair that worked in the previous test
+			x := 253671.97
+			y := 6648897.78
+
+			// First call should hit the API
+			start := time.Now()
+			firstResult, err := apiClient.GetVegreferanseFromCoordinates(x, y)
+			if err != nil {
+				t.Fatalf("Error on first API call: %v", err)
+			}
+			firstCallTime := time.Since(start)
+			t.Logf("First API call took %v", firstCallTime)
+
+			// Second call should use cache and be much faster
+			start = time.Now()
+			secondResult, err := apiClient.GetVegreferanseFromCoordinates(x, y)
+			if err != nil {
+				t.Fatalf("Error on second API call: %v", err)
+			}
+			secondCallTime := time.Since(start)
+			t.Logf("Second API call took %v", secondCallTime)
+
+			// Verify results match
+			if firstResult != secondResult {
+				t.Errorf("Cache returned different result. First: %s, Second: %s",
+					firstResult, secondResult)
+			}
+
+			// Verify second call was significantly faster
+			if secondCallTime > firstCallTime/2 {
+				t.Logf("Warning: Second call doesn't appear to be using cache effectively")
+			}
+		})
+	})
+
+	// Test the multiple matches functionality
+	t.Run("MultipleMatches", func(t *testing.T) {
+		api := newFixtureAPI(t)
+
+		// We'll use coordinates for a location that might have multiple roads nearby
+		// These are example coordinates where roads might intersect
+		x := 253671.97
+		y := 6648897.78
+
+		matches, err := api.GetVegreferanseMatches(x, y)
+		if err != nil {
+			t.Fatalf("Error getting vegreferanse matches: %v", err)
+		}
+
+		// Log the number of matches found
+		t.Logf("Found %d matches for coordinates (%.6f, %.6f)", len(matches), x, y)
+
+		// Log each match with its distance
+		for i, match := range matches {
+			t.Logf("Match %d: %s (distance: %.2f meters)",
+				i+1, match.Vegsystemreferanse.Kortform, match.Avstand)
+		}
+
+		// Test that the matches are properly used with the selector
+		vegrefSelector := selector.New(5)
+
+		// Test with no history first
+		bestMatch := vegrefSelector.SelectBestMatch(matches)
+		t.Logf("Best match with no history: %s", bestMatch)
+
+		// Add a mock history entry and test again to see if selection changes
+		mockVegreferanse := "E18 S65D1 m12500" // Example, might match real road nearby
+		vegrefSelector.AddToHistory(mockVegreferanse)
+
+		bestMatchWithHistory := vegrefSelector.SelectBestMatch(matches)
+		t.Logf("Best match with history: %s", bestMatchWithHistory)
+	})
+}
+
+// TestIntegration_SelectorWithAPI tests the integration between the API and selector
+func TestIntegration_SelectorWithAPI(t *testing.T) {
+	api := newFixtureAPI(t)
+	vegrefSelector := selector.New(5)
+
+	// Simulate a journey along a road by using slightly different coordinates
+	journey := []struct {
+		x           float64
+		y           float64
+		description string
+	}{
+		// These coordinates should be slightly adjusted to follow a path
+		{253671.97, 6648897.78, "Start point"},
+		{253675.00, 6648900.00, "Mid point"}, // slightly adjusted
+		{253680.00, 6648905.00, "End point"}, // slightly adjusted
+	}
+
+	for i, point := range journey {
+		t.Run(fmt.Sprintf("JourneyPoint%d", i+1), func(t *testing.T) {
+			// Get matches for this point
+			matches, err := api.GetVegreferanseMatches(point.x, point.y)
+			if err != nil {
+				t.Fatalf("Error getting matches: %v", err)
+			}
+
+			if len(matches) == 0 {
+				t.Skipf("No matches found for point %s", point.description)
+				return
+			}
+
+			// Log number of matches
+			t.Logf("Found %d matches for %s", len(matches), point.description)
+
+			// Select best match using selector
+			bestMatch := vegrefSelector.SelectBestMatch(matches)
+
+			// Add to history for future selections
+			vegrefSelector.AddToHistory(bestMatch)
+
+			// Log selected vegreferanse
+			t.Logf("Selected vegreferanse: %s", bestMatch)
+		})
+	}
+}
+
+// TestBidirectionalConversion tests that coordinates converted to vegreferanse
+// and back to coordinates match the original coordinates within reasonable precision
+func TestBidirectionalConversion(t *testing.T) {
+	// Create API client
+	api := newFixtureAPI(t)
+
+	// Create selector for continuity (only for coord-to-vegref direction)
+	vegrefSelector := selector.New(5)
+
+	// Test cases with known coordinates in UTM33
+	testCases := []struct {
+		x           float64
+		y           float64
+		description string
+		tolerance   float64 // Acceptable difference between original and round-trip coords (in meters)
+	}{
+		{253671.97, 6648897.78, "E18 near Oslo", 10.0},
+		{269039.00, 7038490.00, "E6 near Trondheim", 10.0},
+		{641470.00, 7679980.00, "County road in northern Norway", 15.0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// Step 1: Coordinates to Vegreferanse with continuity
+			matches, err := api.GetVegreferanseMatches(tc.x, tc.y)
+			if err != nil {
+				t.Fatalf("Error getting vegreferanse matches: %v", err)
+			}
+
+			if len(matches) == 0 {
+				t.Skipf("No vegreferanse matches found for coordinates (%.6f, %.6f)", tc.x, tc.y)
+				return
+			}
+
+			// Select best match considering continuity
+			vegreferanse := vegrefSelector.SelectBestMatch(matches)
+			vegrefSelector.AddToHistory(vegreferanse)
+
+			t.Logf("Coordinates (%.6f, %.6f) -> Vegreferanse: %s", tc.x, tc.y, vegreferanse)
+
+			// Skip empty vegreferanse values
+			if vegreferanse == "" {
+				t.Skipf("Empty vegreferanse returned for coordinates (%.6f, %.6f)", tc.x, tc.y)
+				return
+			}
+
+			// Step 2: Vegreferanse back to Coordinates (ignoring continuity)
+			coords, err := api.GetCoordinatesFromVegreferanse(vegreferanse)
+			if err != nil {
+				t.Fatalf("Error converting vegreferanse back to coordinates: %v", err)
+			}
+
+			t.Logf("Vegreferanse %s -> Coordinates: (%.6f, %.6f)",
+				vegreferanse, coords.X, coords.Y)
+
+			// Step 3: Compare original and round-trip coordinates
+			distance := calculateDistance(tc.x, tc.y, coords.X, coords.Y)
+			t.Logf("Distance between original and round-trip coordinates: %.2f meters", distance)
+
+			// Verify the coordinates are close enough (within tolerance)
+			if distance > tc.tolerance {
+				t.Logf("Round-trip coordinates %.2f meters from original (exceeds tolerance of %.2f meters)",
+					distance, tc.tolerance)
+			}
+
+			// Step 4: Skip SRID verification since we don't have access to raw API responses
+			// We're assuming the API returns coordinates in UTM33/EPSG:5973 format as documented
+			t.Logf("Note: Skipping explicit SRID verification - assuming UTM33/EPSG:5973 format")
+		})
+	}
+}
+
+// calculateDistance calculates the Euclidean distance between two UTM33 coordinates in meters
+func calculateDistance(x1, y1, x2, y2 float64) float64 {
+	dx := x2 - x1
+	dy := y2 - y1
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+// TestMaxDistanceFiltering tests that the max distance filtering works correctly
+func TestMaxDistanceFiltering(t *testing.T) {
+	// Create API client (no distance filtering at API level now)
+	api := newFixtureAPI(t)
+
+	// Use coordinates that should return multiple matches with varying distances
+	x := 253671.97
+	y := 6648897.78
+
+	// Get all matches from API (unfiltered)
+	allMatches, err := api.GetVegreferanseMatches(x, y)
+	if err != nil {
+		t.Fatalf("Error getting matches: %v", err)
+	}
+
+	// Test with different max distance settings
+	testCases := []struct {
+		maxDistance     int
+		expectFiltering bool
+		description     string
+	}{
+		{10, true, "Very restrictive distance (10m) - should filter out most results"},
+		{100, true, "Moderately restrictive distance (100m) - should filter some results"},
+		{1000, false, "Default distance (1000m) - should not filter many results"},
+		{10000, false, "Very permissive distance (10000m) - should not filter any results"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// Apply client-side filtering using production function
+			filteredMatches := vegref.FilterMatchesByDistance(allMatches, tc.maxDistance)
+
+			// Log the number of matches and their distances
+			t.Logf("Max distance %dm: Found %d matches (from %d total)",
+				tc.maxDistance, len(filteredMatches), len(allMatches))
+			for i, match := range filteredMatches {
+				t.Logf("  Match %d: %s (distance: %.2f meters)",
+					i+1, match.Vegsystemreferanse.Kortform, match.Avstand)
+
+				// Verify that all returned matches respect the max distance filter
+				if match.Avstand > float64(tc.maxDistance) {
+					t.Errorf("Match %d has distance %.2f meters, which exceeds max distance %d meters",
+						i+1, match.Avstand, tc.maxDistance)
+				}
+			}
+
+			// Verify filtering behavior
+			if tc.expectFiltering && len(filteredMatches) >= len(allMatches) {
+				t.Logf("Note: Expected filtering but got same number of results. This might be okay if all matches are within the distance threshold.")
+			}
+		})
+	}
+}
+
+// TestWKTFormatCorrespondsToUTM33 verifies that the WKT format returned by the API
+// corresponds to the UTM33 (EPSG:5973) coordinate system
+func TestWKTFormatCorrespondsToUTM33(t *testing.T) {
+	// Create API client
+	api := newFixtureAPI(t)
+
+	// Test known vegreferanse values
+	testCases := []struct {
+		vegreferanse string
+		description  string
+	}{
+		{"E6 S72D1 m1000", "E6 near Trondheim"},
+		{"FV7834 S1D1 m11", "County road 7834"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// Step A: Get coordinates from vegreferanse using the regular method
+			coords, err := api.GetCoordinatesFromVegreferanse(tc.vegreferanse)
+			if err != nil {
+				t.Fatalf("Error getting coordinates: %v", err)
+			}
+
+			// Verify we got valid coordinates
+			t.Logf("Vegreferanse %s -> Coordinates: (%.6f, %.6f)",
+				tc.vegreferanse, coords.X, coords.Y)
+
+			// Check for valid coordinate values (rough check for Norway)
+			if coords.X < 0 || coords.Y < 6000000 {
+				t.Errorf("Coordinates don't appear to be in UTM33/EPSG:5973 format: (%.6f, %.6f)",
+					coords.X, coords.Y)
+			}
+
+			// Step B: Verify coordinates are in reasonable range for UTM33 in Norway
+			// UTM33N Norway approximate boundaries
+			if coords.X < 0 || coords.X > 1000000 {
+				t.Errorf("X coordinate %.6f outside reasonable range for UTM33 in Norway", coords.X)
+			}
+			if coords.Y < 6400000 || coords.Y > 7800000 {
+				t.Errorf("Y coordinate %.6f outside reasonable range for UTM33 in Norway", coords.Y)
+			}
+
+			// Step C: Reverse lookup - convert coordinates back to vegreferanse
+			matches, err := api.GetVegreferanseMatches(coords.X, coords.Y)
+			if err != nil {
+				t.Fatalf("Error getting vegreferanses for coordinates: %v", err)
+			}
+
+			// Check if we got any matches
+			if len(matches) == 0 {
+				t.Errorf("No vegreferanse matches found for coordinates (%.6f, %.6f)",
+					coords.X, coords.Y)
+				return
+			}
+
+			// Log the first match
+			t.Logf("Coordinates (%.6f, %.6f) -> First match: %s (distance: %.2f meters)",
+				coords.X, coords.Y, matches[0].Vegsystemreferanse.Kortform, matches[0].Avstand)
+
+			// Check if the original vegreferanse is among the matches
+			// Note: We can't expect an exact match due to different referencing methods,
+			// but we can check if road ID and main section are similar
+			originalParts := strings.Split(tc.vegreferanse, " ")
+			if len(originalParts) > 0 {
+				originalRoadID := originalParts[0] // e.g., "E18"
+				foundSimilar := false
+
+				for _, match := range matches {
+					matchParts := strings.Split(match.Vegsystemreferanse.Kortform, " ")
+					if len(matchParts) > 0 && matchParts[0] == originalRoadID {
+						foundSimilar = true
+						t.Logf("Found matching road ID %s in reverse lookup", originalRoadID)
+						break
+					}
+				}
+
+				if !foundSimilar {
+					t.Logf("Warning: Original road ID %s not found in reverse lookup matches", originalRoadID)
+				}
+			}
+		})
+	}
+}
+
+// TestSRIDHandling verifies that the configured SRID is sent to the API and checked on returned geometry
+func TestSRIDHandling(t *testing.T) {
+	var requestedSRID string
+	returnedSRID := 5972
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedSRID = r.URL.Query().Get("srid")
+		switch r.URL.Path {
+		case "/vegnett/api/v4/posisjon":
+			fmt.Fprintf(w, `[{"vegsystemreferanse":{"kortform":"EV6 S1D1 m10"},"geometri":{"wkt":"POINT Z(1 2 3)","srid":%d},"avstand":1.5}]`, returnedSRID)
+		case "/vegnett/api/v4/veg/batch":
+			fmt.Fprintf(w, `{"EV6 S1D1 m10":{"geometri":{"wkt":"POINT Z(1 2 3)","srid":%d}}}`, returnedSRID)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithSRID(SRIDUTM32), WithBaseURL(server.URL))
+
+	t.Run("MatchingSRID", func(t *testing.T) {
+		matches, err := api.GetVegreferanseMatches(1, 2)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if requestedSRID != "5972" {
+			t.Errorf("Expected srid=5972 in request, got %q", requestedSRID)
+		}
+		if len(matches) != 1 {
+			t.Fatalf("Expected 1 match, got %d", len(matches))
+		}
+		if point := matches[0].Punkt; point == nil || point.X != 1 || point.Y != 2 {
+			t.Errorf("Expected the position on the road (1, 2), got %+v", point)
+		}
+
+		coords, err := api.GetCoordinatesFromVegreferanse("EV6 S1D1 m10")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if requestedSRID != "5972" {
+			t.Errorf("Expected srid=5972 in batch request, got %q", requestedSRID)
+		}
+		if coords.X != 1 || coords.Y != 2 {
+			t.Errorf("Expected coordinates (1, 2), got (%f, %f)", coords.X, coords.Y)
+		}
+	})
+
+	t.Run("MismatchedSRID", func(t *testing.T) {
+		returnedSRID = 5973
+		if _, err := api.GetVegreferanseMatches(3, 4); err == nil {
+			t.Error("Expected error for geometry in unexpected SRID, got none")
+		}
+		if _, err := api.GetCoordinatesFromVegreferanse("EV6 S1D1 m10"); err == nil {
+			t.Error("Expected error for geometry in unexpected SRID, got none")
+		}
+	})
+
+	t.Run("EquivalentSRID", func(t *testing.T) {
+		returnedSRID = 25832
+		if _, err := api.GetCoordinatesFromVegreferanse("EV6 S1D1 m10"); err != nil {
+			t.Errorf("Expected equivalent SRID to be accepted, got: %v", err)
+		}
+	})
+
+	t.Run("CacheVariant", func(t *testing.T) {
+		defaultAPI := NewVegvesenetAPIV4(WithRateLimit(100, time.Second))
+		if variant := defaultAPI.cacheVariant(defaultAPI.positionQueryOptions("")); variant != "" {
+			t.Errorf("Expected empty cache variant for default SRID, got %q", variant)
+		}
+		if variant := api.cacheVariant(api.positionQueryOptions("")); variant != "srid=5972" {
+			t.Errorf("Expected cache variant srid=5972, got %q", variant)
+		}
+	})
+}
+
+// TestTidspunktHandling tests that historical lookups send the date to the API and cache per date
+func TestTidspunktHandling(t *testing.T) {
+	var requestedTidspunkt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedTidspunkt = r.URL.Query().Get("tidspunkt")
+		switch r.URL.Path {
+		case "/vegnett/api/v4/posisjon":
+			fmt.Fprint(w, `[{"vegsystemreferanse":{"kortform":"EV6 S1D1 m10"},"geometri":{"wkt":"POINT Z(1 2 3)","srid":5973},"avstand":1.5}]`)
+		case "/vegnett/api/v4/veg/batch":
+			fmt.Fprint(w, `{"EV6 S1D1 m10":{"geometri":{"wkt":"POINT Z(1 2 3)","srid":5973}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithTidspunkt("2019-01-01"), WithBaseURL(server.URL))
+
+	testCases := []struct {
+		date        string
+		expected    string
+		description string
+	}{
+		{"", "2019-01-01", "Default date from client"},
+		{"2015-06-30", "2015-06-30", "Per-call date overrides default"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if _, err := api.GetVegreferanseMatchesAt(1, 2, tc.date); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if requestedTidspunkt != tc.expected {
+				t.Errorf("Expected tidspunkt=%s in position request, got %q", tc.expected, requestedTidspunkt)
+			}
+
+			if _, err := api.GetCoordinatesFromVegreferanseAt("EV6 S1D1 m10", tc.date); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if requestedTidspunkt != tc.expected {
+				t.Errorf("Expected tidspunkt=%s in batch request, got %q", tc.expected, requestedTidspunkt)
+			}
+		})
+	}
+
+	t.Run("CurrentNetwork", func(t *testing.T) {
+		currentAPI := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithBaseURL(server.URL))
+		if _, err := currentAPI.GetVegreferanseMatches(1, 2); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if requestedTidspunkt != "" {
+			t.Errorf("Expected no tidspunkt for the current road network, got %q", requestedTidspunkt)
+		}
+	})
+
+	t.Run("CacheVariant", func(t *testing.T) {
+		if variant := api.cacheVariant(api.positionQueryOptions("")); variant != "tidspunkt=2019-01-01" {
+			t.Errorf("Expected cache variant tidspunkt=2019-01-01, got %q", variant)
+		}
+		if api.cacheVariant(api.positionQueryOptions("2015-06-30")) == api.cacheVariant(api.positionQueryOptions("")) {
+			t.Error("Expected different cache variants for different dates")
+		}
+	})
+}
+
+// TestRoadFilterHandling tests that road filters are forwarded to the API, applied to the response and cached separately
+func TestRoadFilterHandling(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		// Respond as if NVDB ignored the filters
+		fmt.Fprint(w, `[
+			{"vegsystemreferanse":{"vegsystem":{"vegkategori":"F","fase":"V","nummer":100},"strekning":{"trafikantgruppe":"K"},"kortform":"FV100 S1D1 m10"},"avstand":1},
+			{"vegsystemreferanse":{"vegsystem":{"vegkategori":"E","fase":"V","nummer":6},"strekning":{"trafikantgruppe":"G","arm":true},"kortform":"EV6 S1D1 m10 KD1 m2"},"avstand":2},
+			{"vegsystemreferanse":{"vegsystem":{"vegkategori":"E","fase":"V","nummer":6},"strekning":{"trafikantgruppe":"G"},"kortform":"EV6 S1D1 m20"},"avstand":3}
+		]`)
+	}))
+	defer server.Close()
+
+	diskCache, err := cache.NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk cache: %v", err)
+	}
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithDiskCache(diskCache), WithRoadFilter(RoadFilter{
+		Kategorier:      []string{"E"},
+		Trafikantgruppe: "G",
+		ExcludeArms:     true,
+	}), WithBaseURL(server.URL))
+
+	matches, err := api.GetVegreferanseMatches(1, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if query.Get("vegsystemreferanse") != "E" || query.Get("trafikantgruppe") != "G" {
+		t.Errorf("Expected filters to be forwarded, got query %s", query.Encode())
+	}
+	if len(matches) != 1 || matches[0].Vegsystemreferanse.Kortform != "EV6 S1D1 m20" {
+		t.Fatalf("Expected only EV6 S1D1 m20 to pass the filter, got %+v", matches)
+	}
+
+	// An unfiltered client must not reuse the filtered cache entry
+	unfiltered := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithDiskCache(diskCache), WithBaseURL(server.URL))
+	query = nil
+	matches, err = unfiltered.GetVegreferanseMatches(1, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if query == nil {
+		t.Error("Expected a request for the unfiltered lookup, got a cache hit")
+	}
+	if len(matches) != 3 {
+		t.Errorf("Expected 3 unfiltered matches, got %d", len(matches))
+	}
+}
+
+// TestGetGeometryFromVegreferanseRange tests joining of segmented road network geometry
+func TestGetGeometryFromVegreferanseRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vegnett/api/v4/vegnett/veglenkesekvenser/segmentert" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.URL.Query().Get("vegsystemreferanse"); got != "EV6S10D1m200-1500" {
+			t.Errorf("Unexpected vegsystemreferanse filter %q", got)
+		}
+
+		// Return two pages: the second segment is against the metering direction
+		// and the third segment is disconnected from the others
+		if r.URL.Query().Get("start") == "" {
+			fmt.Fprint(w, `{"objekter":[
+				{"geometri":{"wkt":"LINESTRING Z(100 100 2, 100 0 1)","srid":5973},
+				 "vegsystemreferanse":{"strekning":{"fra_meter":900,"til_meter":1000,"retning":"MOT"}}},
+				{"geometri":{"wkt":"LINESTRING Z(0 0 0, 100 0 1)","srid":5973},
+				 "vegsystemreferanse":{"strekning":{"fra_meter":800,"til_meter":900,"retning":"MED"}}}
+			],"metadata":{"returnert":2,"neste":{"start":"page2"}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"objekter":[
+			{"geometri":{"wkt":"LINESTRING Z(500 500 3, 600 500 3)","srid":5973},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":1200,"til_meter":1300,"retning":"MED"}}}
+		],"metadata":{"returnert":1,"neste":{"start":"page2"}}}`)
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithBaseURL(server.URL))
+
+	result, err := api.GetGeometryFromVegreferanseRange("EV6 S10D1 m200-1500")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Length != 300 {
+		t.Errorf("Expected length 300, got %f", result.Length)
+	}
+
+	expected := "MULTILINESTRING Z ((0 0 0, 100 0 1, 100 100 2), (500 500 3, 600 500 3))"
+	if wkt := result.Geometry.WKT(); wkt != expected {
+		t.Errorf("Expected %s, got %s", expected, wkt)
+	}
+}
+
+// TestGetGeometryFromVegreferanseRangeWGS84 tests the axis order, multi-line segments and the join
+// tolerance of road network geometry in WGS84
+func TestGetGeometryFromVegreferanseRangeWGS84(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// NVDB returns latitude first. The first segment has two lines, the second continues the
+		// last of them and the third starts about 5 cm further east, beyond the join tolerance.
+		fmt.Fprint(w, `{"objekter":[
+			{"geometri":{"wkt":"MULTILINESTRING((63.4 10.4, 63.4 10.401), (63.4 10.402, 63.4 10.403))","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":0,"til_meter":100,"retning":"MED"}}},
+			{"geometri":{"wkt":"LINESTRING(63.401 10.403, 63.4 10.403)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":100,"til_meter":200,"retning":"MOT"}}},
+			{"geometri":{"wkt":"LINESTRING(63.401 10.403001, 63.402 10.403001)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":200,"til_meter":300,"retning":"MED"}}}
+		],"metadata":{"returnert":3}}`)
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithBaseURL(server.URL), WithSRID(SRIDWGS84))
+
+	result, err := api.GetGeometryFromVegreferanseRange("EV6 S10D1 m0-300")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Length != 300 {
+		t.Errorf("Expected length 300, got %f", result.Length)
+	}
+
+	expected := "MULTILINESTRING ((10.4 63.4, 10.401 63.4), (10.402 63.4, 10.403 63.4, 10.403 63.401), (10.403001 63.401, 10.403001 63.402))"
+	if wkt := result.Geometry.WKT(); wkt != expected {
+		t.Errorf("Expected %s, got %s", expected, wkt)
+	}
+}
+
+// TestGetGeometryFromVegreferanseRangeLength tests that the length counts the road meters once,
+// whatever the number of carriageways and the coordinate system
+func TestGetGeometryFromVegreferanseRangeLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Two carriageways side by side over m0-100, then one road over m100-250 and a ramp
+		// overlapping both over m50-150
+		fmt.Fprint(w, `{"objekter":[
+			{"geometri":{"wkt":"LINESTRING(63.4 10.4, 63.401 10.4)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":0,"til_meter":100,"retning":"MED"}}},
+			{"geometri":{"wkt":"LINESTRING(63.401 10.4001, 63.4 10.4001)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":0,"til_meter":100,"retning":"MOT"}}},
+			{"geometri":{"wkt":"LINESTRING(63.4005 10.4, 63.4015 10.4)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":50,"til_meter":150,"retning":"MED"}}},
+			{"geometri":{"wkt":"LINESTRING(63.401 10.4, 63.4025 10.4)","srid":4326},
+			 "vegsystemreferanse":{"strekning":{"fra_meter":100,"til_meter":250,"retning":"MED"}}}
+		],"metadata":{"returnert":4}}`)
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithBaseURL(server.URL), WithSRID(SRIDWGS84))
+
+	result, err := api.GetGeometryFromVegreferanseRange("EV6 S10D1 m0-250")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Length != 250 {
+		t.Errorf("Expected length 250, got %f", result.Length)
+	}
+}
+
+// TestGetCoordinatesFromLegacyVegreferanse tests conversion of legacy vegreferanse via the road link sequence position
+func TestGetCoordinatesFromLegacyVegreferanse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path != "/vegnett/api/v4/veg":
+			http.NotFound(w, r)
+		case q.Get("vegreferanse") == "1500FV7834hp1m11" && q.Get("tidspunkt") == "2019-12-31":
+			fmt.Fprint(w, `{"veglenkesekvens":{"veglenkesekvensid":1234,"relativPosisjon":0.25,"kortform":"0.25@1234"},
+				"geometri":{"wkt":"POINT Z(100 200 5)","srid":5973}}`)
+		case q.Get("vegreferanse") == "1500FV7834hp2m11":
+			fmt.Fprint(w, `{"veglenkesekvens":{"veglenkesekvensid":999,"relativPosisjon":0.5,"kortform":"0.5@999"},
+				"geometri":{"wkt":"POINT Z(300 400 5)","srid":5973}}`)
+		case q.Get("veglenkesekvens") == "0.25@1234" && q.Get("tidspunkt") == "":
+			fmt.Fprint(w, `{"vegsystemreferanse":{"kortform":"FV7834 S1D1 m11"},
+				"geometri":{"wkt":"POINT Z(101 201 5)","srid":5973}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	api := NewVegvesenetAPIV4(WithRateLimit(100, time.Second), WithBaseURL(server.URL))
+
+	parse := func(text string) vegref.LegacyVegreferanse {
+		ref, err := vegref.ParseLegacyVegreferanse(text)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", text, err)
+		}
+		return ref
+	}
+
+	t.Run("Existing", func(t *testing.T) {
+		conversion, err := api.GetCoordinatesFromLegacyVegreferanse(parse("1500 Fv7834 hp1 m11"), "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if conversion.Retired || conversion.Vegsystemreferanse != "FV7834 S1D1 m11" {
+			t.Errorf("Unexpected conversion: %+v", conversion)
+		}
+		if conversion.Coordinate.X != 101 || conversion.Coordinate.Y != 201 {
+			t.Errorf("Expected current coordinates (101, 201), got %+v", conversion.Coordinate)
+		}
+	})
+
+	t.Run("Retired", func(t *testing.T) {
+		conversion, err := api.GetCoordinatesFromLegacyVegreferanse(parse("1500 Fv7834 hp2 m11"), "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !conversion.Retired || conversion.Vegsystemreferanse != "" {
+			t.Errorf("Expected retired conversion, got %+v", conversion)
+		}
+		if conversion.Coordinate.X != 300 || conversion.Coordinate.Y != 400 {
+			t.Errorf("Expected historical coordinates (300, 400), got %+v", conversion.Coordinate)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := api.GetCoordinatesFromLegacyVegreferanse(parse("1500 Fv7834 hp3 m11"), ""); err == nil {
+			t.Error("Expected error for unknown legacy vegreferanse, got none")
+		}
+	})
+}
+
+// TestParseWKTToCoordinate tests extraction of a coordinate from POINT geometries
+func TestParseWKTToCoordinate(t *testing.T) {
+	coordinate, err := parseWKTToCoordinate("POINT Z(261234.5 7041234.25 12.3)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if coordinate.X != 261234.5 || coordinate.Y != 7041234.25 {
+		t.Errorf("Expected (261234.5, 7041234.25), got (%f, %f)", coordinate.X, coordinate.Y)
+	}
+
+	for _, wkt := range []string{"POINT EMPTY", "LINESTRING (0 0, 1 1)", "INVALID"} {
+		if _, err := parseWKTToCoordinate(wkt); err == nil {
+			t.Errorf("Expected error for %q, got none", wkt)
+		}
+	}
+}
+
+// TestClientStats tests the request counters used for progress reporting
+func TestClientStats(t *testing.T) {
+	diskCache, err := cache.NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk cache: %v", err)
+	}
+	api := newFixtureAPI(t, WithDiskCache(diskCache))
+
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetVegreferanseMatches(253671.97, 6648897.78); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if _, err := api.GetCoordinatesFromVegreferanse("FV7834 S1D1 m11"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := ClientStats{APICalls: 2, CacheHits: 1, CacheMisses: 1}
+	if stats := api.Stats(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+}
+
+// TestOffline tests that an offline client answers from the disk cache only and collects the misses
+func TestOffline(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	diskCache, err := cache.NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk cache: %v", err)
+	}
+	cached := []vegref.VegreferanseMatch{{Avstand: 1}}
+	if err := diskCache.Set(253671.97, 6648897.78, "", cached); err != nil {
+		t.Fatalf("Failed to set entry: %v", err)
+	}
+	api := NewVegvesenetAPIV4(WithBaseURL(server.URL), WithDiskCache(diskCache), WithOffline())
+
+	if matches, err := api.GetVegreferanseMatches(253671.97, 6648897.78); err != nil || len(matches) != 1 {
+		t.Errorf("Expected the cached matches, got %v, %v", matches, err)
+	}
+	for _, key := range []MissingKey{{X: 300000, Y: 6700000}, {X: 200000, Y: 6700000, Date: "2020-01-01"}, {X: 300000, Y: 6700000}} {
+		_, err := api.GetVegreferanseMatchesAt(key.X, key.Y, key.Date)
+		if !errors.Is(err, ErrCacheMiss) || logging.ErrorCategory(err) != logging.CategoryCacheMiss {
+			t.Errorf("Expected a cache miss for %+v, got %v", key, err)
+		}
+	}
+	if _, err := api.GetCoordinatesFromVegreferanse("FV7834 S1D1 m11"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Expected a cache miss for a vegreferanse lookup, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests, got %d", requests)
+	}
+
+	expected := []MissingKey{{X: 300000, Y: 6700000}, {X: 200000, Y: 6700000, Date: "2020-01-01"}}
+	if keys := api.MissingKeys(); !slices.Equal(keys, expected) {
+		t.Errorf("Expected missing keys %v, got %v", expected, keys)
+	}
+
+	path := filepath.Join(t.TempDir(), "missing.txt")
+	if err := WriteMissingKeys(path, expected); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "X\tY\tDate\n300000.000000\t6700000.000000\t\n200000.000000\t6700000.000000\t2020-01-01\n" {
+		t.Errorf("Unexpected missing keys file %q", data)
+	}
+}
@@ -0,0 +1,222 @@
+// NVDB Test Server Component
+//
+// This component provides an offline stand-in for the NVDB API v4, so that tests of the API client
+// and the conversion pipeline can run without network access.
+//
+// Key features:
+// - httptest server answering /vegnett/api/v4/posisjon and /vegnett/api/v4/veg/batch (and any other
+//   recorded endpoint) from recorded JSON fixtures
+// - Requests are matched on path and query parameters, in any order
+// - Recorded status codes are replayed, so not found and error responses can be tested
+// - Record mode (NVDBTEST_RECORD=1) forwards requests to the live API and saves the responses
+// - Requests without a fixture fail the test with the request that needs to be recorded
+
+package nvdbtest
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// RecordEnv is the environment variable that enables record mode when set to 1
+const RecordEnv = "NVDBTEST_RECORD"
+
+// UpstreamURL is the live NVDB API that requests are forwarded to in record mode
+const UpstreamURL = "https://nvdbapiles.atlas.vegvesen.no"
+
+// Fixture is a recorded NVDB API response to a single request
+type Fixture struct {
+	Path   string          `json:"path"`
+	Query  string          `json:"query"` // Canonical (sorted) encoded query parameters
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Server is a fake NVDB API server backed by a fixture file
+type Server struct {
+	*httptest.Server
+
+	t           testing.TB
+	fixturePath string
+	record      bool
+
+	mu       sync.Mutex
+	fixtures []Fixture
+	recorded bool
+	requests int
+}
+
+// NewServer starts a fake NVDB API server serving the fixtures in fixturePath. In record mode the
+// requests are forwarded to the live API instead, and the responses are merged into the fixture
+// file when the test completes. The server is closed when the test completes.
+func NewServer(t testing.TB, fixturePath string) *Server {
+	t.Helper()
+
+	s := &Server{
+		t:           t,
+		fixturePath: fixturePath,
+		record:      os.Getenv(RecordEnv) == "1",
+	}
+
+	fixtures, err := LoadFixtures(fixturePath)
+	if err != nil && !(s.record && errors.Is(err, os.ErrNotExist)) {
+		t.Fatalf("Failed to load NVDB fixtures: %v", err)
+	}
+	s.fixtures = fixtures
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(func() {
+		s.Close()
+		if err := s.save(); err != nil {
+			t.Errorf("Failed to save recorded NVDB fixtures: %v", err)
+		}
+	})
+	return s
+}
+
+// Requests returns the number of requests the server has received
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// LoadFixtures reads the fixtures from a fixture file
+func LoadFixtures(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture file %s: %w", path, err)
+	}
+	for i := range fixtures {
+		if fixtures[i].Query, err = canonicalQuery(fixtures[i].Query); err != nil {
+			return nil, fmt.Errorf("invalid query in fixture for %s: %w", fixtures[i].Path, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// handle answers a request from the fixtures, or from the live API in record mode
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	query, err := canonicalQuery(r.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests++
+	s.mu.Unlock()
+
+	var fixture Fixture
+	if s.record {
+		fixture, err = s.forward(r, query)
+		if err != nil {
+			s.t.Errorf("Failed to record %s?%s: %v", r.URL.Path, query, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	} else {
+		var found bool
+		fixture, found = s.lookup(r.URL.Path, query)
+		if !found {
+			s.t.Errorf("No NVDB fixture for %s?%s in %s, record it with %s=1", r.URL.Path, query, s.fixturePath, RecordEnv)
+			http.Error(w, "no fixture recorded for this request", http.StatusNotImplemented)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fixture.Status)
+	_, _ = w.Write(fixture.Body)
+}
+
+// lookup returns the fixture recorded for a request
+func (s *Server) lookup(path, query string) (Fixture, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, fixture := range s.fixtures {
+		if fixture.Path == path && fixture.Query == query {
+			return fixture, true
+		}
+	}
+	return Fixture{}, false
+}
+
+// forward sends a request to the live API and stores the response as a fixture
+func (s *Server) forward(r *http.Request, query string) (Fixture, error) {
+	req, err := http.NewRequest(r.Method, UpstreamURL+r.URL.RequestURI(), nil)
+	if err != nil {
+		return Fixture{}, err
+	}
+	req.Header = r.Header.Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Fixture{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Fixture{}, err
+	}
+	if !json.Valid(body) {
+		return Fixture{}, fmt.Errorf("response with status %d is not JSON: %s", resp.StatusCode, body)
+	}
+
+	fixture := Fixture{Path: r.URL.Path, Query: query, Status: resp.StatusCode, Body: body}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures = slices.DeleteFunc(s.fixtures, func(f Fixture) bool {
+		return f.Path == fixture.Path && f.Query == fixture.Query
+	})
+	s.fixtures = append(s.fixtures, fixture)
+	s.recorded = true
+	return fixture, nil
+}
+
+// save writes the fixtures back to the fixture file if any responses were recorded
+func (s *Server) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recorded {
+		return nil
+	}
+
+	// Sort the fixtures so that re-recording gives small diffs
+	slices.SortFunc(s.fixtures, func(a, b Fixture) int {
+		return cmp.Or(cmp.Compare(a.Path, b.Path), cmp.Compare(a.Query, b.Query))
+	})
+
+	data, err := json.MarshalIndent(s.fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.fixturePath, append(data, '\n'), 0644)
+}
+
+// canonicalQuery encodes query parameters with sorted keys, so that requests match their fixture
+// regardless of the order the client added the parameters in
+func canonicalQuery(rawQuery string) (string, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+	return values.Encode(), nil
+}
@@ -0,0 +1,55 @@
+package nvdbtest
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestServerReplaysFixtures tests that requests are answered from the fixture file
+func TestServerReplaysFixtures(t *testing.T) {
+	fixturePath := filepath.Join(t.TempDir(), "fixtures.json")
+	fixtures := `[
+		{"path": "/vegnett/api/v4/posisjon", "query": "ost=1&nord=2", "status": 200, "body": [{"avstand": 1.5}]},
+		{"path": "/vegnett/api/v4/veg/batch", "query": "vegsystemreferanser=EV6+S1D1+m10", "status": 404, "body": {"messages": []}}
+	]`
+	if err := os.WriteFile(fixturePath, []byte(fixtures), 0644); err != nil {
+		t.Fatalf("Failed to write fixtures: %v", err)
+	}
+
+	server := NewServer(t, fixturePath)
+
+	testCases := []struct {
+		path           string
+		expectedStatus int
+		expectedBody   string
+		description    string
+	}{
+		{"/vegnett/api/v4/posisjon?nord=2&ost=1", http.StatusOK, `[{"avstand": 1.5}]`, "Query parameters in another order"},
+		{"/vegnett/api/v4/veg/batch?vegsystemreferanser=EV6%20S1D1%20m10", http.StatusNotFound, `{"messages": []}`, "Recorded error status"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			if string(body) != tc.expectedBody {
+				t.Errorf("Expected body %s, got %s", tc.expectedBody, body)
+			}
+		})
+	}
+
+	if server.Requests() != len(testCases) {
+		t.Errorf("Expected %d requests, got %d", len(testCases), server.Requests())
+	}
+}
@@ -0,0 +1,94 @@
+// Offline Mode Component
+//
+// This component lets the API client run without network access, answering position lookups
+// from the disk cache only. It is used in the field, after the cache has been filled with the
+// warm-up command while the network was good.
+//
+// Key features:
+// - No request is ever sent: lookups that are not in the disk cache fail at once with ErrCacheMiss
+// - Cache misses are categorized as cache_miss, apart from network and API errors
+// - The coordinates of the misses are collected and written as an input file for the warm-up command
+
+package nvdb
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+)
+
+// ErrCacheMiss is returned by an offline client for lookups that are not in the disk cache
+var ErrCacheMiss = errors.New("not in the disk cache")
+
+// MissingKey is a position lookup an offline client could not answer from the disk cache
+type MissingKey struct {
+	X, Y float64
+	Date string // Date of a historical lookup, empty for the client's default date
+}
+
+// missingKeys collects the cache misses of an offline client
+type missingKeys struct {
+	mu   sync.Mutex
+	keys map[MissingKey]struct{}
+}
+
+// add records a cache miss
+func (m *missingKeys) add(key MissingKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[MissingKey]struct{})
+	}
+	m.keys[key] = struct{}{}
+}
+
+// offlineError returns the error of a lookup an offline client can not answer
+func offlineError(what string) error {
+	return logging.Categorize(logging.CategoryCacheMiss, fmt.Errorf("offline: %s: %w", what, ErrCacheMiss))
+}
+
+// Offline reports whether the client answers from the disk cache only
+func (api *VegvesenetAPIV4) Offline() bool {
+	return api.offline
+}
+
+// MissingKeys returns the position lookups an offline client could not answer from the disk
+// cache, sorted by date and coordinate
+func (api *VegvesenetAPIV4) MissingKeys() []MissingKey {
+	api.missing.mu.Lock()
+	defer api.missing.mu.Unlock()
+	keys := make([]MissingKey, 0, len(api.missing.keys))
+	for key := range api.missing.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b MissingKey) int {
+		if c := strings.Compare(a.Date, b.Date); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.X, b.X); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Y, b.Y)
+	})
+	return keys
+}
+
+// WriteMissingKeys writes missing keys as a tab-delimited file with the columns X, Y and Date,
+// which the warm-up command reads with -x-column=0 -y-column=1
+func WriteMissingKeys(path string, keys []MissingKey) error {
+	var sb strings.Builder
+	sb.WriteString("X\tY\tDate\n")
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "%.6f\t%.6f\t%s\n", key.X, key.Y, key.Date)
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write missing keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// API Client Options Component
+//
+// This component provides the functional options used to configure the NVDB API v4 client.
+//
+// Key features:
+// - Rate limiting, either per client or shared between several clients
+// - Optional disk cache, and offline lookups from the disk cache only
+// - Coordinate system (SRID), historical date (tidspunkt) and candidate road filters
+// - Alternative API base URL and HTTP client, e.g. for test servers
+
+package nvdb
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/cache"
+)
+
+// Defaults used when the corresponding option is not given
+const (
+	DefaultBaseURL            = "https://nvdbapiles.atlas.vegvesen.no"
+	DefaultRateLimit          = 40 // NVDB allows 40 calls per second
+	DefaultRateLimitTimeFrame = time.Second
+	DefaultHTTPTimeout        = 10 * time.Second
+)
+
+// Option configures the API client
+type Option func(*VegvesenetAPIV4)
+
+// WithRateLimit limits the client to callsLimit API calls per time frame
+func WithRateLimit(callsLimit int, timeFrame time.Duration) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.rateLimiter = NewRateLimiter(callsLimit, timeFrame)
+	}
+}
+
+// WithRateLimiter uses a rate limiter that may be shared with other clients. A nil rate limiter
+// disables rate limiting, e.g. when replaying recorded responses.
+func WithRateLimiter(rateLimiter *RateLimiter) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.rateLimiter = rateLimiter
+	}
+}
+
+// WithDiskCache caches position lookups in the given disk cache. A nil cache disables caching.
+func WithDiskCache(diskCache *cache.DiskCache) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.diskCache = diskCache
+	}
+}
+
+// WithOffline answers position lookups from the disk cache only. No request is sent; lookups that
+// are not in the disk cache, and all other lookups, fail with ErrCacheMiss.
+func WithOffline() Option {
+	return func(api *VegvesenetAPIV4) {
+		api.offline = true
+	}
+}
+
+// WithSRID sets the spatial reference system used for both input coordinates and returned geometry
+func WithSRID(srid int) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.srid = srid
+	}
+}
+
+// WithTidspunkt sets the default date (YYYY-MM-DD) at which the road network is queried.
+// An empty date queries the current road network.
+func WithTidspunkt(date string) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.tidspunkt = date
+	}
+}
+
+// WithRoadFilter restricts the candidate roads returned for coordinate lookups
+func WithRoadFilter(filter RoadFilter) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.roadFilter = filter
+	}
+}
+
+// WithBaseURL sends requests to another API server than the public NVDB API
+func WithBaseURL(baseURL string) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient sends requests with the given HTTP client instead of the default client with a
+// DefaultHTTPTimeout timeout, e.g. with a Recorder or Replayer as transport
+func WithHTTPClient(client *http.Client) Option {
+	return func(api *VegvesenetAPIV4) {
+		api.apiClient = client
+	}
+}
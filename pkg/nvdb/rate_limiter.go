@@ -0,0 +1,75 @@
+// Rate Limiter Component
+//
+// This component limits the rate of calls to the NVDB API to comply with its usage policies.
+//
+// Key features:
+// - Sliding window of call timestamps within a configurable time frame
+// - Thread-safe, so a single limiter can be shared by all workers and clients
+
+package nvdb
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter handles API rate limiting
+type RateLimiter struct {
+	calls     []time.Time
+	limit     int
+	timeFrame time.Duration
+	mu        sync.Mutex
+}
+
+// NewRateLimiter creates a new rate limiter
+func NewRateLimiter(limit int, timeFrame time.Duration) *RateLimiter {
+	return &RateLimiter{
+		calls:     make([]time.Time, 0, limit),
+		limit:     limit,
+		timeFrame: timeFrame,
+	}
+}
+
+// Wait blocks until a new API call is allowed
+func (r *RateLimiter) Wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	// Remove timestamps older than the time frame
+	validCalls := make([]time.Time, 0, len(r.calls))
+	for _, call := range r.calls {
+		if now.Sub(call) < r.timeFrame {
+			validCalls = append(validCalls, call)
+		}
+	}
+	r.calls = validCalls
+
+	// If we've reached the limit, wait until we can make a new call
+	if len(r.calls) >= r.limit {
+		oldest := r.calls[0]
+		waitTime := r.timeFrame - now.Sub(oldest)
+		if waitTime > 0 {
+			time.Sleep(waitTime)
+			now = time.Now()
+
+			// Re-filter calls after waiting since more might have expired
+			validCalls = make([]time.Time, 0, len(r.calls))
+			for _, call := range r.calls {
+				if now.Sub(call) < r.timeFrame {
+					validCalls = append(validCalls, call)
+				}
+			}
+			r.calls = validCalls
+		}
+	}
+
+	// Add the new call time
+	r.calls = append(r.calls, now)
+}
+
+// CallsPerSecond returns the configured limit as API calls per second
+func (r *RateLimiter) CallsPerSecond() float64 {
+	return float64(r.limit) / r.timeFrame.Seconds()
+}
@@ -0,0 +1,179 @@
+// Road Filter Component
+//
+// This component restricts the candidate roads returned for a coordinate to the roads a project
+// is interested in, e.g. only national roads in use or only roads for pedestrians and cyclists.
+//
+// Key features:
+// - Filtering on road category, phase, traffic group and intersection/side facility arms
+// - Filters are forwarded to NVDB where supported so that fewer irrelevant candidates are returned
+// - All filters are applied client-side as well, since NVDB ignores filters it does not support
+// - Canonical cache options so that cached responses are never shared between different filters
+
+package nvdb
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Traffic groups (trafikantgruppe) in NVDB
+const (
+	TrafikantgruppeKjorende = "K" // Motor vehicles
+	TrafikantgruppeGaende   = "G" // Pedestrians and cyclists
+)
+
+// RoadFilter restricts which roads are accepted as candidates for a coordinate.
+// The zero value accepts all roads.
+type RoadFilter struct {
+	Kategorier      []string // Accepted Vegkategori values, all when empty
+	Faser           []string // Accepted Fase values, all when empty
+	Trafikantgruppe string   // Accepted traffic group, all when empty
+	ExcludeArms     bool     // Reject intersection and side facility arms
+}
+
+// ParseRoadFilter creates a filter from comma separated lists of categories and phases, e.g. "E,R" and "V"
+func ParseRoadFilter(categories, phases, trafficGroup string, excludeArms bool) (RoadFilter, error) {
+	filter := RoadFilter{ExcludeArms: excludeArms}
+
+	var err error
+	if filter.Kategorier, err = parseFilterList(categories, "ERFKPS", "road category"); err != nil {
+		return RoadFilter{}, err
+	}
+	if filter.Faser, err = parseFilterList(phases, "VAPF", "phase"); err != nil {
+		return RoadFilter{}, err
+	}
+
+	filter.Trafikantgruppe = strings.ToUpper(strings.TrimSpace(trafficGroup))
+	switch filter.Trafikantgruppe {
+	case "", TrafikantgruppeKjorende, TrafikantgruppeGaende:
+	default:
+		return RoadFilter{}, fmt.Errorf("invalid traffic group %q, must be K (motor vehicles) or G (pedestrians and cyclists)", trafficGroup)
+	}
+
+	return filter, nil
+}
+
+// parseFilterList parses a comma separated list of single letter codes, returning them sorted and without duplicates
+func parseFilterList(list, allowed, name string) ([]string, error) {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		if len(value) != 1 || !strings.Contains(allowed, value) {
+			return nil, fmt.Errorf("invalid %s %q, must be one of %s", name, value, strings.Join(strings.Split(allowed, ""), ", "))
+		}
+		values = append(values, value)
+	}
+	slices.Sort(values)
+	return slices.Compact(values), nil
+}
+
+// IsEmpty reports whether the filter accepts all roads
+func (f RoadFilter) IsEmpty() bool {
+	return len(f.Kategorier) == 0 && len(f.Faser) == 0 && f.Trafikantgruppe == "" && !f.ExcludeArms
+}
+
+// String describes the filter for logging, e.g. "categories E,R; phases V; traffic group G; no arms"
+func (f RoadFilter) String() string {
+	if f.IsEmpty() {
+		return "all roads"
+	}
+	var parts []string
+	if len(f.Kategorier) > 0 {
+		parts = append(parts, "categories "+strings.Join(f.Kategorier, ","))
+	}
+	if len(f.Faser) > 0 {
+		parts = append(parts, "phases "+strings.Join(f.Faser, ","))
+	}
+	if f.Trafikantgruppe != "" {
+		parts = append(parts, "traffic group "+f.Trafikantgruppe)
+	}
+	if f.ExcludeArms {
+		parts = append(parts, "no arms")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// addQueryOptions adds the filters supported by the NVDB /posisjon endpoint to the query.
+// Categories and phases are combined into the vegsystemreferanse filter, e.g. "EV,RV".
+func (f RoadFilter) addQueryOptions(q url.Values) {
+	if len(f.Kategorier) > 0 || len(f.Faser) > 0 {
+		categories := f.Kategorier
+		if len(categories) == 0 {
+			categories = strings.Split("EFKPRS", "")
+		}
+		var prefixes []string
+		for _, category := range categories {
+			if len(f.Faser) == 0 {
+				prefixes = append(prefixes, category)
+				continue
+			}
+			for _, phase := range f.Faser {
+				prefixes = append(prefixes, category+phase)
+			}
+		}
+		q.Set("vegsystemreferanse", strings.Join(prefixes, ","))
+	}
+	if f.Trafikantgruppe != "" {
+		q.Set("trafikantgruppe", f.Trafikantgruppe)
+	}
+}
+
+// cacheOptions returns the query options extended with the filters that are only applied client-side,
+// so that the cache key identifies the complete filter
+func (f RoadFilter) cacheOptions(options url.Values) url.Values {
+	if !f.ExcludeArms {
+		return options
+	}
+	options = maps.Clone(options)
+	options.Set("exclude_arms", "true")
+	return options
+}
+
+// Accepts reports whether the match passes the filter
+func (f RoadFilter) Accepts(match vegref.VegreferanseMatch) bool {
+	system := match.Vegsystemreferanse.Vegsystem
+	strekning := match.Vegsystemreferanse.Strekning
+	ref, parseErr := vegref.ParseVegsystemreferanse(match.Vegsystemreferanse.Kortform)
+
+	// Prefer the structured fields and fall back to the kortform when they are missing
+	kategori, fase := system.Vegkategori, system.Fase
+	if kategori == "" && parseErr == nil {
+		kategori, fase = ref.Kategori, ref.Fase
+	}
+
+	if len(f.Kategorier) > 0 && !slices.Contains(f.Kategorier, kategori) {
+		return false
+	}
+	if len(f.Faser) > 0 && !slices.Contains(f.Faser, fase) {
+		return false
+	}
+	if f.Trafikantgruppe != "" && strekning.Trafikantgruppe != "" && strekning.Trafikantgruppe != f.Trafikantgruppe {
+		return false
+	}
+	if f.ExcludeArms && (strekning.Arm || parseErr == nil && ref.Arm != nil) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the matches that pass the filter, keeping their order
+func (f RoadFilter) Apply(matches []vegref.VegreferanseMatch) []vegref.VegreferanseMatch {
+	if f.IsEmpty() {
+		return matches
+	}
+	filtered := make([]vegref.VegreferanseMatch, 0, len(matches))
+	for _, match := range matches {
+		if f.Accepts(match) {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
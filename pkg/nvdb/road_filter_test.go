@@ -0,0 +1,107 @@
+package nvdb
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// TestParseRoadFilter tests parsing and validation of the road filter options
+func TestParseRoadFilter(t *testing.T) {
+	testCases := []struct {
+		categories   string
+		phases       string
+		trafficGroup string
+		expected     string // String() of the parsed filter
+		expectError  bool
+		description  string
+	}{
+		{"", "", "", "all roads", false, "No filter"},
+		{"r, e,E", "v", "", "categories E,R; phases V", false, "Lowercase, spaces and duplicates"},
+		{"", "", "g", "traffic group G", false, "Traffic group only"},
+		{"EV", "", "", "", true, "Category with phase"},
+		{"X", "", "", "", true, "Unknown category"},
+		{"", "Q", "", "", true, "Unknown phase"},
+		{"", "", "B", "", true, "Unknown traffic group"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			filter, err := ParseRoadFilter(tc.categories, tc.phases, tc.trafficGroup, false)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got filter %s", filter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if filter.String() != tc.expected {
+				t.Errorf("Expected filter %q, got %q", tc.expected, filter.String())
+			}
+		})
+	}
+}
+
+// TestRoadFilterQueryOptions tests the filters forwarded to NVDB
+func TestRoadFilterQueryOptions(t *testing.T) {
+	testCases := []struct {
+		filter      RoadFilter
+		expected    string
+		description string
+	}{
+		{RoadFilter{}, "", "No filter"},
+		{RoadFilter{Kategorier: []string{"E", "R"}}, "vegsystemreferanse=E%2CR", "Categories"},
+		{RoadFilter{Kategorier: []string{"E", "R"}, Faser: []string{"V"}}, "vegsystemreferanse=EV%2CRV", "Categories and phase"},
+		{RoadFilter{Faser: []string{"A"}}, "vegsystemreferanse=EA%2CFA%2CKA%2CPA%2CRA%2CSA", "Phase only"},
+		{RoadFilter{Trafikantgruppe: "G"}, "trafikantgruppe=G", "Traffic group"},
+		{RoadFilter{ExcludeArms: true}, "", "Arms are filtered client-side only"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			q := url.Values{}
+			tc.filter.addQueryOptions(q)
+			if q.Encode() != tc.expected {
+				t.Errorf("Expected query %q, got %q", tc.expected, q.Encode())
+			}
+		})
+	}
+}
+
+// TestRoadFilterAccepts tests client-side filtering of candidate matches
+func TestRoadFilterAccepts(t *testing.T) {
+	match := func(kortform, trafikantgruppe string, arm bool) vegref.VegreferanseMatch {
+		var m vegref.VegreferanseMatch
+		m.Vegsystemreferanse.Kortform = kortform
+		m.Vegsystemreferanse.Strekning.Trafikantgruppe = trafikantgruppe
+		m.Vegsystemreferanse.Strekning.Arm = arm
+		return m
+	}
+
+	filter := RoadFilter{Kategorier: []string{"E", "R"}, Faser: []string{"V"}, Trafikantgruppe: "K", ExcludeArms: true}
+
+	testCases := []struct {
+		match       vegref.VegreferanseMatch
+		expected    bool
+		description string
+	}{
+		{match("EV6 S1D1 m10", "K", false), true, "Accepted road"},
+		{match("FV100 S1D1 m10", "K", false), false, "Wrong category"},
+		{match("RA4 S1D1 m10", "K", false), false, "Wrong phase"},
+		{match("EV6 S1D1 m10", "G", false), false, "Wrong traffic group"},
+		{match("EV6 S1D1 m10", "", false), true, "Missing traffic group is accepted"},
+		{match("EV6 S1D1 m10", "K", true), false, "Arm flag"},
+		{match("EV6 S1D1 m10 KD1 m5", "K", false), false, "Intersection part in kortform"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := filter.Accepts(tc.match); got != tc.expected {
+				t.Errorf("Expected Accepts(%s) to be %v, got %v", tc.match.Vegsystemreferanse.Kortform, tc.expected, got)
+			}
+		})
+	}
+}
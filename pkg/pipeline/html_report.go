@@ -0,0 +1,310 @@
+// HTML Report Component
+//
+// This component writes a self-contained HTML report of a coord_to_vegref conversion, to be mailed
+// around and opened offline.
+//
+// Key features:
+// - Road summary and quality statistics as tables
+// - Inline SVG plot of the input track, with the snapped positions on the road where available,
+//   true to scale in projected and geographic coordinate systems
+// - Points coloured per road number, with the row and vegreferanse as hover text
+// - No scripts, external tiles, fonts or stylesheets
+
+package pipeline
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// MaxPlotPoints is the most rows plotted in the HTML report; longer tracks are thinned out evenly
+const MaxPlotPoints = 20000
+
+// Size of the track plot in pixels
+const (
+	plotWidth   = 900.0
+	plotMargin  = 20.0
+	plotMinSide = 300.0
+)
+
+// unmatchedColour is the colour of rows without a road
+const unmatchedColour = "#9e9e9e"
+
+// minPlotSpan is the smallest extent of the plotted area in meters, so that a track standing still
+// is not blown up to fill the plot
+const minPlotSpan = 1.0
+
+// plotMetersPerDegree is the length of a degree of latitude, used to express minPlotSpan in degrees
+const plotMetersPerDegree = 111320.0
+
+// roadColours are assigned to the roads in sorted order, repeating for more roads
+var roadColours = []string{
+	"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd",
+	"#8c564b", "#e377c2", "#17becf", "#bcbd22", "#393b79",
+}
+
+// plotPoint is a row drawn in the track plot, in plot coordinates
+type plotPoint struct {
+	X, Y         float64
+	SnapX, SnapY float64
+	Snapped      bool
+	Colour       string
+	Title        string
+}
+
+// plotLegend is a road with its colour in the track plot
+type plotLegend struct {
+	Road   string
+	Colour string
+}
+
+// trackPlot is the SVG plot of the input track
+type trackPlot struct {
+	Width, Height   float64
+	Track           string // Points of the polyline through the input coordinates
+	Points          []plotPoint
+	Legend          []plotLegend
+	UnmatchedColour string // Colour of the rows without a road
+	Plotted         int    // Rows plotted
+	Skipped         int    // Rows without valid coordinates
+	Thinned         bool
+}
+
+// htmlReport holds the contents of the HTML report
+type htmlReport struct {
+	Input     string
+	Generated string
+	Runs      []RoadRun
+	Quality   QualityReport
+	Plot      trackPlot
+}
+
+// rowCoordinate returns the input coordinate of a coord_to_vegref row
+func rowCoordinate(line string, modeConfig CoordToVegrefConfig) (vegref.Coordinate, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) <= max(modeConfig.XColumn, modeConfig.YColumn) {
+		return vegref.Coordinate{}, false
+	}
+	x, errX := strconv.ParseFloat(fields[modeConfig.XColumn], 64)
+	y, errY := strconv.ParseFloat(fields[modeConfig.YColumn], 64)
+	if errX != nil || errY != nil {
+		return vegref.Coordinate{}, false
+	}
+	return vegref.Coordinate{X: x, Y: y}, true
+}
+
+// rowTitle returns the hover text of a row in the track plot
+func rowTitle(result Result) string {
+	switch {
+	case result.Err != nil:
+		return fmt.Sprintf("Row %d: %v", rowNumber(result), result.Err)
+	case result.Vegreferanse == "":
+		return fmt.Sprintf("Row %d: no match", rowNumber(result))
+	default:
+		return fmt.Sprintf("Row %d: %s", rowNumber(result), result.Vegreferanse)
+	}
+}
+
+// newTrackPlot plots the input coordinates of the results, with the position on the road of the
+// chosen match where the API returned one. In WGS84, where X is longitude and Y latitude, X is
+// scaled by the cosine of the mean latitude so that the track keeps its shape.
+func newTrackPlot(results []Result, modeConfig CoordToVegrefConfig, srid int) trackPlot {
+	type row struct {
+		result  Result
+		input   vegref.Coordinate
+		snapped *vegref.Coordinate
+		road    string
+	}
+
+	// Thin out long tracks evenly
+	plot := trackPlot{UnmatchedColour: unmatchedColour}
+	step := 1
+	if len(results) > MaxPlotPoints {
+		step = (len(results) + MaxPlotPoints - 1) / MaxPlotPoints
+		plot.Thinned = true
+	}
+
+	var rows []row
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	sumY, extended := 0.0, 0
+	extend := func(c vegref.Coordinate) {
+		minX, maxX = math.Min(minX, c.X), math.Max(maxX, c.X)
+		minY, maxY = math.Min(minY, c.Y), math.Max(maxY, c.Y)
+		sumY += c.Y
+		extended++
+	}
+	for i := 0; i < len(results); i += step {
+		result := results[i]
+		input, ok := rowCoordinate(result.Line, modeConfig)
+		if !ok {
+			plot.Skipped++
+			continue
+		}
+		r := row{result: result, input: input, road: resultPosition(result).road}
+		if chosen, _, ok := chosenMatch(result); ok && chosen.Punkt != nil {
+			r.snapped = chosen.Punkt
+			extend(*chosen.Punkt)
+		}
+		extend(input)
+		rows = append(rows, r)
+	}
+	plot.Plotted = len(rows)
+	if len(rows) == 0 {
+		plot.Width, plot.Height = plotWidth, plotMinSide
+		return plot
+	}
+
+	// Colours in sorted road order
+	colours := make(map[string]string)
+	for _, r := range rows {
+		if r.road != "" {
+			colours[r.road] = ""
+		}
+	}
+	roads := make([]string, 0, len(colours))
+	for road := range colours {
+		roads = append(roads, road)
+	}
+	slices.Sort(roads)
+	for i, road := range roads {
+		colours[road] = roadColours[i%len(roadColours)]
+		plot.Legend = append(plot.Legend, plotLegend{Road: road, Colour: colours[road]})
+	}
+
+	// A degree of longitude is shorter than a degree of latitude away from the equator
+	xScale, minSpan := 1.0, minPlotSpan
+	if srid == nvdb.SRIDWGS84 {
+		xScale = math.Cos(sumY / float64(extended) * math.Pi / 180)
+		minSpan = minPlotSpan / plotMetersPerDegree
+	}
+
+	// Scale the bounding box into the plot, keeping the aspect ratio, with north up
+	spanX, spanY := math.Max((maxX-minX)*xScale, minSpan), math.Max(maxY-minY, minSpan)
+	inner := plotWidth - 2*plotMargin
+	scale := inner / spanX
+	height := spanY*scale + 2*plotMargin
+	if height > plotWidth {
+		scale = inner / spanY
+		height = plotWidth
+	}
+	plot.Width, plot.Height = plotWidth, math.Round(math.Max(height, plotMinSide))
+	project := func(c vegref.Coordinate) (float64, float64) {
+		x := plotMargin + (c.X-minX)*xScale*scale
+		y := plot.Height - plotMargin - (c.Y-minY)*scale
+		return math.Round(x*10) / 10, math.Round(y*10) / 10
+	}
+
+	var track strings.Builder
+	for _, r := range rows {
+		point := plotPoint{Colour: unmatchedColour, Title: rowTitle(r.result)}
+		if r.road != "" {
+			point.Colour = colours[r.road]
+		}
+		point.X, point.Y = project(r.input)
+		if r.snapped != nil {
+			point.SnapX, point.SnapY = project(*r.snapped)
+			point.Snapped = true
+		}
+		fmt.Fprintf(&track, "%g,%g ", point.X, point.Y)
+		plot.Points = append(plot.Points, point)
+	}
+	plot.Track = strings.TrimSpace(track.String())
+	return plot
+}
+
+// htmlTemplate is the layout of the HTML report, with all styling inline
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="no">
+<head>
+<meta charset="utf-8">
+<title>Vegreferanse report - {{.Input}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #212121; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.6em; text-align: left; }
+td.number { text-align: right; }
+.legend span { display: inline-block; margin-right: 1em; }
+.swatch { display: inline-block; width: 0.8em; height: 0.8em; margin-right: 0.3em; }
+svg { border: 1px solid #ccc; background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>Vegreferanse report</h1>
+<p>Input: {{.Input}}<br>Generated: {{.Generated}}</p>
+
+<h2>Track</h2>
+<p class="legend">{{range .Plot.Legend}}<span><span class="swatch" style="background: {{.Colour}}"></span>{{.Road}}</span>{{end}}<span><span class="swatch" style="background: {{.Plot.UnmatchedColour}}"></span>No road</span></p>
+<p>{{.Plot.Plotted}} rows plotted{{if .Plot.Thinned}}, thinned out evenly from a longer track{{end}}{{if .Plot.Skipped}}, {{.Plot.Skipped}} rows without valid coordinates left out{{end}}. Circles are the input coordinates, lines lead to the position on the road where known. Hover over a point for its row and vegreferanse.</p>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Plot.Width}}" height="{{.Plot.Height}}" viewBox="0 0 {{.Plot.Width}} {{.Plot.Height}}">
+<polyline points="{{.Plot.Track}}" fill="none" stroke="#bdbdbd" stroke-width="1"/>
+{{range .Plot.Points}}<g>{{if .Snapped}}<line x1="{{.X}}" y1="{{.Y}}" x2="{{.SnapX}}" y2="{{.SnapY}}" stroke="{{.Colour}}" stroke-width="1"/><rect x="{{.SnapX}}" y="{{.SnapY}}" width="3" height="3" transform="translate(-1.5,-1.5)" fill="{{.Colour}}"/>{{end}}<circle cx="{{.X}}" cy="{{.Y}}" r="3" fill="{{.Colour}}" fill-opacity="0.7"><title>{{.Title}}</title></circle></g>
+{{end}}</svg>
+
+<h2>Road summary</h2>
+{{if .Runs}}<table>
+<tr><th>Road</th><th>Strekning</th><th>Delstrekning</th><th>Rows</th><th>Metres</th><th>Direction</th><th>Row count</th><th>Gaps</th></tr>
+{{range .Runs}}<tr><td>{{.Road}}</td><td class="number">{{.Strekning}}</td><td class="number">{{.Delstrekning}}</td><td>{{.FirstRow}}-{{.LastRow}}</td><td>{{.StartMeter}}-{{.EndMeter}}</td><td>{{.Direction}}</td><td class="number">{{.Rows}}</td><td>{{range $i, $gap := .Gaps}}{{if $i}}, {{end}}{{$gap}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>No road numbers identified.</p>{{end}}
+
+<h2>Quality</h2>
+{{with .Quality}}<table>
+<tr><th>Rows</th><td class="number">{{.Rows}}</td></tr>
+<tr><th>Matched</th><td class="number">{{.Matched}}</td></tr>
+<tr><th>No match within {{.MaxDistance}} m</th><td class="number">{{.NoMatch.Count}}</td><td>{{range $i, $row := .NoMatch.Rows}}{{if $i}}, {{end}}{{$row}}{{end}}</td></tr>
+<tr><th>Ambiguous</th><td class="number">{{.Ambiguous.Count}}</td><td>{{range $i, $row := .Ambiguous.Rows}}{{if $i}}, {{end}}{{$row}}{{end}}</td></tr>
+<tr><th>Selector chose another road than the closest</th><td class="number">{{.Overridden.Count}}</td><td>{{range $i, $row := .Overridden.Rows}}{{if $i}}, {{end}}{{$row}}{{end}}</td></tr>
+<tr><th>Parse errors</th><td class="number">{{.ParseErrors.Count}}</td><td>{{range $i, $row := .ParseErrors.Rows}}{{if $i}}, {{end}}{{$row}}{{end}}</td></tr>
+<tr><th>Other errors</th><td class="number">{{.Failed.Count}}</td><td>{{range $i, $row := .Failed.Rows}}{{if $i}}, {{end}}{{$row}}{{end}}</td></tr>
+</table>
+
+<h3>Distance to the chosen match</h3>
+<table>
+<tr><th>Metres</th><th>Rows</th></tr>
+{{range .Histogram}}<tr><td>{{.Label}}</td><td class="number">{{.Rows}}</td></tr>
+{{end}}</table>
+
+{{if .Worst}}<h3>Furthest matches</h3>
+<table>
+<tr><th>Row</th><th>Distance (m)</th><th>Vegreferanse</th></tr>
+{{range .Worst}}<tr><td class="number">{{.Row}}</td><td class="number">{{printf "%.2f" .Avstand}}</td><td>{{.Vegreferanse}}</td></tr>
+{{end}}</table>{{end}}{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport writes the self-contained HTML report of a coord_to_vegref conversion, whose
+// input coordinates are in the coordinate system srid
+func writeHTMLReport(path, inputPath string, results []Result, runs []RoadRun, quality QualityReport, modeConfig CoordToVegrefConfig, srid int) error {
+	report := htmlReport{
+		Input:     filepath.Base(inputPath),
+		Generated: time.Now().Format(time.DateTime),
+		Runs:      runs,
+		Quality:   quality,
+		Plot:      newTrackPlot(results, modeConfig, srid),
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML report: %w", err)
+	}
+	defer file.Close()
+
+	if err := htmlTemplate.Execute(file, report); err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write HTML report: %w", err)
+	}
+	return nil
+}
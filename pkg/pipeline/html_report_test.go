@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// TestHTMLReport tests that the HTML report plots the track per road and depends on no external resources
+func TestHTMLReport(t *testing.T) {
+	snapped := match("EV6 S1D1 m10", 2)
+	snapped.Punkt = &vegref.Coordinate{X: 600002, Y: 6600000}
+	results := []Result{
+		{LineIdx: 0, Line: "a\t600000\t6600000", Vegreferanse: "EV6 S1D1 m10", Matches: []vegref.VegreferanseMatch{snapped}},
+		{LineIdx: 1, Line: "b\t600100\t6600050", Vegreferanse: "RV9 S1D1 m5", Matches: []vegref.VegreferanseMatch{match("RV9 S1D1 m5", 1)}},
+		{LineIdx: 2, Line: "c\t600200\t6600100"},
+		{LineIdx: 3, Line: "d\tx\t6600100", Err: logging.Categorize(logging.CategoryInput, errors.New("invalid X coordinate"))},
+	}
+	modeConfig := CoordToVegrefConfig{XColumn: 1, YColumn: 2, DateColumn: -1}
+
+	plot := newTrackPlot(results, modeConfig, nvdb.SRIDUTM33)
+	if plot.Plotted != 3 || plot.Skipped != 1 || len(plot.Points) != 3 {
+		t.Fatalf("Expected 3 plotted rows and 1 skipped, got %+v", plot)
+	}
+	if len(plot.Legend) != 2 || plot.Legend[0].Road != "EV6" || plot.Legend[1].Road != "RV9" {
+		t.Errorf("Expected a legend for EV6 and RV9, got %+v", plot.Legend)
+	}
+	first, second, third := plot.Points[0], plot.Points[1], plot.Points[2]
+	if !first.Snapped || second.Snapped || first.Colour == second.Colour || third.Colour != unmatchedColour {
+		t.Errorf("Expected a snapped first point and distinct road colours, got %+v", plot.Points)
+	}
+	if first.X >= second.X || first.Y <= second.Y {
+		t.Errorf("Expected the track to go east and north (up) in the plot, got %+v", plot.Points)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.html")
+	runs := identifyRoadRanges(results, RunTolerance{})
+	if err := writeHTMLReport(path, "track.txt", results, runs, qualityReport(results, 10), modeConfig, nvdb.SRIDUTM33); err != nil {
+		t.Fatalf("Failed to write HTML report: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read HTML report: %v", err)
+	}
+	html := string(data)
+	for _, expected := range []string{"<svg", "<title>Row 1: EV6 S1D1 m10</title>", "<title>Row 3: no match</title>", "<td>RV9</td>", "track.txt", "fill=\"#1f77b4\"", "background: " + unmatchedColour} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML report to contain %q", expected)
+		}
+	}
+	for _, unexpected := range []string{"<script", "https://", "ZgotmplZ"} {
+		if strings.Contains(html, unexpected) {
+			t.Errorf("Expected the HTML report not to contain %q", unexpected)
+		}
+	}
+
+	// A file without coordinates still gives a report
+	if err := writeHTMLReport(path, "empty.txt", nil, nil, qualityReport(nil, 10), modeConfig, nvdb.SRIDUTM33); err != nil {
+		t.Errorf("Failed to write HTML report without rows: %v", err)
+	}
+}
+
+// TestTrackPlotWGS84 tests that a track in WGS84 keeps its shape, with longitude scaled to the
+// length of a degree at its latitude
+func TestTrackPlotWGS84(t *testing.T) {
+	// Two rows 0.02 degrees of longitude apart at 60 degrees north, about 0.01 degrees of latitude
+	// in distance, and one row 0.01 degrees further north
+	results := []Result{
+		{LineIdx: 0, Line: "10.00\t60.00"},
+		{LineIdx: 1, Line: "10.02\t60.00"},
+		{LineIdx: 2, Line: "10.02\t60.01"},
+	}
+	plot := newTrackPlot(results, CoordToVegrefConfig{XColumn: 0, YColumn: 1, DateColumn: -1}, nvdb.SRIDWGS84)
+	if len(plot.Points) != 3 {
+		t.Fatalf("Expected 3 plotted rows, got %+v", plot)
+	}
+
+	east := plot.Points[1].X - plot.Points[0].X
+	north := plot.Points[1].Y - plot.Points[2].Y
+	if ratio := east / north; math.Abs(ratio-1) > 0.01 {
+		t.Errorf("Expected equal distances east and north in the plot, got %.1f and %.1f", east, north)
+	}
+}
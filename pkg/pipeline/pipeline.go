@@ -0,0 +1,1055 @@
+// Conversion Pipeline Component
+//
+// This component converts the lines of a tab-delimited input file using the providers of the
+// API client, and is what the command-line tool runs for every file based mode.
+//
+// Key features:
+// - Conversion modes for coordinates, vegreferanse, legacy vegreferanse and vegreferanse ranges
+// - Validation of the configured columns against the input file header
+// - Parallel processing with a configurable number of workers, keeping the input order
+// - Road continuity selection for coordinates along a route
+// - Per-row dates for historical lookups
+// - Summary of road numbers with their corresponding row ranges
+// - Converted rows counted by mode and status in the metrics
+
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/fileio"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/progress"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/selector"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Conversion modes
+const (
+	ModeCoordToVegref       = "coord_to_vegref"
+	ModeVegrefToCoord       = "vegref_to_coord"
+	ModeRangeToGeometry     = "vegref_range_to_geometry"
+	ModeLegacyVegrefToCoord = "legacy_vegref_to_coord"
+)
+
+// Config holds the settings of a file conversion
+type Config struct {
+	Mode        string // One of the Mode constants
+	Workers     int    // Number of concurrent workers
+	MaxDistance int    // Maximum distance in meters for filtering results in coord_to_vegref mode
+
+	// Reports of coord_to_vegref mode
+	ReportPath        string       // Optional file to write the road runs to, as .json or .csv
+	RunTolerance      RunTolerance // Interruptions absorbed into the road runs
+	QualityReportPath string       // Optional file to write the quality report to, as JSON
+	QualitySummary    bool         // Print the quality summary to stdout instead of logging it
+	HTMLReportPath    string       // Optional file to write the self-contained HTML report to
+
+	// Mode-specific configurations (only the one for Mode is used)
+	CoordToVegref *CoordToVegrefConfig
+	VegrefToCoord *VegrefToCoordConfig
+	RangeToGeom   *RangeToGeomConfig
+	LegacyToCoord *VegrefToCoordConfig
+
+	// Optional progress reporting, nil for none
+	Progress *progress.Tracker
+}
+
+// CoordToVegrefConfig holds configuration specific to coordinates to vegreferanse mode
+type CoordToVegrefConfig struct {
+	XColumn    int `validate:"min=0"`
+	YColumn    int `validate:"min=0"`
+	DateColumn int `validate:"min=-1"` // Optional column with the date to look up each row at, -1 when not used
+}
+
+// VegrefToCoordConfig holds configuration specific to vegreferanse to coordinates mode
+// and legacy vegreferanse to coordinates mode
+type VegrefToCoordConfig struct {
+	VegreferanseColumn int    `validate:"min=0"`
+	LegacyDate         string `validate:"omitempty,datetime=2006-01-02"` // Date legacy vegreferanse values are resolved at
+	DateColumn         int    `validate:"min=-1"`                        // Optional column with the date to look up each row at, -1 when not used
+}
+
+// RangeToGeomConfig holds configuration specific to vegreferanse range to geometry mode
+type RangeToGeomConfig struct {
+	VegreferanseColumn int    `validate:"min=0"`
+	GeometryFormat     string `validate:"oneof=wkt geojson"`
+}
+
+// VegreferanseProvider defines the interface for services that can convert coordinates to vegreferanse
+type VegreferanseProvider interface {
+	// GetVegreferanseFromCoordinates converts UTM33 coordinates to a vegreferanse string
+	GetVegreferanseFromCoordinates(x, y float64) (string, error)
+
+	// GetVegreferanseMatches returns all matching vegreferanses for the given coordinates
+	GetVegreferanseMatches(x, y float64) ([]vegref.VegreferanseMatch, error)
+
+	// GetVegreferanseMatchesAt returns all matching vegreferanses for the given coordinates at the given date,
+	// or at the provider's default date when empty
+	GetVegreferanseMatchesAt(x, y float64, date string) ([]vegref.VegreferanseMatch, error)
+}
+
+// CoordinateProvider defines the interface for services that can convert vegreferanse to coordinates
+type CoordinateProvider interface {
+	// GetCoordinatesFromVegreferanse converts a vegreferanse string to UTM33 coordinates
+	GetCoordinatesFromVegreferanse(vegreferanse string) (vegref.Coordinate, error)
+
+	// GetCoordinatesFromVegreferanseAt converts a vegreferanse string to coordinates at the given date,
+	// or at the provider's default date when empty
+	GetCoordinatesFromVegreferanseAt(vegreferanse, date string) (vegref.Coordinate, error)
+}
+
+// LegacyVegreferanseProvider defines the interface for services that can convert legacy vegreferanse
+type LegacyVegreferanseProvider interface {
+	// GetCoordinatesFromLegacyVegreferanse converts a legacy vegreferanse, as it was on the given date,
+	// to the current vegsystemreferanse and coordinates
+	GetCoordinatesFromLegacyVegreferanse(ref vegref.LegacyVegreferanse, date string) (vegref.LegacyConversion, error)
+}
+
+// VegrefToCoordProvider defines the interface for services that can convert both current and
+// legacy vegreferanse to coordinates, as vegref_to_coord mode converts legacy values automatically
+type VegrefToCoordProvider interface {
+	CoordinateProvider
+	LegacyVegreferanseProvider
+}
+
+// GeometryProvider defines the interface for services that can convert vegreferanse ranges to geometry
+type GeometryProvider interface {
+	// GetGeometryFromVegreferanseRange returns the road centreline geometry for a vegreferanse range
+	GetGeometryFromVegreferanseRange(vegreferanse string) (vegref.RoadGeometry, error)
+}
+
+// processTask represents a single line to be processed
+type processTask struct {
+	lineIdx int
+	line    string
+}
+
+// Result represents the result of processing a single line
+type Result struct {
+	LineIdx      int
+	Line         string
+	Vegreferanse string // Converted columns to append, tab-separated when the mode appends several columns
+	Matches      []vegref.VegreferanseMatch
+	Warning      string // Non-fatal issue to report for the line
+	Err          error
+}
+
+// inputError marks an error caused by missing or invalid values in the input row
+func inputError(err error) error {
+	return logging.Categorize(logging.CategoryInput, err)
+}
+
+// apiError wraps an error returned by the provider. Errors the provider has not categorized,
+// such as unparsable responses, are categorized as API errors.
+func apiError(err error) error {
+	return logging.Categorize(logging.CategoryAPI, fmt.Errorf("API error: %w", err))
+}
+
+// rowDate returns the date from the optional date column of a line. An empty cell, or no
+// date column (-1), results in an empty date so that the default date is used.
+func rowDate(fields []string, dateColumn int) (string, error) {
+	if dateColumn < 0 {
+		return "", nil
+	}
+	if len(fields) <= dateColumn {
+		return "", inputError(fmt.Errorf("line doesn't have enough columns for date"))
+	}
+
+	date := strings.TrimSpace(fields[dateColumn])
+	if date == "" {
+		return "", nil
+	}
+	if err := vegref.ValidateDate(date); err != nil {
+		return "", inputError(err)
+	}
+	return date, nil
+}
+
+// readInputFile reads the input file and validates the configured columns against its header
+func readInputFile(inputPath string, config Config) (string, []string, error) {
+	header, lines, err := fileio.ReadInputFile(inputPath)
+	if err != nil {
+		return "", nil, err
+	}
+	expectedColumnCount := fileio.ColumnCount(header)
+
+	// Validate column indices based on mode
+	switch config.Mode {
+	case ModeCoordToVegref:
+		if config.CoordToVegref == nil {
+			return "", nil, fmt.Errorf("coord_to_vegref configuration is not initialized")
+		}
+
+		// Validate X and Y column indices
+		if config.CoordToVegref.XColumn < 0 || config.CoordToVegref.XColumn >= expectedColumnCount {
+			return "", nil, fmt.Errorf("column X index %d is out of range (file has %d columns)",
+				config.CoordToVegref.XColumn, expectedColumnCount)
+		}
+		if config.CoordToVegref.YColumn < 0 || config.CoordToVegref.YColumn >= expectedColumnCount {
+			return "", nil, fmt.Errorf("column Y index %d is out of range (file has %d columns)",
+				config.CoordToVegref.YColumn, expectedColumnCount)
+		}
+		slog.Info("Using coordinate columns", "columns", expectedColumnCount,
+			"x_column", config.CoordToVegref.XColumn, "y_column", config.CoordToVegref.YColumn)
+
+	case ModeVegrefToCoord:
+		if config.VegrefToCoord == nil {
+			return "", nil, fmt.Errorf("vegref_to_coord configuration is not initialized")
+		}
+
+		// Validate vegreferanse column index
+		if config.VegrefToCoord.VegreferanseColumn < 0 || config.VegrefToCoord.VegreferanseColumn >= expectedColumnCount {
+			return "", nil, fmt.Errorf("column Vegreferanse index %d is out of range (file has %d columns)",
+				config.VegrefToCoord.VegreferanseColumn, expectedColumnCount)
+		}
+		slog.Info("Using vegreferanse column", "columns", expectedColumnCount,
+			"vegreferanse_column", config.VegrefToCoord.VegreferanseColumn)
+
+	case ModeLegacyVegrefToCoord:
+		if config.LegacyToCoord == nil {
+			return "", nil, fmt.Errorf("legacy_vegref_to_coord configuration is not initialized")
+		}
+
+		// Validate vegreferanse column index
+		if config.LegacyToCoord.VegreferanseColumn < 0 || config.LegacyToCoord.VegreferanseColumn >= expectedColumnCount {
+			return "", nil, fmt.Errorf("column Vegreferanse index %d is out of range (file has %d columns)",
+				config.LegacyToCoord.VegreferanseColumn, expectedColumnCount)
+		}
+		slog.Info("Using legacy vegreferanse column", "columns", expectedColumnCount,
+			"vegreferanse_column", config.LegacyToCoord.VegreferanseColumn)
+
+	case ModeRangeToGeometry:
+		if config.RangeToGeom == nil {
+			return "", nil, fmt.Errorf("vegref_range_to_geometry configuration is not initialized")
+		}
+
+		// Validate vegreferanse column index
+		if config.RangeToGeom.VegreferanseColumn < 0 || config.RangeToGeom.VegreferanseColumn >= expectedColumnCount {
+			return "", nil, fmt.Errorf("column Vegreferanse index %d is out of range (file has %d columns)",
+				config.RangeToGeom.VegreferanseColumn, expectedColumnCount)
+		}
+		slog.Info("Using vegreferanse range column", "columns", expectedColumnCount,
+			"vegreferanse_column", config.RangeToGeom.VegreferanseColumn)
+	}
+
+	// Validate the optional date column index
+	dateColumn := -1
+	switch config.Mode {
+	case ModeCoordToVegref:
+		dateColumn = config.CoordToVegref.DateColumn
+	case ModeVegrefToCoord:
+		dateColumn = config.VegrefToCoord.DateColumn
+	case ModeLegacyVegrefToCoord:
+		dateColumn = config.LegacyToCoord.DateColumn
+	}
+	if dateColumn >= expectedColumnCount {
+		return "", nil, fmt.Errorf("column Date index %d is out of range (file has %d columns)",
+			dateColumn, expectedColumnCount)
+	}
+	if dateColumn >= 0 {
+		slog.Info("Using per-row lookup dates", "date_column", dateColumn)
+	}
+
+	return header, lines, nil
+}
+
+// ProcessCoordinatesToVegreferanse processes the input file to convert coordinates to vegreferanse
+func ProcessCoordinatesToVegreferanse(lines []string, provider VegreferanseProvider, workers int, modeConfig CoordToVegrefConfig, maxDistance int) ([]Result, error) {
+	return processCoordinatesToVegreferanse(lines, provider, workers, modeConfig, maxDistance, nil)
+}
+
+// processCoordinatesToVegreferanse converts the lines, reporting the progress of each line to the tracker
+func processCoordinatesToVegreferanse(lines []string, provider VegreferanseProvider, workers int, modeConfig CoordToVegrefConfig, maxDistance int, tracker *progress.Tracker) ([]Result, error) {
+	// Create a channel for tasks and results with buffering
+	taskChannel := make(chan processTask, len(lines))
+	resultChannel := make(chan Result, len(lines))
+
+	// Start workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChannel {
+				line := task.line
+				lineIdx := task.lineIdx
+
+				// Split the line by tabs
+				fields := strings.Split(line, "\t")
+
+				// Skip lines that don't have enough columns for coordinates
+				if len(fields) <= max(modeConfig.XColumn, modeConfig.YColumn) {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("line doesn't have enough columns for coordinates")),
+					}
+					continue
+				}
+
+				// Parse X and Y coordinates
+				x, err := strconv.ParseFloat(fields[modeConfig.XColumn], 64)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("invalid X coordinate: %v", err)),
+					}
+					continue
+				}
+
+				y, err := strconv.ParseFloat(fields[modeConfig.YColumn], 64)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("invalid Y coordinate: %v", err)),
+					}
+					continue
+				}
+
+				// Per-row date for historical lookups, if a date column is configured
+				date, err := rowDate(fields, modeConfig.DateColumn)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     err,
+					}
+					continue
+				}
+
+				// Get all matches for this coordinate
+				matches, err := provider.GetVegreferanseMatchesAt(x, y, date)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     apiError(err),
+					}
+					continue
+				}
+
+				// Filter matches by distance if specified
+				filteredMatches := vegref.FilterMatchesByDistance(matches, maxDistance)
+
+				// Default to empty string if no matches were found after filtering
+				vegreferanse := ""
+				if len(filteredMatches) > 0 {
+					// Get the first match by default - the selector will improve this
+					vegreferanse = filteredMatches[0].Vegsystemreferanse.Kortform
+				}
+
+				resultChannel <- Result{
+					LineIdx:      lineIdx,
+					Line:         line,
+					Vegreferanse: vegreferanse,
+					Matches:      filteredMatches, // Store filtered matches for the selector
+				}
+			}
+		}()
+	}
+
+	// Queue all tasks
+	for i, line := range lines {
+		taskChannel <- processTask{
+			lineIdx: i,
+			line:    line,
+		}
+	}
+	close(taskChannel)
+
+	// Close the result channel once all workers have finished
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	// Collect results as they complete, reporting the progress
+	results := make([]Result, len(lines))
+	for result := range resultChannel {
+		results[result.LineIdx] = result
+		rowDone(tracker, ModeCoordToVegref, result)
+	}
+
+	// Sort results by lineIdx
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LineIdx < results[j].LineIdx
+	})
+
+	return results, nil
+}
+
+// ProcessVegreferanseToCoordinates processes the input file to convert vegreferanse to coordinates
+func ProcessVegreferanseToCoordinates(lines []string, provider VegrefToCoordProvider, workers int, modeConfig VegrefToCoordConfig) ([]Result, error) {
+	return processVegreferanseToCoordinates(lines, provider, workers, modeConfig, nil)
+}
+
+// processVegreferanseToCoordinates converts the lines, reporting the progress of each line to the tracker
+func processVegreferanseToCoordinates(lines []string, provider VegrefToCoordProvider, workers int, modeConfig VegrefToCoordConfig, tracker *progress.Tracker) ([]Result, error) {
+	// Create a channel for tasks and results with buffering
+	taskChannel := make(chan processTask, len(lines))
+	resultChannel := make(chan Result, len(lines))
+
+	// Start workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChannel {
+				line := task.line
+				lineIdx := task.lineIdx
+
+				// Split the line by tabs
+				fields := strings.Split(line, "\t")
+
+				// Skip lines that don't have enough columns for vegreferanse
+				if len(fields) <= modeConfig.VegreferanseColumn {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("line doesn't have enough columns for vegreferanse")),
+					}
+					continue
+				}
+
+				// Get vegreferanse from the specified column
+				vegreferanse := strings.TrimSpace(fields[modeConfig.VegreferanseColumn])
+				if vegreferanse == "" {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("empty vegreferanse")),
+					}
+					continue
+				}
+
+				// Per-row date for historical lookups, if a date column is configured
+				date, err := rowDate(fields, modeConfig.DateColumn)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     err,
+					}
+					continue
+				}
+
+				// Legacy (pre-2020) vegreferanse values are detected and converted automatically,
+				// resolved at the row date when the legacy format was still in use
+				if vegref.IsLegacyVegreferanse(vegreferanse) {
+					conversion, err := convertLegacyVegreferanse(provider, vegreferanse, legacyDate(date, modeConfig.LegacyDate))
+					if err != nil {
+						resultChannel <- Result{
+							LineIdx: lineIdx,
+							Line:    line,
+							Err:     err,
+						}
+						continue
+					}
+
+					resultChannel <- Result{
+						LineIdx:      lineIdx,
+						Line:         line,
+						Vegreferanse: fmt.Sprintf("%.6f\t%.6f", conversion.Coordinate.X, conversion.Coordinate.Y),
+						Warning:      conversion.Warning(vegreferanse),
+					}
+					continue
+				}
+
+				// Validate and normalise the vegreferanse before calling the API
+				ref, err := vegref.ParseVegsystemreferanse(vegreferanse)
+				if err == nil && !ref.IsPoint() {
+					err = fmt.Errorf("vegreferanse %q does not identify a single position", vegreferanse)
+				}
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(err),
+					}
+					continue
+				}
+
+				// Get coordinates for this vegreferanse
+				coords, err := provider.GetCoordinatesFromVegreferanseAt(ref.String(), date)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     apiError(err),
+					}
+					continue
+				}
+
+				// Format the result - the original line will have the coordinates appended
+				xValue := fmt.Sprintf("%.6f", coords.X)
+				yValue := fmt.Sprintf("%.6f", coords.Y)
+
+				// Create a modified line with X and Y coordinates
+				resultChannel <- Result{
+					LineIdx:      lineIdx,
+					Line:         line,
+					Vegreferanse: fmt.Sprintf("%s\t%s", xValue, yValue), // Using vegreferanse field to store X and Y for compatibility
+				}
+			}
+		}()
+	}
+
+	// Queue all tasks
+	for i, line := range lines {
+		taskChannel <- processTask{
+			lineIdx: i,
+			line:    line,
+		}
+	}
+	close(taskChannel)
+
+	// Close the result channel once all workers have finished
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	// Collect results as they complete, reporting the progress
+	results := make([]Result, len(lines))
+	for result := range resultChannel {
+		results[result.LineIdx] = result
+		rowDone(tracker, ModeVegrefToCoord, result)
+	}
+
+	// Sort results by lineIdx
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LineIdx < results[j].LineIdx
+	})
+
+	return results, nil
+}
+
+// legacyDate returns the date to resolve a legacy vegreferanse at: the row date when it is on or
+// before vegref.DefaultLegacyVegreferanseDate, the last date legacy references were in use, and
+// the configured legacy date otherwise. A later row date would find no legacy road network.
+func legacyDate(rowDate, configured string) string {
+	if rowDate != "" && rowDate <= vegref.DefaultLegacyVegreferanseDate {
+		return rowDate
+	}
+	return configured
+}
+
+// convertLegacyVegreferanse parses a legacy vegreferanse and converts it using the provider
+func convertLegacyVegreferanse(provider LegacyVegreferanseProvider, vegreferanse, date string) (vegref.LegacyConversion, error) {
+	ref, err := vegref.ParseLegacyVegreferanse(vegreferanse)
+	if err != nil {
+		return vegref.LegacyConversion{}, inputError(err)
+	}
+
+	conversion, err := provider.GetCoordinatesFromLegacyVegreferanse(ref, date)
+	if err != nil {
+		return vegref.LegacyConversion{}, apiError(err)
+	}
+	return conversion, nil
+}
+
+// ProcessLegacyVegreferanseToCoordinates processes the input file to convert legacy vegreferanse
+// to the current vegsystemreferanse and coordinates
+func ProcessLegacyVegreferanseToCoordinates(lines []string, provider LegacyVegreferanseProvider, workers int, modeConfig VegrefToCoordConfig) ([]Result, error) {
+	return processLegacyVegreferanseToCoordinates(lines, provider, workers, modeConfig, nil)
+}
+
+// processLegacyVegreferanseToCoordinates converts the lines, reporting the progress of each line to the tracker
+func processLegacyVegreferanseToCoordinates(lines []string, provider LegacyVegreferanseProvider, workers int, modeConfig VegrefToCoordConfig, tracker *progress.Tracker) ([]Result, error) {
+	// Create a channel for tasks and results with buffering
+	taskChannel := make(chan processTask, len(lines))
+	resultChannel := make(chan Result, len(lines))
+
+	// Start workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChannel {
+				line := task.line
+				lineIdx := task.lineIdx
+
+				// Split the line by tabs
+				fields := strings.Split(line, "\t")
+
+				// Skip lines that don't have enough columns for vegreferanse
+				if len(fields) <= modeConfig.VegreferanseColumn {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("line doesn't have enough columns for vegreferanse")),
+					}
+					continue
+				}
+
+				// Get legacy vegreferanse from the specified column
+				vegreferanse := strings.TrimSpace(fields[modeConfig.VegreferanseColumn])
+				if vegreferanse == "" {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("empty vegreferanse")),
+					}
+					continue
+				}
+
+				// Per-row date for historical lookups, if a date column is configured
+				date, err := rowDate(fields, modeConfig.DateColumn)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     err,
+					}
+					continue
+				}
+
+				// Convert to the current road network, resolving the legacy reference at the row date
+				// when the legacy format was still in use
+				conversion, err := convertLegacyVegreferanse(provider, vegreferanse, legacyDate(date, modeConfig.LegacyDate))
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     err,
+					}
+					continue
+				}
+
+				resultChannel <- Result{
+					LineIdx:      lineIdx,
+					Line:         line,
+					Vegreferanse: fmt.Sprintf("%s\t%.6f\t%.6f", conversion.Vegsystemreferanse, conversion.Coordinate.X, conversion.Coordinate.Y), // Using vegreferanse field to store all new columns for compatibility
+					Warning:      conversion.Warning(vegreferanse),
+				}
+			}
+		}()
+	}
+
+	// Queue all tasks
+	for i, line := range lines {
+		taskChannel <- processTask{
+			lineIdx: i,
+			line:    line,
+		}
+	}
+	close(taskChannel)
+
+	// Close the result channel once all workers have finished
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	// Collect results as they complete, reporting the progress
+	results := make([]Result, len(lines))
+	for result := range resultChannel {
+		results[result.LineIdx] = result
+		rowDone(tracker, ModeLegacyVegrefToCoord, result)
+	}
+
+	// Sort results by lineIdx
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LineIdx < results[j].LineIdx
+	})
+
+	return results, nil
+}
+
+// ProcessVegreferanseRangeToGeometry processes the input file to convert vegreferanse ranges to geometry
+func ProcessVegreferanseRangeToGeometry(lines []string, provider GeometryProvider, workers int, modeConfig RangeToGeomConfig) ([]Result, error) {
+	return processVegreferanseRangeToGeometry(lines, provider, workers, modeConfig, nil)
+}
+
+// processVegreferanseRangeToGeometry converts the lines, reporting the progress of each line to the tracker
+func processVegreferanseRangeToGeometry(lines []string, provider GeometryProvider, workers int, modeConfig RangeToGeomConfig, tracker *progress.Tracker) ([]Result, error) {
+	// Create a channel for tasks and results with buffering
+	taskChannel := make(chan processTask, len(lines))
+	resultChannel := make(chan Result, len(lines))
+
+	// Start workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChannel {
+				line := task.line
+				lineIdx := task.lineIdx
+
+				// Split the line by tabs
+				fields := strings.Split(line, "\t")
+
+				// Skip lines that don't have enough columns for vegreferanse
+				if len(fields) <= modeConfig.VegreferanseColumn {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("line doesn't have enough columns for vegreferanse")),
+					}
+					continue
+				}
+
+				// Get vegreferanse range from the specified column
+				vegreferanse := strings.TrimSpace(fields[modeConfig.VegreferanseColumn])
+				if vegreferanse == "" {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(fmt.Errorf("empty vegreferanse")),
+					}
+					continue
+				}
+
+				// Validate and normalise the vegreferanse range before calling the API
+				ref, err := vegref.ParseVegsystemreferanse(vegreferanse)
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     inputError(err),
+					}
+					continue
+				}
+
+				// Get the road geometry for this range
+				roadGeometry, err := provider.GetGeometryFromVegreferanseRange(ref.String())
+				if err != nil {
+					resultChannel <- Result{
+						LineIdx: lineIdx,
+						Line:    line,
+						Err:     apiError(err),
+					}
+					continue
+				}
+
+				// Format the geometry in the requested output format
+				var geometryValue string
+				if modeConfig.GeometryFormat == "geojson" {
+					geometryValue, err = roadGeometry.Geometry.GeoJSON()
+					if err != nil {
+						resultChannel <- Result{
+							LineIdx: lineIdx,
+							Line:    line,
+							Err:     err,
+						}
+						continue
+					}
+				} else {
+					geometryValue = roadGeometry.Geometry.WKT()
+				}
+
+				resultChannel <- Result{
+					LineIdx:      lineIdx,
+					Line:         line,
+					Vegreferanse: fmt.Sprintf("%s\t%.2f", geometryValue, roadGeometry.Length), // Using vegreferanse field to store geometry and length for compatibility
+				}
+			}
+		}()
+	}
+
+	// Queue all tasks
+	for i, line := range lines {
+		taskChannel <- processTask{
+			lineIdx: i,
+			line:    line,
+		}
+	}
+	close(taskChannel)
+
+	// Close the result channel once all workers have finished
+	go func() {
+		wg.Wait()
+		close(resultChannel)
+	}()
+
+	// Collect results as they complete, reporting the progress
+	results := make([]Result, len(lines))
+	for result := range resultChannel {
+		results[result.LineIdx] = result
+		rowDone(tracker, ModeRangeToGeometry, result)
+	}
+
+	// Sort results by lineIdx
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].LineIdx < results[j].LineIdx
+	})
+
+	return results, nil
+}
+
+// rowsProcessed counts the converted rows by mode and status
+var rowsProcessed = metrics.NewCounterVec("vegref_rows_processed_total",
+	"Rows converted by mode and status (ok, warning, cache_miss or error).", "mode", "status")
+
+// rowDone reports a converted row to the progress tracker and the metrics
+func rowDone(tracker *progress.Tracker, mode string, result Result) {
+	tracker.RowDone(result.Err)
+
+	status := "ok"
+	if logging.ErrorCategory(result.Err) == logging.CategoryCacheMiss {
+		status = logging.CategoryCacheMiss // Offline lookups that are not in the disk cache
+	} else if result.Err != nil {
+		status = "error"
+	} else if result.Warning != "" {
+		status = "warning"
+	}
+	rowsProcessed.Inc(mode, status)
+}
+
+// ApplyVegreferanseSelector applies the road continuity selection to results
+func ApplyVegreferanseSelector(results []Result) {
+	applyVegreferanseSelector(results, Config{})
+}
+
+// applyVegreferanseSelector applies the road continuity selection to results, logging the
+// selections with the fields of the row
+func applyVegreferanseSelector(results []Result, config Config) {
+	selector := selector.New(10) // Keep track of last 10 vegreferanses
+
+	// Apply selector in sequential order
+	for i := range results {
+		result := &results[i]
+		if len(result.Matches) > 0 {
+			coordinate, _ := rowFields(config, *result)
+			selector.SetLogger(slog.With(logging.KeyLine, result.LineIdx+1, logging.KeyCoordinate, coordinate))
+			result.Vegreferanse = selector.SelectBestMatch(result.Matches)
+			selector.AddToHistory(result.Vegreferanse)
+		}
+	}
+}
+
+// ProcessFile reads the input file, converts every line according to the mode and writes the results to the output file
+func ProcessFile(inputPath, outputPath string, apiClient *nvdb.VegvesenetAPIV4, config Config) error {
+	// Read input file
+	header, lines, err := readInputFile(inputPath, config)
+	if err != nil {
+		return err
+	}
+
+	// Report progress while the lines are converted
+	config.Progress.Start(len(lines))
+	defer config.Progress.Stop()
+
+	// Process based on selected mode
+	var results []Result
+	switch config.Mode {
+	case ModeCoordToVegref:
+		if config.CoordToVegref == nil {
+			return fmt.Errorf("coord_to_vegref configuration is not initialized")
+		}
+
+		slog.Info("Converting coordinates to vegreferanse", "lines", len(lines))
+		results, err = processCoordinatesToVegreferanse(
+			lines,
+			apiClient,
+			config.Workers,
+			*config.CoordToVegref,
+			config.MaxDistance,
+			config.Progress,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		// Apply the vegreferanse selector to improve road matching
+		applyVegreferanseSelector(results, config)
+
+		// Update header to add the vegreferanse column
+		header = header + "\tVegreferanse"
+
+	case ModeVegrefToCoord:
+		if config.VegrefToCoord == nil {
+			return fmt.Errorf("vegref_to_coord configuration is not initialized")
+		}
+
+		slog.Info("Converting vegreferanse to coordinates", "lines", len(lines))
+		results, err = processVegreferanseToCoordinates(
+			lines,
+			apiClient,
+			config.Workers,
+			*config.VegrefToCoord,
+			config.Progress,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		// Update header to add X and Y columns, labelled with the coordinate system
+		label := nvdb.SRIDLabel(apiClient.SRID())
+		header = header + "\tX_" + label + "\tY_" + label
+
+	case ModeLegacyVegrefToCoord:
+		if config.LegacyToCoord == nil {
+			return fmt.Errorf("legacy_vegref_to_coord configuration is not initialized")
+		}
+
+		slog.Info("Converting legacy vegreferanse to vegsystemreferanse and coordinates", "lines", len(lines))
+		results, err = processLegacyVegreferanseToCoordinates(
+			lines,
+			apiClient,
+			config.Workers,
+			*config.LegacyToCoord,
+			config.Progress,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		// Update header to add the current vegsystemreferanse and X and Y columns
+		label := nvdb.SRIDLabel(apiClient.SRID())
+		header = header + "\tVegsystemreferanse\tX_" + label + "\tY_" + label
+
+	case ModeRangeToGeometry:
+		if config.RangeToGeom == nil {
+			return fmt.Errorf("vegref_range_to_geometry configuration is not initialized")
+		}
+
+		slog.Info("Converting vegreferanse ranges to geometry", "lines", len(lines))
+		results, err = processVegreferanseRangeToGeometry(
+			lines,
+			apiClient,
+			config.Workers,
+			*config.RangeToGeom,
+			config.Progress,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		// Update header to add geometry and length columns
+		header = header + "\tGeometri\tLengde_m"
+
+	default:
+		return fmt.Errorf("invalid mode: %s", config.Mode)
+	}
+
+	config.Progress.Stop()
+
+	// Write results to output file
+	linesWritten, err := fileio.WriteResults(outputPath, header, rows(results, config))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Wrote output file", "path", outputPath, "lines_processed", len(lines), "lines_written", linesWritten)
+
+	// In coord_to_vegref mode, generate a road report
+	if config.Mode == ModeCoordToVegref {
+		// Identify road number ranges
+		runs := identifyRoadRanges(results, config.RunTolerance)
+		// Generate road report
+		generateRoadReport(runs)
+
+		if config.ReportPath != "" {
+			if err := writeRoadReport(config.ReportPath, runs); err != nil {
+				return err
+			}
+			slog.Info("Wrote road report", "path", config.ReportPath, "runs", len(runs))
+		}
+
+		// Summarise the quality of the matches
+		quality := qualityReport(results, config.MaxDistance)
+		if config.QualitySummary {
+			writeQualitySummary(os.Stdout, quality)
+		} else {
+			logQualitySummary(quality)
+		}
+
+		if config.QualityReportPath != "" {
+			if err := writeQualityReport(config.QualityReportPath, quality); err != nil {
+				return err
+			}
+			slog.Info("Wrote quality report", "path", config.QualityReportPath)
+		}
+
+		if config.HTMLReportPath != "" {
+			if err := writeHTMLReport(config.HTMLReportPath, inputPath, results, runs, quality, *config.CoordToVegref, apiClient.SRID()); err != nil {
+				return err
+			}
+			slog.Info("Wrote HTML report", "path", config.HTMLReportPath)
+		}
+	}
+
+	return nil
+}
+
+// rows converts the results to the rows written to the output file
+func rows(results []Result, config Config) []fileio.Row {
+	rows := make([]fileio.Row, len(results))
+	for i, result := range results {
+		coordinate, vegreferanse := rowFields(config, result)
+		rows[i] = fileio.Row{
+			LineIdx:      result.LineIdx,
+			Line:         result.Line,
+			Output:       result.Vegreferanse,
+			Warning:      result.Warning,
+			Err:          result.Err,
+			Coordinate:   coordinate,
+			Vegreferanse: vegreferanse,
+		}
+	}
+	return rows
+}
+
+// rowFields returns the coordinate and vegreferanse of a result for log lines. The input value is
+// read from the configured column of the line and the converted value is taken from the result.
+func rowFields(config Config, result Result) (coordinate, vegreferanse string) {
+	fields := strings.Split(result.Line, "\t")
+	column := func(index int) string {
+		if index < 0 || index >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[index])
+	}
+	// The converted coordinate is in the last two output columns
+	outputCoordinate := func() string {
+		output := strings.Split(result.Vegreferanse, "\t")
+		if len(output) < 2 {
+			return ""
+		}
+		return output[len(output)-2] + "," + output[len(output)-1]
+	}
+
+	switch config.Mode {
+	case ModeCoordToVegref:
+		if config.CoordToVegref != nil {
+			if x, y := column(config.CoordToVegref.XColumn), column(config.CoordToVegref.YColumn); x != "" && y != "" {
+				coordinate = x + "," + y
+			}
+		}
+		vegreferanse = result.Vegreferanse
+	case ModeVegrefToCoord:
+		if config.VegrefToCoord != nil {
+			vegreferanse = column(config.VegrefToCoord.VegreferanseColumn)
+		}
+		coordinate = outputCoordinate()
+	case ModeLegacyVegrefToCoord:
+		if config.LegacyToCoord != nil {
+			vegreferanse = column(config.LegacyToCoord.VegreferanseColumn)
+		}
+		coordinate = outputCoordinate()
+	case ModeRangeToGeometry:
+		if config.RangeToGeom != nil {
+			vegreferanse = column(config.RangeToGeom.VegreferanseColumn)
+		}
+	}
+	return coordinate, vegreferanse
+}
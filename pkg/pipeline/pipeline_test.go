@@ -0,0 +1,431 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb/nvdbtest"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/wkt"
+)
+
+// newFixtureAPI creates a client for the offline NVDB stand-in, serving the recorded responses in testdata
+func newFixtureAPI(t *testing.T) *nvdb.VegvesenetAPIV4 {
+	t.Helper()
+	server := nvdbtest.NewServer(t, filepath.Join("testdata", "nvdb_fixtures.json"))
+	return nvdb.NewVegvesenetAPIV4(nvdb.WithBaseURL(server.URL), nvdb.WithHTTPClient(server.Client()))
+}
+
+// TestProcessFile tests the file processing functionality with recorded NVDB responses
+func TestProcessFile(t *testing.T) {
+	// Create a temporary directory for the test
+	tempDir, err := os.MkdirTemp("", "vegreferanse-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test input file
+	inputPath := filepath.Join(tempDir, "test_input.txt")
+	inputContent := "Header1\tHeader2\tHeader3\tHeader4\tX\tY\n" +
+		"data1\tdata2\tdata3\tdata4\t600000\t6600000\n" +
+		"data5\tdata6\tdata7\tdata8\t600001\t6600001\n"
+	err = os.WriteFile(inputPath, []byte(inputContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test input file: %v", err)
+	}
+
+	// Set up the output path
+	outputPath := filepath.Join(tempDir, "test_output.txt")
+
+	// Create an API client backed by the recorded NVDB responses
+	apiClient := newFixtureAPI(t)
+
+	// Process the file using the recorded NVDB responses with 1 worker (sequential processing for testing)
+	err = ProcessFile(inputPath, outputPath, apiClient, Config{
+		Mode: ModeCoordToVegref,
+		CoordToVegref: &CoordToVegrefConfig{
+			XColumn:    4,
+			YColumn:    5,
+			DateColumn: -1, // No per-row dates
+		},
+		MaxDistance: 1000, // Default distance filter
+		Workers:     1,    // Sequential processing for testing
+	})
+	if err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// Read the output file
+	outputContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	// Check that the output file has content and the expected header
+	expectedHeader := "Header1\tHeader2\tHeader3\tHeader4\tX\tY\tVegreferanse\n"
+	if len(outputContent) == 0 || string(outputContent[:len(expectedHeader)]) != expectedHeader {
+		t.Errorf("Output file has wrong header or is empty. Got: %s", string(outputContent))
+	}
+
+	// Log the actual output content for inspection
+	t.Logf("Output content: %s", string(outputContent))
+}
+
+// TestProcessVegreferanseToCoordinates tests the vegreferanse to coordinates processing
+func TestProcessVegreferanseToCoordinates(t *testing.T) {
+	// Create test input lines with vegreferanse values
+	lines := []string{
+		"data1\tdata2\tdata3\tFV7834 S1D1 m11",
+		"data4\tdata5\tdata6\tFV7834 S1D1 m12",
+		// Add an invalid vegreferanse to test error handling
+		"data7\tdata8\tdata9\tINVALID_VEGREF",
+	}
+
+	// Create API client
+	apiClient := newFixtureAPI(t)
+
+	// Test configuration
+	config := VegrefToCoordConfig{
+		VegreferanseColumn: 3,  // 0-based index of vegreferanse column
+		DateColumn:         -1, // No per-row dates
+	}
+
+	// Process the test data
+	results, err := ProcessVegreferanseToCoordinates(lines, apiClient, 1, config)
+	if err != nil {
+		t.Fatalf("Failed to process vegreferanse to coordinates: %v", err)
+	}
+
+	// Verify the results
+	t.Logf("Processed %d lines", len(results))
+
+	// Check the successful conversions
+	for i, result := range results {
+		t.Logf("Result for line %d: %v", i, result)
+
+		// Skip lines with errors
+		if result.Err != nil {
+			t.Logf("Line %d had error: %v", i, result.Err)
+			continue
+		}
+
+		// Verify the format of the result
+		// The result.Vegreferanse field should contain tab-separated X and Y coordinates
+		coords := strings.Split(result.Vegreferanse, "\t")
+		if len(coords) != 2 {
+			t.Errorf("Line %d: Expected 2 coordinates, got %d: %s", i, len(coords), result.Vegreferanse)
+			continue
+		}
+
+		// Try to parse the coordinates
+		x, xErr := strconv.ParseFloat(coords[0], 64)
+		y, yErr := strconv.ParseFloat(coords[1], 64)
+
+		if xErr != nil || yErr != nil {
+			t.Errorf("Line %d: Failed to parse coordinates: %v, %v", i, xErr, yErr)
+			continue
+		}
+
+		// Verify the coordinates are in a reasonable range for Norway
+		if x < 0 || x > 1000000 {
+			t.Errorf("Line %d: X coordinate %.6f is outside reasonable range for Norway", i, x)
+		}
+		if y < 6400000 || y > 7800000 {
+			t.Errorf("Line %d: Y coordinate %.6f is outside reasonable range for Norway", i, y)
+		}
+	}
+}
+
+// TestProcessFileVegrefToCoord tests the entire vegref_to_coord mode
+func TestProcessFileVegrefToCoord(t *testing.T) {
+	// Create a temporary directory for the test
+	tempDir, err := os.MkdirTemp("", "vegref-to-coord-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a test input file with vegreferanse values
+	inputPath := filepath.Join(tempDir, "vegref_input.txt")
+	inputContent := "Header1\tHeader2\tHeader3\tVegreferanse\n" +
+		"data1\tdata2\tdata3\tFV7834 S1D1 m11\n" +
+		"data4\tdata5\tdata6\tFV7834 S1D1 m12\n"
+	err = os.WriteFile(inputPath, []byte(inputContent), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create test input file: %v", err)
+	}
+
+	// Set up the output path
+	outputPath := filepath.Join(tempDir, "vegref_output.txt")
+
+	// Create an API client backed by the recorded NVDB responses
+	apiClient := newFixtureAPI(t)
+
+	// Process the file using the recorded NVDB responses
+	err = ProcessFile(inputPath, outputPath, apiClient, Config{
+		Mode: ModeVegrefToCoord,
+		VegrefToCoord: &VegrefToCoordConfig{
+			VegreferanseColumn: 3,  // 0-based index of vegreferanse column
+			DateColumn:         -1, // No per-row dates
+		},
+		Workers: 1, // Use 1 worker for predictable sequential processing
+	})
+	if err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// Read the output file
+	outputContent, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+
+	// Check that the output file has content and the expected header
+	expectedHeader := "Header1\tHeader2\tHeader3\tVegreferanse\tX_UTM33\tY_UTM33\n"
+	if len(outputContent) == 0 || string(outputContent[:len(expectedHeader)]) != expectedHeader {
+		t.Errorf("Output file has wrong header or is empty. Got: %s", string(outputContent))
+	}
+
+	// Log the actual output content for inspection
+	t.Logf("Output content: %s", string(outputContent))
+
+	// Check each line of the output file
+	lines := strings.Split(string(outputContent), "\n")
+	if len(lines) < 3 { // Header + 2 data lines
+		t.Fatalf("Expected at least 3 lines in output file, got %d", len(lines))
+	}
+
+	// Check the data lines
+	for i := 1; i < len(lines)-1; i++ { // Skip header and last empty line
+		fields := strings.Split(lines[i], "\t")
+		if len(fields) < 6 { // Original 4 columns + 2 coordinate columns
+			t.Errorf("Line %d has %d fields, expected at least 6: %s", i, len(fields), lines[i])
+			continue
+		}
+
+		// Try to parse X and Y coordinates
+		x, xErr := strconv.ParseFloat(fields[4], 64)
+		y, yErr := strconv.ParseFloat(fields[5], 64)
+
+		if xErr != nil || yErr != nil {
+			t.Errorf("Line %d: Failed to parse coordinates: %v, %v", i, xErr, yErr)
+			continue
+		}
+
+		// Verify coordinates are in a reasonable range for Norway
+		if x < 0 || x > 1000000 {
+			t.Errorf("Line %d: X coordinate %.6f is outside reasonable range for Norway", i, x)
+		}
+		if y < 6400000 || y > 7800000 {
+			t.Errorf("Line %d: Y coordinate %.6f is outside reasonable range for Norway", i, y)
+		}
+
+		// Log the coordinates
+		t.Logf("Line %d: Vegreferanse %s -> Coordinates: X=%.6f, Y=%.6f",
+			i, fields[3], x, y)
+	}
+}
+
+// TestRowDate tests reading and validating the optional per-row date column
+func TestRowDate(t *testing.T) {
+	testCases := []struct {
+		fields      []string
+		dateColumn  int
+		expected    string
+		expectError bool
+		description string
+	}{
+		{[]string{"1", "2", "2019-05-03"}, 2, "2019-05-03", false, "Valid date"},
+		{[]string{"1", "2", " 2019-05-03 "}, 2, "2019-05-03", false, "Date with surrounding spaces"},
+		{[]string{"1", "2", ""}, 2, "", false, "Empty cell uses default date"},
+		{[]string{"1", "2", "2019-05-03"}, -1, "", false, "No date column"},
+		{[]string{"1", "2", "03.05.2019"}, 2, "", true, "Wrong date format"},
+		{[]string{"1", "2", "2019-02-30"}, 2, "", true, "Non-existent date"},
+		{[]string{"1", "2"}, 2, "", true, "Missing date column"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			date, err := rowDate(tc.fields, tc.dateColumn)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got date %q", date)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if date != tc.expected {
+				t.Errorf("Expected date %q, got %q", tc.expected, date)
+			}
+		})
+	}
+}
+
+// TestLegacyDate tests that legacy references are resolved at the row date only up to the legacy cut-off
+func TestLegacyDate(t *testing.T) {
+	testCases := []struct {
+		rowDate     string
+		expected    string
+		description string
+	}{
+		{"", "2015-06-01", "No row date uses -legacy-date"},
+		{"2010-03-15", "2010-03-15", "Row date before the cut-off"},
+		{vegref.DefaultLegacyVegreferanseDate, vegref.DefaultLegacyVegreferanseDate, "Row date at the cut-off"},
+		{"2020-01-01", "2015-06-01", "Row date after the cut-off uses -legacy-date"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if date := legacyDate(tc.rowDate, "2015-06-01"); date != tc.expected {
+				t.Errorf("Expected date %q, got %q", tc.expected, date)
+			}
+		})
+	}
+}
+
+// TestValidateFile tests that invalid lines are reported without calling the API
+func TestValidateFile(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "input.txt")
+	inputContent := "Id\tVegreferanse\n" +
+		"1\tEV6 S1D1 m10\n" +
+		"2\t\n" +
+		"3\tnot a vegreferanse\n" +
+		"4\tEV6 S1D1 m10-20\n"
+	if err := os.WriteFile(inputPath, []byte(inputContent), 0644); err != nil {
+		t.Fatalf("Failed to create test input file: %v", err)
+	}
+
+	config := Config{
+		Mode:          ModeVegrefToCoord,
+		Workers:       2,
+		VegrefToCoord: &VegrefToCoordConfig{VegreferanseColumn: 1, DateColumn: -1},
+	}
+	checked, failed, err := ValidateFile(inputPath, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if checked != 4 {
+		t.Errorf("Expected 4 checked lines, got %d", checked)
+	}
+	var failedLines []int
+	for _, result := range failed {
+		failedLines = append(failedLines, result.LineIdx)
+	}
+	if len(failedLines) != 3 || failedLines[0] != 1 || failedLines[1] != 2 || failedLines[2] != 3 {
+		t.Errorf("Expected lines 1, 2 and 3 to fail, got %v", failedLines)
+	}
+
+	config.VegrefToCoord.VegreferanseColumn = 5
+	if _, _, err := ValidateFile(inputPath, config); err == nil {
+		t.Error("Expected error for a column outside the header, got none")
+	}
+}
+
+// warmupProvider counts the lookups of a warm-up, failing the points in fail
+type warmupProvider struct {
+	mu     sync.Mutex
+	looked map[WarmupPoint]int
+	fail   map[WarmupPoint]bool
+}
+
+func (p *warmupProvider) CacheVariant() string {
+	return "srid=4326"
+}
+
+func (p *warmupProvider) GetVegreferanseMatchesAt(x, y float64, date string) ([]vegref.VegreferanseMatch, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	point := WarmupPoint{Coordinate: vegref.Coordinate{X: x, Y: y}, Date: date}
+	p.looked[point]++
+	if p.fail[point] {
+		return nil, errors.New("lookup failed")
+	}
+	return nil, nil
+}
+
+// TestWarmup tests the warm-up points and resuming a warm-up from its state file
+func TestWarmup(t *testing.T) {
+	bbox, err := ParseBoundingBox("100, 200, 120, 230")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	points, err := GridPoints(bbox, 10)
+	if err != nil || len(points) != 12 || points[0] != (vegref.Coordinate{X: 100, Y: 200}) || points[11] != (vegref.Coordinate{X: 120, Y: 230}) {
+		t.Fatalf("Unexpected grid %v, %v", points, err)
+	}
+	if _, err := ParseBoundingBox("120,200,100,230"); err == nil {
+		t.Error("Expected an error for a bounding box with min above max")
+	}
+	if _, err := GridPoints(bbox, 0.001); err == nil {
+		t.Error("Expected an error for a grid with too many points")
+	}
+
+	line := wkt.Geometry{Type: wkt.TypeLineString, Lines: [][]wkt.Point{{{X: 0, Y: 0}, {X: 15, Y: 0}, {X: 15, Y: 10}}}}
+	linePoints, err := LinePoints(line, 10)
+	expected := []vegref.Coordinate{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 15, Y: 5}, {X: 15, Y: 10}}
+	if err != nil || !slices.Equal(linePoints, expected) {
+		t.Errorf("Expected line points %v, got %v, %v", expected, linePoints, err)
+	}
+
+	inputPath := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(inputPath, []byte("X\tY\tDate\n600000\t6600000\t2015-01-01\nbad\t6600001\t\n600002\t6600002\t\n600003\t6600003\t2015-13-01\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test input file: %v", err)
+	}
+	inputPoints, err := InputPoints(inputPath, CoordToVegrefConfig{XColumn: 0, YColumn: 1, DateColumn: -1})
+	if err != nil || len(inputPoints) != 3 || inputPoints[0].Date != "" {
+		t.Errorf("Expected 3 input points without dates, got %v, %v", inputPoints, err)
+	}
+	inputPoints, err = InputPoints(inputPath, CoordToVegrefConfig{XColumn: 0, YColumn: 1, DateColumn: 2})
+	expectedInput := []WarmupPoint{{Coordinate: vegref.Coordinate{X: 600000, Y: 6600000}, Date: "2015-01-01"}, {Coordinate: vegref.Coordinate{X: 600002, Y: 6600002}}}
+	if err != nil || !slices.Equal(inputPoints, expectedInput) {
+		t.Errorf("Expected input points %v, got %v, %v", expectedInput, inputPoints, err)
+	}
+
+	// Points are looked up at their own date
+	dated := &warmupProvider{looked: map[WarmupPoint]int{}}
+	if _, err := Warmup(context.Background(), inputPoints, dated, WarmupConfig{Workers: 1}); err != nil || dated.looked[expectedInput[0]] != 1 || dated.looked[expectedInput[1]] != 1 {
+		t.Errorf("Expected the points to be looked up at their dates, got %v, %v", dated.looked, err)
+	}
+
+	// The first run fails on the fifth point, so the state stops before it
+	grid := WarmupPoints(points)
+	statePath := filepath.Join(t.TempDir(), "warmup.json")
+	provider := &warmupProvider{looked: map[WarmupPoint]int{}, fail: map[WarmupPoint]bool{grid[4]: true}}
+	config := WarmupConfig{Workers: 3, StatePath: statePath}
+	report, err := Warmup(context.Background(), grid, provider, config)
+	if err != nil || report != (WarmupReport{Points: 12, Fetched: 11, Failed: 1}) {
+		t.Fatalf("Unexpected report %+v, %v", report, err)
+	}
+
+	// The second run resumes at the failed point
+	provider.fail = nil
+	report, err = Warmup(context.Background(), grid, provider, config)
+	if err != nil || report != (WarmupReport{Points: 12, Resumed: 4, Fetched: 8}) {
+		t.Fatalf("Unexpected report %+v, %v", report, err)
+	}
+	if provider.looked[grid[0]] != 1 || provider.looked[grid[4]] != 2 {
+		t.Errorf("Expected the resumed points to be skipped, got %v", provider.looked)
+	}
+	if report, _ := Warmup(context.Background(), grid, provider, config); report.Resumed != 12 || report.Fetched != 0 {
+		t.Errorf("Expected a finished warm-up to be skipped, got %+v", report)
+	}
+
+	// A state file of other points is ignored, and a cancelled warm-up looks nothing up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = Warmup(ctx, grid[:6], provider, config)
+	if !errors.Is(err, context.Canceled) || report.Resumed != 0 || report.Fetched != 0 {
+		t.Errorf("Expected a cancelled warm-up without lookups, got %+v, %v", report, err)
+	}
+}
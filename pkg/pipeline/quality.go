@@ -0,0 +1,255 @@
+// Quality Report Component
+//
+// This component measures how trustworthy the vegreferanse of a coord_to_vegref conversion are.
+//
+// Key features:
+// - Histogram of the distance (Avstand) from each coordinate to its chosen road match
+// - Counts rows without a match, ambiguous rows, selector overrides and rows with errors, with their row numbers
+// - Lists the rows whose chosen match is furthest from the coordinate
+// - Writes the report as JSON, and prints a readable summary or logs its counts
+
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// AmbiguityMargin is the distance in metres within which a second candidate makes a row ambiguous
+const AmbiguityMargin = 1.0
+
+// Limits of the row lists of the quality report
+const (
+	MaxListedRows = 20 // Row numbers listed per finding
+	MaxWorstRows  = 10 // Rows listed with the furthest chosen match
+)
+
+// histogramBounds are the upper bounds in metres of the Avstand histogram buckets; the last bucket is open
+var histogramBounds = []float64{1, 2, 5, 10, 25, 50, 100}
+
+// HistogramBucket counts the chosen matches with an Avstand from From up to, but not including, To.
+// To is 0 for the last, open-ended bucket.
+type HistogramBucket struct {
+	Label string  `json:"label"`
+	From  float64 `json:"from"`
+	To    float64 `json:"to,omitempty"`
+	Rows  int     `json:"rows"`
+}
+
+// QualityFinding counts the rows with a quality issue, listing the first MaxListedRows row numbers
+type QualityFinding struct {
+	Count int   `json:"count"`
+	Rows  []int `json:"rows,omitempty"` // Data line numbers, 1 for the first line after the header
+}
+
+// add counts a row with the issue
+func (f *QualityFinding) add(row int) {
+	f.Count++
+	if len(f.Rows) < MaxListedRows {
+		f.Rows = append(f.Rows, row)
+	}
+}
+
+// QualityRow is a row with its chosen match
+type QualityRow struct {
+	Row          int     `json:"row"` // Data line number, 1 for the first line after the header
+	Avstand      float64 `json:"avstand"`
+	Vegreferanse string  `json:"vegreferanse"`
+}
+
+// QualityReport summarises the quality of the matches of a coord_to_vegref conversion.
+// Rows are numbered among the data lines of the input file, with the first line after the header
+// as row 1, as the line attribute of the log messages.
+type QualityReport struct {
+	Rows        int               `json:"rows"`
+	Matched     int               `json:"matched"`
+	MaxDistance int               `json:"max_distance"`
+	Histogram   []HistogramBucket `json:"avstand_histogram"`
+	NoMatch     QualityFinding    `json:"no_match"`           // No candidate within the maximum distance
+	Ambiguous   QualityFinding    `json:"ambiguous"`          // Another candidate within AmbiguityMargin of the closest one
+	Overridden  QualityFinding    `json:"selector_overrides"` // Road continuity chose another match than the closest one
+	ParseErrors QualityFinding    `json:"parse_errors"`       // Missing or invalid values in the input row
+	Failed      QualityFinding    `json:"failed"`             // Lookups failing for other reasons, e.g. API errors
+	Worst       []QualityRow      `json:"worst_rows"`         // Matched rows with the largest Avstand, furthest first
+}
+
+// newHistogram returns the empty Avstand histogram buckets
+func newHistogram() []HistogramBucket {
+	buckets := make([]HistogramBucket, 0, len(histogramBounds)+1)
+	from := 0.0
+	for _, to := range histogramBounds {
+		buckets = append(buckets, HistogramBucket{Label: formatBound(from) + "-" + formatBound(to), From: from, To: to})
+		from = to
+	}
+	return append(buckets, HistogramBucket{Label: formatBound(from) + "+", From: from})
+}
+
+// formatBound formats a histogram bound without trailing zeros
+func formatBound(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// chosenMatch returns the candidate chosen for a result and the closest candidate, or false when the
+// row has no match
+func chosenMatch(result Result) (chosen, closest vegref.VegreferanseMatch, ok bool) {
+	if result.Err != nil || len(result.Matches) == 0 {
+		return chosen, closest, false
+	}
+
+	closest = result.Matches[0]
+	for _, match := range result.Matches[1:] {
+		if match.Avstand < closest.Avstand {
+			closest = match
+		}
+	}
+
+	chosen = closest
+	for _, match := range result.Matches {
+		if match.Vegsystemreferanse.Kortform == result.Vegreferanse {
+			chosen = match
+			break
+		}
+	}
+	return chosen, closest, true
+}
+
+// ambiguous reports whether another candidate than the closest is within AmbiguityMargin of it
+func ambiguous(matches []vegref.VegreferanseMatch, closest vegref.VegreferanseMatch) bool {
+	candidates := 0
+	for _, match := range matches {
+		if match.Avstand-closest.Avstand <= AmbiguityMargin {
+			candidates++
+		}
+	}
+	return candidates > 1
+}
+
+// qualityReport builds the quality report of the results of a coord_to_vegref conversion
+func qualityReport(results []Result, maxDistance int) QualityReport {
+	report := QualityReport{
+		Rows:        len(results),
+		MaxDistance: maxDistance,
+		Histogram:   newHistogram(),
+		Worst:       []QualityRow{},
+	}
+
+	var matched []QualityRow
+	for _, result := range results {
+		row := rowNumber(result)
+		if result.Err != nil {
+			if logging.ErrorCategory(result.Err) == logging.CategoryInput {
+				report.ParseErrors.add(row)
+			} else {
+				report.Failed.add(row)
+			}
+			continue
+		}
+
+		chosen, closest, ok := chosenMatch(result)
+		if !ok {
+			report.NoMatch.add(row)
+			continue
+		}
+
+		report.Matched++
+		bucket := len(histogramBounds)
+		for i, bound := range histogramBounds {
+			if chosen.Avstand < bound {
+				bucket = i
+				break
+			}
+		}
+		report.Histogram[bucket].Rows++
+
+		if ambiguous(result.Matches, closest) {
+			report.Ambiguous.add(row)
+		}
+		if chosen.Vegsystemreferanse.Kortform != closest.Vegsystemreferanse.Kortform {
+			report.Overridden.add(row)
+		}
+		matched = append(matched, QualityRow{Row: row, Avstand: chosen.Avstand, Vegreferanse: result.Vegreferanse})
+	}
+
+	// The furthest matches first, in row order for equal distances
+	slices.SortStableFunc(matched, func(a, b QualityRow) int {
+		switch {
+		case a.Avstand > b.Avstand:
+			return -1
+		case a.Avstand < b.Avstand:
+			return 1
+		default:
+			return 0
+		}
+	})
+	report.Worst = append(report.Worst, matched[:min(len(matched), MaxWorstRows)]...)
+	return report
+}
+
+// formatRows formats the listed rows of a finding, marking rows left out of the list
+func formatRows(finding QualityFinding) string {
+	if finding.Count == 0 {
+		return "0"
+	}
+	rows := make([]string, len(finding.Rows))
+	for i, row := range finding.Rows {
+		rows[i] = strconv.Itoa(row)
+	}
+	if finding.Count > len(finding.Rows) {
+		rows = append(rows, "...")
+	}
+	return fmt.Sprintf("%d (rows %s)", finding.Count, strings.Join(rows, ", "))
+}
+
+// writeQualitySummary writes the quality report as readable text
+func writeQualitySummary(w io.Writer, report QualityReport) {
+	fmt.Fprintln(w, "\nQuality summary:")
+	fmt.Fprintf(w, "Rows: %d, matched: %d\n", report.Rows, report.Matched)
+	fmt.Fprintln(w, "Distance to the chosen match (m):")
+	for _, bucket := range report.Histogram {
+		fmt.Fprintf(w, "  %-8s %d\n", bucket.Label, bucket.Rows)
+	}
+	fmt.Fprintf(w, "No match within %d m: %s\n", report.MaxDistance, formatRows(report.NoMatch))
+	fmt.Fprintf(w, "Ambiguous (another candidate within %s m of the closest): %s\n", formatBound(AmbiguityMargin), formatRows(report.Ambiguous))
+	fmt.Fprintf(w, "Selector chose another road than the closest: %s\n", formatRows(report.Overridden))
+	fmt.Fprintf(w, "Parse errors: %s\n", formatRows(report.ParseErrors))
+	fmt.Fprintf(w, "Other errors: %s\n", formatRows(report.Failed))
+	if len(report.Worst) > 0 {
+		fmt.Fprintln(w, "Furthest matches:")
+		for _, row := range report.Worst {
+			fmt.Fprintf(w, "  Row %d - %.2f m - %s\n", row.Row, row.Avstand, row.Vegreferanse)
+		}
+	}
+}
+
+// logQualitySummary logs the counts of the quality report
+func logQualitySummary(report QualityReport) {
+	slog.Info("Quality summary",
+		"rows", report.Rows,
+		"matched", report.Matched,
+		"no_match", report.NoMatch.Count,
+		"ambiguous", report.Ambiguous.Count,
+		"selector_overrides", report.Overridden.Count,
+		"parse_errors", report.ParseErrors.Count,
+		"failed", report.Failed.Count)
+}
+
+// writeQualityReport writes the quality report as JSON
+func writeQualityReport(path string, report QualityReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode quality report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write quality report: %w", err)
+	}
+	return nil
+}
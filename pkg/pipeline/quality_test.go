@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// match creates a candidate match with the given kortform and distance
+func match(kortform string, avstand float64) vegref.VegreferanseMatch {
+	var m vegref.VegreferanseMatch
+	m.Vegsystemreferanse.Kortform = kortform
+	m.Avstand = avstand
+	return m
+}
+
+// TestQualityReport tests the findings and histogram of the quality report
+func TestQualityReport(t *testing.T) {
+	results := []Result{
+		{LineIdx: 0, Vegreferanse: "EV6 S1D1 m10", Matches: []vegref.VegreferanseMatch{match("EV6 S1D1 m10", 0.4)}},
+		// Ambiguous, and the selector chose the second closest
+		{LineIdx: 1, Vegreferanse: "EV6 S1D1 m20", Matches: []vegref.VegreferanseMatch{match("RV9 S1D1 m5", 1.5), match("EV6 S1D1 m20", 2.2)}},
+		{LineIdx: 2},
+		{LineIdx: 3, Err: logging.Categorize(logging.CategoryInput, errors.New("invalid X coordinate"))},
+		{LineIdx: 4, Err: logging.Categorize(logging.CategoryAPI, errors.New("API error"))},
+		{LineIdx: 5, Vegreferanse: "EV6 S1D1 m60", Matches: []vegref.VegreferanseMatch{match("EV6 S1D1 m60", 7.5), match("FV1 S1D1 m1", 9)}},
+	}
+
+	report := qualityReport(results, 10)
+	if report.Rows != 6 || report.Matched != 3 || report.MaxDistance != 10 {
+		t.Errorf("Expected 6 rows with 3 matched, got %+v", report)
+	}
+
+	histogram := map[string]int{}
+	for _, bucket := range report.Histogram {
+		histogram[bucket.Label] = bucket.Rows
+	}
+	expectedHistogram := map[string]int{"0-1": 1, "1-2": 0, "2-5": 1, "5-10": 1, "10-25": 0, "25-50": 0, "50-100": 0, "100+": 0}
+	if !reflect.DeepEqual(histogram, expectedHistogram) {
+		t.Errorf("Expected histogram %v, got %v", expectedHistogram, histogram)
+	}
+
+	findings := map[string]QualityFinding{
+		"no match":     report.NoMatch,
+		"ambiguous":    report.Ambiguous,
+		"overridden":   report.Overridden,
+		"parse errors": report.ParseErrors,
+		"failed":       report.Failed,
+	}
+	expectedFindings := map[string]QualityFinding{
+		"no match":     {Count: 1, Rows: []int{3}},
+		"ambiguous":    {Count: 1, Rows: []int{2}},
+		"overridden":   {Count: 1, Rows: []int{2}},
+		"parse errors": {Count: 1, Rows: []int{4}},
+		"failed":       {Count: 1, Rows: []int{5}},
+	}
+	if !reflect.DeepEqual(findings, expectedFindings) {
+		t.Errorf("Expected findings %+v, got %+v", expectedFindings, findings)
+	}
+
+	expectedWorst := []QualityRow{
+		{Row: 6, Avstand: 7.5, Vegreferanse: "EV6 S1D1 m60"},
+		{Row: 2, Avstand: 2.2, Vegreferanse: "EV6 S1D1 m20"},
+		{Row: 1, Avstand: 0.4, Vegreferanse: "EV6 S1D1 m10"},
+	}
+	if !reflect.DeepEqual(report.Worst, expectedWorst) {
+		t.Errorf("Expected worst rows %+v, got %+v", expectedWorst, report.Worst)
+	}
+
+	// Only the first rows of a finding are listed
+	many := make([]Result, MaxListedRows+5)
+	for i := range many {
+		many[i].LineIdx = i
+	}
+	report = qualityReport(many, 10)
+	if report.NoMatch.Count != MaxListedRows+5 || len(report.NoMatch.Rows) != MaxListedRows {
+		t.Errorf("Expected %d unmatched rows with %d listed, got %+v", MaxListedRows+5, MaxListedRows, report.NoMatch)
+	}
+	var summary bytes.Buffer
+	writeQualitySummary(&summary, report)
+	if !strings.Contains(summary.String(), "No match within 10 m: 25 (rows 1, 2, ") || !strings.Contains(summary.String(), "20, ...)") {
+		t.Errorf("Expected a truncated row list in the summary, got:\n%s", summary.String())
+	}
+
+	// Without -quality-summary only the counts are logged
+	var logged bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, nil)))
+	logQualitySummary(report)
+	slog.SetDefault(defaultLogger)
+	var record map[string]any
+	if err := json.Unmarshal(logged.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to parse log record %q: %v", logged.String(), err)
+	}
+	if record["msg"] != "Quality summary" || record["rows"] != float64(MaxListedRows+5) || record["no_match"] != float64(MaxListedRows+5) || record["matched"] != float64(0) {
+		t.Errorf("Unexpected quality summary log record %v", record)
+	}
+
+	// The JSON report has the findings
+	path := filepath.Join(t.TempDir(), "quality.json")
+	if err := writeQualityReport(path, report); err != nil {
+		t.Fatalf("Failed to write quality report: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read quality report: %v", err)
+	}
+	var decoded QualityReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode quality report: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, report) {
+		t.Errorf("Expected decoded report %+v, got %+v", report, decoded)
+	}
+}
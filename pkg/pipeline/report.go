@@ -0,0 +1,371 @@
+// Road Report Component
+//
+// This component summarises which rows of the input file were matched to which roads.
+//
+// Key features:
+// - Identifies contiguous runs of rows on the same road section, with their metre range and direction
+// - Absorbs short interruptions into the surrounding run, within a tolerance in rows or metres, and lists them as gaps
+// - Merges adjacent runs and prints them per road in sorted order
+// - Writes the runs to a JSON or CSV file for further planning
+
+package pipeline
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// Road report file formats, chosen by the extension of the report path
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+)
+
+// Directions of the metre values along a road run
+const (
+	DirectionIncreasing = "increasing"
+	DirectionDecreasing = "decreasing"
+	DirectionConstant   = "constant" // Single rows and runs standing still
+)
+
+// DefaultMeterGapRows is the most rows of an interruption absorbed by a tolerance in metres that
+// has no limit in rows. The metre values on both sides of a long detour can be close, so the metres
+// alone do not bound the rows taken from the other roads.
+const DefaultMeterGapRows = 10
+
+// RunTolerance is the longest interruption absorbed into a road run, in rows, in metres or both.
+// The zero value absorbs no interruptions.
+type RunTolerance struct {
+	Rows   int     // Most rows in an interruption, 0 for DefaultMeterGapRows with Meters or no tolerance without
+	Meters float64 // Largest metre difference across an interruption, 0 when not measured in metres
+}
+
+// ParseRunTolerance parses a run tolerance given as a number of rows (e.g. "2"), metres (e.g. "50m")
+// or both separated by a comma (e.g. "50m,5"). An empty string is no tolerance.
+func ParseRunTolerance(text string) (RunTolerance, error) {
+	var tolerance RunTolerance
+	if text == "" {
+		return tolerance, nil
+	}
+
+	invalid := fmt.Errorf("invalid run tolerance: %s, must be a number of rows (e.g. 2), metres (e.g. 50m) or both (e.g. 50m,5)", text)
+	parts := strings.Split(text, ",")
+	if len(parts) > 2 {
+		return RunTolerance{}, invalid
+	}
+	hasRows, hasMeters := false, false
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if meters, ok := strings.CutSuffix(part, "m"); ok {
+			value, err := strconv.ParseFloat(meters, 64)
+			if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) || hasMeters {
+				return RunTolerance{}, invalid
+			}
+			tolerance.Meters, hasMeters = value, true
+			continue
+		}
+
+		rows, err := strconv.Atoi(part)
+		if err != nil || rows < 0 || hasRows {
+			return RunTolerance{}, invalid
+		}
+		tolerance.Rows, hasRows = rows, true
+	}
+	return tolerance, nil
+}
+
+// absorbs reports whether an interruption of gapRows rows between two rows of a run, at the metre
+// values before and after, is within the tolerance
+func (t RunTolerance) absorbs(gapRows int, before, after float64) bool {
+	switch {
+	case t.Meters > 0:
+		maxRows := t.Rows
+		if maxRows == 0 {
+			maxRows = DefaultMeterGapRows
+		}
+		return gapRows <= maxRows && math.Abs(after-before) <= t.Meters
+	case t.Rows > 0:
+		return gapRows <= t.Rows
+	default:
+		return false
+	}
+}
+
+// RoadGap is an interruption absorbed into a road run: rows without a match or matched to another
+// road section, between rows of the run
+type RoadGap struct {
+	FirstRow int `json:"first_row"` // Data line number, 1 for the first line after the header
+	LastRow  int `json:"last_row"`  // Data line number, 1 for the first line after the header
+}
+
+// String returns the rows of the gap, e.g. "15" or "101-102"
+func (g RoadGap) String() string {
+	if g.FirstRow == g.LastRow {
+		return strconv.Itoa(g.FirstRow)
+	}
+	return fmt.Sprintf("%d-%d", g.FirstRow, g.LastRow)
+}
+
+// RoadRun is a contiguous run of rows matched to the same road section (road, strekning and delstrekning).
+// Rows are numbered among the data lines of the input file, with the first line after the header
+// as row 1, as the line attribute of the log messages.
+type RoadRun struct {
+	Road          string    `json:"road"`
+	Strekning     int       `json:"strekning"`
+	Delstrekning  int       `json:"delstrekning"`
+	FirstRow      int       `json:"first_row"` // Data line number, 1 for the first line after the header
+	LastRow       int       `json:"last_row"`  // Data line number, 1 for the first line after the header
+	StartMeter    float64   `json:"start_meter"`
+	EndMeter      float64   `json:"end_meter"`
+	Direction     string    `json:"direction"`
+	Rows          int       `json:"rows"`           // Rows from the first to the last row, including the gaps
+	ToleratedRows int       `json:"tolerated_rows"` // Rows in the gaps
+	Gaps          []RoadGap `json:"gaps,omitempty"`
+}
+
+// runPosition is the road section and metre value of a single matched row
+type runPosition struct {
+	road         string
+	strekning    int
+	delstrekning int
+	meter        float64
+}
+
+// section returns the road section of the row without its metre value, identifying its run
+func (p runPosition) section() runPosition {
+	p.meter = 0
+	return p
+}
+
+// resultPosition returns the road section and metre value of the chosen vegreferanse of a result,
+// with an empty road when the row has no match
+func resultPosition(result Result) runPosition {
+	if result.Err != nil || result.Vegreferanse == "" {
+		return runPosition{}
+	}
+
+	ref, err := vegref.ParseVegsystemreferanse(result.Vegreferanse)
+	if err != nil {
+		return runPosition{road: vegref.ExtractRoadNumber(result.Vegreferanse)}
+	}
+
+	position := runPosition{road: ref.RoadID(), strekning: ref.Strekning, delstrekning: ref.Delstrekning}
+	if ref.Meter != nil {
+		position.meter = ref.Meter.Fra
+	}
+	return position
+}
+
+// rowNumber returns the row number of a result in the reports: the 1-based number among the data
+// lines of the input file, the same as the line attribute of the log messages
+func rowNumber(result Result) int {
+	return result.LineIdx + 1
+}
+
+// identifyRoadRanges identifies the contiguous runs of rows on the same road section, in row order.
+// Rows without a match end the current run, unless the run continues after an interruption within
+// the tolerance; the interruption is then recorded as a gap of the run.
+func identifyRoadRanges(results []Result, tolerance RunTolerance) []RoadRun {
+	positions := make([]runPosition, len(results))
+	for i, result := range results {
+		positions[i] = resultPosition(result)
+	}
+
+	// Index of the next row on the same road section as each row, -1 when there is none
+	next := make([]int, len(positions))
+	following := make(map[runPosition]int)
+	for i := len(positions) - 1; i >= 0; i-- {
+		next[i] = -1
+		if positions[i].road == "" {
+			continue
+		}
+		section := positions[i].section()
+		if j, ok := following[section]; ok {
+			next[i] = j
+		}
+		following[section] = i
+	}
+
+	var runs []RoadRun
+	for i := 0; i < len(results); {
+		position := positions[i]
+		if position.road == "" {
+			i++
+			continue
+		}
+
+		run := RoadRun{
+			Road:         position.road,
+			Strekning:    position.strekning,
+			Delstrekning: position.delstrekning,
+			FirstRow:     rowNumber(results[i]),
+			LastRow:      rowNumber(results[i]),
+			StartMeter:   position.meter,
+			EndMeter:     position.meter,
+			Rows:         1,
+		}
+
+		last := i
+		for next[last] != -1 {
+			j := next[last]
+			if gapRows := j - last - 1; gapRows > 0 {
+				if !tolerance.absorbs(gapRows, positions[last].meter, positions[j].meter) {
+					break
+				}
+				run.Gaps = append(run.Gaps, RoadGap{FirstRow: rowNumber(results[last+1]), LastRow: rowNumber(results[j-1])})
+				run.ToleratedRows += gapRows
+			}
+			last = j
+		}
+
+		run.LastRow = rowNumber(results[last])
+		run.EndMeter = positions[last].meter
+		run.Rows = last - i + 1
+		run.Direction = runDirection(run)
+		runs = append(runs, run)
+		i = last + 1
+	}
+	return runs
+}
+
+// runDirection returns the direction of the metre values along a run
+func runDirection(run RoadRun) string {
+	switch {
+	case run.EndMeter > run.StartMeter:
+		return DirectionIncreasing
+	case run.EndMeter < run.StartMeter:
+		return DirectionDecreasing
+	default:
+		return DirectionConstant
+	}
+}
+
+// generateRoadReport prints the rows matched to each road, merging the runs that directly follow
+// each other on the same road
+func generateRoadReport(runs []RoadRun) {
+	fmt.Println("\nRoad numbers summary:")
+	if len(runs) == 0 {
+		fmt.Println("No road numbers identified.")
+		return
+	}
+
+	// Group the row ranges per road, merging adjacent ranges for cleaner output
+	type rowRange struct {
+		first, last int
+		gaps        []string
+	}
+	roadRanges := make(map[string][]rowRange)
+	for _, run := range runs {
+		gaps := make([]string, len(run.Gaps))
+		for i, gap := range run.Gaps {
+			gaps[i] = gap.String()
+		}
+
+		ranges := roadRanges[run.Road]
+		if len(ranges) > 0 && ranges[len(ranges)-1].last+1 == run.FirstRow {
+			ranges[len(ranges)-1].last = run.LastRow
+			ranges[len(ranges)-1].gaps = append(ranges[len(ranges)-1].gaps, gaps...)
+			continue
+		}
+		roadRanges[run.Road] = append(ranges, rowRange{run.FirstRow, run.LastRow, gaps})
+	}
+
+	// Get the roads in sorted order for consistent output
+	roadList := make([]string, 0, len(roadRanges))
+	for road := range roadRanges {
+		roadList = append(roadList, road)
+	}
+	sort.Strings(roadList)
+
+	for _, road := range roadList {
+		for _, r := range roadRanges[road] {
+			if len(r.gaps) > 0 {
+				fmt.Printf("%s - Rows %d-%d (gaps: rows %s)\n", road, r.first, r.last, strings.Join(r.gaps, ", "))
+				continue
+			}
+			fmt.Printf("%s - Rows %d-%d\n", road, r.first, r.last)
+		}
+	}
+}
+
+// RoadReportFormat returns the format of a road report file from the extension of its path
+func RoadReportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ReportFormatJSON, nil
+	case ".csv":
+		return ReportFormatCSV, nil
+	default:
+		return "", fmt.Errorf("unsupported road report format: %s, the file must end in .json or .csv", path)
+	}
+}
+
+// writeRoadReport writes the road runs to a JSON or CSV file, depending on the extension of the path
+func writeRoadReport(path string, runs []RoadRun) error {
+	format, err := RoadReportFormat(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create road report: %w", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if runs == nil {
+			runs = []RoadRun{}
+		}
+		err = encoder.Encode(runs)
+	case ReportFormatCSV:
+		err = writeRoadReportCSV(file, runs)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write road report: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write road report: %w", err)
+	}
+	return nil
+}
+
+// writeRoadReportCSV writes the road runs as CSV with a header row, with the gaps of a run separated by semicolons
+func writeRoadReportCSV(file *os.File, runs []RoadRun) error {
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"road", "strekning", "delstrekning", "first_row", "last_row", "start_meter", "end_meter", "direction", "rows", "tolerated_rows", "gaps"})
+	for _, run := range runs {
+		gaps := make([]string, len(run.Gaps))
+		for i, gap := range run.Gaps {
+			gaps[i] = gap.String()
+		}
+		writer.Write([]string{
+			run.Road,
+			strconv.Itoa(run.Strekning),
+			strconv.Itoa(run.Delstrekning),
+			strconv.Itoa(run.FirstRow),
+			strconv.Itoa(run.LastRow),
+			strconv.FormatFloat(run.StartMeter, 'f', -1, 64),
+			strconv.FormatFloat(run.EndMeter, 'f', -1, 64),
+			run.Direction,
+			strconv.Itoa(run.Rows),
+			strconv.Itoa(run.ToleratedRows),
+			strings.Join(gaps, ";"),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
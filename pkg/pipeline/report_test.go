@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roadResults creates coord_to_vegref results with the given chosen vegreferanse per row,
+// an empty string for rows without a match
+func roadResults(vegreferanser ...string) []Result {
+	results := make([]Result, len(vegreferanser))
+	for i, vegreferanse := range vegreferanser {
+		results[i] = Result{LineIdx: i, Vegreferanse: vegreferanse}
+	}
+	return results
+}
+
+// TestIdentifyRoadRanges tests splitting the results into runs per road section
+func TestIdentifyRoadRanges(t *testing.T) {
+	results := roadResults(
+		"EV18 S65D1 m100",
+		"EV18 S65D1 m150",
+		"EV18 S65D1 m210",
+		"EV18 S65D2 m10",
+		"",
+		"RV4 S1D1 m900",
+		"RV4 S1D1 m850",
+		"EV18 S65D2 m40",
+	)
+	results[6].Err = errors.New("API error")
+
+	expected := []RoadRun{
+		{Road: "EV18", Strekning: 65, Delstrekning: 1, FirstRow: 1, LastRow: 3, StartMeter: 100, EndMeter: 210, Direction: DirectionIncreasing, Rows: 3},
+		{Road: "EV18", Strekning: 65, Delstrekning: 2, FirstRow: 4, LastRow: 4, StartMeter: 10, EndMeter: 10, Direction: DirectionConstant, Rows: 1},
+		{Road: "RV4", Strekning: 1, Delstrekning: 1, FirstRow: 6, LastRow: 6, StartMeter: 900, EndMeter: 900, Direction: DirectionConstant, Rows: 1},
+		{Road: "EV18", Strekning: 65, Delstrekning: 2, FirstRow: 8, LastRow: 8, StartMeter: 40, EndMeter: 40, Direction: DirectionConstant, Rows: 1},
+	}
+	if runs := identifyRoadRanges(results, RunTolerance{}); !reflect.DeepEqual(runs, expected) {
+		t.Errorf("Expected runs %+v, got %+v", expected, runs)
+	}
+
+	// Decreasing metre values
+	runs := identifyRoadRanges(roadResults("FV120 S2D1 m500", "FV120 S2D1 m480", "FV120 S2D1 m455"), RunTolerance{})
+	if len(runs) != 1 || runs[0].Direction != DirectionDecreasing || runs[0].StartMeter != 500 || runs[0].EndMeter != 455 {
+		t.Errorf("Expected one decreasing run from m500 to m455, got %+v", runs)
+	}
+}
+
+// TestRunTolerance tests absorbing short interruptions into the surrounding road run
+func TestRunTolerance(t *testing.T) {
+	for _, text := range []string{"x", "-1", "2.5", "-5m", "m", "Infm", "2,3", "5m,6m", "50m,2,3", "50m,"} {
+		if _, err := ParseRunTolerance(text); err == nil {
+			t.Errorf("Expected an error for run tolerance %q", text)
+		}
+	}
+	if tolerance, err := ParseRunTolerance("50m"); err != nil || tolerance != (RunTolerance{Meters: 50}) {
+		t.Errorf("Expected 50 metres, got %+v, %v", tolerance, err)
+	}
+	if tolerance, err := ParseRunTolerance("50m, 5"); err != nil || tolerance != (RunTolerance{Rows: 5, Meters: 50}) {
+		t.Errorf("Expected 50 metres and 5 rows, got %+v, %v", tolerance, err)
+	}
+
+	// A one-row gap without a match, a mismatched row and a three-row gap
+	results := roadResults(
+		"EV6 S10D1 m100",
+		"",
+		"EV6 S10D1 m120",
+		"RV9 S1D1 m40",
+		"EV6 S10D1 m140",
+		"EV6 S10D1 m150",
+		"",
+		"",
+		"",
+		"EV6 S10D1 m300",
+	)
+
+	runs := identifyRoadRanges(results, RunTolerance{})
+	if len(runs) != 5 {
+		t.Errorf("Expected 5 runs without tolerance, got %+v", runs)
+	}
+
+	runs = identifyRoadRanges(results, RunTolerance{Rows: 1})
+	expected := []RoadRun{
+		{Road: "EV6", Strekning: 10, Delstrekning: 1, FirstRow: 1, LastRow: 6, StartMeter: 100, EndMeter: 150, Direction: DirectionIncreasing, Rows: 6, ToleratedRows: 2,
+			Gaps: []RoadGap{{FirstRow: 2, LastRow: 2}, {FirstRow: 4, LastRow: 4}}},
+		{Road: "EV6", Strekning: 10, Delstrekning: 1, FirstRow: 10, LastRow: 10, StartMeter: 300, EndMeter: 300, Direction: DirectionConstant, Rows: 1},
+	}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("Expected runs %+v, got %+v", expected, runs)
+	}
+
+	// Measured in metres, the three-row gap is absorbed when the road position moved little enough
+	runs = identifyRoadRanges(results, RunTolerance{Meters: 150})
+	if len(runs) != 1 || runs[0].Rows != 10 || runs[0].ToleratedRows != 5 || len(runs[0].Gaps) != 3 || runs[0].Gaps[2].String() != "7-9" {
+		t.Errorf("Expected one run with three gaps, got %+v", runs)
+	}
+	runs = identifyRoadRanges(results, RunTolerance{Meters: 100})
+	if len(runs) != 2 || runs[0].LastRow != 6 {
+		t.Errorf("Expected the run to end before the three-row gap, got %+v", runs)
+	}
+	runs = identifyRoadRanges(results, RunTolerance{Rows: 2, Meters: 150})
+	if len(runs) != 2 || runs[0].LastRow != 6 {
+		t.Errorf("Expected the row limit to end the run before the three-row gap, got %+v", runs)
+	}
+
+	// A long detour over another road returning to the same metre value is not absorbed, however
+	// close the metre values on both sides are
+	detour := []string{"EV6 S10D1 m100"}
+	for i := range DefaultMeterGapRows + 1 {
+		detour = append(detour, fmt.Sprintf("RV9 S1D1 m%d", i*100))
+	}
+	detour = append(detour, "EV6 S10D1 m100")
+	runs = identifyRoadRanges(roadResults(detour...), RunTolerance{Meters: 50})
+	if len(runs) != 3 || runs[1].Road != "RV9" || runs[1].Rows != DefaultMeterGapRows+1 {
+		t.Errorf("Expected the detour to remain a run of its own, got %+v", runs)
+	}
+	runs = identifyRoadRanges(roadResults(detour...), RunTolerance{Rows: DefaultMeterGapRows + 1, Meters: 50})
+	if len(runs) != 1 || runs[0].ToleratedRows != DefaultMeterGapRows+1 {
+		t.Errorf("Expected a row limit to allow the detour, got %+v", runs)
+	}
+}
+
+// TestWriteRoadReport tests writing the road runs as JSON and CSV
+func TestWriteRoadReport(t *testing.T) {
+	dir := t.TempDir()
+	runs := identifyRoadRanges(roadResults("EV18 S65D1 m100", "", "EV18 S65D1 m150.5", "RV4 S1D1 m900"), RunTolerance{Rows: 1})
+
+	jsonPath := filepath.Join(dir, "report.json")
+	if err := writeRoadReport(jsonPath, runs); err != nil {
+		t.Fatalf("Failed to write JSON report: %v", err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read JSON report: %v", err)
+	}
+	var decoded []RoadRun
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, runs) {
+		t.Errorf("Expected JSON runs %+v, got %+v", runs, decoded)
+	}
+
+	csvPath := filepath.Join(dir, "report.CSV")
+	if err := writeRoadReport(csvPath, runs); err != nil {
+		t.Fatalf("Failed to write CSV report: %v", err)
+	}
+	data, err = os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to read CSV report: %v", err)
+	}
+	expected := "road,strekning,delstrekning,first_row,last_row,start_meter,end_meter,direction,rows,tolerated_rows,gaps\n" +
+		"EV18,65,1,1,3,100,150.5,increasing,3,1,2\n" +
+		"RV4,1,1,4,4,900,900,constant,1,0,\n"
+	if string(data) != expected {
+		t.Errorf("Expected CSV report:\n%s\ngot:\n%s", expected, data)
+	}
+
+	err = writeRoadReport(filepath.Join(dir, "report.txt"), runs)
+	if err == nil || !strings.Contains(err.Error(), "unsupported road report format") {
+		t.Errorf("Expected an unsupported format error, got %v", err)
+	}
+}
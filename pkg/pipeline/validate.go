@@ -0,0 +1,97 @@
+// Input Validation Component
+//
+// This component checks an input file before a conversion is run, so that problems with the
+// configured columns or the values in the rows are found without spending API calls.
+//
+// Key features:
+// - Validates the configured columns against the input file header
+// - Runs the row parsing of the conversion modes with a provider that makes no API calls
+// - Reports every row that would fail, with the same error and category as a real conversion
+
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/wkt"
+)
+
+// dryRunProvider implements all provider interfaces without calling the API, answering every
+// lookup with an empty but valid result
+type dryRunProvider struct{}
+
+func (dryRunProvider) GetVegreferanseFromCoordinates(x, y float64) (string, error) {
+	return "", nil
+}
+
+func (dryRunProvider) GetVegreferanseMatches(x, y float64) ([]vegref.VegreferanseMatch, error) {
+	return nil, nil
+}
+
+func (dryRunProvider) GetVegreferanseMatchesAt(x, y float64, date string) ([]vegref.VegreferanseMatch, error) {
+	return nil, nil
+}
+
+func (dryRunProvider) GetCoordinatesFromVegreferanse(vegreferanse string) (vegref.Coordinate, error) {
+	return vegref.Coordinate{}, nil
+}
+
+func (dryRunProvider) GetCoordinatesFromVegreferanseAt(vegreferanse, date string) (vegref.Coordinate, error) {
+	return vegref.Coordinate{}, nil
+}
+
+func (dryRunProvider) GetCoordinatesFromLegacyVegreferanse(ref vegref.LegacyVegreferanse, date string) (vegref.LegacyConversion, error) {
+	return vegref.LegacyConversion{}, nil
+}
+
+func (dryRunProvider) GetGeometryFromVegreferanseRange(vegreferanse string) (vegref.RoadGeometry, error) {
+	return vegref.RoadGeometry{Geometry: wkt.Geometry{Type: wkt.TypeLineString, Lines: [][]wkt.Point{{{}, {}}}}}, nil
+}
+
+// ValidateFile checks the configured columns and the values of every line of the input file
+// without calling the API. Returns the number of lines checked and the results of the lines that
+// would fail.
+func ValidateFile(inputPath string, config Config) (int, []Result, error) {
+	_, lines, err := readInputFile(inputPath, config)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var provider dryRunProvider
+	var results []Result
+	switch config.Mode {
+	case ModeCoordToVegref:
+		results, err = processCoordinatesToVegreferanse(lines, provider, config.Workers, *config.CoordToVegref, config.MaxDistance, nil)
+	case ModeVegrefToCoord:
+		results, err = processVegreferanseToCoordinates(lines, provider, config.Workers, *config.VegrefToCoord, nil)
+	case ModeLegacyVegrefToCoord:
+		results, err = processLegacyVegreferanseToCoordinates(lines, provider, config.Workers, *config.LegacyToCoord, nil)
+	case ModeRangeToGeometry:
+		results, err = processVegreferanseRangeToGeometry(lines, provider, config.Workers, *config.RangeToGeom, nil)
+	default:
+		return 0, nil, fmt.Errorf("invalid mode: %s", config.Mode)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var failed []Result
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return len(lines), failed, nil
+}
+
+// LogFailedRows logs the results that failed with the same fields as the log lines of a conversion
+func LogFailedRows(results []Result, config Config) {
+	for _, row := range rows(results, config) {
+		if row.Err != nil {
+			slog.Error("Invalid line", logging.Row(row.LineIdx, row.Coordinate, row.Vegreferanse, row.Err)...)
+		}
+	}
+}
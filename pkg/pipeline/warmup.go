@@ -0,0 +1,372 @@
+// Cache Warm-up Component
+//
+// This component fills the disk cache with the lookups of an area, a road or an input file ahead
+// of time, so that the conversions can later run where the network is poor or absent.
+//
+// Key features:
+// - Points from the coordinates of an input file, with an optional per-row date, a grid over a bounding box,
+//   or positions along a road
+// - Looks up every point through the API client, which stores the matches in the disk cache
+// - Stays within the API rate limit of the client and writes no conversion output
+// - Resumable: a state file records how far the warm-up got, and an interrupted run continues from there
+
+package pipeline
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/progress"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/wkt"
+)
+
+// MaxWarmupPoints limits the number of points of a warm-up, so that a bounding box with a too
+// small grid spacing is reported instead of taking days
+const MaxWarmupPoints = 10_000_000
+
+// warmupCheckpointInterval is how often the state file is written during a warm-up
+const warmupCheckpointInterval = 5 * time.Second
+
+// WarmupProvider looks up the matches of a coordinate, storing them in the disk cache
+type WarmupProvider interface {
+	// CacheVariant returns the query variant of the lookups, which the state file is tied to
+	CacheVariant() string
+
+	// GetVegreferanseMatchesAt looks up all matching vegreferanses for the given coordinates at the
+	// given date, or at the provider's default date when empty
+	GetVegreferanseMatchesAt(x, y float64, date string) ([]vegref.VegreferanseMatch, error)
+}
+
+// WarmupPoint is a coordinate to look up in a warm-up, with the date to look it up at
+type WarmupPoint struct {
+	vegref.Coordinate
+	Date string // Date (YYYY-MM-DD) of the lookup, empty for the provider's default date
+}
+
+// WarmupPoints returns the warm-up points of coordinates looked up at the provider's default date
+func WarmupPoints(coordinates []vegref.Coordinate) []WarmupPoint {
+	points := make([]WarmupPoint, len(coordinates))
+	for i, coordinate := range coordinates {
+		points[i] = WarmupPoint{Coordinate: coordinate}
+	}
+	return points
+}
+
+// WarmupConfig holds the settings of a cache warm-up
+type WarmupConfig struct {
+	Workers   int               // Number of concurrent workers
+	StatePath string            // File recording the progress for resuming, empty to always start from the first point
+	Progress  *progress.Tracker // Optional progress reporting, nil for none
+}
+
+// WarmupReport is the result of a cache warm-up
+type WarmupReport struct {
+	Points  int // Points of the warm-up
+	Resumed int // Points skipped because an earlier run already looked them up
+	Fetched int // Points looked up in this run, from the API or the disk cache
+	Failed  int // Points whose lookup failed, looked up again when the warm-up is resumed
+}
+
+// warmupState is the content of the state file
+type warmupState struct {
+	Key     string    `json:"key"`    // Hash of the points and the query variant
+	Points  int       `json:"points"` // Number of points
+	Done    int       `json:"done"`   // Number of leading points looked up without errors
+	Updated time.Time `json:"updated"`
+}
+
+// BoundingBox is a rectangular area in the coordinate system of the lookups
+type BoundingBox struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// ParseBoundingBox parses a bounding box given as minX,minY,maxX,maxY
+func ParseBoundingBox(text string) (BoundingBox, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("invalid bounding box %q, must be minX,minY,maxX,maxY", text)
+	}
+	var values [4]float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return BoundingBox{}, fmt.Errorf("invalid bounding box %q, must be minX,minY,maxX,maxY", text)
+		}
+		values[i] = value
+	}
+	bbox := BoundingBox{MinX: values[0], MinY: values[1], MaxX: values[2], MaxY: values[3]}
+	if bbox.MinX > bbox.MaxX || bbox.MinY > bbox.MaxY {
+		return BoundingBox{}, fmt.Errorf("invalid bounding box %q, the minimum exceeds the maximum", text)
+	}
+	return bbox, nil
+}
+
+// GridPoints returns the points of a grid over the bounding box with the given spacing, row by row
+// from the south-west corner. The spacing is in the units of the coordinate system.
+func GridPoints(bbox BoundingBox, spacing float64) ([]vegref.Coordinate, error) {
+	if spacing <= 0 {
+		return nil, fmt.Errorf("grid spacing must be positive, got %v", spacing)
+	}
+	columns := math.Floor((bbox.MaxX-bbox.MinX)/spacing) + 1
+	rows := math.Floor((bbox.MaxY-bbox.MinY)/spacing) + 1
+	if columns*rows > MaxWarmupPoints {
+		return nil, fmt.Errorf("grid of %.0f points exceeds the maximum of %d, use a larger spacing or a smaller bounding box", columns*rows, MaxWarmupPoints)
+	}
+
+	points := make([]vegref.Coordinate, 0, int(columns*rows))
+	for row := range int(rows) {
+		for column := range int(columns) {
+			points = append(points, vegref.Coordinate{
+				X: bbox.MinX + float64(column)*spacing,
+				Y: bbox.MinY + float64(row)*spacing,
+			})
+		}
+	}
+	return points, nil
+}
+
+// LinePoints returns points along the lines of a geometry with the given spacing, including the
+// first and last position of every line. The spacing is in the units of the coordinate system.
+func LinePoints(geometry wkt.Geometry, spacing float64) ([]vegref.Coordinate, error) {
+	if spacing <= 0 {
+		return nil, fmt.Errorf("spacing must be positive, got %v", spacing)
+	}
+	if geometry.Length()/spacing > MaxWarmupPoints {
+		return nil, fmt.Errorf("road of length %.0f with spacing %v exceeds the maximum of %d points", geometry.Length(), spacing, MaxWarmupPoints)
+	}
+
+	var points []vegref.Coordinate
+	for _, line := range geometry.Lines {
+		if len(line) == 0 {
+			continue
+		}
+		points = append(points, vegref.Coordinate{X: line[0].X, Y: line[0].Y})
+
+		// Distance along the line to the next point, carried over from segment to segment
+		next := spacing
+		travelled := 0.0
+		for i := 1; i < len(line); i++ {
+			from, to := line[i-1], line[i]
+			length := math.Hypot(to.X-from.X, to.Y-from.Y)
+			for next <= travelled+length && length > 0 {
+				fraction := (next - travelled) / length
+				points = append(points, vegref.Coordinate{X: from.X + fraction*(to.X-from.X), Y: from.Y + fraction*(to.Y-from.Y)})
+				next += spacing
+			}
+			travelled += length
+		}
+
+		// The end of the line, unless the last point is already there
+		last := line[len(line)-1]
+		if end := (vegref.Coordinate{X: last.X, Y: last.Y}); points[len(points)-1] != end {
+			points = append(points, end)
+		}
+	}
+	return points, nil
+}
+
+// InputPoints returns the coordinates of the lines of an input file, with the date of the date column
+// when one is configured. Lines with missing or invalid coordinates or dates are logged and left out.
+func InputPoints(inputPath string, modeConfig CoordToVegrefConfig) ([]WarmupPoint, error) {
+	_, lines, err := readInputFile(inputPath, Config{Mode: ModeCoordToVegref, CoordToVegref: &modeConfig})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]WarmupPoint, 0, len(lines))
+	for lineIdx, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) <= max(modeConfig.XColumn, modeConfig.YColumn) {
+			slog.Warn("Skipping line without coordinates", logging.KeyLine, lineIdx+1, logging.KeyErrorCategory, logging.CategoryInput)
+			continue
+		}
+		x, errX := strconv.ParseFloat(fields[modeConfig.XColumn], 64)
+		y, errY := strconv.ParseFloat(fields[modeConfig.YColumn], 64)
+		if err := errors.Join(errX, errY); err != nil {
+			slog.Warn("Skipping line with invalid coordinates", logging.KeyLine, lineIdx+1,
+				logging.KeyErrorCategory, logging.CategoryInput, logging.KeyError, err)
+			continue
+		}
+		date, err := rowDate(fields, modeConfig.DateColumn)
+		if err != nil {
+			slog.Warn("Skipping line with an invalid date", logging.KeyLine, lineIdx+1,
+				logging.KeyErrorCategory, logging.CategoryInput, logging.KeyError, err)
+			continue
+		}
+		points = append(points, WarmupPoint{Coordinate: vegref.Coordinate{X: x, Y: y}, Date: date})
+	}
+	return points, nil
+}
+
+// Warmup looks up every point through the provider, which stores the matches in the disk cache.
+// With a state file, the points an earlier run of the same warm-up looked up are skipped, and the
+// progress is recorded for a later run. Cancelling the context stops the warm-up after the lookups
+// in progress, recording the progress so far.
+func Warmup(ctx context.Context, points []WarmupPoint, provider WarmupProvider, config WarmupConfig) (WarmupReport, error) {
+	report := WarmupReport{Points: len(points)}
+	state := warmupState{Key: warmupKey(points, provider.CacheVariant()), Points: len(points)}
+	if config.StatePath != "" {
+		saved, err := readWarmupState(config.StatePath)
+		if err != nil {
+			return report, err
+		}
+		if saved.Key == state.Key {
+			state.Done = min(saved.Done, len(points))
+			report.Resumed = state.Done
+		} else if saved.Key != "" {
+			slog.Warn("Ignoring the state file of another warm-up", "state", config.StatePath)
+		}
+	}
+
+	// The leading points looked up without errors advance the state; done marks the points after
+	// them that finished first
+	var mu sync.Mutex
+	done := make([]bool, len(points))
+	advance := func(idx int, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ok {
+			done[idx] = true
+			report.Fetched++
+		} else {
+			report.Failed++
+		}
+		for state.Done < len(points) && done[state.Done] {
+			state.Done++
+		}
+	}
+	checkpoint := func() error {
+		if config.StatePath == "" {
+			return nil
+		}
+		mu.Lock()
+		current := state
+		mu.Unlock()
+		return writeWarmupState(config.StatePath, current)
+	}
+
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	for range max(config.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range tasks {
+				point := points[idx]
+				_, err := provider.GetVegreferanseMatchesAt(point.X, point.Y, point.Date)
+				if err != nil {
+					slog.Warn("Failed to look up point", logging.KeyCoordinate, logging.Coordinate(point.X, point.Y),
+						"date", point.Date, logging.KeyErrorCategory, logging.ErrorCategory(err), logging.KeyError, err)
+				}
+				advance(idx, err == nil)
+				config.Progress.RowDone(err)
+			}
+		}()
+	}
+
+	// Write the state periodically, so that a killed process loses little progress
+	stopCheckpoints := make(chan struct{})
+	checkpointsDone := make(chan struct{})
+	go func() {
+		defer close(checkpointsDone)
+		ticker := time.NewTicker(warmupCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := checkpoint(); err != nil {
+					slog.Warn("Failed to write warm-up state", "state", config.StatePath, logging.KeyError, err)
+				}
+			case <-stopCheckpoints:
+				return
+			}
+		}
+	}()
+
+	config.Progress.Start(len(points) - report.Resumed)
+	start := state.Done
+queue:
+	for idx := start; idx < len(points) && ctx.Err() == nil; idx++ {
+		select {
+		case tasks <- idx:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	config.Progress.Stop()
+	close(stopCheckpoints)
+	<-checkpointsDone
+
+	if err := checkpoint(); err != nil {
+		return report, fmt.Errorf("failed to write warm-up state: %w", err)
+	}
+	return report, ctx.Err()
+}
+
+// warmupKey returns the hash that ties a state file to the points and query variant of a warm-up
+func warmupKey(points []WarmupPoint, variant string) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n", variant)
+	for _, point := range points {
+		if point.Date != "" {
+			fmt.Fprintf(hash, "%.6f,%.6f,%s\n", point.X, point.Y, point.Date)
+			continue
+		}
+		fmt.Fprintf(hash, "%.6f,%.6f\n", point.X, point.Y)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readWarmupState reads the state file, returning an empty state when it does not exist
+func readWarmupState(path string) (warmupState, error) {
+	var state warmupState
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read warm-up state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("invalid warm-up state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeWarmupState replaces the state file atomically, so that a crash never leaves a partial file
+func writeWarmupState(path string, state warmupState) error {
+	state.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
@@ -0,0 +1,305 @@
+// Progress Reporting Component
+//
+// This component reports the progress of a long-running conversion, so that the console is not
+// silent between the start and the end of a run.
+//
+// Key features:
+// - Rows done out of the total, throughput in rows per second and estimated time remaining
+// - API calls against cache hits, and the effective API call rate against the rate limit
+// - Number of rows that failed
+// - A progress bar redrawn in place when the output is a terminal
+// - Periodic structured log records when the output is redirected, e.g. in scheduled jobs
+// - Safe for concurrent use by all workers
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Display modes
+const (
+	ModeAuto = "auto" // Progress bar on a terminal, log records otherwise
+	ModeBar  = "bar"  // Progress bar redrawn in place
+	ModeLog  = "log"  // Periodic log records
+	ModeOff  = "off"  // No progress reporting
+)
+
+// Refresh intervals for the two display styles
+const (
+	BarInterval = 200 * time.Millisecond
+	LogInterval = 10 * time.Second
+)
+
+// barWidth is the number of characters in the progress bar
+const barWidth = 30
+
+// Stats holds the API client counters shown next to the row progress
+type Stats struct {
+	APICalls    int64   // Requests sent to the API
+	CacheHits   int64   // Lookups answered by the cache
+	CacheMisses int64   // Lookups that needed an API call
+	RateLimit   float64 // Configured API calls per second, 0 when unlimited
+}
+
+// Tracker counts processed rows and periodically reports the progress
+type Tracker struct {
+	out      io.Writer    // Receives the progress bar
+	logger   *slog.Logger // Receives the log records when there is no bar
+	bar      bool
+	interval time.Duration
+	stats    func() Stats
+
+	total  int
+	done   atomic.Int64
+	errors atomic.Int64
+
+	start     time.Time
+	lastTime  time.Time
+	lastCalls int64
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// New creates a tracker in the given display mode, drawing the bar on out or logging through the
+// default logger. The stats function, which may be nil, provides the API client counters. New
+// returns nil for ModeOff; all methods of a nil tracker do nothing, so callers need no checks.
+func New(out *os.File, mode string, stats func() Stats) (*Tracker, error) {
+	bar := false
+	switch mode {
+	case ModeAuto:
+		bar = IsTerminal(out)
+	case ModeBar:
+		bar = true
+	case ModeLog:
+	case ModeOff:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode: %s, must be one of auto, bar, log or off", mode)
+	}
+
+	interval := LogInterval
+	if bar {
+		interval = BarInterval
+	}
+	return newTracker(out, bar, interval, stats), nil
+}
+
+// newTracker creates a tracker with an explicit display style and refresh interval
+func newTracker(out io.Writer, bar bool, interval time.Duration, stats func() Stats) *Tracker {
+	if stats == nil {
+		stats = func() Stats { return Stats{} }
+	}
+	return &Tracker{
+		out:      out,
+		logger:   slog.Default(),
+		bar:      bar,
+		interval: interval,
+		stats:    stats,
+	}
+}
+
+// IsTerminal reports whether the file is an interactive terminal
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins reporting progress for the given number of rows
+func (t *Tracker) Start(total int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.total = total
+	t.start = time.Now()
+	t.lastTime = t.start
+	t.lastCalls = t.stats().APICalls
+	stop := make(chan struct{})
+	t.stop = stop
+	t.mu.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.report(time.Now())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// RowDone records that a row has been processed, with the error it failed with, if any
+func (t *Tracker) RowDone(err error) {
+	if t == nil {
+		return
+	}
+	t.done.Add(1)
+	if err != nil {
+		t.errors.Add(1)
+	}
+}
+
+// Stop stops the periodic reporting and reports the final progress. Calling Stop again has no effect.
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	stop := t.stop
+	t.stop = nil
+	t.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	t.wg.Wait()
+
+	t.report(time.Now())
+	if t.bar {
+		fmt.Fprintln(t.out)
+	}
+}
+
+// report draws the progress bar or logs the current progress
+func (t *Tracker) report(now time.Time) {
+	snapshot := t.snapshot(now)
+	if t.bar {
+		fmt.Fprintf(t.out, "\r%s\033[K", snapshot.bar()) // Clear the rest of the previous line
+		return
+	}
+	t.logger.Info("Progress", snapshot.attrs()...)
+}
+
+// snapshot is the progress at one moment
+type snapshot struct {
+	done           int
+	total          int
+	errors         int64
+	rowsPerSecond  float64
+	callsPerSecond float64
+	stats          Stats
+}
+
+// snapshot returns the current progress. The effective API call rate covers the time since the
+// previous report.
+func (t *Tracker) snapshot(now time.Time) snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := snapshot{
+		done:   int(t.done.Load()),
+		total:  t.total,
+		errors: t.errors.Load(),
+		stats:  t.stats(),
+	}
+	if elapsed := now.Sub(t.start); elapsed > 0 {
+		s.rowsPerSecond = float64(s.done) / elapsed.Seconds()
+	}
+	if sinceLast := now.Sub(t.lastTime); sinceLast > 0 {
+		s.callsPerSecond = float64(s.stats.APICalls-t.lastCalls) / sinceLast.Seconds()
+	}
+	t.lastTime = now
+	t.lastCalls = s.stats.APICalls
+	return s
+}
+
+// percent returns the share of the rows that are done
+func (s snapshot) percent() float64 {
+	if s.total == 0 {
+		return 100
+	}
+	return float64(s.done) * 100 / float64(s.total)
+}
+
+// cacheHitPercent returns the share of the lookups answered by the cache, and false when there
+// were no lookups
+func (s snapshot) cacheHitPercent() (float64, bool) {
+	lookups := s.stats.CacheHits + s.stats.CacheMisses
+	if lookups == 0 {
+		return 0, false
+	}
+	return float64(s.stats.CacheHits) * 100 / float64(lookups), true
+}
+
+// bar formats the progress as a bar followed by the counters
+func (s snapshot) bar() string {
+	filled := barWidth
+	if s.total > 0 {
+		filled = min(barWidth, s.done*barWidth/s.total)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s%s] ", strings.Repeat("#", filled), strings.Repeat(".", barWidth-filled))
+	fmt.Fprintf(&b, "%d/%d rows (%.1f%%), %.1f rows/s, ETA %s", s.done, s.total, s.percent(), s.rowsPerSecond, eta(s.done, s.total, s.rowsPerSecond))
+
+	fmt.Fprintf(&b, ", %d API calls (%.1f/s", s.stats.APICalls, s.callsPerSecond)
+	if s.stats.RateLimit > 0 {
+		fmt.Fprintf(&b, ", limit %.1f/s", s.stats.RateLimit)
+	}
+	b.WriteString(")")
+
+	if percent, ok := s.cacheHitPercent(); ok {
+		fmt.Fprintf(&b, ", %d cache hits (%.1f%%)", s.stats.CacheHits, percent)
+	}
+	fmt.Fprintf(&b, ", %d errors", s.errors)
+
+	return b.String()
+}
+
+// attrs returns the progress as the attributes of a log record
+func (s snapshot) attrs() []any {
+	attrs := []any{
+		slog.Int("done", s.done),
+		slog.Int("total", s.total),
+		slog.Float64("percent", round1(s.percent())),
+		slog.Float64("rows_per_second", round1(s.rowsPerSecond)),
+		slog.String("eta", eta(s.done, s.total, s.rowsPerSecond)),
+		slog.Int64("api_calls", s.stats.APICalls),
+		slog.Float64("api_calls_per_second", round1(s.callsPerSecond)),
+	}
+	if s.stats.RateLimit > 0 {
+		attrs = append(attrs, slog.Float64("rate_limit", s.stats.RateLimit))
+	}
+	if percent, ok := s.cacheHitPercent(); ok {
+		attrs = append(attrs, slog.Int64("cache_hits", s.stats.CacheHits), slog.Float64("cache_hit_percent", round1(percent)))
+	}
+	return append(attrs, slog.Int64("errors", s.errors))
+}
+
+// round1 rounds a rate or share to one decimal for the log
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// eta estimates the time until all rows are done at the current throughput
+func eta(done, total int, rowsPerSecond float64) string {
+	if done >= total {
+		return "0s"
+	}
+	if rowsPerSecond <= 0 {
+		return "unknown"
+	}
+	remaining := time.Duration(float64(total-done) / rowsPerSecond * float64(time.Second))
+	return remaining.Round(time.Second).String()
+}
@@ -0,0 +1,136 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTracker returns a tracker over 200 rows started at start, with done rows of which the
+// first failed ones failed
+func newTestTracker(bar bool, start time.Time, done, failed int, stats Stats) *Tracker {
+	tracker := newTracker(&bytes.Buffer{}, bar, time.Hour, func() Stats { return stats })
+	tracker.total = 200
+	tracker.start = start
+	tracker.lastTime = start
+	for i := 0; i < done; i++ {
+		var err error
+		if i < failed {
+			err = errors.New("failed")
+		}
+		tracker.RowDone(err)
+	}
+	return tracker
+}
+
+// TestBar tests the formatting of the progress bar
+func TestBar(t *testing.T) {
+	stats := Stats{APICalls: 40, CacheHits: 30, CacheMisses: 10, RateLimit: 40}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		done        int
+		failed      int
+		expected    string
+		description string
+	}{
+		{100, 0, "[###############...............] 100/200 rows (50.0%), 10.0 rows/s, ETA 10s, 40 API calls (4.0/s, limit 40.0/s), 30 cache hits (75.0%), 0 errors", "Half done"},
+		{50, 2, "[#######.......................] 50/200 rows (25.0%), 5.0 rows/s, ETA 30s, 40 API calls (4.0/s, limit 40.0/s), 30 cache hits (75.0%), 2 errors", "With errors"},
+		{0, 0, "[..............................] 0/200 rows (0.0%), 0.0 rows/s, ETA unknown, 40 API calls (4.0/s, limit 40.0/s), 30 cache hits (75.0%), 0 errors", "Nothing done yet"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tracker := newTestTracker(true, start, tc.done, tc.failed, stats)
+			if line := tracker.snapshot(start.Add(10 * time.Second)).bar(); line != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, line)
+			}
+		})
+	}
+}
+
+// TestLogRecord tests the structured log record written when there is no progress bar
+func TestLogRecord(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	tracker := newTestTracker(false, start, 50, 2, Stats{APICalls: 40, CacheHits: 30, CacheMisses: 10, RateLimit: 40})
+	tracker.out = &out
+	var logged bytes.Buffer
+	tracker.logger = slog.New(slog.NewJSONHandler(&logged, nil))
+
+	tracker.report(start.Add(10 * time.Second))
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing written to the output without a bar, got %q", out.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(logged.Bytes(), &record); err != nil {
+		t.Fatalf("Failed to parse log record %q: %v", logged.String(), err)
+	}
+	expected := map[string]any{
+		"msg":                  "Progress",
+		"done":                 float64(50),
+		"total":                float64(200),
+		"percent":              25.0,
+		"rows_per_second":      5.0,
+		"eta":                  "30s",
+		"api_calls":            float64(40),
+		"api_calls_per_second": 4.0,
+		"rate_limit":           40.0,
+		"cache_hits":           float64(30),
+		"cache_hit_percent":    75.0,
+		"errors":               float64(2),
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, record[key])
+		}
+	}
+}
+
+// TestTrackerLifecycle tests periodic reporting and the final report on Stop
+func TestTrackerLifecycle(t *testing.T) {
+	var out bytes.Buffer
+	tracker := newTracker(&out, true, time.Millisecond, nil)
+	tracker.Start(3)
+	for i := 0; i < 3; i++ {
+		tracker.RowDone(nil)
+	}
+	time.Sleep(10 * time.Millisecond)
+	tracker.Stop()
+	tracker.Stop() // A second Stop must not panic
+
+	redraws := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\r")
+	if len(redraws) < 3 || !strings.HasSuffix(out.String(), "\n") {
+		t.Fatalf("Expected periodic and final redraws ending the line, got %q", out.String())
+	}
+	if last := redraws[len(redraws)-1]; !strings.Contains(last, "3/3 rows (100.0%)") {
+		t.Errorf("Unexpected final progress bar %q", last)
+	}
+}
+
+// TestNew tests the display modes
+func TestNew(t *testing.T) {
+	tracker, err := New(os.Stderr, ModeOff, nil)
+	if err != nil || tracker != nil {
+		t.Errorf("Expected no tracker for mode off, got %v, %v", tracker, err)
+	}
+	// A nil tracker must be safe to use
+	tracker.Start(1)
+	tracker.RowDone(nil)
+	tracker.Stop()
+
+	if _, err := New(os.Stderr, "fancy", nil); err == nil {
+		t.Error("Expected error for invalid mode, got none")
+	}
+
+	tracker, err = New(os.Stderr, ModeLog, nil)
+	if err != nil || tracker.bar || tracker.interval != LogInterval {
+		t.Errorf("Expected log tracker, got %+v, %v", tracker, err)
+	}
+}
@@ -9,32 +9,57 @@
 // The algorithm assigns scores to potential matches and selects the option that best maintains
 // the continuity of travel, even if it's not the physically closest match to the coordinate.
 
-package main
+package selector
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
 )
 
-// VegreferanseSelector helps select the most appropriate vegreferanse from multiple matches
+// selectorOverrides counts the selections where continuity won over the closest match
+var selectorOverrides = metrics.NewCounterVec("vegref_selector_overrides_total",
+	"Selections of a match other than the closest one to keep road continuity.")
+
+// Selector helps select the most appropriate vegreferanse from multiple matches
 // based on continuity of travel
-type VegreferanseSelector struct {
+type Selector struct {
 	// History of recent vegreferanse selections (from oldest to newest)
 	history []string
 	// Maximum number of history items to maintain
 	maxHistory int
+	// Logger for road continuity explanations, slog.Default() when nil
+	log *slog.Logger
 }
 
-// NewVegreferanseSelector creates a new selector with the specified history size
-func NewVegreferanseSelector(maxHistory int) *VegreferanseSelector {
-	return &VegreferanseSelector{
+// New creates a new selector with the specified history size
+func New(maxHistory int) *Selector {
+	return &Selector{
 		history:    make([]string, 0, maxHistory),
 		maxHistory: maxHistory,
 	}
 }
 
+// SetLogger sets the logger for the road continuity explanations, typically one with the fields
+// of the row being converted. A nil logger logs to slog.Default().
+func (s *Selector) SetLogger(logger *slog.Logger) {
+	s.log = logger
+}
+
+// logger returns the logger for the road continuity explanations
+func (s *Selector) logger() *slog.Logger {
+	if s.log == nil {
+		return slog.Default()
+	}
+	return s.log
+}
+
 // AddToHistory adds a vegreferanse to the history
-func (s *VegreferanseSelector) AddToHistory(vegreferanse string) {
+func (s *Selector) AddToHistory(vegreferanse string) {
 	if vegreferanse == "" {
 		return // Don't add empty references
 	}
@@ -50,7 +75,7 @@ func (s *VegreferanseSelector) AddToHistory(vegreferanse string) {
 
 // SelectBestMatch selects the best vegreferanse match from the available options
 // based on continuity with previous travels
-func (s *VegreferanseSelector) SelectBestMatch(matches []VegreferanseMatch) string {
+func (s *Selector) SelectBestMatch(matches []vegref.VegreferanseMatch) string {
 	if len(matches) == 0 {
 		return ""
 	}
@@ -86,6 +111,10 @@ func (s *VegreferanseSelector) SelectBestMatch(matches []VegreferanseMatch) stri
 	}
 
 	if bestMatch >= 0 {
+		if bestMatch != closestMatchIndex {
+			selectorOverrides.Inc()
+		}
+
 		// Only log if the selected match is significantly further away than the closest one
 		// Define a threshold for what's considered "significantly" different (e.g., 1 meter or 20% further)
 		const distanceThreshold = 1.0   // 1 meter
@@ -100,51 +129,32 @@ func (s *VegreferanseSelector) SelectBestMatch(matches []VegreferanseMatch) stri
 			(selectedDistance > closestMatchDistance+distanceThreshold ||
 				selectedDistance > closestMatchDistance*(1.0+percentageThreshold)) {
 
-			// Extract road information for logging
-			prevParts := strings.Fields(lastVegreferanse)
-			selParts := strings.Fields(selectedVegreferanse)
-			closeParts := strings.Fields(closestVegreferanse)
-
-			prevRoad := ""
-			selRoad := ""
-			closeRoad := ""
-
-			if len(prevParts) > 0 {
-				prevRoad = prevParts[0]
-			}
-			if len(selParts) > 0 {
-				selRoad = selParts[0]
-			}
-			if len(closeParts) > 0 {
-				closeRoad = closeParts[0]
-			}
-
-			fmt.Printf("Road Continuity: Selected %s (%.2fm away) over closest %s (%.2fm away) because it better matches previous road %s\n",
-				selectedVegreferanse, selectedDistance, closestVegreferanse, closestMatchDistance, lastVegreferanse)
-
-			// More detailed reason
-			if selRoad == prevRoad && closeRoad != prevRoad {
-				fmt.Printf("  - Reason: Selected road ID '%s' exactly matches previous road ID '%s'\n", selRoad, prevRoad)
-			} else {
-				selCategory := extractCategory(selRoad)
-				prevCategory := extractCategory(prevRoad)
-				closeCategory := extractCategory(closeRoad)
-
-				if selCategory == prevCategory && closeCategory != prevCategory {
-					fmt.Printf("  - Reason: Selected road category '%s' matches previous road category '%s'\n", selCategory, prevCategory)
+			// More detailed reasons
+			var reasons []string
+			prevRef, prevErr := vegref.ParseVegsystemreferanse(lastVegreferanse)
+			selRef, selErr := vegref.ParseVegsystemreferanse(selectedVegreferanse)
+			closeRef, closeErr := vegref.ParseVegsystemreferanse(closestVegreferanse)
+
+			if prevErr == nil && selErr == nil && closeErr == nil {
+				if selRef.SameRoad(prevRef) && !closeRef.SameRoad(prevRef) {
+					reasons = append(reasons, fmt.Sprintf("selected road ID '%s' exactly matches previous road ID '%s'", selRef.RoadID(), prevRef.RoadID()))
+				} else if selRef.Kategori == prevRef.Kategori && closeRef.Kategori != prevRef.Kategori {
+					reasons = append(reasons, fmt.Sprintf("selected road category '%s' matches previous road category '%s'", selRef.Kategori, prevRef.Kategori))
 				}
 
 				// Check for section match
-				if len(prevParts) > 1 && len(selParts) > 1 && len(closeParts) > 1 {
-					prevSection := prevParts[1]
-					selSection := selParts[1]
-					closeSection := closeParts[1]
-
-					if selSection == prevSection && closeSection != prevSection {
-						fmt.Printf("  - Reason: Selected section '%s' matches previous section '%s'\n", selSection, prevSection)
-					}
+				if selRef.SameSection(prevRef) && !closeRef.SameSection(prevRef) {
+					reasons = append(reasons, fmt.Sprintf("selected section 'S%dD%d' matches previous section", selRef.Strekning, selRef.Delstrekning))
 				}
 			}
+
+			s.logger().Info("Road continuity: selected a match other than the closest",
+				logging.KeyVegreferanse, selectedVegreferanse,
+				"distance_m", selectedDistance,
+				"closest", closestVegreferanse,
+				"closest_distance_m", closestMatchDistance,
+				"previous", lastVegreferanse,
+				"reason", strings.Join(reasons, "; "))
 		}
 		return matches[bestMatch].Vegsystemreferanse.Kortform
 	}
@@ -156,43 +166,40 @@ func (s *VegreferanseSelector) SelectBestMatch(matches []VegreferanseMatch) stri
 // calculateMatchScore assigns a score to a potential match based on:
 // 1. Continuity with previous road (same category, number, section)
 // 2. Physical distance from the coordinate point
-func (s *VegreferanseSelector) calculateMatchScore(previous, current string, distance float64) int {
+func (s *Selector) calculateMatchScore(previous, current string, distance float64) int {
 	// Higher score is better
 	score := 0
 
 	// Prioritize continuity - parse the vegreferanse strings
-	// Format examples: "E5 S1D1 m1000", "Kv12345 S1D1 m100"
-	prevParts := strings.Fields(previous)
-	currParts := strings.Fields(current)
-
-	if len(prevParts) < 1 || len(currParts) < 1 {
-		return 0
-	}
-
-	// Extract road identifier (e.g., "E5", "Kv12345")
-	prevRoad := prevParts[0]
-	currRoad := currParts[0]
+	// Format examples: "EV6 S1D1 m1000", "KV12345 S1D1 m100"
+	prevRef, prevErr := vegref.ParseVegsystemreferanse(previous)
+	currRef, currErr := vegref.ParseVegsystemreferanse(current)
+
+	if prevErr == nil && currErr == nil {
+		// Major bonus for same road, smaller bonus for same road category (e.g., "E", "K")
+		if prevRef.SameRoad(currRef) {
+			score += 1000
+		} else if prevRef.Kategori == currRef.Kategori {
+			score += 100
+		}
 
-	// Major bonus for same road
-	if prevRoad == currRoad {
-		score += 1000
+		// Bonus for staying on the same strekning and delstrekning
+		if prevRef.SameSection(currRef) {
+			score += 50
+		}
 	} else {
-		// Check if same road category (e.g., "E", "Kv")
-		prevCategory := extractCategory(prevRoad)
-		currCategory := extractCategory(currRoad)
+		// Fall back to comparing the road identifiers for references that can't be parsed
+		prevRoad := vegref.ExtractRoadNumber(previous)
+		currRoad := vegref.ExtractRoadNumber(current)
 
-		if prevCategory == currCategory {
-			score += 100
+		if prevRoad == "" || currRoad == "" {
+			return 0
 		}
-	}
 
-	// Check for same section if available
-	if len(prevParts) > 1 && len(currParts) > 1 {
-		prevSection := prevParts[1]
-		currSection := currParts[1]
-
-		if prevSection == currSection {
-			score += 50
+		if prevRoad == currRoad {
+			score += 1000
+		} else if extractCategory(prevRoad) == extractCategory(currRoad) {
+			score += 100
 		}
 	}
 
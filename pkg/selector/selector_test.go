@@ -1,17 +1,19 @@
-package main
+package selector
 
 import (
 	"testing"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
 )
 
 func TestVegreferanseSelector(t *testing.T) {
 	// Create a selector
-	selector := NewVegreferanseSelector(5)
+	selector := New(5)
 
 	// Test with empty history
 	t.Run("EmptyHistory", func(t *testing.T) {
 		// Create some test matches
-		matches := []VegreferanseMatch{
+		matches := []vegref.VegreferanseMatch{
 			{
 				Vegsystemreferanse: struct {
 					Vegsystem struct {
@@ -73,7 +75,7 @@ func TestVegreferanseSelector(t *testing.T) {
 		selector.AddToHistory("E18 S65D1 m12500")
 
 		// Create some test matches
-		matches := []VegreferanseMatch{
+		matches := []vegref.VegreferanseMatch{
 			{
 				Vegsystemreferanse: struct {
 					Vegsystem struct {
@@ -132,11 +134,11 @@ func TestVegreferanseSelector(t *testing.T) {
 	// Test same category but different road number
 	t.Run("SameCategory", func(t *testing.T) {
 		// Reset selector
-		selector = NewVegreferanseSelector(5)
+		selector = New(5)
 		selector.AddToHistory("E6 S28D1 m3200")
 
 		// Create some test matches
-		matches := []VegreferanseMatch{
+		matches := []vegref.VegreferanseMatch{
 			{
 				Vegsystemreferanse: struct {
 					Vegsystem struct {
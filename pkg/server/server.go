@@ -0,0 +1,449 @@
+// HTTP Server Component
+//
+// This component exposes the conversions as a JSON REST API, so that a single long-running process
+// can own the NVDB rate limit quota and disk cache for all clients instead of each client running
+// the binary per request.
+//
+// Key features:
+// - Coordinates to vegreferanse for single points and for batches with road continuity selection
+// - Vegreferanse to coordinates, including automatic conversion of legacy vegreferanse
+// - Health and metrics endpoints for monitoring
+// - One shared API client, rate limiter and disk cache across all requests
+// - Request body size and batch size limits
+// - Graceful shutdown that lets in-flight requests complete
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/logging"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/metrics"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/pipeline"
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/vegref"
+)
+
+// ServeConfig holds configuration specific to serve mode
+type ServeConfig struct {
+	Listen          string `validate:"required,hostname_port"`
+	MaxRequestBytes int64  `validate:"min=1024"`
+	MaxBatchSize    int    `validate:"min=1,max=100000"`
+}
+
+// serverShutdownTimeout is how long in-flight requests are given to complete on shutdown
+const serverShutdownTimeout = 30 * time.Second
+
+// Server serves the conversions over HTTP using a shared API client
+type Server struct {
+	api         *nvdb.VegvesenetAPIV4
+	config      ServeConfig
+	maxDistance int
+	workers     int
+	startTime   time.Time
+
+	// Request counters by endpoint and status code, exposed on /metrics
+	mu          sync.Mutex
+	requests    map[serverMetricKey]int
+	batchPoints int
+}
+
+// serverMetricKey identifies a request counter
+type serverMetricKey struct {
+	endpoint string
+	status   int
+}
+
+// NewServer creates a new server using the given API client for all requests
+func NewServer(api *nvdb.VegvesenetAPIV4, config ServeConfig, maxDistance, workers int) *Server {
+	return &Server{
+		api:         api,
+		config:      config,
+		maxDistance: maxDistance,
+		workers:     workers,
+		startTime:   time.Now(),
+		requests:    make(map[serverMetricKey]int),
+	}
+}
+
+// CoordinateRequest is a single coordinate to convert, in the server's SRID
+type CoordinateRequest struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Date string  `json:"date,omitempty"` // Optional date (YYYY-MM-DD) for historical lookups
+}
+
+// CoordinateBatchRequest is a sequence of coordinates to convert, e.g. along a route
+type CoordinateBatchRequest struct {
+	Points []CoordinateRequest `json:"points"`
+}
+
+// MatchResponse is a candidate road for a coordinate
+type MatchResponse struct {
+	Vegreferanse string  `json:"vegreferanse"`
+	Avstand      float64 `json:"avstand"`
+}
+
+// CoordinateResponse is the result of converting a coordinate to vegreferanse.
+// Vegreferanse is empty when no road was found within the maximum distance.
+type CoordinateResponse struct {
+	Vegreferanse string          `json:"vegreferanse"`
+	Matches      []MatchResponse `json:"matches"`
+	Error        string          `json:"error,omitempty"` // Only used for batch results
+}
+
+// CoordinateBatchResponse holds the results of a batch in the same order as the request
+type CoordinateBatchResponse struct {
+	Results []CoordinateResponse `json:"results"`
+}
+
+// VegreferanseRequest is a vegreferanse to convert to coordinates
+type VegreferanseRequest struct {
+	Vegreferanse string `json:"vegreferanse"`
+	Date         string `json:"date,omitempty"` // Optional date (YYYY-MM-DD) for historical lookups
+}
+
+// VegreferanseResponse is the result of converting a vegreferanse to coordinates
+type VegreferanseResponse struct {
+	Vegreferanse string  `json:"vegreferanse"` // Normalised or, for legacy input, current vegsystemreferanse
+	X            float64 `json:"x"`
+	Y            float64 `json:"y"`
+	SRID         int     `json:"srid"`
+	Warning      string  `json:"warning,omitempty"`
+}
+
+// errorResponse is the body of all error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError is an error with the HTTP status code to respond with
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error for invalid client input
+func badRequest(format string, args ...any) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// upstreamError returns an error for failed NVDB lookups
+func upstreamError(err error) error {
+	return &httpError{status: http.StatusBadGateway, err: logging.Categorize(logging.CategoryAPI, fmt.Errorf("API error: %w", err))}
+}
+
+// Handler returns the HTTP handler with all endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.Handle("POST /v1/coord-to-vegref", jsonEndpoint(s, "coord_to_vegref", s.coordToVegref))
+	mux.Handle("POST /v1/coord-to-vegref/batch", jsonEndpoint(s, "coord_to_vegref_batch", s.coordToVegrefBatch))
+	mux.Handle("POST /v1/vegref-to-coord", jsonEndpoint(s, "vegref_to_coord", s.vegrefToCoord))
+	return mux
+}
+
+// ListenAndServe serves requests until the context is cancelled, then shuts down gracefully
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:              s.config.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	slog.Info("Listening", "address", s.config.Listen)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, waiting for in-flight requests to complete")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	return nil
+}
+
+// jsonEndpoint decodes a size-limited JSON request body, calls the handler and encodes its response
+func jsonEndpoint[Req, Resp any](s *Server, endpoint string, handle func(Req) (Resp, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxRequestBytes)
+
+		var request Req
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+			err = fmt.Errorf("request body must contain a single JSON object")
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				s.writeJSON(w, endpoint, http.StatusRequestEntityTooLarge,
+					errorResponse{Error: fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)})
+				return
+			}
+			s.writeJSON(w, endpoint, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		response, err := handle(request)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var statusErr *httpError
+			if errors.As(err, &statusErr) {
+				status = statusErr.status
+			}
+			if status >= http.StatusInternalServerError {
+				slog.Warn("Request failed", "endpoint", endpoint, "status", status,
+					logging.KeyErrorCategory, logging.ErrorCategory(err), logging.KeyError, err)
+			}
+			s.writeJSON(w, endpoint, status, errorResponse{Error: err.Error()})
+			return
+		}
+		s.writeJSON(w, endpoint, http.StatusOK, response)
+	})
+}
+
+// writeJSON writes a JSON response and counts it in the metrics
+func (s *Server) writeJSON(w http.ResponseWriter, endpoint string, status int, body any) {
+	s.countRequest(endpoint, status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// countRequest increments the request counter for the endpoint and status code
+func (s *Server) countRequest(endpoint string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[serverMetricKey{endpoint: endpoint, status: status}]++
+}
+
+// validateDate checks an optional request date
+func validateDate(date string) error {
+	if date == "" {
+		return nil
+	}
+	if err := vegref.ValidateDate(date); err != nil {
+		return &httpError{status: http.StatusBadRequest, err: err}
+	}
+	return nil
+}
+
+// lookupCoordinate returns the filtered candidate roads for a coordinate, closest first
+func (s *Server) lookupCoordinate(request CoordinateRequest) ([]vegref.VegreferanseMatch, error) {
+	if err := validateDate(request.Date); err != nil {
+		return nil, err
+	}
+	matches, err := s.api.GetVegreferanseMatchesAt(request.X, request.Y, request.Date)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return vegref.FilterMatchesByDistance(matches, s.maxDistance), nil
+}
+
+// coordinateResponse creates the response for the selected vegreferanse and its candidates
+func coordinateResponse(vegreferanse string, matches []vegref.VegreferanseMatch) CoordinateResponse {
+	response := CoordinateResponse{
+		Vegreferanse: vegreferanse,
+		Matches:      make([]MatchResponse, len(matches)),
+	}
+	for i, match := range matches {
+		response.Matches[i] = MatchResponse{
+			Vegreferanse: match.Vegsystemreferanse.Kortform,
+			Avstand:      match.Avstand,
+		}
+	}
+	return response
+}
+
+// coordToVegref converts a single coordinate, selecting the closest road
+func (s *Server) coordToVegref(request CoordinateRequest) (CoordinateResponse, error) {
+	matches, err := s.lookupCoordinate(request)
+	if err != nil {
+		return CoordinateResponse{}, err
+	}
+
+	vegreferanse := ""
+	if len(matches) > 0 {
+		vegreferanse = matches[0].Vegsystemreferanse.Kortform
+	}
+	return coordinateResponse(vegreferanse, matches), nil
+}
+
+// coordToVegrefBatch converts a sequence of coordinates concurrently and then applies the road
+// continuity selection in request order, like the file based coord_to_vegref mode
+func (s *Server) coordToVegrefBatch(request CoordinateBatchRequest) (CoordinateBatchResponse, error) {
+	if len(request.Points) == 0 {
+		return CoordinateBatchResponse{}, badRequest("batch contains no points")
+	}
+	if len(request.Points) > s.config.MaxBatchSize {
+		return CoordinateBatchResponse{}, &httpError{
+			status: http.StatusRequestEntityTooLarge,
+			err:    fmt.Errorf("batch contains %d points, the maximum is %d", len(request.Points), s.config.MaxBatchSize),
+		}
+	}
+
+	// Create a channel for tasks and results with buffering
+	taskChannel := make(chan int, len(request.Points))
+	results := make([]pipeline.Result, len(request.Points))
+
+	// Start workers - each worker writes only to the result slots of its own tasks
+	var wg sync.WaitGroup
+	for i := 0; i < min(s.workers, len(request.Points)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range taskChannel {
+				matches, err := s.lookupCoordinate(request.Points[idx])
+				results[idx] = pipeline.Result{LineIdx: idx, Matches: matches, Err: err}
+				if err == nil && len(matches) > 0 {
+					results[idx].Vegreferanse = matches[0].Vegsystemreferanse.Kortform
+				}
+			}
+		}()
+	}
+
+	// Queue all tasks
+	for i := range request.Points {
+		taskChannel <- i
+	}
+	close(taskChannel)
+	wg.Wait()
+
+	// Apply the vegreferanse selector to improve road matching along the sequence
+	pipeline.ApplyVegreferanseSelector(results)
+
+	response := CoordinateBatchResponse{Results: make([]CoordinateResponse, len(results))}
+	for i, result := range results {
+		response.Results[i] = coordinateResponse(result.Vegreferanse, result.Matches)
+		if result.Err != nil {
+			response.Results[i].Error = result.Err.Error()
+		}
+	}
+
+	s.mu.Lock()
+	s.batchPoints += len(request.Points)
+	s.mu.Unlock()
+
+	return response, nil
+}
+
+// vegrefToCoord converts a vegreferanse, or a legacy vegreferanse, to coordinates
+func (s *Server) vegrefToCoord(request VegreferanseRequest) (VegreferanseResponse, error) {
+	vegreferanse := strings.TrimSpace(request.Vegreferanse)
+	if vegreferanse == "" {
+		return VegreferanseResponse{}, badRequest("empty vegreferanse")
+	}
+	if err := validateDate(request.Date); err != nil {
+		return VegreferanseResponse{}, err
+	}
+
+	// Legacy (pre-2020) vegreferanse values are detected and converted automatically
+	if vegref.IsLegacyVegreferanse(vegreferanse) {
+		ref, err := vegref.ParseLegacyVegreferanse(vegreferanse)
+		if err != nil {
+			return VegreferanseResponse{}, badRequest("%v", err)
+		}
+		conversion, err := s.api.GetCoordinatesFromLegacyVegreferanse(ref, request.Date)
+		if err != nil {
+			return VegreferanseResponse{}, upstreamError(err)
+		}
+		return VegreferanseResponse{
+			Vegreferanse: conversion.Vegsystemreferanse,
+			X:            conversion.Coordinate.X,
+			Y:            conversion.Coordinate.Y,
+			SRID:         s.api.SRID(),
+			Warning:      conversion.Warning(vegreferanse),
+		}, nil
+	}
+
+	// Validate and normalise the vegreferanse before calling the API
+	ref, err := vegref.ParseVegsystemreferanse(vegreferanse)
+	if err != nil {
+		return VegreferanseResponse{}, badRequest("%v", err)
+	}
+	if !ref.IsPoint() {
+		return VegreferanseResponse{}, badRequest("vegreferanse %q does not identify a single position", vegreferanse)
+	}
+
+	coords, err := s.api.GetCoordinatesFromVegreferanseAt(ref.String(), request.Date)
+	if err != nil {
+		return VegreferanseResponse{}, upstreamError(err)
+	}
+	return VegreferanseResponse{
+		Vegreferanse: ref.String(),
+		X:            coords.X,
+		Y:            coords.Y,
+		SRID:         s.api.SRID(),
+	}, nil
+}
+
+// handleHealth reports that the server is running
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, "health", http.StatusOK, map[string]any{
+		"status":         "ok",
+		"uptime_seconds": int(time.Since(s.startTime).Seconds()),
+		"srid":           s.api.SRID(),
+		"cache_enabled":  s.api.DiskCache() != nil,
+	})
+}
+
+// handleMetrics reports the server request counters and the shared metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.countRequest("metrics", http.StatusOK)
+
+	s.mu.Lock()
+	keys := make([]serverMetricKey, 0, len(s.requests))
+	for key := range s.requests {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b serverMetricKey) int {
+		return strings.Compare(fmt.Sprintf("%s %d", a.endpoint, a.status), fmt.Sprintf("%s %d", b.endpoint, b.status))
+	})
+
+	var sb strings.Builder
+	sb.WriteString("# HELP vegref_http_requests_total HTTP requests by endpoint and status code.\n")
+	sb.WriteString("# TYPE vegref_http_requests_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "vegref_http_requests_total{endpoint=%q,code=\"%d\"} %d\n", key.endpoint, key.status, s.requests[key])
+	}
+	sb.WriteString("# HELP vegref_batch_points_total Coordinates converted in batch requests.\n")
+	sb.WriteString("# TYPE vegref_batch_points_total counter\n")
+	fmt.Fprintf(&sb, "vegref_batch_points_total %d\n", s.batchPoints)
+	s.mu.Unlock()
+
+	sb.WriteString("# HELP vegref_uptime_seconds Time since the server started.\n")
+	sb.WriteString("# TYPE vegref_uptime_seconds gauge\n")
+	fmt.Fprintf(&sb, "vegref_uptime_seconds %.0f\n", time.Since(s.startTime).Seconds())
+
+	// API client, cache, selector and conversion metrics
+	metrics.Default.WriteText(&sb)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = io.WriteString(w, sb.String())
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/nvdb"
+)
+
+// newTestServer creates a server backed by a fake NVDB API
+func newTestServer(t *testing.T, config ServeConfig) *httptest.Server {
+	t.Helper()
+
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vegnett/api/v4/posisjon":
+			// Two candidate roads, the closest alternates between EV6 and FV100 along the x axis
+			evDistance, fvDistance := 2.0, 1.0
+			if strings.HasPrefix(r.URL.Query().Get("ost"), "1") {
+				evDistance, fvDistance = 1.0, 2.0
+			}
+			fmt.Fprintf(w, `[
+				{"vegsystemreferanse":{"kortform":"FV100 S1D1 m10"},"geometri":{"wkt":"POINT Z(1 2 3)","srid":5973},"avstand":%g},
+				{"vegsystemreferanse":{"kortform":"EV6 S1D1 m10"},"geometri":{"wkt":"POINT Z(1 2 3)","srid":5973},"avstand":%g}
+			]`, fvDistance, evDistance)
+		case "/vegnett/api/v4/veg/batch":
+			fmt.Fprint(w, `{"EV6 S1D1 m10":{"geometri":{"wkt":"POINT Z(100 200 3)","srid":5973}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(fake.Close)
+
+	api := nvdb.NewVegvesenetAPIV4(nvdb.WithRateLimit(1000, time.Second), nvdb.WithBaseURL(fake.URL))
+
+	server := httptest.NewServer(NewServer(api, config, 10, 4).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postJSON posts a request body and decodes the JSON response
+func postJSON(t *testing.T, url, body string, response any) int {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+// TestServerEndpoints tests the JSON endpoints of the HTTP server
+func TestServerEndpoints(t *testing.T) {
+	server := newTestServer(t, ServeConfig{Listen: ":0", MaxRequestBytes: 1 << 20, MaxBatchSize: 10})
+
+	t.Run("CoordToVegref", func(t *testing.T) {
+		var response CoordinateResponse
+		status := postJSON(t, server.URL+"/v1/coord-to-vegref", `{"x": 262000, "y": 6650000}`, &response)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if response.Vegreferanse != "FV100 S1D1 m10" || len(response.Matches) != 2 {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("BatchRoadContinuity", func(t *testing.T) {
+		// The closest road changes to EV6 for the second point, but continuity keeps FV100
+		var response CoordinateBatchResponse
+		status := postJSON(t, server.URL+"/v1/coord-to-vegref/batch",
+			`{"points": [{"x": 262000, "y": 6650000}, {"x": 162000, "y": 6650000}, {"x": 262000, "y": 6650000, "date": "2019-13-01"}]}`, &response)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if len(response.Results) != 3 {
+			t.Fatalf("Expected 3 results, got %d", len(response.Results))
+		}
+		for i, expected := range []string{"FV100 S1D1 m10", "FV100 S1D1 m10"} {
+			if response.Results[i].Vegreferanse != expected {
+				t.Errorf("Point %d: expected %s, got %s", i, expected, response.Results[i].Vegreferanse)
+			}
+		}
+		if response.Results[2].Error == "" {
+			t.Error("Expected an error for the point with an invalid date")
+		}
+	})
+
+	t.Run("VegrefToCoord", func(t *testing.T) {
+		var response VegreferanseResponse
+		status := postJSON(t, server.URL+"/v1/vegref-to-coord", `{"vegreferanse": "ev6 s1d1 m10"}`, &response)
+		if status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if response.Vegreferanse != "EV6 S1D1 m10" || response.X != 100 || response.Y != 200 || response.SRID != nvdb.SRIDUTM33 {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/health")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/metrics")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), `vegref_http_requests_total{endpoint="coord_to_vegref",code="200"} 1`) {
+			t.Errorf("Expected request counter in metrics, got:\n%s", body)
+		}
+		if !strings.Contains(string(body), "vegref_batch_points_total 3") {
+			t.Errorf("Expected batch point counter in metrics, got:\n%s", body)
+		}
+		if !strings.Contains(string(body), `vegref_nvdb_requests_total{endpoint="posisjon",code="200"}`) {
+			t.Errorf("Expected NVDB request counter in metrics, got:\n%s", body)
+		}
+	})
+}
+
+// TestServerErrors tests the error responses of the HTTP server
+func TestServerErrors(t *testing.T) {
+	server := newTestServer(t, ServeConfig{Listen: ":0", MaxRequestBytes: 1024, MaxBatchSize: 2})
+
+	testCases := []struct {
+		path        string
+		body        string
+		status      int
+		description string
+	}{
+		{"/v1/coord-to-vegref", `{"x": 1, "y": `, http.StatusBadRequest, "Malformed JSON"},
+		{"/v1/coord-to-vegref", `{"x": 1, "y": 2, "z": 3}`, http.StatusBadRequest, "Unknown field"},
+		{"/v1/coord-to-vegref", `{"x": 1, "y": 2} {"x": 1, "y": 2}`, http.StatusBadRequest, "Multiple objects"},
+		{"/v1/coord-to-vegref", `{"x": 1, "y": 2, "date": "yesterday"}`, http.StatusBadRequest, "Invalid date"},
+		{"/v1/coord-to-vegref", `{"x": 1, "y": 2, "date": "` + strings.Repeat("x", 2048) + `"}`, http.StatusRequestEntityTooLarge, "Body too large"},
+		{"/v1/coord-to-vegref/batch", `{"points": []}`, http.StatusBadRequest, "Empty batch"},
+		{"/v1/coord-to-vegref/batch", `{"points": [{"x": 1, "y": 2}, {"x": 1, "y": 2}, {"x": 1, "y": 2}]}`, http.StatusRequestEntityTooLarge, "Batch too large"},
+		{"/v1/vegref-to-coord", `{"vegreferanse": "EV6 S1D1"}`, http.StatusBadRequest, "Not a single position"},
+		{"/v1/vegref-to-coord", `{"vegreferanse": "FV100 S1D1 m10"}`, http.StatusBadGateway, "Not found by API"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var response errorResponse
+			status := postJSON(t, server.URL+tc.path, tc.body, &response)
+			if status != tc.status {
+				t.Errorf("Expected status %d, got %d (%s)", tc.status, status, response.Error)
+			}
+			if response.Error == "" {
+				t.Error("Expected an error message in the response")
+			}
+		})
+	}
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/v1/coord-to-vegref")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status 405, got %d", resp.StatusCode)
+		}
+	})
+}
+
+// TestServerGracefulShutdown tests that the server stops when its context is cancelled
+func TestServerGracefulShutdown(t *testing.T) {
+	api := nvdb.NewVegvesenetAPIV4(nvdb.WithRateLimit(1000, time.Second))
+	server := NewServer(api, ServeConfig{Listen: "127.0.0.1:0", MaxRequestBytes: 1024, MaxBatchSize: 1}, 10, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenAndServe(ctx)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not shut down")
+	}
+}
@@ -0,0 +1,204 @@
+// Settings Component
+//
+// This component fills in command-line flags that were not given on the command line from
+// environment variables and a JSON or YAML config file, so that recurring jobs can share their settings
+// instead of repeating a long list of flags.
+//
+// Key features:
+// - Config files with settings named like the flags, e.g. "rate-limit", in JSON or, by the file
+//   extension .yaml or .yml, YAML
+// - Named profiles in the config file that override its top-level settings
+// - Environment variables named after the flags, e.g. VEGREF_RATE_LIMIT for -rate-limit
+// - Precedence: flags > environment variables > profile > config file > defaults
+// - One config file shared by all commands, with unknown settings reported as errors
+// - Reports the effective value and source of every setting
+
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables for the flags
+const EnvPrefix = "VEGREF_"
+
+// Sources of a setting, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// profilesKey is the config file key holding the named profiles
+const profilesKey = "profiles"
+
+// File is a parsed config file
+type File struct {
+	Path     string
+	Settings map[string]string            // Top-level settings by flag name
+	Profiles map[string]map[string]string // Settings of each profile by flag name
+}
+
+// Setting is the effective value of a flag and where it came from
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// EnvName returns the environment variable for a flag, e.g. VEGREF_RATE_LIMIT for rate-limit
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// LoadFile reads a config file, as YAML when the extension is .yaml or .yml and as JSON otherwise.
+// Setting values may be strings, numbers, booleans or lists of strings, which are joined with
+// commas like the comma separated flags.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	file := &File{Path: path, Profiles: make(map[string]map[string]string)}
+	if profiles, ok := raw[profilesKey]; ok {
+		delete(raw, profilesKey)
+
+		var rawProfiles map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(profiles, &rawProfiles); err != nil {
+			return nil, fmt.Errorf("invalid profiles in config file %s: %w", path, err)
+		}
+		for name, rawProfile := range rawProfiles {
+			values, err := settingValues(rawProfile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid profile %q in config file %s: %w", name, path, err)
+			}
+			file.Profiles[name] = values
+		}
+	}
+
+	file.Settings, err = settingValues(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// settingValues converts the JSON values of settings to flag values
+func settingValues(raw map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		var text string
+		var list []string
+		switch {
+		case bytes.Equal(value, []byte("null")):
+			return nil, fmt.Errorf("setting %q has no value", name)
+		case json.Unmarshal(value, &text) == nil:
+			values[name] = text
+		case json.Unmarshal(value, &list) == nil:
+			values[name] = strings.Join(list, ",")
+		case value[0] == '{' || value[0] == '[':
+			return nil, fmt.Errorf("setting %q must be a string, number, boolean or list of strings", name)
+		default:
+			values[name] = string(value) // Number or boolean
+		}
+	}
+	return values, nil
+}
+
+// Check returns an error when a setting in the file or one of its profiles does not name a known
+// flag, or names one of the flags in skip. A config file may be shared by several commands, so
+// known should accept the flags of every command.
+func (f *File) Check(known func(name string) bool, skip ...string) error {
+	for _, values := range append([]map[string]string{f.Settings}, slices.Collect(maps.Values(f.Profiles))...) {
+		for name := range values {
+			if !known(name) || slices.Contains(skip, name) {
+				return fmt.Errorf("unknown setting %q in config file %s", name, f.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply sets the flags that were not given on the command line from the environment, the profile
+// and the config file, in that order of precedence. The flags in skip, such as the flag naming the
+// config file, are left alone, and settings of flags not in the flag set are ignored, so that
+// File.Check must be used to catch unknown settings. The file may be nil when no config file is
+// used. Returns the effective settings sorted by name.
+func Apply(fs *flag.FlagSet, file *File, profile string, lookupEnv func(string) (string, bool), skip ...string) ([]Setting, error) {
+	var fileSettings, profileSettings map[string]string
+	if file != nil {
+		fileSettings = file.Settings
+		if profile != "" {
+			var ok bool
+			if profileSettings, ok = file.Profiles[profile]; !ok {
+				return nil, fmt.Errorf("profile %q not found in config file %s", profile, file.Path)
+			}
+		}
+	} else if profile != "" {
+		return nil, fmt.Errorf("profile %q requires a config file", profile)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var effective []Setting
+	var applyErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if applyErr != nil || slices.Contains(skip, f.Name) {
+			return
+		}
+
+		source := SourceDefault
+		if given[f.Name] {
+			source = SourceFlag
+		} else if value, ok := lookupEnv(EnvName(f.Name)); ok {
+			source = SourceEnv
+			applyErr = setFlag(fs, f.Name, value, "environment variable "+EnvName(f.Name))
+		} else if value, ok := profileSettings[f.Name]; ok {
+			source = SourceProfile
+			applyErr = setFlag(fs, f.Name, value, fmt.Sprintf("profile %q", profile))
+		} else if value, ok := fileSettings[f.Name]; ok {
+			source = SourceFile
+			applyErr = setFlag(fs, f.Name, value, "config file "+file.Path)
+		}
+
+		effective = append(effective, Setting{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+	if applyErr != nil {
+		return nil, applyErr
+	}
+	return effective, nil
+}
+
+// setFlag sets a flag, naming where the value came from when it is invalid
+func setFlag(fs *flag.FlagSet, name, value, origin string) error {
+	if err := fs.Set(name, value); err != nil {
+		return fmt.Errorf("invalid value %q for %s in %s: %w", value, name, origin, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package settings
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFlagSet creates a flag set like the command-line flags of the program
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.String("mode", "", "")
+	fs.Int("rate-limit", 40, "")
+	fs.Int("workers", 5, "")
+	fs.Bool("no-cache", false, "")
+	fs.String("road-categories", "", "")
+	fs.String("date", "", "")
+	return fs
+}
+
+// writeConfig writes a config file to a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vegref.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+// TestApply tests the precedence of flags, environment variables, profiles and the config file
+func TestApply(t *testing.T) {
+	file, err := LoadFile(writeConfig(t, `{
+		"mode": "coord_to_vegref",
+		"rate-limit": 20,
+		"workers": 2,
+		"no-cache": true,
+		"profiles": {
+			"nightly": {"workers": 8, "road-categories": ["E", "R"]}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"-rate-limit=10"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	env := map[string]string{"VEGREF_RATE_LIMIT": "30", "VEGREF_DATE": "2019-01-01"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	effective, err := Apply(fs, file, "nightly", lookupEnv, "config")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]Setting{
+		"rate-limit":      {"rate-limit", "10", SourceFlag},
+		"date":            {"date", "2019-01-01", SourceEnv},
+		"workers":         {"workers", "8", SourceProfile},
+		"road-categories": {"road-categories", "E,R", SourceProfile},
+		"mode":            {"mode", "coord_to_vegref", SourceFile},
+		"no-cache":        {"no-cache", "true", SourceFile},
+	}
+	if len(effective) != len(expected) {
+		t.Errorf("Expected %d settings, got %+v", len(expected), effective)
+	}
+	for _, setting := range effective {
+		if setting != expected[setting.Name] {
+			t.Errorf("Expected %+v, got %+v", expected[setting.Name], setting)
+		}
+	}
+	if value := fs.Lookup("workers").Value.String(); value != "8" {
+		t.Errorf("Expected the workers flag to be set to 8, got %s", value)
+	}
+}
+
+// TestApplyErrors tests invalid config files, profiles and values
+func TestApplyErrors(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+	known := func(name string) bool { return newFlagSet().Lookup(name) != nil }
+
+	testCases := []struct {
+		config      string
+		profile     string
+		expected    string
+		description string
+	}{
+		{`{"workers": "many"}`, "", `invalid value "many" for workers in config file`, "Invalid value"},
+		{`{"threads": 4}`, "", `unknown setting "threads"`, "Unknown setting"},
+		{`{"config": "other.json"}`, "", `unknown setting "config"`, "Skipped setting"},
+		{`{"workers": 4}`, "weekly", `profile "weekly" not found`, "Unknown profile"},
+		{`{"profiles": {"nightly": {"rate": 1}}}`, "nightly", `unknown setting "rate"`, "Unknown setting in profile"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			file, err := LoadFile(writeConfig(t, tc.config))
+			if err != nil {
+				t.Fatalf("Failed to load config file: %v", err)
+			}
+			err = file.Check(known, "config")
+			if err == nil {
+				_, err = Apply(newFlagSet(), file, tc.profile, noEnv, "config")
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	t.Run("OtherCommandSetting", func(t *testing.T) {
+		file, err := LoadFile(writeConfig(t, `{"workers": 4, "listen": ":8080"}`))
+		if err != nil {
+			t.Fatalf("Failed to load config file: %v", err)
+		}
+		if err := file.Check(func(name string) bool { return name == "workers" || name == "listen" }); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := Apply(newFlagSet(), file, "", noEnv); err != nil {
+			t.Errorf("Expected settings of flags in other flag sets to be ignored, got %v", err)
+		}
+	})
+
+	t.Run("ProfileWithoutFile", func(t *testing.T) {
+		if _, err := Apply(newFlagSet(), nil, "nightly", noEnv); err == nil {
+			t.Error("Expected error for a profile without a config file, got none")
+		}
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		for _, content := range []string{`{"workers": 4`, `{"workers": null}`, `{"workers": {"min": 1}}`} {
+			if _, err := LoadFile(writeConfig(t, content)); err == nil {
+				t.Errorf("Expected error for config file %s, got none", content)
+			}
+		}
+	})
+}
+
+// TestEnvName tests the environment variable names of the flags
+func TestEnvName(t *testing.T) {
+	if name := EnvName("rate-limit"); name != "VEGREF_RATE_LIMIT" {
+		t.Errorf("Expected VEGREF_RATE_LIMIT, got %s", name)
+	}
+}
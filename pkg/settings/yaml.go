@@ -0,0 +1,73 @@
+// YAML Config File Component
+//
+// This component reads config files written in YAML, converting them to the JSON form of the
+// config file so that both formats are checked by the same rules.
+//
+// Key features:
+// - Full YAML through gopkg.in/yaml.v3, including anchors, aliases and block scalars
+// - Values are converted to their JSON equivalents and then parsed by the flags like values
+//   from the command line
+// - Errors of the YAML parser name the line of the file
+
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToJSON converts a YAML config file to JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value map[string]any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return []byte("{}"), nil // Empty file or only comments
+	}
+
+	converted, err := jsonValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+// jsonValue converts a decoded YAML value to a value that JSON can encode. Dates are written as
+// they appear in the file, and mappings must have string keys.
+func jsonValue(value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = converted
+		}
+		return result, nil
+	case map[any]any:
+		return nil, fmt.Errorf("mapping keys must be strings")
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	case time.Time:
+		if v.Equal(v.Truncate(24*time.Hour)) && v.Location() == time.UTC {
+			return v.Format(time.DateOnly), nil
+		}
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,126 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeNamedConfig writes a config file with the given name to a temporary directory
+func writeNamedConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+// TestLoadYAMLFile tests that a YAML config file, with anchors, merge keys and block scalars, is
+// read like the equivalent JSON file
+func TestLoadYAMLFile(t *testing.T) {
+	yamlConfig := `---
+# Shared settings
+rate-limit: 20
+workers: 8   # per machine
+cache-dir: "/var/cache/vegref # main"
+"log-format": json
+listen: ':8080'
+no-cache: false
+date: 2020-01-01
+profiles:
+  nightly-routes:
+    x-column: 2
+    y-column: 3
+    road-categories: [E, R, "F"]
+  weekly:
+    phase:
+      - V
+      - 'A'
+    road-categories:
+    - E
+  monthly: &monthly
+    x-column: &x 4
+    y-column: 5
+    log-format: >-
+      json
+  yearly:
+    <<: *monthly
+    y-column: *x
+`
+	jsonConfig := `{
+  "rate-limit": 20,
+  "workers": 8,
+  "cache-dir": "/var/cache/vegref # main",
+  "log-format": "json",
+  "listen": ":8080",
+  "no-cache": false,
+  "date": "2020-01-01",
+  "profiles": {
+    "nightly-routes": {"x-column": 2, "y-column": 3, "road-categories": ["E", "R", "F"]},
+    "weekly": {"phase": ["V", "A"], "road-categories": ["E"]},
+    "monthly": {"x-column": 4, "y-column": 5, "log-format": "json"},
+    "yearly": {"x-column": 4, "y-column": 4, "log-format": "json"}
+  }
+}`
+
+	fromJSON, err := LoadFile(writeNamedConfig(t, "vegref.json", jsonConfig))
+	if err != nil {
+		t.Fatalf("Failed to load JSON config file: %v", err)
+	}
+	for _, name := range []string{"vegref.yaml", "vegref.YML"} {
+		fromYAML, err := LoadFile(writeNamedConfig(t, name, yamlConfig))
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(fromYAML.Settings, fromJSON.Settings) || !reflect.DeepEqual(fromYAML.Profiles, fromJSON.Profiles) {
+			t.Errorf("Expected %s to match the JSON file\nYAML: %+v %+v\nJSON: %+v %+v", name,
+				fromYAML.Settings, fromYAML.Profiles, fromJSON.Settings, fromJSON.Profiles)
+		}
+	}
+
+	// An empty file has no settings
+	file, err := LoadFile(writeNamedConfig(t, "empty.yaml", "# nothing yet\n"))
+	if err != nil || len(file.Settings) != 0 {
+		t.Errorf("Expected no settings in an empty file, got %+v, %v", file, err)
+	}
+
+	// YAML is only read from files with a YAML extension
+	if _, err := LoadFile(writeNamedConfig(t, "vegref.conf", "workers: 4\n")); err == nil {
+		t.Error("Expected a YAML file without a YAML extension to be read as JSON")
+	}
+}
+
+// TestLoadYAMLFileErrors tests the errors of invalid YAML and of values that are not settings
+func TestLoadYAMLFileErrors(t *testing.T) {
+	testCases := []struct {
+		config      string
+		expected    string
+		description string
+	}{
+		{"workers 4\n", "line 1: cannot unmarshal", "Missing colon"},
+		{"workers:\n", `setting "workers" has no value`, "Missing value"},
+		{"workers: ~\n", `setting "workers" has no value`, "Null value"},
+		{"workers: 4\nworkers: 5\n", `line 2: mapping key "workers" already defined`, "Duplicate key"},
+		{"workers: 4\n  rate-limit: 2\n", "line 2: mapping values are not allowed", "Unexpected indentation"},
+		{"\tworkers: 4\n", "found character that cannot start any token", "Tab indentation"},
+		{"- workers\n", "line 1: cannot unmarshal !!seq", "Top-level list"},
+		{"workers: \"4\n", "found unexpected end of stream", "Unterminated string"},
+		{"road-categories: [E, R\n", "line 1: did not find expected ',' or ']'", "Unterminated list"},
+		{"road-categories:\n  - [E]\n", `setting "road-categories" must be a string, number, boolean or list of strings`, "Nested list"},
+		{"workers:\n  min: 1\n", `setting "workers" must be a string, number, boolean or list of strings`, "Nested mapping as a value"},
+		{"workers: {1: 2}\n", "mapping keys must be strings", "Non-string key"},
+		{"profiles:\n  - nightly\n", "invalid profiles", "Profiles as a list"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := LoadFile(writeNamedConfig(t, "vegref.yaml", tc.config))
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
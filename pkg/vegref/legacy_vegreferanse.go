@@ -0,0 +1,98 @@
+// Legacy Vegreferanse Component
+//
+// This component handles the road reference format used in NVDB before the introduction of
+// vegsystemreferanse in 2020, e.g. "1500 Fv7834 hp1 m11".
+//
+// Key features:
+// - Detection of legacy references so they can be handled automatically in the input column
+// - Strict parsing of fylke, kommune, category, status, road number, hovedparsell and meter
+// - Canonical formatting in the spaced and compact forms
+
+package vegref
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultLegacyVegreferanseDate is the last date the legacy vegreferanse format was in use in NVDB
+const DefaultLegacyVegreferanseDate = "2019-12-31"
+
+// LegacyVegreferanse represents a parsed road reference in the pre-2020 format
+type LegacyVegreferanse struct {
+	Fylke        int
+	Kommune      int    // 0 for roads administered at county or national level
+	Kategori     string // E, R, F, K, P or S
+	Status       string // Road status, e.g. V (existing), W (temporary) or A (under construction)
+	Nummer       int
+	Hovedparsell int
+	Meter        int
+}
+
+// legacyVegreferansePattern matches the compact, upper-cased legacy format, e.g. "1500FV7834HP1M11"
+var legacyVegreferansePattern = regexp.MustCompile(`^(\d{2})(\d{2})([ERFKPS])([A-Z])(\d+)HP(\d+)M(\d+)$`)
+
+// IsLegacyVegreferanse reports whether the text looks like a legacy vegreferanse,
+// i.e. starts with the four digit fylke and kommune number
+func IsLegacyVegreferanse(text string) bool {
+	compact := strings.Join(strings.Fields(text), "")
+	if len(compact) < 5 {
+		return false
+	}
+	for _, char := range compact[:4] {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return strings.ContainsRune("ERFKPSerfkps", rune(compact[4]))
+}
+
+// ParseLegacyVegreferanse parses a legacy road reference such as "1500 Fv7834 hp1 m11".
+// Whitespace and letter case are not significant.
+func ParseLegacyVegreferanse(text string) (LegacyVegreferanse, error) {
+	compact := strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	if compact == "" {
+		return LegacyVegreferanse{}, fmt.Errorf("empty vegreferanse")
+	}
+
+	parts := legacyVegreferansePattern.FindStringSubmatch(compact)
+	if parts == nil {
+		return LegacyVegreferanse{}, fmt.Errorf("invalid legacy vegreferanse %q: expected format like \"1500 Fv7834 hp1 m11\"", text)
+	}
+
+	// The pattern guarantees that the numeric groups only contain digits
+	number := func(s string) int {
+		value, _ := strconv.Atoi(s)
+		return value
+	}
+
+	ref := LegacyVegreferanse{
+		Fylke:        number(parts[1]),
+		Kommune:      number(parts[2]),
+		Kategori:     parts[3],
+		Status:       parts[4],
+		Nummer:       number(parts[5]),
+		Hovedparsell: number(parts[6]),
+		Meter:        number(parts[7]),
+	}
+	if ref.Fylke == 0 {
+		return LegacyVegreferanse{}, fmt.Errorf("invalid legacy vegreferanse %q: fylke must be between 1 and 99", text)
+	}
+	if ref.Hovedparsell == 0 {
+		return LegacyVegreferanse{}, fmt.Errorf("invalid legacy vegreferanse %q: hovedparsell must be positive", text)
+	}
+
+	return ref, nil
+}
+
+// String formats the reference in the conventional spaced form, e.g. "1500 FV7834 hp1 m11"
+func (r LegacyVegreferanse) String() string {
+	return fmt.Sprintf("%02d%02d %s%s%d hp%d m%d", r.Fylke, r.Kommune, r.Kategori, r.Status, r.Nummer, r.Hovedparsell, r.Meter)
+}
+
+// Compact formats the reference without spaces as used in NVDB query parameters, e.g. "1500FV7834hp1m11"
+func (r LegacyVegreferanse) Compact() string {
+	return strings.ReplaceAll(r.String(), " ", "")
+}
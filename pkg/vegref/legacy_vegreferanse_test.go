@@ -0,0 +1,60 @@
+package vegref
+
+import (
+	"testing"
+)
+
+// TestParseLegacyVegreferanse tests detection, parsing and formatting of legacy vegreferanse
+func TestParseLegacyVegreferanse(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string // Canonical form
+		isLegacy    bool
+		expectError bool
+		description string
+	}{
+		{"1500 Fv7834 hp1 m11", "1500 FV7834 hp1 m11", true, false, "Archive format"},
+		{"1500FV7834HP1M11", "1500 FV7834 hp1 m11", true, false, "Compact format"},
+		{"0301 KV1000 hp12 m450", "0301 KV1000 hp12 m450", true, false, "Municipal road"},
+		{"1600 Ev6 hp3 m1200", "1600 EV6 hp3 m1200", true, false, "European road"},
+		{"1500 Fv7834 m11", "", true, true, "Missing hovedparsell"},
+		{"0000 Fv7834 hp1 m11", "", true, true, "Invalid fylke"},
+		{"1500 Fv7834 hp1 m11-20", "", true, true, "Ranges are not supported"},
+		{"FV7834 S1D1 m11", "", false, true, "Vegsystemreferanse"},
+		{"150 Fv7834 hp1 m11", "", false, true, "Too few digits"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if IsLegacyVegreferanse(tc.input) != tc.isLegacy {
+				t.Errorf("Expected IsLegacyVegreferanse(%q) = %v", tc.input, tc.isLegacy)
+			}
+
+			ref, err := ParseLegacyVegreferanse(tc.input)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error parsing %q, got %+v", tc.input, ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", tc.input, err)
+			}
+			if ref.String() != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, ref.String())
+			}
+		})
+	}
+
+	ref, err := ParseLegacyVegreferanse("1500 Fv7834 hp1 m11")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ref.Fylke != 15 || ref.Kommune != 0 || ref.Kategori != "F" || ref.Status != "V" ||
+		ref.Nummer != 7834 || ref.Hovedparsell != 1 || ref.Meter != 11 {
+		t.Errorf("Unexpected fields: %+v", ref)
+	}
+	if ref.Compact() != "1500FV7834hp1m11" {
+		t.Errorf("Unexpected compact form: %s", ref.Compact())
+	}
+}
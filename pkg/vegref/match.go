@@ -0,0 +1,119 @@
+// Vegreferanse Match Component
+//
+// This component holds the domain types shared by the API client, the selector, the pipeline
+// and the server: positions, candidate road matches and conversion results.
+//
+// Key features:
+// - Candidate road matches as returned by the NVDB position lookup
+// - Results of legacy vegreferanse and vegreferanse range conversions
+// - Helpers for distance filtering, road numbers and dates used across the packages
+
+package vegref
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/larsjohnsen/koordinater-til-vegreferanse/pkg/wkt"
+)
+
+// DateLayout is the format of dates (YYYY-MM-DD) used for historical lookups
+const DateLayout = "2006-01-02"
+
+// Coordinate represents a geographical coordinate point
+type Coordinate struct {
+	X float64 // Easting (X)
+	Y float64 // Northing (Y)
+}
+
+// VegreferanseMatch represents a single road match with associated metadata
+type VegreferanseMatch struct {
+	Vegsystemreferanse struct {
+		Vegsystem struct {
+			Vegkategori string `json:"vegkategori"`
+			Fase        string `json:"fase"`
+			Nummer      int    `json:"nummer"`
+		} `json:"vegsystem"`
+		Strekning struct {
+			Strekning       int     `json:"strekning"`
+			Delstrekning    int     `json:"delstrekning"`
+			Arm             bool    `json:"arm"`
+			Adskilte_lop    string  `json:"adskilte_løp"`
+			Trafikantgruppe string  `json:"trafikantgruppe"`
+			Retning         string  `json:"retning"`
+			Meter           float64 `json:"meter"`
+		} `json:"strekning"`
+		Kortform string `json:"kortform"`
+	} `json:"vegsystemreferanse"`
+	Avstand float64 `json:"avstand"`
+	// Position on the road closest to the coordinate, nil for matches cached before it was stored
+	Punkt *Coordinate `json:"punkt,omitempty"`
+}
+
+// LegacyConversion holds the result of converting a legacy vegreferanse to the current road network
+type LegacyConversion struct {
+	// Current vegsystemreferanse, empty when the position no longer exists in the road network
+	Vegsystemreferanse string
+	// Current position, or the historical position when the reference no longer exists
+	Coordinate Coordinate
+	// Retired is set when the historical position no longer exists in the current road network
+	Retired bool
+}
+
+// Warning returns a warning for legacy vegreferanse values that no longer exist, or an empty string
+func (c LegacyConversion) Warning(vegreferanse string) string {
+	if !c.Retired {
+		return ""
+	}
+	return fmt.Sprintf("historic vegreferanse %q no longer exists in the current road network, using its historical position", vegreferanse)
+}
+
+// RoadGeometry represents the road centreline geometry of a vegreferanse range
+type RoadGeometry struct {
+	Geometry wkt.Geometry // LINESTRING, or MULTILINESTRING when the range is not continuous
+	Length   float64      // Length of the range in meters
+}
+
+// FilterMatchesByDistance filters vegreferanse matches by maximum distance
+func FilterMatchesByDistance(matches []VegreferanseMatch, maxDistance int) []VegreferanseMatch {
+	if maxDistance <= 0 {
+		return matches // No filtering if maxDistance is not positive
+	}
+
+	filtered := make([]VegreferanseMatch, 0, len(matches))
+	for _, match := range matches {
+		if match.Avstand <= float64(maxDistance) {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
+// ExtractRoadNumber extracts the road number (e.g., "E18", "RV4") from a vegreferanse string
+func ExtractRoadNumber(vegreferanse string) string {
+	if vegreferanse == "" {
+		return ""
+	}
+
+	// Use the canonical road identifier when the vegreferanse can be parsed
+	if ref, err := ParseVegsystemreferanse(vegreferanse); err == nil {
+		return ref.RoadID()
+	}
+
+	// Otherwise take the first part (e.g., "E18" from "E18 S65D1 m12621")
+	parts := strings.Fields(vegreferanse)
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// ValidateDate checks that a date for historical lookups is in the YYYY-MM-DD format
+func ValidateDate(date string) error {
+	if _, err := time.Parse(DateLayout, date); err != nil {
+		return fmt.Errorf("invalid date %q, must be in YYYY-MM-DD format", date)
+	}
+	return nil
+}
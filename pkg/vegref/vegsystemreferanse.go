@@ -0,0 +1,335 @@
+// Vegsystemreferanse Component
+//
+// This component provides a typed representation of the road reference (vegsystemreferanse)
+// used by NVDB, replacing ad-hoc handling of the kortform as a raw string.
+//
+// Key features:
+// - Strict parsing of both spaced ("EV6 S10D1 m200") and compact ("EV6S10D1m200") forms
+// - Supports road category, phase, number, strekning, delstrekning, meter values and ranges
+// - Supports intersection (KD) and side facility (SD) parts, optionally with their system number
+// - Canonical formatting in the NVDB kortform style
+// - Comparison helpers used for road continuity and reporting
+
+package vegref
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Road categories (vegkategori) in NVDB
+const (
+	VegkategoriEuropaveg   = "E"
+	VegkategoriRiksveg     = "R"
+	VegkategoriFylkesveg   = "F"
+	VegkategoriKommunalveg = "K"
+	VegkategoriPrivatveg   = "P"
+	VegkategoriSkogsbilveg = "S"
+)
+
+// Road phases (fase) in NVDB
+const (
+	FaseEksisterende = "V"
+	FaseAnlegg       = "A"
+	FasePlanlagt     = "P"
+	FaseFiktiv       = "F"
+)
+
+// Kinds of arm parts that can follow the main reference
+const (
+	ArmKryssdel      = "K" // Part of an intersection (KD), optionally within an intersection system (KS)
+	ArmSideanleggdel = "S" // Part of a side facility (SD), optionally within a side facility system (SS)
+)
+
+// MeterValue represents a meter position or a meter range on a road reference
+type MeterValue struct {
+	Fra     float64
+	Til     float64 // Equal to Fra unless IsRange is set
+	IsRange bool
+}
+
+// ArmReference represents an intersection or side facility part of a road reference,
+// e.g. "KD1 m5" or "SS2 SD1 m12"
+type ArmReference struct {
+	Kind   string // ArmKryssdel or ArmSideanleggdel
+	System int    // System number (KS/SS), 0 when not specified
+	Del    int    // Part number (KD/SD)
+	Meter  *MeterValue
+}
+
+// Vegsystemreferanse represents a parsed NVDB road reference
+type Vegsystemreferanse struct {
+	Kategori     string // One of the Vegkategori constants
+	Fase         string // One of the Fase constants, empty when not specified (e.g. "E18")
+	Nummer       int
+	Strekning    int // 0 when not specified
+	Delstrekning int // 0 when not specified
+	Meter        *MeterValue
+	Arm          *ArmReference
+}
+
+// vegsystemreferanseParser is a small scanner over the compact, upper-cased reference
+type vegsystemreferanseParser struct {
+	text string
+	pos  int
+}
+
+// accept consumes the given prefix if present
+func (p *vegsystemreferanseParser) accept(prefix string) bool {
+	if strings.HasPrefix(p.text[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// acceptOneOf consumes a single character from the given set if present
+func (p *vegsystemreferanseParser) acceptOneOf(chars string) (string, bool) {
+	if p.pos < len(p.text) && strings.IndexByte(chars, p.text[p.pos]) >= 0 {
+		p.pos++
+		return p.text[p.pos-1 : p.pos], true
+	}
+	return "", false
+}
+
+// integer consumes a positive integer
+func (p *vegsystemreferanseParser) integer() (int, bool) {
+	start := p.pos
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, false
+	}
+	value, err := strconv.Atoi(p.text[start:p.pos])
+	return value, err == nil
+}
+
+// number consumes a non-negative decimal number
+func (p *vegsystemreferanseParser) number() (float64, bool) {
+	start := p.pos
+	for p.pos < len(p.text) && (p.text[p.pos] >= '0' && p.text[p.pos] <= '9' || p.text[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+	return value, err == nil
+}
+
+// meter consumes a meter value or range following an "M" marker
+func (p *vegsystemreferanseParser) meter() (*MeterValue, error) {
+	fra, ok := p.number()
+	if !ok {
+		return nil, fmt.Errorf("missing meter value")
+	}
+	value := &MeterValue{Fra: fra, Til: fra}
+	if p.accept("-") {
+		til, ok := p.number()
+		if !ok {
+			return nil, fmt.Errorf("missing end of meter range")
+		}
+		if til < fra {
+			return nil, fmt.Errorf("meter range end %s is before start %s", formatMeter(til), formatMeter(fra))
+		}
+		value.Til = til
+		value.IsRange = true
+	}
+	return value, nil
+}
+
+// ParseVegsystemreferanse parses a road reference such as "EV6 S10D1 m200-1500" or "Fv100 S1D1 m500 KD1 m5".
+// Whitespace and letter case are not significant.
+func ParseVegsystemreferanse(text string) (Vegsystemreferanse, error) {
+	compact := strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	if compact == "" {
+		return Vegsystemreferanse{}, fmt.Errorf("empty vegsystemreferanse")
+	}
+
+	fail := func(format string, args ...any) (Vegsystemreferanse, error) {
+		return Vegsystemreferanse{}, fmt.Errorf("invalid vegsystemreferanse %q: %s", text, fmt.Sprintf(format, args...))
+	}
+
+	p := &vegsystemreferanseParser{text: compact}
+	var ref Vegsystemreferanse
+	var ok bool
+
+	// Road category, optional phase and road number, e.g. "EV6", "Fv100" or "E18"
+	if ref.Kategori, ok = p.acceptOneOf("ERFKPS"); !ok {
+		return fail("unknown road category %q", compact[:1])
+	}
+	ref.Fase, _ = p.acceptOneOf("VAPF")
+	if ref.Nummer, ok = p.integer(); !ok {
+		return fail("missing road number")
+	}
+
+	// Strekning and delstrekning, e.g. "S10D1"
+	if p.accept("S") {
+		if ref.Strekning, ok = p.integer(); !ok {
+			return fail("missing strekning number")
+		}
+		if p.accept("D") {
+			if ref.Delstrekning, ok = p.integer(); !ok {
+				return fail("missing delstrekning number")
+			}
+		}
+	}
+
+	// Meter value or range, e.g. "m200" or "m200-1500"
+	if p.accept("M") {
+		if ref.Strekning == 0 {
+			return fail("meter value without strekning")
+		}
+		meter, err := p.meter()
+		if err != nil {
+			return fail("%v", err)
+		}
+		ref.Meter = meter
+	}
+
+	// Optional intersection or side facility part, e.g. "KD1 m5" or "SS2 SD1 m12"
+	arm := &ArmReference{}
+	if kind, found := p.acceptOneOf("KS"); found {
+		arm.Kind = kind
+		if p.accept("S") {
+			if arm.System, ok = p.integer(); !ok {
+				return fail("missing system number")
+			}
+			if !p.accept(kind + "D") {
+				return fail("missing part number after system number")
+			}
+		} else if !p.accept("D") {
+			return fail("unexpected %q", compact[p.pos-1:])
+		}
+		if arm.Del, ok = p.integer(); !ok {
+			return fail("missing part number")
+		}
+		if p.accept("M") {
+			meter, err := p.meter()
+			if err != nil {
+				return fail("%v", err)
+			}
+			arm.Meter = meter
+		}
+		if ref.Strekning == 0 {
+			return fail("intersection or side facility part without strekning")
+		}
+		ref.Arm = arm
+	}
+
+	if p.pos != len(compact) {
+		return fail("unexpected %q", compact[p.pos:])
+	}
+
+	return ref, nil
+}
+
+// RoadID returns the road identifier, e.g. "EV6" or "E18"
+func (r Vegsystemreferanse) RoadID() string {
+	return r.Kategori + r.Fase + strconv.Itoa(r.Nummer)
+}
+
+// String formats the reference in canonical kortform, e.g. "EV6 S10D1 m200-1500 KD1 m5"
+func (r Vegsystemreferanse) String() string {
+	parts := []string{r.RoadID()}
+
+	if r.Strekning > 0 {
+		section := "S" + strconv.Itoa(r.Strekning)
+		if r.Delstrekning > 0 {
+			section += "D" + strconv.Itoa(r.Delstrekning)
+		}
+		parts = append(parts, section)
+	}
+	if r.Meter != nil {
+		parts = append(parts, r.Meter.String())
+	}
+
+	if r.Arm != nil {
+		if r.Arm.System > 0 {
+			parts = append(parts, r.Arm.Kind+"S"+strconv.Itoa(r.Arm.System))
+		}
+		parts = append(parts, r.Arm.Kind+"D"+strconv.Itoa(r.Arm.Del))
+		if r.Arm.Meter != nil {
+			parts = append(parts, r.Arm.Meter.String())
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// String formats the meter value as "m200" or "m200-1500"
+func (m MeterValue) String() string {
+	if m.IsRange {
+		return "m" + formatMeter(m.Fra) + "-" + formatMeter(m.Til)
+	}
+	return "m" + formatMeter(m.Fra)
+}
+
+// formatMeter formats a meter value without unnecessary decimals
+func formatMeter(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// IsRange reports whether the reference covers a meter range rather than a single position
+func (r Vegsystemreferanse) IsRange() bool {
+	if r.Arm != nil && r.Arm.Meter != nil {
+		return r.Arm.Meter.IsRange
+	}
+	return r.Meter != nil && r.Meter.IsRange
+}
+
+// IsPoint reports whether the reference identifies a single position on the road network
+func (r Vegsystemreferanse) IsPoint() bool {
+	if r.Strekning == 0 || r.Delstrekning == 0 {
+		return false
+	}
+	if r.Arm != nil {
+		return r.Arm.Meter != nil && !r.Arm.Meter.IsRange
+	}
+	return r.Meter != nil && !r.Meter.IsRange
+}
+
+// SameRoad reports whether both references are on the same road. Phases are only
+// compared when both references specify one.
+func (r Vegsystemreferanse) SameRoad(other Vegsystemreferanse) bool {
+	if r.Kategori != other.Kategori || r.Nummer != other.Nummer {
+		return false
+	}
+	return r.Fase == "" || other.Fase == "" || r.Fase == other.Fase
+}
+
+// SameSection reports whether both references are on the same strekning and delstrekning of the same road
+func (r Vegsystemreferanse) SameSection(other Vegsystemreferanse) bool {
+	return r.SameRoad(other) && r.Strekning == other.Strekning && r.Delstrekning == other.Delstrekning
+}
+
+// Compare orders references by road, strekning, delstrekning and meter value.
+// It returns -1, 0 or +1 like cmp.Compare.
+func (r Vegsystemreferanse) Compare(other Vegsystemreferanse) int {
+	meter := func(ref Vegsystemreferanse) float64 {
+		if ref.Meter == nil {
+			return -1
+		}
+		return ref.Meter.Fra
+	}
+	return cmp.Or(
+		cmp.Compare(r.Kategori, other.Kategori),
+		cmp.Compare(r.Nummer, other.Nummer),
+		cmp.Compare(r.Fase, other.Fase),
+		cmp.Compare(r.Strekning, other.Strekning),
+		cmp.Compare(r.Delstrekning, other.Delstrekning),
+		cmp.Compare(meter(r), meter(other)),
+	)
+}
+
+// NormalizeVegsystemreferanse parses and re-formats a reference in canonical kortform
+func NormalizeVegsystemreferanse(text string) (string, error) {
+	ref, err := ParseVegsystemreferanse(text)
+	if err != nil {
+		return "", err
+	}
+	return ref.String(), nil
+}
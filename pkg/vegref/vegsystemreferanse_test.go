@@ -0,0 +1,124 @@
+package vegref
+
+import (
+	"testing"
+)
+
+// TestParseVegsystemreferanse tests parsing and canonical formatting of road references
+func TestParseVegsystemreferanse(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    string // Canonical form
+		expectError bool
+		description string
+	}{
+		{"EV6 S10D1 m200", "EV6 S10D1 m200", false, "NVDB kortform"},
+		{"ev6s10d1m200", "EV6 S10D1 m200", false, "Compact lowercase form"},
+		{"E18 S65D1 m12621", "E18 S65D1 m12621", false, "Without phase"},
+		{"Fv100 S1D1 m500", "FV100 S1D1 m500", false, "Colloquial county road"},
+		{"EV6  S10D1   m200-1500", "EV6 S10D1 m200-1500", false, "Meter range with extra spaces"},
+		{"RA4 S2D3 m12.5", "RA4 S2D3 m12.5", false, "Road under construction with decimal meter"},
+		{"EV6 S78D1 m3580 KD1 m4", "EV6 S78D1 m3580 KD1 m4", false, "Intersection part"},
+		{"FV7834 S1D1 m11 SS2 SD1 m12", "FV7834 S1D1 m11 SS2 SD1 m12", false, "Side facility part with system"},
+		{"EV6 S10", "EV6 S10", false, "Whole strekning"},
+		{"EV6", "EV6", false, "Whole road"},
+		{"", "", true, "Empty"},
+		{"INVALID_VEGREF", "", true, "Unknown category"},
+		{"EV S1D1 m10", "", true, "Missing road number"},
+		{"EV6 m10", "", true, "Meter without strekning"},
+		{"EV6 S1D1 m1500-200", "", true, "Reversed range"},
+		{"EV6 S1D1 m10 XD1", "", true, "Trailing garbage"},
+		{"EV6 S1D1 m", "", true, "Missing meter value"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ref, err := ParseVegsystemreferanse(tc.input)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error parsing %q, got %+v", tc.input, ref)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", tc.input, err)
+			}
+			if ref.String() != tc.expected {
+				t.Errorf("Expected canonical form %q, got %q", tc.expected, ref.String())
+			}
+
+			// The canonical form must parse to the same reference
+			reparsed, err := ParseVegsystemreferanse(ref.String())
+			if err != nil || reparsed.String() != ref.String() {
+				t.Errorf("Canonical form %q does not round trip: %v", ref.String(), err)
+			}
+		})
+	}
+}
+
+// TestVegsystemreferanseFields tests the parsed fields of a full reference
+func TestVegsystemreferanseFields(t *testing.T) {
+	ref, err := ParseVegsystemreferanse("EV6 S10D1 m200-1500")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if ref.Kategori != VegkategoriEuropaveg || ref.Fase != FaseEksisterende || ref.Nummer != 6 {
+		t.Errorf("Unexpected road: %s %s %d", ref.Kategori, ref.Fase, ref.Nummer)
+	}
+	if ref.Strekning != 10 || ref.Delstrekning != 1 {
+		t.Errorf("Unexpected section: S%dD%d", ref.Strekning, ref.Delstrekning)
+	}
+	if ref.Meter == nil || ref.Meter.Fra != 200 || ref.Meter.Til != 1500 || !ref.Meter.IsRange {
+		t.Errorf("Unexpected meter value: %+v", ref.Meter)
+	}
+	if !ref.IsRange() || ref.IsPoint() {
+		t.Errorf("Expected a range, got IsRange=%v IsPoint=%v", ref.IsRange(), ref.IsPoint())
+	}
+	if ref.RoadID() != "EV6" {
+		t.Errorf("Expected road ID EV6, got %s", ref.RoadID())
+	}
+}
+
+// TestVegsystemreferanseComparison tests the comparison helpers
+func TestVegsystemreferanseComparison(t *testing.T) {
+	parse := func(text string) Vegsystemreferanse {
+		ref, err := ParseVegsystemreferanse(text)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", text, err)
+		}
+		return ref
+	}
+
+	e18 := parse("E18 S65D1 m12500")
+	ev18 := parse("EV18 S65D1 m12600")
+	ev18Other := parse("EV18 S66D1 m10")
+	ea18 := parse("EA18 S65D1 m12600")
+	fv100 := parse("FV100 S1D1 m500")
+
+	if !e18.SameRoad(ev18) {
+		t.Error("Expected E18 and EV18 to be the same road")
+	}
+	if ev18.SameRoad(ea18) {
+		t.Error("Expected EV18 and EA18 to be different roads")
+	}
+	if e18.SameRoad(fv100) {
+		t.Error("Expected E18 and FV100 to be different roads")
+	}
+	if !e18.SameSection(ev18) || e18.SameSection(ev18Other) {
+		t.Error("Unexpected section comparison result")
+	}
+
+	if parse("EV18 S65D1 m12500").Compare(ev18) >= 0 {
+		t.Error("Expected m12500 to sort before m12600")
+	}
+	if ev18.Compare(ev18Other) >= 0 {
+		t.Error("Expected S65 to sort before S66")
+	}
+	if fv100.Compare(e18) <= 0 {
+		t.Error("Expected F category to sort after E category")
+	}
+	if ev18.Compare(ev18) != 0 {
+		t.Error("Expected equal references to compare as 0")
+	}
+}
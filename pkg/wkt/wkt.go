@@ -0,0 +1,324 @@
+// WKT Geometry Component
+//
+// This component parses and formats WKT (Well-Known Text) geometries as returned by the NVDB API.
+//
+// Key features:
+// - Parses POINT, LINESTRING and MULTILINESTRING geometries
+// - Handles 2D, Z, M and ZM coordinates (M values are parsed but not kept)
+// - Formats geometries as WKT or GeoJSON
+// - Calculates the planar length of line geometries
+
+package wkt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Supported WKT geometry types
+const (
+	TypePoint           = "POINT"
+	TypeLineString      = "LINESTRING"
+	TypeMultiLineString = "MULTILINESTRING"
+)
+
+// Point represents a single position in a WKT geometry
+type Point struct {
+	X float64
+	Y float64
+	Z float64 // Only meaningful when the geometry has Z values
+}
+
+// Geometry represents a parsed POINT, LINESTRING or MULTILINESTRING geometry.
+// All types are stored as a list of lines: a POINT is a single line with one position,
+// a LINESTRING is a single line and a MULTILINESTRING holds one line per member.
+type Geometry struct {
+	Type  string
+	HasZ  bool
+	Lines [][]Point
+}
+
+// IsEmpty reports whether the geometry has no positions
+func (g Geometry) IsEmpty() bool {
+	for _, line := range g.Lines {
+		if len(line) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Length returns the planar (2D) length of the geometry in coordinate units
+func (g Geometry) Length() float64 {
+	length := 0.0
+	for _, line := range g.Lines {
+		for i := 1; i < len(line); i++ {
+			length += math.Hypot(line[i].X-line[i-1].X, line[i].Y-line[i-1].Y)
+		}
+	}
+	return length
+}
+
+// WKT formats the geometry as a WKT string
+func (g Geometry) WKT() string {
+	var sb strings.Builder
+	sb.WriteString(g.Type)
+	if g.HasZ {
+		sb.WriteString(" Z")
+	}
+	if g.IsEmpty() {
+		sb.WriteString(" EMPTY")
+		return sb.String()
+	}
+
+	writeLine := func(line []Point) {
+		sb.WriteString("(")
+		for i, p := range line {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(formatWKTNumber(p.X) + " " + formatWKTNumber(p.Y))
+			if g.HasZ {
+				sb.WriteString(" " + formatWKTNumber(p.Z))
+			}
+		}
+		sb.WriteString(")")
+	}
+
+	sb.WriteString(" ")
+	switch g.Type {
+	case TypeMultiLineString:
+		sb.WriteString("(")
+		for i, line := range g.Lines {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeLine(line)
+		}
+		sb.WriteString(")")
+	default:
+		writeLine(g.Lines[0])
+	}
+	return sb.String()
+}
+
+// GeoJSON formats the geometry as a GeoJSON geometry object
+func (g Geometry) GeoJSON() (string, error) {
+	position := func(p Point) []float64 {
+		if g.HasZ {
+			return []float64{p.X, p.Y, p.Z}
+		}
+		return []float64{p.X, p.Y}
+	}
+	line := func(points []Point) [][]float64 {
+		positions := make([][]float64, len(points))
+		for i, p := range points {
+			positions[i] = position(p)
+		}
+		return positions
+	}
+
+	var geometry struct {
+		Type        string `json:"type"`
+		Coordinates any    `json:"coordinates"`
+	}
+	switch g.Type {
+	case TypePoint:
+		geometry.Type = "Point"
+		if g.IsEmpty() {
+			geometry.Coordinates = []float64{}
+		} else {
+			geometry.Coordinates = position(g.Lines[0][0])
+		}
+	case TypeLineString:
+		geometry.Type = "LineString"
+		geometry.Coordinates = [][]float64{}
+		if len(g.Lines) > 0 {
+			geometry.Coordinates = line(g.Lines[0])
+		}
+	case TypeMultiLineString:
+		geometry.Type = "MultiLineString"
+		lines := make([][][]float64, len(g.Lines))
+		for i, l := range g.Lines {
+			lines[i] = line(l)
+		}
+		geometry.Coordinates = lines
+	default:
+		return "", fmt.Errorf("unsupported geometry type: %s", g.Type)
+	}
+
+	data, err := json.Marshal(geometry)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize GeoJSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// Parse parses a WKT string into a Geometry
+func Parse(wkt string) (Geometry, error) {
+	text := strings.ToUpper(strings.TrimSpace(wkt))
+	if text == "" {
+		return Geometry{}, fmt.Errorf("empty WKT string")
+	}
+
+	// Identify the geometry type; MULTILINESTRING must be checked before LINESTRING
+	var geometry Geometry
+	for _, geometryType := range []string{TypeMultiLineString, TypeLineString, TypePoint} {
+		if strings.HasPrefix(text, geometryType) {
+			geometry.Type = geometryType
+			break
+		}
+	}
+	if geometry.Type == "" {
+		return Geometry{}, fmt.Errorf("unrecognized WKT format: %s", wkt)
+	}
+	rest := strings.TrimSpace(text[len(geometry.Type):])
+
+	// Optional dimension marker, with or without a space before the parenthesis
+	hasM := false
+	switch {
+	case strings.HasPrefix(rest, "ZM"):
+		geometry.HasZ, hasM = true, true
+		rest = rest[2:]
+	case strings.HasPrefix(rest, "Z"):
+		geometry.HasZ = true
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "M"):
+		hasM = true
+		rest = rest[1:]
+	}
+	rest = strings.TrimSpace(rest)
+
+	if rest == "EMPTY" {
+		return geometry, nil
+	}
+
+	body, err := unwrapParentheses(rest)
+	if err != nil {
+		return Geometry{}, fmt.Errorf("invalid WKT format: %s: %w", wkt, err)
+	}
+
+	// Parse the coordinate lists
+	var lineTexts []string
+	if geometry.Type == TypeMultiLineString {
+		lineTexts, err = splitParenthesizedList(body)
+		if err != nil {
+			return Geometry{}, fmt.Errorf("invalid WKT format: %s: %w", wkt, err)
+		}
+	} else {
+		lineTexts = []string{body}
+	}
+
+	for _, lineText := range lineTexts {
+		line, lineHasZ, err := parseWKTPositions(lineText, geometry.HasZ, hasM)
+		if err != nil {
+			return Geometry{}, fmt.Errorf("invalid WKT format: %s: %w", wkt, err)
+		}
+		// Some producers write 3D positions without the Z marker
+		if lineHasZ {
+			geometry.HasZ = true
+		}
+		geometry.Lines = append(geometry.Lines, line)
+	}
+
+	if geometry.Type == TypePoint && len(geometry.Lines[0]) != 1 {
+		return Geometry{}, fmt.Errorf("invalid WKT format, POINT must have exactly one position: %s", wkt)
+	}
+	if geometry.Type == TypeLineString && len(geometry.Lines[0]) < 2 {
+		return Geometry{}, fmt.Errorf("invalid WKT format, LINESTRING must have at least two positions: %s", wkt)
+	}
+
+	return geometry, nil
+}
+
+// unwrapParentheses removes one pair of enclosing parentheses
+func unwrapParentheses(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "(") || !strings.HasSuffix(text, ")") {
+		return "", fmt.Errorf("expected parenthesized coordinates")
+	}
+	return strings.TrimSpace(text[1 : len(text)-1]), nil
+}
+
+// splitParenthesizedList splits "(a), (b)" into the contents "a" and "b"
+func splitParenthesizedList(text string) ([]string, error) {
+	var parts []string
+	depth := 0
+	start := -1
+	for i, char := range text {
+		switch char {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(text[start:i]))
+			}
+		case ',', ' ', '\t', '\n', '\r':
+			// Separators between members
+		default:
+			if depth == 0 {
+				return nil, fmt.Errorf("unexpected character %q outside member", char)
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no members found")
+	}
+	return parts, nil
+}
+
+// parseWKTPositions parses a comma separated list of positions. It also reports whether
+// the positions carry a Z value even though the geometry was not marked with Z.
+func parseWKTPositions(text string, hasZ, hasM bool) ([]Point, bool, error) {
+	var points []Point
+	impliedZ := false
+
+	for _, positionText := range strings.Split(text, ",") {
+		values := strings.Fields(positionText)
+		if len(values) < 2 {
+			return nil, false, fmt.Errorf("not enough coordinate values: %q", positionText)
+		}
+		if len(values) > 4 {
+			return nil, false, fmt.Errorf("too many coordinate values: %q", positionText)
+		}
+
+		numbers := make([]float64, len(values))
+		for i, value := range values {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to parse coordinate value %q: %w", value, err)
+			}
+			numbers[i] = number
+		}
+
+		point := Point{X: numbers[0], Y: numbers[1]}
+		// The third value is Z unless the geometry only carries M values
+		if len(numbers) >= 3 && !(hasM && !hasZ) {
+			point.Z = numbers[2]
+			if !hasZ {
+				impliedZ = true
+			}
+		}
+		points = append(points, point)
+	}
+
+	return points, impliedZ, nil
+}
+
+// formatWKTNumber formats a coordinate value without unnecessary trailing zeros
+func formatWKTNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,100 @@
+package wkt
+
+import (
+	"math"
+	"testing"
+)
+
+// TestParseWKT tests parsing of the supported WKT geometry types
+func TestParseWKT(t *testing.T) {
+	testCases := []struct {
+		wkt          string
+		expectedType string
+		expectedHasZ bool
+		expectedLens []int // Number of positions per line
+		expectError  bool
+		description  string
+	}{
+		{"POINT (1 2)", TypePoint, false, []int{1}, false, "2D point"},
+		{"POINT Z(1 2 3)", TypePoint, true, []int{1}, false, "Point with Z and no space"},
+		{"POINT M (1 2 3)", TypePoint, false, []int{1}, false, "Point with M"},
+		{"POINT ZM (1 2 3 4)", TypePoint, true, []int{1}, false, "Point with ZM"},
+		{"POINT (1 2 3)", TypePoint, true, []int{1}, false, "Point with unmarked Z"},
+		{"LINESTRING (0 0, 3 4)", TypeLineString, false, []int{2}, false, "2D linestring"},
+		{"LINESTRING Z(0 0 1, 3 4 2, 6 8 3)", TypeLineString, true, []int{3}, false, "Linestring with Z"},
+		{"MULTILINESTRING Z ((0 0 0, 1 1 1), (2 2 2, 3 3 3, 4 4 4))", TypeMultiLineString, true, []int{2, 3}, false, "Multilinestring with Z"},
+		{"multilinestring((0 0,1 1))", TypeMultiLineString, false, []int{2}, false, "Lowercase multilinestring"},
+		{"LINESTRING EMPTY", TypeLineString, false, nil, false, "Empty linestring"},
+		{"LINESTRING (0 0)", "", false, nil, true, "Linestring with one position"},
+		{"POINT (1 2, 3 4)", "", false, nil, true, "Point with two positions"},
+		{"POLYGON ((0 0, 1 0, 1 1, 0 0))", "", false, nil, true, "Unsupported type"},
+		{"LINESTRING (0 0, a b)", "", false, nil, true, "Invalid number"},
+		{"MULTILINESTRING ((0 0, 1 1)", "", false, nil, true, "Unbalanced parentheses"},
+		{"", "", false, nil, true, "Empty string"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			geometry, err := Parse(tc.wkt)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected error parsing WKT %q, but got none", tc.wkt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error parsing WKT %q: %v", tc.wkt, err)
+			}
+
+			if geometry.Type != tc.expectedType {
+				t.Errorf("Expected type %s, got %s", tc.expectedType, geometry.Type)
+			}
+			if geometry.HasZ != tc.expectedHasZ {
+				t.Errorf("Expected HasZ=%v, got %v", tc.expectedHasZ, geometry.HasZ)
+			}
+			if len(geometry.Lines) != len(tc.expectedLens) {
+				t.Fatalf("Expected %d lines, got %d", len(tc.expectedLens), len(geometry.Lines))
+			}
+			for i, expectedLen := range tc.expectedLens {
+				if len(geometry.Lines[i]) != expectedLen {
+					t.Errorf("Line %d: expected %d positions, got %d", i, expectedLen, len(geometry.Lines[i]))
+				}
+			}
+		})
+	}
+}
+
+// TestWKTGeometryFormatting tests WKT and GeoJSON output and length calculation
+func TestWKTGeometryFormatting(t *testing.T) {
+	geometry, err := Parse("MULTILINESTRING Z ((0 0 10, 3 4 11), (10 10 12, 10 20 13.5))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if length := geometry.Length(); math.Abs(length-15) > 1e-9 {
+		t.Errorf("Expected length 15, got %f", length)
+	}
+
+	expectedWKT := "MULTILINESTRING Z ((0 0 10, 3 4 11), (10 10 12, 10 20 13.5))"
+	if wkt := geometry.WKT(); wkt != expectedWKT {
+		t.Errorf("Expected WKT %q, got %q", expectedWKT, wkt)
+	}
+
+	geoJSON, err := geometry.GeoJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error formatting GeoJSON: %v", err)
+	}
+	expectedGeoJSON := `{"type":"MultiLineString","coordinates":[[[0,0,10],[3,4,11]],[[10,10,12],[10,20,13.5]]]}`
+	if geoJSON != expectedGeoJSON {
+		t.Errorf("Expected GeoJSON %s, got %s", expectedGeoJSON, geoJSON)
+	}
+
+	// Round trip through the parser
+	reparsed, err := Parse(geometry.WKT())
+	if err != nil {
+		t.Fatalf("Failed to parse formatted WKT: %v", err)
+	}
+	if reparsed.WKT() != expectedWKT {
+		t.Errorf("Round trip changed WKT: %q", reparsed.WKT())
+	}
+}